@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile merges a YAML config file into the parsed options. Keys are
+// the long option names. Values only apply to options that were neither given
+// on the command line nor through their environment variable, so the
+// precedence is flags > env vars > file > defaults. It returns the long names
+// of the options the file set.
+func loadConfigFile(p *flags.Parser, path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	applied, err := applyOptions(p, values, nil)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return applied, nil
+}
+
+// applyOptions sets option values keyed by long option name, skipping options
+// given on the command line, through their environment variable or listed in
+// skip. It returns the long names of the options it set.
+func applyOptions(p *flags.Parser, values map[string]interface{}, skip map[string]bool) (map[string]bool, error) {
+	options := make(map[string]*flags.Option, len(values))
+	var unknown []string
+	for key := range values {
+		if option := p.FindOptionByLongName(key); option != nil && key != "config" {
+			options[key] = option
+		} else {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown option(s) %s", strings.Join(unknown, ", "))
+	}
+
+	applied := make(map[string]bool, len(values))
+	for key, value := range values {
+		option := options[key]
+		if skip[key] {
+			continue
+		}
+		if option.IsSet() && !option.IsSetDefault() {
+			continue // given on the command line
+		}
+		if env := option.EnvKeyWithNamespace(); env != "" {
+			if _, ok := os.LookupEnv(env); ok {
+				continue
+			}
+		}
+
+		if option.IsSetDefault() && option.Field().Type.Kind() == reflect.Slice {
+			// go-flags appends to the defaults of repeatable options.
+			field := reflect.ValueOf(&config).Elem().FieldByName(option.Field().Name)
+			field.Set(reflect.Zero(field.Type()))
+		}
+		for _, v := range configFileValues(option, value) {
+			if err := option.Set(&v); err != nil {
+				return nil, fmt.Errorf("option %s: %w", key, err)
+			}
+		}
+		applied[key] = true
+	}
+
+	return applied, nil
+}
+
+// configFileValues flattens a YAML value into the string arguments go-flags
+// expects. Lists set repeatable options once per element, and a number sets a
+// counter option such as verbose that many times.
+func configFileValues(option *flags.Option, value interface{}) []string {
+	field := option.Field().Type
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			values = append(values, fmt.Sprint(e))
+		}
+		return values
+	case []string:
+		return v
+	case int:
+		if field.Kind() == reflect.Slice && field.Elem().Kind() == reflect.Bool {
+			values := make([]string, v)
+			for i := range values {
+				values[i] = "true"
+			}
+			return values
+		}
+	case nil:
+		return nil
+	}
+
+	if field.Kind() == reflect.Slice {
+		if s, ok := value.(string); ok {
+			if delim := option.EnvDefaultDelim; delim != "" {
+				return strings.Split(s, delim)
+			}
+		}
+	}
+
+	return []string{fmt.Sprint(value)}
+}
+
+// validateConfig checks the merged configuration for values that can't work.
+func validateConfig(c *Config) error {
+	for name, port := range map[string]int{"port-spot": c.SpotAddress, "port-futures": c.FuturesAddress} {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("%s must be between 1 and 65535, got %d", name, port)
+		}
+	}
+	if !c.DisableSpot && !c.DisableFutures && c.SpotAddress == c.FuturesAddress {
+		return fmt.Errorf("port-spot and port-futures must differ, both are %d", c.SpotAddress)
+	}
+	if c.WsDialRate <= 0 || c.WsDialBurst < 1 {
+		return fmt.Errorf("ws-dial-rate must be positive and ws-dial-burst at least 1")
+	}
+	if c.ClientRate < 0 || (c.ClientRate > 0 && c.ClientBurst < 1) {
+		return fmt.Errorf("client-rate must not be negative and client-burst must be at least 1")
+	}
+	if c.UpstreamTimeout <= 0 || c.MaxUpstreamTimeout < c.UpstreamTimeout {
+		return fmt.Errorf("upstream-timeout must be positive and not above max-upstream-timeout")
+	}
+	if c.AutoRecovery && (c.RecoveryInterval <= 0 || c.RecoveryStaleAfter <= 0 || c.RecoveryThreshold <= 0) {
+		return fmt.Errorf("recovery-interval, recovery-stale-after and recovery-threshold must be positive")
+	}
+	if c.SuperviseErrors < 0 || (c.SuperviseErrors > 0 && (c.SuperviseInterval <= 0 || c.SuperviseShare <= 0)) {
+		return fmt.Errorf("supervise-stream-errors must not be negative, supervise-interval and supervise-class-share must be positive")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("tls-cert and tls-key must be set together")
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" {
+		return fmt.Errorf("tls-client-ca requires tls-cert and tls-key")
+	}
+	if c.WeightReserve < 0 || c.WeightReserve >= 1 {
+		return fmt.Errorf("weight-reserve must be at least 0 and below 1")
+	}
+	if c.SLOAvailability < 0 || c.SLOAvailability >= 1 || c.SLOLatencyTarget < 0 || c.SLOLatencyTarget >= 1 {
+		return fmt.Errorf("slo-availability and slo-latency-target must be at least 0 and below 1")
+	}
+	if c.SLOLatencyTarget > 0 && c.SLOLatency <= 0 {
+		return fmt.Errorf("slo-latency must be positive")
+	}
+	if c.ClientQuota < 0 || (c.ClientQuota > 0 && c.ClientQuotaPeriod <= 0) {
+		return fmt.Errorf("client-quota must not be negative and client-quota-period must be positive")
+	}
+	if c.GCPercent < -1 {
+		return fmt.Errorf("gc-percent must be -1 or more")
+	}
+	if c.MaxResponseSize < 0 {
+		return fmt.Errorf("max-response-size must not be negative")
+	}
+	if c.NotifyRate <= 0 {
+		return fmt.Errorf("notify-rate must be positive")
+	}
+	if c.ShutdownTimeout < 0 || c.ReconnectStagger < 0 {
+		return fmt.Errorf("shutdown-timeout and reconnect-stagger must not be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// configProfiles are the built-in option sets selected with --profile. Their
+// values rank below the config file, so the precedence is flags > env vars >
+// file > profile > defaults.
+var configProfiles = map[string]map[string]interface{}{
+	// A freqtrade instance trading spot pairs: streams shared over few
+	// connections and kept through bot restarts, and cached data served
+	// while Binance bans the proxy instead of empty answers.
+	"freqtrade-spot": {
+		"disable-futures":  true,
+		"combined-streams": true,
+		"idle-grace":       "5m",
+		"weight-reserve":   0.2,
+		"ban-policy":       "stale-cache",
+		"restart-confirm":  true,
+	},
+	"freqtrade-futures": {
+		"disable-spot":     true,
+		"combined-streams": true,
+		"idle-grace":       "5m",
+		"weight-reserve":   0.2,
+		"ban-policy":       "stale-cache",
+		"restart-confirm":  true,
+	},
+	// Charts and tickers for many viewers: every client is rate limited, no
+	// client can pull large responses through, and both markets are reachable
+	// from one websocket.
+	"dashboard-readonly": {
+		"cross-class-streams": true,
+		"client-rate":         5,
+		"client-burst":        20,
+		"max-response-size":   8 << 20,
+		"ban-policy":          "stale-cache",
+		"api-key-routes":      []string{"/admin", "/restart", "/events", "/stats"},
+		"restart-confirm":     true,
+	},
+	// Replicas behind a load balancer: they heal themselves, reconnect
+	// gently after shared network outages and drain on rolling restarts.
+	"ha-cluster": {
+		"combined-streams":  true,
+		"auto-recovery":     true,
+		"ws-dial-rate":      0.5,
+		"reconnect-stagger": "200ms",
+		"idle-grace":        "10m",
+		"shutdown-timeout":  "30s",
+		"weight-reserve":    0.2,
+		"ban-policy":        "stale-cache",
+		"restart-confirm":   true,
+	},
+}
+
+// configProfileNames returns the names of the built-in profiles, sorted.
+func configProfileNames() []string {
+	names := make([]string, 0, len(configProfiles))
+	for name := range configProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyConfigProfile merges a built-in profile into the options that the
+// command line, the environment and the config file (fileOptions) left
+// unset. It returns the long names of the options the profile set.
+func applyConfigProfile(p *flags.Parser, name string, fileOptions map[string]bool) (map[string]bool, error) {
+	values, ok := configProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q, expected one of %s", name, strings.Join(configProfileNames(), ", "))
+	}
+	applied, err := applyOptions(p, values, fileOptions)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	return applied, nil
+}
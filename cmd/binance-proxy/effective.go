@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	log "github.com/sirupsen/logrus"
+)
+
+// redacted replaces secret option values in the effective configuration.
+const redacted = "<redacted>"
+
+// effectiveOption is the resolved value of an option and where it came from.
+type effectiveOption struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"` // flag, env, file, profile or default
+}
+
+// effectiveConfig resolves every option with its source. Options tagged
+// secret:"true" are redacted; secret:"apikeys" only redacts entries that
+// aren't IP addresses or CIDR ranges.
+func effectiveConfig(p *flags.Parser, fileOptions, profileOptions map[string]bool) map[string]effectiveOption {
+	config := make(map[string]effectiveOption)
+	fields := reflect.TypeOf(Config{})
+	for i := 0; i < fields.NumField(); i++ {
+		name := fields.Field(i).Tag.Get("long")
+		option := p.FindOptionByLongName(name)
+		if option == nil {
+			continue
+		}
+
+		source := "default"
+		switch {
+		case fileOptions[name]:
+			source = "file"
+		case profileOptions[name]:
+			source = "profile"
+		case option.IsSet() && !option.IsSetDefault():
+			source = "flag"
+		case option.EnvKeyWithNamespace() != "":
+			if _, ok := os.LookupEnv(option.EnvKeyWithNamespace()); ok {
+				source = "env"
+			}
+		}
+
+		value := option.Value()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		switch option.Field().Tag.Get("secret") {
+		case "true":
+			if source != "default" {
+				value = redacted
+			}
+		case "apikeys":
+			if entries, ok := value.([]string); ok {
+				value = redactAPIKeys(entries)
+			}
+		}
+		config[name] = effectiveOption{Value: value, Source: source}
+	}
+	return config
+}
+
+func redactAPIKeys(entries []string) []string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = e
+		if _, _, err := net.ParseCIDR(e); err != nil && net.ParseIP(e) == nil {
+			out[i] = redacted
+		}
+	}
+	return out
+}
+
+// logEffectiveConfig writes the startup banner with the resolved options.
+func logEffectiveConfig(config map[string]effectiveOption) {
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	log.Info("Effective configuration:")
+	for _, name := range names {
+		o := config[name]
+		log.WithField("source", o.Source).Infof("  %-22s %v", name, formatOptionValue(o.Value))
+	}
+}
+
+func formatOptionValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case []bool:
+		return len(v)
+	}
+	return v
+}
@@ -0,0 +1,9 @@
+//go:build boringcrypto
+
+package main
+
+// Restricts crypto/tls to FIPS 140 approved versions, cipher suites and
+// curves, for builds with GOEXPERIMENT=boringcrypto.
+import _ "crypto/tls/fipsonly"
+
+const fipsBuild = true
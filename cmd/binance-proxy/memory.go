@@ -0,0 +1,44 @@
+package main
+
+import (
+	"binance-proxy/internal/tool"
+	"os"
+	"runtime/debug"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// autoMemoryShare is the share of the container memory limit used as the
+// soft limit with --memory-limit=auto, leaving room for memory the Go
+// runtime doesn't account for.
+const autoMemoryShare = 0.9
+
+// applyMemorySettings sets the soft memory limit and GC percent of the
+// runtime. Explicit GOMEMLIMIT and GOGC environment variables win over auto
+// and the default.
+func applyMemorySettings(memoryLimit string, gcPercent int) error {
+	limit, auto, err := tool.ParseMemoryLimit(memoryLimit)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case limit > 0:
+		debug.SetMemoryLimit(limit)
+		log.Infof("Soft memory limit set to %d MB", limit>>20)
+	case auto && os.Getenv("GOMEMLIMIT") != "":
+		log.Infof("Soft memory limit taken from GOMEMLIMIT=%s", os.Getenv("GOMEMLIMIT"))
+	case auto:
+		if cgroup, ok := tool.CgroupMemoryLimit(); ok {
+			limit = int64(float64(cgroup) * autoMemoryShare)
+			debug.SetMemoryLimit(limit)
+			log.Infof("Soft memory limit set to %d MB, %.0f%% of the container limit of %d MB", limit>>20, autoMemoryShare*100, cgroup>>20)
+		}
+	}
+
+	if gcPercent != 0 {
+		debug.SetGCPercent(gcPercent)
+		log.Infof("GC percent set to %d", gcPercent)
+	}
+	return nil
+}
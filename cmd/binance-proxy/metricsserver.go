@@ -0,0 +1,86 @@
+package main
+
+import (
+	"binance-proxy/internal/handler"
+	"binance-proxy/internal/logcache"
+	"binance-proxy/internal/metrics"
+	"binance-proxy/internal/service"
+	"context"
+	"encoding/json"
+	"fmt"
+	stdlog "log"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var metricsServer *http.Server
+
+// startMetricsServer serves /metrics, /healthz and the pprof handlers under
+// /debug/pprof/ on port, apart from the proxy ports, so scrapers, probes and
+// profilers can be given access without reaching the API. It returns once
+// the port is bound.
+func startMetricsServer(ctx context.Context, port int, classes []service.Class) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		service.UpdateBanMetrics(classes...)
+		metrics.Handler().ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		status, code := "ok", http.StatusOK
+		if ctx.Err() != nil {
+			status, code = "shutting down", http.StatusServiceUnavailable
+		}
+		banned := []service.Class{}
+		for _, class := range classes {
+			if service.GetBanDetector().IsBanned(class) {
+				banned = append(banned, class)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		body := handler.HealthDetails(classes...)
+		body["status"], body["banned"] = status, banned
+		json.NewEncoder(w).Encode(body)
+	})
+	// net/http/pprof registers its handlers on the default mux
+	mux.Handle("/debug/pprof/", http.DefaultServeMux)
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		ErrorLog: stdlog.New(
+			logcache.NewSuppressingWriter(os.Stderr),
+			"", stdlog.LstdFlags,
+		),
+	}
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	metricsServer = srv
+	log.Infof("Metrics, health and pprof listener starting on port %d.", port)
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Metrics listener failed (error: %s).", err)
+		}
+	}()
+	return nil
+}
+
+// shutdownMetricsServer stops the metrics listener, if it was started.
+func shutdownMetricsServer(ctx context.Context) {
+	if metricsServer == nil {
+		return
+	}
+	if err := metricsServer.Shutdown(ctx); err != nil {
+		log.Warnf("Metrics listener did not finish in-flight requests (error: %s).", err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"binance-proxy/internal/mock"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+	log "github.com/sirupsen/logrus"
+)
+
+// MockConfig holds the options of the mock subcommand.
+type MockConfig struct {
+	Port    int      `short:"p" long:"port" env:"BPX_MOCK_PORT" description:"Port the mock Binance upstream listens on" default:"9090"`
+	Symbols []string `long:"symbols" env:"BPX_MOCK_SYMBOLS" env-delim:"," description:"Symbol the mock serves (can be repeated)" default:"BTCUSDT" default:"ETHUSDT" default:"BNBUSDT"`
+}
+
+// runMock serves deterministic market data in place of Binance until the
+// process is stopped: binance-proxy mock, then binance-proxy
+// --mock-upstream=http://localhost:9090.
+func runMock(args []string) {
+	var config MockConfig
+	parser := flags.NewParser(&config, flags.Default)
+	parser.Usage = "mock [OPTIONS]"
+	if _, err := parser.ParseArgs(args); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", config.Port),
+		Handler:           mock.NewServer(config.Symbols),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Infof("Mock Binance upstream serving %v on port %d", config.Symbols, config.Port)
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatalf("Mock Binance upstream failed (error: %s).", err)
+	}
+}
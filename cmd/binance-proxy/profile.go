@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"runtime/pprof"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// startCPUProfile records a CPU profile of the whole run to path, the input
+// of profile-guided optimization (make pgo-profile). The returned function
+// stops the recording and must be called before exiting.
+func startCPUProfile(path string) (stop func(), err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	log.Infof("Recording a CPU profile to %s until shutdown", path)
+
+	return func() {
+		pprof.StopCPUProfile()
+		if err := f.Close(); err != nil {
+			log.Errorf("Writing the CPU profile to %s failed (error: %s).", path, err)
+			return
+		}
+		log.Infof("CPU profile written to %s", path)
+	}, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"binance-proxy/internal/handler"
+	"binance-proxy/internal/service"
+	"context"
+	"encoding/json"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// memorySampleInterval is how often memory usage is sampled for the peak.
+const memorySampleInterval = 10 * time.Second
+
+// memoryPeak tracks the highest heap and total memory obtained from the OS.
+type memoryPeak struct {
+	mu   sync.Mutex
+	heap uint64
+	sys  uint64
+}
+
+func (m *memoryPeak) sample() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.heap = max(m.heap, ms.HeapAlloc)
+	m.sys = max(m.sys, ms.Sys)
+}
+
+// run samples until ctx is done.
+func (m *memoryPeak) run(ctx context.Context) {
+	t := time.NewTicker(memorySampleInterval)
+	defer t.Stop()
+
+	for {
+		m.sample()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (m *memoryPeak) peak() (heap, sys uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.heap, m.sys
+}
+
+// shutdownReport summarizes the lifetime of the process for capacity reviews.
+type shutdownReport struct {
+	Version           string           `json:"version"`
+	Start             time.Time        `json:"start"`
+	End               time.Time        `json:"end"`
+	Uptime            string           `json:"uptime"`
+	Requests          int64            `json:"requests"`
+	RequestsBySource  map[string]int64 `json:"requests_by_source"`
+	PeakStreams       int64            `json:"peak_streams"`
+	PeakHeapBytes     uint64           `json:"peak_heap_bytes"`
+	PeakSysBytes      uint64           `json:"peak_sys_bytes"`
+	BanEvents         int64            `json:"ban_events"`
+	UncleanGoroutines int              `json:"unclean_goroutines"`
+}
+
+// newShutdownReport collects the report. Goroutines still running on top of
+// baseGoroutines, the count before the proxies started, are reported as
+// unclean once they had a second to finish.
+func newShutdownReport(start time.Time, mem *memoryPeak, baseGoroutines int) shutdownReport {
+	mem.sample()
+	heap, sys := mem.peak()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseGoroutines && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	end := time.Now()
+	r := shutdownReport{
+		Version:           Version,
+		Start:             start,
+		End:               end,
+		Uptime:            end.Sub(start).Round(time.Second).String(),
+		RequestsBySource:  handler.RequestsBySource(),
+		PeakStreams:       service.PeakStreams(),
+		PeakHeapBytes:     heap,
+		PeakSysBytes:      sys,
+		BanEvents:         service.BanEvents(),
+		UncleanGoroutines: max(0, runtime.NumGoroutine()-baseGoroutines),
+	}
+	for _, n := range r.RequestsBySource {
+		r.Requests += n
+	}
+	return r
+}
+
+// log writes the report as a single structured log line.
+func (r shutdownReport) log() {
+	sources := make([]string, 0, len(r.RequestsBySource))
+	for source := range r.RequestsBySource {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	fields := log.Fields{
+		"uptime":             r.Uptime,
+		"requests":           r.Requests,
+		"peak_streams":       r.PeakStreams,
+		"peak_heap_mb":       r.PeakHeapBytes >> 20,
+		"peak_sys_mb":        r.PeakSysBytes >> 20,
+		"ban_events":         r.BanEvents,
+		"unclean_goroutines": r.UncleanGoroutines,
+	}
+	for _, source := range sources {
+		fields["requests_"+source] = r.RequestsBySource[source]
+	}
+	log.WithFields(fields).Info("shutdown report")
+}
+
+// write stores the report as JSON in path.
+func (r shutdownReport) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
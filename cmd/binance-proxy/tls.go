@@ -0,0 +1,141 @@
+package main
+
+import (
+	"binance-proxy/internal/audit"
+	"binance-proxy/internal/handler"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certReloadInterval is how often the certificate files are checked for changes.
+const certReloadInterval = 10 * time.Second
+
+// certReloader serves a certificate pair from disk and reloads it when either
+// file changes, so renewed certificates are picked up without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime returns the newest modification time of the pair.
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	if cert.Leaf != nil {
+		handler.SetTLSCertExpiry(cert.Leaf.NotAfter)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// watch reloads the certificate whenever the files change until ctx is done.
+// A broken pair is logged and the previous certificate stays in use.
+func (r *certReloader) watch(ctx context.Context) {
+	t := time.NewTicker(certReloadInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		modTime, err := r.latestModTime()
+		r.mu.RLock()
+		changed := err == nil && modTime.After(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := r.reload(); err != nil {
+			log.Errorf("TLS certificate reload failed, keeping the previous certificate (error: %s).", err)
+			audit.Record(audit.Event{Action: audit.ActionTLSReload, Outcome: audit.OutcomeFailed, Details: map[string]interface{}{"cert": r.certFile, "error": err.Error()}})
+		} else {
+			log.Infof("TLS certificate reloaded from %s", r.certFile)
+			audit.Record(audit.Event{Action: audit.ActionTLSReload, Outcome: audit.OutcomeSuccess, Details: map[string]interface{}{"cert": r.certFile}})
+		}
+	}
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// newTLSConfig builds the server TLS configuration. With a client CA file,
+// clients must present a certificate signed by it (mTLS). Cipher suites only
+// apply to TLS 1.2, TLS 1.3 suites aren't configurable.
+func newTLSConfig(ctx context.Context, certFile, keyFile, clientCAFile string, minVersion uint16, cipherSuites []uint16) (*tls.Config, error) {
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("TLS certificate: %w", err)
+	}
+	go reloader.watch(ctx)
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		GetCertificate: reloader.getCertificate,
+	}
+
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TLS client CA: no certificates found in %s", clientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,119 @@
+// Package audit records administrative actions (restarts, ban clears,
+// reloads, API key changes) to an append-only log of JSON lines.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Actions.
+const (
+	ActionRestart       = "restart"
+	ActionBanClear      = "ban_clear"
+	ActionKeyCreate     = "key_create"
+	ActionKeyRevoke     = "key_revoke"
+	ActionKeyRotate     = "key_rotate"
+	ActionKeysReload    = "keys_reload"
+	ActionTLSReload     = "tls_reload"
+	ActionRuntimeChange = "runtime_change"
+	ActionCacheLoad     = "cache_load"
+	ActionStreamControl = "stream_control"
+)
+
+// Outcomes.
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeFailed  = "failed"
+)
+
+// Event is an audit record. Client and KeyID are empty for actions the proxy
+// takes by itself, such as reloading a changed file.
+type Event struct {
+	Time     time.Time              `json:"time"`
+	Action   string                 `json:"action"`
+	Outcome  string                 `json:"outcome"`
+	Class    string                 `json:"class,omitempty"`
+	Client   string                 `json:"client,omitempty"`
+	KeyID    string                 `json:"key_id,omitempty"`
+	Identity string                 `json:"identity,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+var (
+	mu  sync.Mutex
+	out io.Writer
+)
+
+// Open appends audit records to the file at target, created with mode 0600
+// if needed, or sends them to the local syslog daemon when target is
+// "syslog". An empty target keeps records in the proxy log only.
+func Open(target string) error {
+	var w io.Writer
+	switch target {
+	case "":
+	case "syslog":
+		var err error
+		if w, err = openSyslog(); err != nil {
+			return fmt.Errorf("audit log: %w", err)
+		}
+	default:
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return fmt.Errorf("audit log: %w", err)
+		}
+		w = f
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if c, ok := out.(io.Closer); ok {
+		c.Close()
+	}
+	out = w
+	return nil
+}
+
+// Record writes e to the audit log and the proxy log. Time is set when zero.
+func Record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	fields := log.Fields{"action": e.Action, "outcome": e.Outcome}
+	for name, v := range map[string]string{"class": e.Class, "client": e.Client, "key_id": e.KeyID, "identity": e.Identity} {
+		if v != "" {
+			fields[name] = v
+		}
+	}
+	for name, v := range e.Details {
+		fields[name] = v
+	}
+	entry := log.WithFields(fields)
+	if e.Outcome == OutcomeSuccess {
+		entry.Warnf("audit: %s", e.Action)
+	} else {
+		entry.Warnf("audit: %s %s", e.Action, e.Outcome)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if out == nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("Audit record for %s not written (error: %s).", e.Action, err)
+		return
+	}
+	if _, err := out.Write(append(line, '\n')); err != nil {
+		log.Errorf("Audit record for %s not written (error: %s).", e.Action, err)
+	}
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxRequestIDLength bounds request IDs taken over from clients.
+const maxRequestIDLength = 128
+
+var (
+	accessLogMu sync.Mutex
+	accessLog   io.Writer
+)
+
+// SetAccessLog writes one JSON line per request to target: "stdout",
+// "stderr" or a file that is appended to. An empty target disables the
+// access log.
+func SetAccessLog(target string) error {
+	var w io.Writer
+	switch target {
+	case "":
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+		if err != nil {
+			return fmt.Errorf("access log: %w", err)
+		}
+		w = f
+	}
+
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	accessLog = w
+	return nil
+}
+
+// requestID returns the X-Request-ID sent by the client, or a new one when it
+// is missing or not a short token.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); validRequestID(id) {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// localSources are Data-Source values of responses served from data the
+// proxy holds, counted as cache hits in the access log.
+var localSources = map[string]bool{
+	"websocket":   true,
+	"rest-poll":   true,
+	"cache":       true,
+	"stale-cache": true,
+	"snapshot":    true,
+	"clock":       true,
+}
+
+type accessEntry struct {
+	Time      string  `json:"time"`
+	RequestID string  `json:"request_id"`
+	TraceID   string  `json:"trace_id,omitempty"`
+	Class     string  `json:"class"`
+	Client    string  `json:"client"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Query     string  `json:"query,omitempty"`
+	Status    int     `json:"status"`
+	Bytes     int64   `json:"bytes"`
+	LatencyMs float64 `json:"latency_ms"`
+	Source    string  `json:"source"`
+	CacheHit  bool    `json:"cache_hit"`
+	UserAgent string  `json:"user_agent,omitempty"`
+}
+
+// logAccess writes the access log line of a served request.
+func (s *Handler) logAccess(r *http.Request, rec *responseRecorder, id, traceID, source string, start time.Time, d time.Duration) {
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	if accessLog == nil {
+		return
+	}
+
+	line, err := json.Marshal(accessEntry{
+		Time:      start.UTC().Format(time.RFC3339Nano),
+		RequestID: id,
+		TraceID:   traceID,
+		Class:     string(s.class),
+		Client:    clientIP(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Status:    rec.Status(),
+		Bytes:     rec.bytes,
+		LatencyMs: float64(d.Microseconds()) / 1000,
+		Source:    source,
+		CacheHit:  localSources[source],
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		return
+	}
+	if _, err := accessLog.Write(append(line, '\n')); err != nil {
+		log.Debugf("Access log write failed (error: %s).", err)
+	}
+}
@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"binance-proxy/internal/audit"
+	"binance-proxy/internal/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// adminRecovery reports the auto recovery and stream supervisor state and
+// the upstream circuit breakers of this class.
+func (s *Handler) adminRecovery(w http.ResponseWriter) {
+	var recovery interface{} = map[string]interface{}{"class": s.class, "enabled": false}
+	if s.recovery != nil {
+		recovery = s.recovery.Stats()
+	}
+	var supervisor interface{} = map[string]interface{}{"class": s.class, "enabled": false}
+	if s.supervisor != nil {
+		supervisor = s.supervisor.Stats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"class":            string(s.class),
+		"recovery":         recovery,
+		"supervisor":       supervisor,
+		"circuit_breakers": []service.BreakerState{service.GetBanDetector().Breaker(s.class)},
+	})
+}
+
+var (
+	effectiveConfigMu sync.RWMutex
+	effectiveConfig   interface{}
+)
+
+// SetEffectiveConfig sets the resolved configuration served at /admin/config.
+// Secrets must already be redacted.
+func SetEffectiveConfig(config interface{}) {
+	effectiveConfigMu.Lock()
+	defer effectiveConfigMu.Unlock()
+	effectiveConfig = config
+}
+
+func (s *Handler) adminConfig(w http.ResponseWriter) {
+	effectiveConfigMu.RLock()
+	config := effectiveConfig
+	effectiveConfigMu.RUnlock()
+
+	buildInfoMu.RLock()
+	response := map[string]interface{}{
+		"version":    version,
+		"build_time": buildtime,
+		"options":    config,
+	}
+	buildInfoMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// adminCacheDump returns the cached klines, depth and ticker of a symbol in
+// the layout of the REST responses. With download=1 the dump is sent as a file.
+func (s *Handler) adminCacheDump(w http.ResponseWriter, r *http.Request) {
+	symbol := strings.ToUpper(r.URL.Query().Get("symbol"))
+	interval := r.URL.Query().Get("interval")
+	if symbol == "" {
+		writeError(w, http.StatusBadRequest, codeMandatory, "Mandatory parameter 'symbol' was not sent, was empty/null, or malformed.")
+		return
+	}
+
+	snap := s.srv.CacheSnapshot(symbol, interval)
+	dump := map[string]interface{}{
+		"class":       string(s.class),
+		"symbol":      symbol,
+		"interval":    interval,
+		"exported_at": time.Now().UnixMilli(),
+		"klines":      nil,
+		"depth":       nil,
+		"ticker":      snap.Ticker,
+	}
+	if snap.Klines != nil {
+		rows := make([][]interface{}, len(snap.Klines))
+		for i, k := range snap.Klines {
+			rows[i] = klineRow(k)
+		}
+		dump["klines"] = rows
+	}
+	if snap.Depth != nil {
+		dump["depth"] = depthResponse(snap.Depth, len(snap.Depth.Bids)+len(snap.Depth.Asks))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("download") == "1" {
+		name := strings.ToLower(fmt.Sprintf("%s-%s", s.class, symbol))
+		if interval != "" {
+			name += "-" + interval
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%d.json"`, name, time.Now().Unix()))
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(dump)
+}
+
+// maxCacheLoadSize bounds /admin/cache/load bodies; a full dump with
+// exchangeInfo stays well below it.
+const maxCacheLoadSize = 32 << 20
+
+// cacheDump is the layout of /admin/cache/dump, accepted back by
+// /admin/cache/load together with an optional exchangeInfo response.
+type cacheDump struct {
+	Class        string              `json:"class"`
+	Symbol       string              `json:"symbol"`
+	Interval     string              `json:"interval"`
+	Klines       [][]json.RawMessage `json:"klines"`
+	Depth        *depthDump          `json:"depth"`
+	Ticker       *service.Ticker24hr `json:"ticker"`
+	ExchangeInfo json.RawMessage     `json:"exchange_info"`
+}
+
+type depthDump struct {
+	LastUpdateID int64       `json:"lastUpdateId"`
+	Time         int64       `json:"E"`
+	TradeTime    int64       `json:"T"`
+	Bids         [][2]string `json:"bids"`
+	Asks         [][2]string `json:"asks"`
+}
+
+// parseKlineRow is the inverse of klineRow.
+func parseKlineRow(row []json.RawMessage) (*service.Kline, error) {
+	if len(row) < 11 {
+		return nil, fmt.Errorf("expected at least 11 fields, got %d", len(row))
+	}
+	k := &service.Kline{}
+	fields := []interface{}{
+		&k.OpenTime, &k.Open, &k.High, &k.Low, &k.Close, &k.Volume,
+		&k.CloseTime, &k.QuoteAssetVolume, &k.TradeNum,
+		&k.TakerBuyBaseAssetVolume, &k.TakerBuyQuoteAssetVolume,
+	}
+	for i, field := range fields {
+		if err := json.Unmarshal(row[i], field); err != nil {
+			return nil, fmt.Errorf("field %d: %w", i, err)
+		}
+	}
+	return k, nil
+}
+
+func priceLevels(levels [][2]string) []futures.Bid {
+	out := make([]futures.Bid, len(levels))
+	for i, l := range levels {
+		out[i] = futures.Bid{Price: l[0], Quantity: l[1]}
+	}
+	return out
+}
+
+var cacheLoadEnabled atomic.Bool
+
+// SetCacheLoad enables /admin/cache/load. Seeded data is served like data
+// from the streams, so loading dumps is off unless asked for.
+func SetCacheLoad(enabled bool) {
+	cacheLoadEnabled.Store(enabled)
+}
+
+// adminCacheLoad seeds the caches from a previously exported dump.
+func (s *Handler) adminCacheLoad(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !cacheLoadEnabled.Load() {
+		s.audit(r, audit.ActionCacheLoad, audit.OutcomeDenied, map[string]interface{}{"error": "cache load disabled"})
+		writeError(w, http.StatusForbidden, codeUnknown, "Loading cache dumps is disabled, start the proxy with --enable-cache-load to enable it.")
+		return
+	}
+
+	var dump cacheDump
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCacheLoadSize)).Decode(&dump); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("Invalid cache dump: %s.", err))
+		return
+	}
+	dump.Symbol = strings.ToUpper(dump.Symbol)
+	if dump.Symbol == "" {
+		writeError(w, http.StatusBadRequest, codeMandatory, "Mandatory parameter 'symbol' was not sent, was empty/null, or malformed.")
+		return
+	}
+	if dump.Class != "" && dump.Class != string(s.class) {
+		writeError(w, http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("The dump is for %s, this port serves %s.", dump.Class, s.class))
+		return
+	}
+
+	snap := service.CacheSnapshot{Ticker: dump.Ticker}
+	for i, row := range dump.Klines {
+		k, err := parseKlineRow(row)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("Invalid kline %d: %s.", i, err))
+			return
+		}
+		snap.Klines = append(snap.Klines, k)
+	}
+	if d := dump.Depth; d != nil {
+		snap.Depth = &service.Depth{
+			LastUpdateID: d.LastUpdateID,
+			Time:         d.Time,
+			TradeTime:    d.TradeTime,
+			Bids:         priceLevels(d.Bids),
+			Asks:         priceLevels(d.Asks),
+		}
+	}
+	var exchangeInfo []byte
+	if len(dump.ExchangeInfo) > 0 && string(dump.ExchangeInfo) != "null" {
+		exchangeInfo = dump.ExchangeInfo
+	}
+
+	loaded, err := s.srv.LoadCache(dump.Symbol, dump.Interval, snap, exchangeInfo)
+	details := map[string]interface{}{"symbol": dump.Symbol, "interval": dump.Interval}
+	if err != nil {
+		details["error"] = err.Error()
+		s.audit(r, audit.ActionCacheLoad, audit.OutcomeFailed, details)
+		status, code := http.StatusBadRequest, codeInvalidParam
+		if errors.Is(err, service.ErrExchangeInfoUnavailable) {
+			status, code = http.StatusServiceUnavailable, codeDisconnected
+		}
+		writeError(w, status, code, err.Error())
+		return
+	}
+
+	details["loaded"] = loaded
+	s.audit(r, audit.ActionCacheLoad, audit.OutcomeSuccess, details)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"class":    string(s.class),
+		"symbol":   dump.Symbol,
+		"interval": dump.Interval,
+		"loaded":   loaded,
+	})
+}
+
+// adminBans lists the ban state of every enabled class. POST clears the ban
+// of the class given by ?class= (this port's class by default, or "all").
+func (s *Handler) adminBans(w http.ResponseWriter, r *http.Request) {
+	buildInfoMu.RLock()
+	classes := enabledClasses
+	buildInfoMu.RUnlock()
+	if len(classes) == 0 {
+		classes = []service.Class{s.class}
+	}
+
+	bd := service.GetBanDetector()
+	response := map[string]interface{}{}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		target := strings.ToUpper(r.URL.Query().Get("class"))
+		cleared := []service.Class{}
+		for _, class := range classes {
+			if target == "ALL" || class == service.Class(target) || (target == "" && class == s.class) {
+				wasBanned := bd.Clear(class)
+				if wasBanned {
+					cleared = append(cleared, class)
+				}
+				auditRequest(r, class, audit.ActionBanClear, audit.OutcomeSuccess, map[string]interface{}{"was_banned": wasBanned})
+			}
+		}
+		response["cleared"] = cleared
+	default:
+		writeMethodNotAllowed(w, "GET, POST")
+		return
+	}
+
+	states := make([]service.BanState, len(classes))
+	for i, class := range classes {
+		states[i] = bd.State(class)
+	}
+	response["bans"] = states
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// adminStreams lists the running streams of this class. POST stops, restarts
+// or resubscribes the one given by ?action=, kind=, symbol= and, for
+// klines, interval=.
+func (s *Handler) adminStreams(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"class":   string(s.class),
+			"streams": s.srv.StreamHealth(),
+		})
+		return
+	case http.MethodPost:
+	default:
+		writeMethodNotAllowed(w, "GET, POST")
+		return
+	}
+
+	query := r.URL.Query()
+	action := query.Get("action")
+	kind := query.Get("kind")
+	symbol := strings.ToUpper(query.Get("symbol"))
+	interval := query.Get("interval")
+	if symbol == "" {
+		writeError(w, http.StatusBadRequest, codeMandatory, "Mandatory parameter 'symbol' was not sent, was empty/null, or malformed.")
+		return
+	}
+
+	details := map[string]interface{}{"action": action, "kind": kind, "symbol": symbol, "interval": interval}
+	if err := s.srv.ControlStream(action, kind, symbol, interval); err != nil {
+		details["error"] = err.Error()
+		s.audit(r, audit.ActionStreamControl, audit.OutcomeFailed, details)
+		status := http.StatusBadRequest
+		if errors.Is(err, service.ErrStreamNotRunning) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, codeInvalidParam, err.Error())
+		return
+	}
+	s.audit(r, audit.ActionStreamControl, audit.OutcomeSuccess, details)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"class":    string(s.class),
+		"action":   action,
+		"kind":     kind,
+		"symbol":   symbol,
+		"interval": interval,
+	})
+}
@@ -0,0 +1,582 @@
+package handler
+
+import (
+	"binance-proxy/internal/audit"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// apiKeyReloadInterval is how often the key file is checked for changes.
+const apiKeyReloadInterval = 10 * time.Second
+
+// apiKey is a key from the key file with its usage since it was first loaded.
+type apiKey struct {
+	id       string // first bytes of the SHA-256, for management
+	name     string
+	hint     string // first characters of the key, for display
+	admin    bool   // may use /restart and the /admin endpoints
+	requests int64
+	lastUsed time.Time
+	lastIP   string
+	routes   map[string]int64
+}
+
+// apiKeys authenticates the proxy's own endpoints with the X-API-Key header.
+// Keys are kept by their SHA-256 so the plain keys don't stay in memory.
+type apiKeys struct {
+	fileMu sync.Mutex // serializes changes of the key file
+
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	keys    map[[sha256.Size]byte]*apiKey
+	routes  []string
+}
+
+var proxyKeys = &apiKeys{}
+
+// LoadAPIKeys requires a key from the file at path in the X-API-Key header
+// for requests to routes, given as path prefixes ("/" protects everything),
+// and reloads the file whenever it changes until ctx is done.
+//
+// The file has one key per line, optionally followed by a name, and may
+// contain blank lines and # comments. Lines starting with "admin" hold admin
+// keys, the only keys accepted by the admin endpoints.
+func LoadAPIKeys(ctx context.Context, path string, routes []string) error {
+	proxyKeys.mu.Lock()
+	proxyKeys.path = path
+	proxyKeys.routes = nil
+	for _, route := range routes {
+		if route = strings.TrimSpace(route); route != "" {
+			proxyKeys.routes = append(proxyKeys.routes, route)
+		}
+	}
+	proxyKeys.mu.Unlock()
+
+	if err := proxyKeys.reload(); err != nil {
+		return err
+	}
+	go proxyKeys.watch(ctx)
+	return nil
+}
+
+func readAPIKeys(path string) (map[[sha256.Size]byte]*apiKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make(map[[sha256.Size]byte]*apiKey)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, name, admin := parseKeyLine(text)
+		if len(key) < 16 {
+			return nil, fmt.Errorf("%s:%d: API key is shorter than 16 characters", path, line)
+		}
+		sum := sha256.Sum256([]byte(key))
+		keys[sum] = &apiKey{
+			id:     hex.EncodeToString(sum[:6]),
+			name:   name,
+			hint:   key[:4] + "...",
+			admin:  admin,
+			routes: make(map[string]int64),
+		}
+	}
+	return keys, scanner.Err()
+}
+
+// parseKeyLine splits a line of the key file into the key, its name and
+// whether it is an admin key. Keys are at least 16 characters, so the
+// "admin" marker can't be taken for a key.
+func parseKeyLine(line string) (key, name string, admin bool) {
+	key, name, _ = strings.Cut(strings.TrimSpace(line), " ")
+	if key == "admin" {
+		admin = true
+		key, name, _ = strings.Cut(strings.TrimSpace(name), " ")
+	}
+	return key, strings.TrimSpace(name), admin
+}
+
+func (k *apiKeys) reload() error {
+	fi, err := os.Stat(k.path)
+	if err != nil {
+		return fmt.Errorf("API keys: %w", err)
+	}
+	keys, err := readAPIKeys(k.path)
+	if err != nil {
+		return fmt.Errorf("API keys: %w", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	// Keys that stay keep their statistics
+	for sum, key := range keys {
+		if old, ok := k.keys[sum]; ok {
+			old.name = key.name
+			old.admin = key.admin
+			keys[sum] = old
+		}
+	}
+	k.keys = keys
+	k.modTime = fi.ModTime()
+	admins := 0
+	for _, key := range keys {
+		if key.admin {
+			admins++
+		}
+	}
+	log.Infof("%d API keys (%d admin keys) loaded from %s, required for %s", len(keys), admins, k.path, strings.Join(k.routes, ", "))
+	return nil
+}
+
+// watch reloads the key file whenever it changes. A broken file is logged
+// and the previous keys stay in use.
+func (k *apiKeys) watch(ctx context.Context) {
+	t := time.NewTicker(apiKeyReloadInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		fi, err := os.Stat(k.path)
+		k.mu.Lock()
+		changed := err == nil && !fi.ModTime().Equal(k.modTime)
+		k.mu.Unlock()
+		if !changed {
+			continue
+		}
+
+		if err := k.reload(); err != nil {
+			log.Errorf("API key reload failed, keeping the previous keys (error: %s).", err)
+			audit.Record(audit.Event{Action: audit.ActionKeysReload, Outcome: audit.OutcomeFailed, Details: map[string]interface{}{"path": k.path, "error": err.Error()}})
+		} else {
+			audit.Record(audit.Event{Action: audit.ActionKeysReload, Outcome: audit.OutcomeSuccess, Details: map[string]interface{}{"path": k.path}})
+		}
+	}
+}
+
+// protects reports whether requests to path need a key. Callers hold mu.
+func (k *apiKeys) protects(path string) bool {
+	for _, route := range k.routes {
+		if route == "/" || path == route || strings.HasPrefix(path, strings.TrimSuffix(route, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize reports whether the request may use its route and counts it for
+// the key it carries. The admin token is accepted in place of a key.
+func (k *apiKeys) authorize(r *http.Request) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.keys == nil || !k.protects(r.URL.Path) {
+		return true
+	}
+	key, ok := k.keys[sha256.Sum256([]byte(r.Header.Get("X-API-Key")))]
+	if !ok {
+		return validAdminToken(r)
+	}
+	key.requests++
+	key.lastUsed = time.Now()
+	key.lastIP = clientIP(r)
+	key.routes[metricsEndpoint(r.URL.Path)]++
+	return true
+}
+
+// keyID returns the id of the loaded API key in the request's X-API-Key
+// header, "" without a valid one.
+func (k *apiKeys) keyID(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return ""
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if found, ok := k.keys[sha256.Sum256([]byte(key))]; ok {
+		return found.id
+	}
+	return ""
+}
+
+var adminToken atomic.Pointer[string]
+
+// SetAdminToken sets a bearer token that authenticates admin actions such as
+// /restart besides the admin keys. An empty token accepts none.
+func SetAdminToken(token string) {
+	adminToken.Store(&token)
+}
+
+// adminIdentity returns who the request authenticates as for admin actions:
+// an admin key from the key file in X-API-Key, whose id is returned as keyID,
+// or the admin token in an "Authorization: Bearer" header. ok is false
+// without valid credentials; other API keys are no admin credentials.
+func adminIdentity(r *http.Request) (identity, keyID string, ok bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		proxyKeys.mu.Lock()
+		k, found := proxyKeys.keys[sha256.Sum256([]byte(key))]
+		found = found && k.admin
+		proxyKeys.mu.Unlock()
+		if found {
+			if k.name != "" {
+				return "api key " + k.id + " (" + k.name + ")", k.id, true
+			}
+			return "api key " + k.id, k.id, true
+		}
+	}
+	if validAdminToken(r) {
+		return "admin token", "", true
+	}
+	return "", "", false
+}
+
+func validAdminToken(r *http.Request) bool {
+	token := adminToken.Load()
+	if token == nil || *token == "" {
+		return false
+	}
+	bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(bearer), []byte(*token)) == 1
+}
+
+// adminAuthConfigured reports whether admin keys or an admin token are set
+// up.
+func adminAuthConfigured() bool {
+	return adminKeyCount() > 0 || adminTokenSet()
+}
+
+// adminKeyCount returns the number of loaded admin keys.
+func adminKeyCount() int {
+	proxyKeys.mu.Lock()
+	defer proxyKeys.mu.Unlock()
+	n := 0
+	for _, key := range proxyKeys.keys {
+		if key.admin {
+			n++
+		}
+	}
+	return n
+}
+
+func adminTokenSet() bool {
+	token := adminToken.Load()
+	return token != nil && *token != ""
+}
+
+// isAdminRoute reports whether path is /restart or one of the /admin
+// endpoints, which always need an admin key or the admin token, whatever
+// --api-key-routes says.
+func isAdminRoute(path string) bool {
+	return path == "/restart" || strings.HasPrefix(path, "/admin/")
+}
+
+// authorizeAdmin answers requests to admin routes without valid credentials
+// and reports whether the request may go on. Admin routes are disabled
+// unless admin keys or an admin token are configured.
+func (s *Handler) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	configured := adminAuthConfigured()
+	if configured {
+		if _, _, ok := adminIdentity(r); ok {
+			return true
+		}
+	}
+	if action := auditAction(r); action != "" {
+		s.audit(r, action, audit.OutcomeDenied, nil)
+	}
+	if !configured {
+		log.Debugf("%s request %s %s from %s rejected, admin endpoints are disabled", s.class, r.Method, r.RequestURI, clientIP(r))
+		w.Header().Set("Data-Source", "proxy-auth")
+		writeError(w, http.StatusForbidden, codeRejectedKey, "Admin endpoints of the proxy are disabled, configure an admin key in --api-keys-file or --admin-token to enable them.")
+		return false
+	}
+	log.Debugf("%s request %s %s from %s rejected without admin credentials", s.class, r.Method, r.RequestURI, clientIP(r))
+	w.Header().Set("Data-Source", "proxy-auth")
+	w.Header().Set("WWW-Authenticate", `APIKey header="X-API-Key"`)
+	writeError(w, http.StatusUnauthorized, codeRejectedKey, "An admin X-API-Key header or the admin bearer token is required for this endpoint of the proxy.")
+	return false
+}
+
+func (s *Handler) rejectUnauthorized(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("%s request %s %s from %s rejected without a valid API key", s.class, r.Method, r.RequestURI, clientIP(r))
+
+	w.Header().Set("Data-Source", "proxy-auth")
+	w.Header().Set("WWW-Authenticate", `APIKey header="X-API-Key"`)
+	writeError(w, http.StatusUnauthorized, codeRejectedKey, "A valid X-API-Key header or admin bearer token is required for this endpoint of the proxy.")
+}
+
+type apiKeyStats struct {
+	ID       string           `json:"id"`
+	Key      string           `json:"key"`
+	Name     string           `json:"name,omitempty"`
+	Admin    bool             `json:"admin"`
+	Requests int64            `json:"requests"`
+	LastUsed *time.Time       `json:"last_used,omitempty"`
+	LastIP   string           `json:"last_ip,omitempty"`
+	Routes   map[string]int64 `json:"routes"`
+}
+
+// adminKeys lists the loaded API keys with their usage on GET, creates a key
+// on POST (?name=, ?admin=true for an admin key) and revokes one on DELETE
+// (?id=). Keys are listed by their first characters only; a new key is shown
+// once, in the response creating it.
+func (s *Handler) adminKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listKeys(w)
+	case http.MethodPost:
+		var admin bool
+		if raw := r.URL.Query().Get("admin"); raw != "" {
+			var err error
+			if admin, err = strconv.ParseBool(raw); err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidParam, "Parameter 'admin' must be true or false.")
+				return
+			}
+		}
+		s.changeKeys(w, r, "", r.URL.Query().Get("name"), admin)
+	case http.MethodDelete:
+		s.changeKeys(w, r, r.URL.Query().Get("id"), "", false)
+	default:
+		writeMethodNotAllowed(w, "GET, POST, DELETE")
+	}
+}
+
+// adminKeysRotate replaces the key ?id= with a new one of the same name.
+func (s *Handler) adminKeysRotate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, codeMandatory, "Mandatory parameter 'id' was not sent, was empty/null, or malformed.")
+		return
+	}
+	s.changeKeys(w, r, id, "", false)
+}
+
+var (
+	errKeysDisabled = errors.New("API keys are not enabled, start the proxy with --api-keys-file")
+	errKeyNotFound  = errors.New("no API key with this id")
+	errLastKey      = errors.New("the last API key can't be revoked")
+	errLastAdminKey = errors.New("the last admin key can't be revoked without an admin token")
+)
+
+// changeKeys revokes the key revokeID and/or adds a new key, an admin key
+// with admin, and writes the key file. With both, the new key takes the
+// revoked key's name and admin flag (rotation).
+func (s *Handler) changeKeys(w http.ResponseWriter, r *http.Request, revokeID, name string, admin bool) {
+	// Managing keys always takes admin credentials, even where the routes
+	// don't say so
+	proxyKeys.mu.Lock()
+	enabled := proxyKeys.keys != nil
+	proxyKeys.mu.Unlock()
+	_, _, authorized := adminIdentity(r)
+	if !enabled {
+		writeError(w, http.StatusConflict, codeUnsupported, errKeysDisabled.Error())
+		return
+	}
+	action := auditAction(r)
+	if !authorized {
+		s.audit(r, action, audit.OutcomeDenied, nil)
+		s.authorizeAdmin(w, r)
+		return
+	}
+
+	rotate := revokeID != "" && r.URL.Path == "/admin/keys/rotate"
+	if revokeID == "" && strings.ContainsAny(name, "\r\n#") {
+		writeError(w, http.StatusBadRequest, codeInvalidParam, "Parameter 'name' must be a single line without #.")
+		return
+	}
+	if r.Method == http.MethodDelete && revokeID == "" {
+		writeError(w, http.StatusBadRequest, codeMandatory, "Mandatory parameter 'id' was not sent, was empty/null, or malformed.")
+		return
+	}
+
+	newKey, newName, newAdmin, err := proxyKeys.update(revokeID, name, admin, rotate || revokeID == "")
+	if err != nil {
+		s.audit(r, action, audit.OutcomeFailed, map[string]interface{}{"id": revokeID, "error": err.Error()})
+	}
+	switch {
+	case errors.Is(err, errLastKey), errors.Is(err, errLastAdminKey):
+		writeError(w, http.StatusConflict, codeUnsupported, err.Error())
+		return
+	case errors.Is(err, errKeyNotFound):
+		writeError(w, http.StatusNotFound, codeInvalidParam, err.Error())
+		return
+	case err != nil:
+		log.Errorf("API key file update failed (error: %s).", err)
+		writeError(w, http.StatusInternalServerError, codeUnknown, "Updating the API key file failed.")
+		return
+	}
+
+	response := map[string]interface{}{}
+	details := map[string]interface{}{}
+	if revokeID != "" {
+		response["revoked"] = revokeID
+		details["revoked"] = revokeID
+	}
+	if newKey != "" {
+		sum := sha256.Sum256([]byte(newKey))
+		id := hex.EncodeToString(sum[:6])
+		response["id"], response["name"], response["key"], response["admin"] = id, newName, newKey, newAdmin
+		details["created"], details["name"], details["admin"] = id, newName, newAdmin
+	}
+	s.audit(r, action, audit.OutcomeSuccess, details)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if newKey != "" {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// update rewrites the key file without the key revokeID and, with create,
+// with a new key appended, then loads it. The new key is returned; it is an
+// admin key with admin or when it replaces one.
+func (k *apiKeys) update(revokeID, name string, admin, create bool) (newKey, newName string, newAdmin bool, err error) {
+	k.fileMu.Lock()
+	defer k.fileMu.Unlock()
+
+	k.mu.Lock()
+	path := k.path
+	k.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", false, err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if revokeID != "" {
+		found, revokedAdmin, remaining, remainingAdmins := false, false, 0, 0
+		for i, line := range lines {
+			key, keyName, keyAdmin := parseKeyLine(line)
+			if key == "" || strings.HasPrefix(key, "#") {
+				continue
+			}
+			sum := sha256.Sum256([]byte(key))
+			if hex.EncodeToString(sum[:6]) == revokeID {
+				found, revokedAdmin = true, keyAdmin
+				lines[i] = ""
+				if name == "" {
+					name = keyName
+				}
+				admin = admin || keyAdmin
+				continue
+			}
+			remaining++
+			if keyAdmin {
+				remainingAdmins++
+			}
+		}
+		if !found {
+			return "", "", false, errKeyNotFound
+		}
+		if remaining == 0 && !create {
+			return "", "", false, errLastKey
+		}
+		if revokedAdmin && remainingAdmins == 0 && !create && !adminTokenSet() {
+			return "", "", false, errLastAdminKey
+		}
+	}
+
+	if create {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", "", false, err
+		}
+		newKey, newName, newAdmin = hex.EncodeToString(b), strings.TrimSpace(name), admin
+		if n := len(lines); n > 0 && lines[n-1] != "" && !strings.HasSuffix(lines[n-1], "\n") {
+			lines[n-1] += "\n"
+		}
+		line := strings.TrimSpace(newKey + " " + newName)
+		if newAdmin {
+			line = "admin " + line
+		}
+		lines = append(lines, line+"\n")
+	}
+
+	// Replace the file atomically so a crash can't leave it half written
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".api_keys-*")
+	if err != nil {
+		return "", "", false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(lines, "")); err != nil {
+		tmp.Close()
+		return "", "", false, err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return "", "", false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", "", false, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", "", false, err
+	}
+	return newKey, newName, newAdmin, k.reload()
+}
+
+func (s *Handler) listKeys(w http.ResponseWriter) {
+	proxyKeys.mu.Lock()
+	stats := make([]apiKeyStats, 0, len(proxyKeys.keys))
+	for _, key := range proxyKeys.keys {
+		st := apiKeyStats{ID: key.id, Key: key.hint, Name: key.name, Admin: key.admin, Requests: key.requests, LastIP: key.lastIP, Routes: make(map[string]int64, len(key.routes))}
+		if !key.lastUsed.IsZero() {
+			lastUsed := key.lastUsed
+			st.LastUsed = &lastUsed
+		}
+		for route, n := range key.routes {
+			st.Routes[route] = n
+		}
+		stats = append(stats, st)
+	}
+	enabled := proxyKeys.keys != nil
+	routes := append([]string(nil), proxyKeys.routes...)
+	proxyKeys.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Name != stats[j].Name {
+			return stats[i].Name < stats[j].Name
+		}
+		return stats[i].Key < stats[j].Key
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": enabled,
+		"routes":  routes,
+		"keys":    stats,
+	})
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testBotKey   = "3f9a1c0e7b2d4e8f9a6b5c4d3e2f1a0b"
+	testAdminKey = "8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f"
+	testToken    = "abcdefabcdefabcdef123"
+)
+
+// loadTestKeys loads a key file with a bot key and an admin key, and the
+// admin token when token is set.
+func loadTestKeys(t *testing.T, token string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "api_keys")
+	if err := os.WriteFile(path, []byte(testBotKey+" bot\nadmin "+testAdminKey+" ops\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := LoadAPIKeys(ctx, path, []string{"/admin", "/restart"}); err != nil {
+		t.Fatal(err)
+	}
+	SetAdminToken(token)
+	t.Cleanup(func() {
+		cancel()
+		SetAdminToken("")
+		proxyKeys.mu.Lock()
+		proxyKeys.keys = nil
+		proxyKeys.mu.Unlock()
+	})
+	return path
+}
+
+func TestParseKeyLine(t *testing.T) {
+	tests := []struct {
+		line      string
+		key, name string
+		admin     bool
+	}{
+		{testBotKey, testBotKey, "", false},
+		{testBotKey + " grafana board", testBotKey, "grafana board", false},
+		{"admin " + testAdminKey, testAdminKey, "", true},
+		{"  admin " + testAdminKey + " ops \n", testAdminKey, "ops", true},
+		{testBotKey + " admin", testBotKey, "admin", false},
+	}
+	for _, tt := range tests {
+		key, name, admin := parseKeyLine(tt.line)
+		if key != tt.key || name != tt.name || admin != tt.admin {
+			t.Errorf("parseKeyLine(%q) = %q, %q, %v, want %q, %q, %v", tt.line, key, name, admin, tt.key, tt.name, tt.admin)
+		}
+	}
+}
+
+func TestAdminIdentity(t *testing.T) {
+	loadTestKeys(t, testToken)
+
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		wantOK  bool
+		wantKey bool
+	}{
+		{"admin key", "X-API-Key", testAdminKey, true, true},
+		{"bot key", "X-API-Key", testBotKey, false, false},
+		{"token", "Authorization", "Bearer " + testToken, true, false},
+		{"wrong token", "Authorization", "Bearer " + testToken + "x", false, false},
+		{"nothing", "", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/restart", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			_, keyID, ok := adminIdentity(r)
+			if ok != tt.wantOK || (keyID != "") != tt.wantKey {
+				t.Errorf("adminIdentity() = %q, %v, want ok %v", keyID, ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAdminRoutesRejectBotKeys(t *testing.T) {
+	loadTestKeys(t, "")
+	h := &Handler{ctx: context.Background(), class: service.SPOT}
+
+	r := httptest.NewRequest("POST", "/admin/keys?name=x", nil)
+	r.Header.Set("X-API-Key", testBotKey)
+	w := httptest.NewRecorder()
+	if h.authorizeAdmin(w, r) || w.Code != http.StatusUnauthorized {
+		t.Errorf("bot key authorized for an admin route, status %d", w.Code)
+	}
+
+	r.Header.Set("X-API-Key", testAdminKey)
+	if !h.authorizeAdmin(httptest.NewRecorder(), r) {
+		t.Errorf("admin key rejected")
+	}
+}
+
+func TestChangeKeys(t *testing.T) {
+	path := loadTestKeys(t, testToken)
+	h := &Handler{ctx: context.Background(), class: service.SPOT}
+
+	call := func(method, target string, header, value string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		if strings.HasPrefix(target, "/admin/keys/rotate") {
+			h.adminKeysRotate(w, r)
+		} else {
+			h.adminKeys(w, r)
+		}
+		return w
+	}
+
+	if w := call("POST", "/admin/keys?name=x", "X-API-Key", testBotKey); w.Code != http.StatusUnauthorized {
+		t.Errorf("bot key created a key, status %d", w.Code)
+	}
+
+	w := call("POST", "/admin/keys?name=oncall&admin=true", "Authorization", "Bearer "+testToken)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("token couldn't create a key, status %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		ID    string `json:"id"`
+		Key   string `json:"key"`
+		Admin bool   `json:"admin"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || !created.Admin {
+		t.Fatalf("created %s, want an admin key", w.Body)
+	}
+
+	w = call("POST", "/admin/keys/rotate?id="+created.ID, "X-API-Key", created.Key)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("admin key couldn't rotate itself, status %d: %s", w.Code, w.Body)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), " oncall\n") || strings.Count(string(data), "admin ") != 2 {
+		t.Errorf("key file after rotation:\n%s", data)
+	}
+}
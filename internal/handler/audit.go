@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"binance-proxy/internal/audit"
+	"binance-proxy/internal/service"
+	"net/http"
+)
+
+// auditAction returns the audited admin action a request asks for, or "" for
+// requests that don't change anything.
+func auditAction(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return ""
+	}
+	switch r.URL.Path {
+	case "/restart":
+		return audit.ActionRestart
+	case "/admin/bans":
+		return audit.ActionBanClear
+	case "/admin/keys":
+		if r.Method == http.MethodDelete {
+			return audit.ActionKeyRevoke
+		}
+		return audit.ActionKeyCreate
+	case "/admin/keys/rotate":
+		return audit.ActionKeyRotate
+	case "/admin/runtime":
+		return audit.ActionRuntimeChange
+	case "/admin/cache/load":
+		return audit.ActionCacheLoad
+	case "/admin/streams":
+		return audit.ActionStreamControl
+	}
+	return ""
+}
+
+// audit records an admin action of this class requested by r.
+func (s *Handler) audit(r *http.Request, action, outcome string, details map[string]interface{}) {
+	auditRequest(r, s.class, action, outcome, details)
+}
+
+// auditRequest records an admin action requested by r, with the caller's
+// address and the API key or token it authenticated with.
+func auditRequest(r *http.Request, class service.Class, action, outcome string, details map[string]interface{}) {
+	e := audit.Event{
+		Action:  action,
+		Outcome: outcome,
+		Class:   string(class),
+		Client:  clientIP(r),
+		Details: details,
+	}
+	e.Identity, e.KeyID, _ = adminIdentity(r)
+	audit.Record(e)
+}
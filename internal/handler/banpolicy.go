@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Ban policies decide how requests are answered while the upstream API bans
+// this proxy.
+const (
+	// BanPolicyEmpty answers with an empty body and 429 Too Many Requests.
+	BanPolicyEmpty = "empty-429"
+	// BanPolicyStaleCache answers from the last cached websocket data when
+	// there is some, marked with X-Stale, and falls back to BanPolicyEmpty.
+	BanPolicyStaleCache = "stale-cache"
+	// BanPolicyBlockAndQueue holds requests until the ban is lifted, within
+	// their upstream deadline, and falls back to BanPolicyEmpty.
+	BanPolicyBlockAndQueue = "block-and-queue"
+	// BanPolicyPassThrough ignores the ban and forwards requests as usual,
+	// returning Binance's own responses.
+	BanPolicyPassThrough = "pass-through"
+)
+
+// banPollInterval is how often held requests check whether the ban is over.
+const banPollInterval = 250 * time.Millisecond
+
+var banPolicies = []string{BanPolicyEmpty, BanPolicyStaleCache, BanPolicyBlockAndQueue, BanPolicyPassThrough}
+
+// banQueued counts the requests held by BanPolicyBlockAndQueue.
+var banQueued atomic.Int64
+
+var (
+	banPolicyMu sync.RWMutex
+	banPolicy   = BanPolicyEmpty
+)
+
+// SetBanPolicy selects how requests are answered during a ban.
+func SetBanPolicy(policy string) error {
+	for _, p := range banPolicies {
+		if p == policy {
+			banPolicyMu.Lock()
+			defer banPolicyMu.Unlock()
+			banPolicy = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown ban policy %q, valid values are %s", policy, strings.Join(banPolicies, ", "))
+}
+
+func currentBanPolicy() string {
+	banPolicyMu.RLock()
+	defer banPolicyMu.RUnlock()
+	return banPolicy
+}
+
+// handleBan applies the ban policy to a request arriving during a ban. It
+// reports whether r was answered; otherwise it should be served as usual.
+func (s *Handler) handleBan(w http.ResponseWriter, r *http.Request) bool {
+	switch currentBanPolicy() {
+	case BanPolicyPassThrough:
+		return false
+	case BanPolicyBlockAndQueue:
+		if s.awaitBanLift(r) {
+			return false
+		}
+	}
+	s.banResponse(w, r)
+	return true
+}
+
+// awaitBanLift holds r until the ban is lifted and reports whether it was
+// lifted before the request's upstream deadline.
+func (s *Handler) awaitBanLift(r *http.Request) bool {
+	banQueued.Add(1)
+	defer banQueued.Add(-1)
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout(r))
+	defer cancel()
+
+	t := time.NewTicker(banPollInterval)
+	defer t.Stop()
+	for service.GetBanDetector().IsBanned(s.class) {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-t.C:
+		}
+	}
+	return true
+}
+
+// banResponse answers r locally according to the ban policy.
+func (s *Handler) banResponse(w http.ResponseWriter, r *http.Request) {
+	if currentBanPolicy() == BanPolicyStaleCache && s.serveStale(w, r) {
+		return
+	}
+	s.returnEmptyResponse(w, r)
+}
+
+// setBackoffHeaders tells clients when the ban is expected to end.
+func (s *Handler) setBackoffHeaders(h http.Header) {
+	if bd := service.GetBanDetector(); bd != nil {
+		if banned, until := bd.GetBanStatus(s.class); banned {
+			secs := int(time.Until(until).Seconds())
+			if secs < 1 {
+				secs = 30
+			}
+			h.Set("Retry-After", fmt.Sprintf("%d", secs))
+			h.Set("X-Backoff-Until", until.Format(time.RFC3339))
+		}
+	}
+}
+
+// serveStale answers klines, depth and ticker requests from whatever the
+// websocket caches hold, without starting streams or waiting for them. It
+// reports false when nothing is cached for the request.
+func (s *Handler) serveStale(w http.ResponseWriter, r *http.Request) bool {
+	query := r.URL.Query()
+	symbol := query.Get("symbol")
+	if symbol == "" {
+		return false
+	}
+
+	var response interface{}
+	switch r.URL.Path {
+	case "/api/v3/klines", "/fapi/v1/klines":
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 500
+		}
+		limit = min(limit, maxKlineLimit(s.class))
+		klines := s.srv.CacheSnapshot(symbol, query.Get("interval")).Klines
+		if len(klines) == 0 {
+			return false
+		}
+		if len(klines) > limit {
+			klines = klines[len(klines)-limit:]
+		}
+		rows := make([][]interface{}, len(klines))
+		for i, k := range klines {
+			rows[i] = klineRow(k)
+		}
+		response = rows
+
+	case "/api/v3/depth", "/fapi/v1/depth":
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 20
+		}
+		depth := s.srv.CacheSnapshot(symbol, "").Depth
+		if depth == nil {
+			return false
+		}
+		response = depthResponse(depth, limit)
+
+	case "/api/v3/ticker/24hr":
+		ticker := s.srv.CacheSnapshot(symbol, "").Ticker
+		if ticker == nil {
+			return false
+		}
+		response = ticker
+
+	default:
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Data-Source", "stale-cache")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Stale", "1")
+	s.setBackoffHeaders(w.Header())
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(response)
+	return true
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxBatchKlineSymbols bounds the symbols of one /proxy/v1/klines request.
+const maxBatchKlineSymbols = 200
+
+// batchKlines serves /proxy/v1/klines?symbols=BTCUSDT,ETHUSDT&interval=5m,
+// the klines of many symbols in one response keyed by symbol. Symbols are
+// looked up like single klines requests, starting their streams, but
+// nothing is forwarded: unlisted symbols and symbols whose klines aren't
+// available are null.
+func (s *Handler) batchKlines(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	closedOnly := klinesClosedOnly(r)
+	interval := query.Get("interval")
+	if interval == "" {
+		writeError(w, http.StatusBadRequest, codeMandatory, "Mandatory parameter 'interval' was not sent, was empty/null, or malformed.")
+		return
+	}
+	if _, ok := service.INTERVAL_2_DURATION[interval]; !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidParam, "Parameter 'interval' is not a valid kline interval.")
+		return
+	}
+
+	var symbols []string
+	seen := make(map[string]bool)
+	for _, symbol := range strings.Split(query.Get("symbols"), ",") {
+		if symbol = strings.ToUpper(strings.TrimSpace(symbol)); symbol != "" && !seen[symbol] {
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+	if len(symbols) == 0 {
+		writeError(w, http.StatusBadRequest, codeMandatory, "Mandatory parameter 'symbols' was not sent, was empty/null, or malformed.")
+		return
+	}
+	if len(symbols) > maxBatchKlineSymbols {
+		writeError(w, http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("Parameter 'symbols' must list 1 to %d symbols.", maxBatchKlineSymbols))
+		return
+	}
+
+	limit := 500
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			limit = 0
+		}
+	}
+	retention := service.KlineRetention(interval)
+	if closedOnly {
+		retention--
+	}
+	if maxLimit := min(maxKlineLimit(s.class), retention); limit <= 0 || limit > maxLimit {
+		writeError(w, http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("Parameter 'limit' must be between 1 and %d.", maxLimit))
+		return
+	}
+
+	span := traceLookup(r, "klines.batch", query.Get("symbols"))
+	span.SetAttr("binance.interval", interval)
+	result := make(map[string]interface{}, len(symbols))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	cached, faked := 0, false
+	for _, symbol := range symbols {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var rows interface{}
+			var fake bool
+			// Unlisted symbols would hold the response until their stream
+			// gives up initializing
+			if !s.srv.Unlisted(symbol) {
+				if data := s.srv.Klines(r.Context(), symbol, interval); data != nil {
+					rows, fake = s.klineRows(symbol, interval, data, limit, closedOnly)
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			result[symbol] = rows
+			if rows != nil {
+				cached++
+			}
+			faked = faked || fake
+		}()
+	}
+	wg.Wait()
+	span.SetAttr("binance_proxy.hit", cached == len(symbols))
+	span.End()
+
+	if closedOnly {
+		w.Header().Set("X-Closed-Only", "1")
+	}
+	if faked {
+		w.Header().Set("X-Fake-Candle", "1")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Data-Source", s.streamSource())
+	w.Header().Set("X-Proxy-Cached-Symbols", strconv.Itoa(cached))
+	s.writeJSON(w, r, result)
+}
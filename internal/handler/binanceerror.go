@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Binance error codes of errors the proxy answers itself.
+const (
+	codeUnknown      = -1000 // UNKNOWN
+	codeDisconnected = -1001 // DISCONNECTED, internal error
+	codeTooMany      = -1003 // TOO_MANY_REQUESTS
+	codeTimeout      = -1007 // TIMEOUT
+	codeOverloaded   = -1008 // SERVER_BUSY
+	codeUnsupported  = -1020 // UNSUPPORTED_OPERATION
+	codeMandatory    = -1102 // MANDATORY_PARAM_EMPTY_OR_MALFORMED
+	codeInvalidParam = -1130 // INVALID_PARAMETER
+	codeRejectedKey  = -2015 // REJECTED_MBX_KEY
+)
+
+// binanceError is Binance's error object.
+type binanceError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// errorBody returns the JSON of a Binance error object.
+func errorBody(code int, msg string) []byte {
+	body, _ := json.Marshal(binanceError{Code: code, Msg: msg})
+	return body
+}
+
+// writeError answers with status and a Binance error object, so clients
+// handle errors generated by the proxy like Binance's. Diagnostic headers
+// such as Data-Source are left to the caller.
+func writeError(w http.ResponseWriter, status, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(errorBody(code, msg))
+}
+
+// writeMethodNotAllowed answers requests with a method the endpoint doesn't
+// take; allow lists the methods it does.
+func writeMethodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "This operation is not supported; the endpoint takes "+allow+".")
+}
+
+// banProtectionBody is the body of ban-protection 429 responses, worded
+// like Binance's own ban error.
+func (s *Handler) banProtectionBody() []byte {
+	msg := fmt.Sprintf("Way too many requests; %s API access is banned or rate limited, answered by the proxy's ban protection.", s.class)
+	if bd := service.GetBanDetector(); bd != nil {
+		if banned, until := bd.GetBanStatus(s.class); banned && !until.IsZero() {
+			msg = fmt.Sprintf("Way too many requests; %s API access is banned until %d, answered by the proxy's ban protection.", s.class, until.UnixMilli())
+		}
+	}
+	return errorBody(codeTooMany, msg)
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// cachedEndpoint describes an upstream endpoint the proxy can serve locally.
+type cachedEndpoint struct {
+	Path    string          `json:"path"`
+	Classes []service.Class `json:"classes"`
+	Source  string          `json:"source"`
+	Limits  string          `json:"limits,omitempty"`
+	// Params are the query parameters, Required those of them that must be set
+	Params   []string `json:"params,omitempty"`
+	Required []string `json:"required,omitempty"`
+}
+
+// Query parameters shared by the cached endpoints.
+var (
+	klineParams = []string{"symbol", "interval", "startTime", "endTime", "limit", "closedOnly"}
+	klineSymbol = []string{"symbol", "interval"}
+)
+
+var cachedEndpoints = []cachedEndpoint{
+	{Path: "/api/v3/klines", Classes: []service.Class{service.SPOT}, Source: "websocket", Limits: "limit <= 1000 and the interval's kline retention, no startTime/endTime", Params: klineParams, Required: klineSymbol},
+	{Path: "/fapi/v1/klines", Classes: []service.Class{service.FUTURES}, Source: "websocket", Limits: "limit <= 1500 and the interval's kline retention, no startTime/endTime", Params: klineParams, Required: klineSymbol},
+	{Path: "/fapi/v1/markPriceKlines", Classes: []service.Class{service.FUTURES}, Source: "websocket", Limits: "limit <= 1500 and the interval's kline retention, no startTime/endTime", Params: klineParams, Required: klineSymbol},
+	{Path: "/fapi/v1/indexPriceKlines", Classes: []service.Class{service.FUTURES}, Source: "websocket", Limits: "pair instead of symbol, limit <= 1500 and the interval's kline retention, no startTime/endTime", Params: []string{"pair", "interval", "startTime", "endTime", "limit", "closedOnly"}, Required: []string{"pair", "interval"}},
+	{Path: "/api/v3/depth", Classes: []service.Class{service.SPOT}, Source: "websocket", Limits: "5 <= limit <= 20", Params: []string{"symbol", "limit"}, Required: []string{"symbol"}},
+	{Path: "/fapi/v1/depth", Classes: []service.Class{service.FUTURES}, Source: "websocket", Limits: "5 <= limit <= 20", Params: []string{"symbol", "limit"}, Required: []string{"symbol"}},
+	{Path: "/api/v3/ticker/24hr", Classes: []service.Class{service.SPOT}, Source: "websocket", Limits: "symbol or symbols required, symbols without a cached ticker are forwarded", Params: []string{"symbol", "symbols", "type"}},
+	{Path: "/api/v3/ticker/price", Classes: []service.Class{service.SPOT}, Source: "websocket", Limits: "symbol or symbols required, symbols without a cached ticker are forwarded", Params: []string{"symbol", "symbols"}},
+	{Path: "/api/v3/avgPrice", Classes: []service.Class{service.SPOT}, Source: "websocket", Limits: "symbol required, computed from the 1m klines", Params: []string{"symbol"}, Required: []string{"symbol"}},
+	{Path: "/api/v3/time", Classes: []service.Class{service.SPOT}, Source: "clock", Limits: "forwarded until the first time sync"},
+	{Path: "/fapi/v1/time", Classes: []service.Class{service.FUTURES}, Source: "clock", Limits: "forwarded until the first time sync"},
+	{Path: "/fapi/v1/ticker/price", Classes: []service.Class{service.FUTURES}, Source: "websocket", Limits: "symbol required, forwarded until the ticker is cached", Params: []string{"symbol"}},
+	{Path: "/api/v3/trades", Classes: []service.Class{service.SPOT}, Source: "websocket", Limits: "limit <= the trades retention, no fromId", Params: []string{"symbol", "limit"}, Required: []string{"symbol"}},
+	{Path: "/fapi/v1/trades", Classes: []service.Class{service.FUTURES}, Source: "websocket", Limits: "limit <= the trades retention, no fromId", Params: []string{"symbol", "limit"}, Required: []string{"symbol"}},
+	{Path: "/api/v3/exchangeInfo", Classes: []service.Class{service.SPOT}, Source: "cache", Params: []string{"symbol", "symbols", "permissions"}},
+	{Path: "/fapi/v1/exchangeInfo", Classes: []service.Class{service.FUTURES}, Source: "cache"},
+}
+
+// extensionEndpoints are proxy specific endpoints that don't exist upstream.
+var extensionEndpoints = []string{"/status", "/healthz", "/readyz", "/restart", "/ws", "/sse", "/capabilities", "/metrics", "/stats/sources", "/events", "/admin/recovery", "/admin/bans", "/admin/keys", "/admin/keys/rotate", "/admin/config", "/admin/runtime", "/admin/cache/dump", "/admin/cache/load", "/admin/streams", "/proxy/v1/klines", "/proxy/v1/export", "/proxy/v1/capabilities", "/openapi.json"}
+
+var (
+	buildInfoMu    sync.RWMutex
+	version        string
+	buildtime      string
+	enabledClasses []service.Class
+)
+
+// SetBuildInfo records the version reported by /capabilities.
+func SetBuildInfo(v, bt string) {
+	buildInfoMu.Lock()
+	defer buildInfoMu.Unlock()
+	version, buildtime = v, bt
+}
+
+// SetEnabledClasses records which market classes this process serves.
+func SetEnabledClasses(classes ...service.Class) {
+	buildInfoMu.Lock()
+	defer buildInfoMu.Unlock()
+	enabledClasses = classes
+}
+
+func (s *Handler) capabilities(w http.ResponseWriter) {
+	intervals := make([]string, 0, len(service.INTERVAL_2_DURATION))
+	for interval := range service.INTERVAL_2_DURATION {
+		intervals = append(intervals, interval)
+	}
+	sort.Slice(intervals, func(i, j int) bool {
+		return service.INTERVAL_2_DURATION[intervals[i]] < service.INTERVAL_2_DURATION[intervals[j]]
+	})
+
+	endpoints := make([]cachedEndpoint, 0, len(cachedEndpoints))
+	for _, e := range cachedEndpoints {
+		if servesClass(e, s.class) {
+			endpoints = append(endpoints, e)
+		}
+	}
+
+	streams := []string{service.StreamKline, service.StreamDepth, service.StreamTicker}
+
+	buildInfoMu.RLock()
+	response := map[string]interface{}{
+		"version":    version,
+		"build_time": buildtime,
+		"class":      string(s.class),
+		"classes":    enabledClasses,
+		"intervals":  intervals,
+		"endpoints":  endpoints,
+		"extensions": extensionEndpoints,
+		"caches":     s.srv.CacheSizes(),
+		"features": map[string]interface{}{
+			"fake_klines":      s.enableFakeKline,
+			"websocket_fanout": true,
+			"streams":          streams,
+		},
+	}
+	buildInfoMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ChaosConfig injects faults into the proxy's own answers, so bot authors can
+// check how their error handling copes with a misbehaving exchange. It only
+// applies to the Binance API paths and the downstream /ws and /sse streams,
+// never to the proxy's own status and admin endpoints.
+type ChaosConfig struct {
+	// Latency is added to every request, plus a random share of Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+	// ErrorRate is the share of requests answered with one of Errors
+	// instead of being served.
+	ErrorRate float64
+	Errors    []int
+	// WSDrop is the mean lifetime of downstream websockets and server-sent
+	// events streams before the proxy cuts them; 0 keeps them open.
+	WSDrop time.Duration
+}
+
+// chaosErrors are the statuses chaos can answer with and Binance's body for
+// each of them.
+var chaosErrors = map[int]string{
+	http.StatusTooManyRequests:     `{"code":-1003,"msg":"Too many requests; injected by the proxy's chaos mode."}`,
+	http.StatusTeapot:              `{"code":-1003,"msg":"Way too many requests; IP banned by the proxy's chaos mode."}`,
+	http.StatusInternalServerError: `{"code":-1001,"msg":"Internal error; unable to process your request. Please try again."}`,
+	http.StatusBadGateway:          `{"code":-1001,"msg":"Bad gateway; injected by the proxy's chaos mode."}`,
+	http.StatusServiceUnavailable:  `{"code":-1008,"msg":"Server is currently overloaded with other requests. Please try again in a few minutes."}`,
+	http.StatusGatewayTimeout:      `{"code":-1007,"msg":"Timeout waiting for response from backend server. Send status unknown; execution status unknown."}`,
+}
+
+var chaos atomic.Pointer[ChaosConfig]
+
+// SetChaos enables fault injection; a zero config disables it.
+func SetChaos(c ChaosConfig) error {
+	if c.Latency < 0 || c.Jitter < 0 || c.WSDrop < 0 {
+		return fmt.Errorf("chaos latency, jitter and websocket drop interval must not be negative")
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("chaos error rate must be between 0 and 1, got %g", c.ErrorRate)
+	}
+	if c.ErrorRate > 0 && len(c.Errors) == 0 {
+		return fmt.Errorf("chaos error rate needs at least one status to answer with")
+	}
+	for _, status := range c.Errors {
+		if _, ok := chaosErrors[status]; !ok {
+			return fmt.Errorf("chaos can't answer with status %d, valid statuses are 429, 418, 500, 502, 503 and 504", status)
+		}
+	}
+	if c.Latency == 0 && c.Jitter == 0 && c.ErrorRate == 0 && c.WSDrop == 0 {
+		chaos.Store(nil)
+		return nil
+	}
+	chaos.Store(&c)
+	return nil
+}
+
+func chaosTarget(path string) bool {
+	return strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/fapi/") || path == "/ws" || path == "/sse"
+}
+
+// injectChaos delays r and may answer it with an injected error. It reports
+// whether r was answered.
+func (s *Handler) injectChaos(w http.ResponseWriter, r *http.Request) bool {
+	c := chaos.Load()
+	if c == nil || !chaosTarget(r.URL.Path) {
+		return false
+	}
+
+	if delay := c.Latency + time.Duration(rand.Int64N(int64(c.Jitter)+1)); delay > 0 {
+		t := time.NewTimer(delay)
+		select {
+		case <-r.Context().Done():
+			t.Stop()
+			return true
+		case <-t.C:
+		}
+	}
+
+	if c.ErrorRate == 0 || rand.Float64() >= c.ErrorRate {
+		return false
+	}
+	status := c.Errors[rand.IntN(len(c.Errors))]
+	log.Debugf("%s request %s %s from %s answered with injected status %d", s.class, r.Method, r.RequestURI, clientIP(r), status)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Data-Source", "chaos")
+	w.Header().Set("Cache-Control", "no-store")
+	if status == http.StatusTooManyRequests || status == http.StatusTeapot {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.WriteHeader(status)
+	fmt.Fprint(w, chaosErrors[status])
+	return true
+}
+
+// chaosDrop returns a channel that fires when chaos cuts a downstream stream
+// opened now, or nil if streams aren't dropped.
+func chaosDrop() <-chan time.Time {
+	c := chaos.Load()
+	if c == nil || c.WSDrop == 0 {
+		return nil
+	}
+	return time.After(time.Duration(rand.ExpFloat64() * float64(c.WSDrop)))
+}
@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// clientLimiterIdle is how long an unused per-client limiter is kept.
+const clientLimiterIdle = 10 * time.Minute
+
+// maxClientLimiters bounds the number of clients tracked. Once reached,
+// further clients share the overflow limiter until idle ones are dropped.
+const maxClientLimiters = 10000
+
+// overflowClient is the client key shared by the clients over
+// maxClientLimiters.
+const overflowClient = "overflow"
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+
+	quotaWindow int64 // start of the quota window, unix seconds
+	quotaUsed   int64
+}
+
+// clientLimits enforces a token bucket and an optional request quota per
+// downstream client, identified by its proxy API key when it sends a valid
+// one and by IP otherwise. Exempt callers skip both, but every forwarded request still goes
+// through the upstream weight budget.
+type clientLimits struct {
+	mu          sync.Mutex
+	rate        rate.Limit
+	burst       int
+	quota       int64
+	quotaPeriod time.Duration
+	clients     map[string]*clientLimiter
+	lastGC      time.Time
+	exemptIP    []*net.IPNet
+	exemptKeys  map[string]struct{}
+}
+
+var clientLimit = &clientLimits{clients: make(map[string]*clientLimiter)}
+
+// SetClientRateLimit enables per-client rate limiting; a rate of 0 disables it.
+func SetClientRateLimit(perSecond float64, burst int) {
+	clientLimit.mu.Lock()
+	defer clientLimit.mu.Unlock()
+
+	clientLimit.rate = rate.Limit(perSecond)
+	clientLimit.burst = burst
+	clientLimit.clients = make(map[string]*clientLimiter)
+}
+
+// SetClientQuota allows each client n requests per period, counted in fixed
+// windows; n of 0 disables the quota.
+func SetClientQuota(n int64, period time.Duration) {
+	clientLimit.mu.Lock()
+	defer clientLimit.mu.Unlock()
+
+	clientLimit.quota = n
+	clientLimit.quotaPeriod = period
+	clientLimit.clients = make(map[string]*clientLimiter)
+}
+
+// SetRateLimitExempt configures callers that bypass per-client rate limiting.
+// Entries are IP addresses, CIDR ranges or API keys.
+func SetRateLimitExempt(entries []string) error {
+	var nets []*net.IPNet
+	keys := make(map[string]struct{})
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if ipNet, err := parseIPNet(entry); err == nil {
+			nets = append(nets, ipNet)
+		} else if strings.ContainsAny(entry, "/:.") {
+			return fmt.Errorf("invalid rate limit exemption %q", entry)
+		} else {
+			keys[entry] = struct{}{}
+		}
+	}
+
+	clientLimit.mu.Lock()
+	defer clientLimit.mu.Unlock()
+	clientLimit.exemptIP = nets
+	clientLimit.exemptKeys = keys
+
+	if len(nets)+len(keys) > 0 {
+		log.Infof("%d IP ranges and %d API keys are exempt from per-client rate limiting", len(nets), len(keys))
+	}
+	return nil
+}
+
+// clientKey identifies the client for rate limiting purposes: by the id of
+// the proxy API key it authenticated with, or by its IP address. Headers a
+// client can pick freely, such as X-MBX-APIKEY, would give it a fresh bucket
+// per request.
+func clientKey(r *http.Request) string {
+	if id := proxyKeys.keyID(r); id != "" {
+		return "key:" + id
+	}
+	return "ip:" + clientIP(r)
+}
+
+func (c *clientLimits) exempt(r *http.Request) bool {
+	if key := r.Header.Get("X-MBX-APIKEY"); key != "" {
+		if _, ok := c.exemptKeys[key]; ok {
+			return true
+		}
+	}
+	if ip := net.ParseIP(clientIP(r)); ip != nil {
+		for _, n := range c.exemptIP {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// limitDecision is the outcome of the per-client checks of a request.
+type limitDecision struct {
+	allowed    bool
+	source     string // Data-Source of the rejection
+	retryAfter time.Duration
+}
+
+// check counts the request against the client's rate limit and quota and
+// sets the X-RateLimit-* and X-Quota-* headers describing what is left.
+func (c *clientLimits) check(w http.ResponseWriter, r *http.Request) limitDecision {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if (c.rate <= 0 && c.quota <= 0) || c.exempt(r) {
+		return limitDecision{allowed: true}
+	}
+
+	now := time.Now()
+	if now.Sub(c.lastGC) > time.Minute {
+		c.gc(now)
+	}
+
+	key := clientKey(r)
+	cl, ok := c.clients[key]
+	if !ok && len(c.clients) >= maxClientLimiters {
+		c.gc(now)
+		if len(c.clients) >= maxClientLimiters {
+			key = overflowClient
+			cl, ok = c.clients[key]
+		}
+	}
+	if !ok {
+		cl = &clientLimiter{}
+		if c.rate > 0 {
+			cl.limiter = rate.NewLimiter(c.rate, c.burst)
+		}
+		c.clients[key] = cl
+	}
+	cl.lastSeen = now
+
+	h := w.Header()
+	var quotaReset time.Duration
+	if c.quota > 0 {
+		if window := now.Truncate(c.quotaPeriod).Unix(); window != cl.quotaWindow {
+			cl.quotaWindow = window
+			cl.quotaUsed = 0
+		}
+		quotaReset = time.Unix(cl.quotaWindow, 0).Add(c.quotaPeriod).Sub(now)
+		h.Set("X-Quota-Limit", strconv.FormatInt(c.quota, 10))
+		h.Set("X-Quota-Reset", strconv.Itoa(ceilSeconds(quotaReset)))
+		if cl.quotaUsed >= c.quota {
+			h.Set("X-Quota-Remaining", "0")
+			return limitDecision{source: "client-quota", retryAfter: quotaReset}
+		}
+	}
+
+	if cl.limiter != nil {
+		allowed := cl.limiter.AllowN(now, 1)
+		tokens := cl.limiter.TokensAt(now)
+		h.Set("X-RateLimit-Limit", strconv.Itoa(c.burst))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(max(0, int(tokens))))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(time.Duration((float64(c.burst)-tokens)/float64(c.rate)*float64(time.Second)))))
+		if !allowed {
+			return limitDecision{source: "client-limit", retryAfter: time.Duration((1 - tokens) / float64(c.rate) * float64(time.Second))}
+		}
+	}
+
+	if c.quota > 0 {
+		cl.quotaUsed++
+		h.Set("X-Quota-Remaining", strconv.FormatInt(c.quota-cl.quotaUsed, 10))
+	}
+	return limitDecision{allowed: true}
+}
+
+// gc drops the limiters of idle clients. Callers hold mu.
+func (c *clientLimits) gc(now time.Time) {
+	for k, cl := range c.clients {
+		// Limiters still counting a quota window are kept until it ends
+		if now.Sub(cl.lastSeen) > clientLimiterIdle && (c.quota <= 0 || now.Unix() >= cl.quotaWindow+int64(c.quotaPeriod.Seconds())) {
+			delete(c.clients, k)
+		}
+	}
+	c.lastGC = now
+}
+
+// ceilSeconds rounds d up to whole seconds.
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+func (s *Handler) rejectClient(w http.ResponseWriter, r *http.Request, d limitDecision) {
+	msg := "Too many requests from this client to the proxy; please slow down."
+	if d.source == "client-quota" {
+		msg = "Request quota of this client on the proxy is used up; retry after the quota resets."
+	}
+	log.Debugf("%s request %s %s from %s rejected by per-client %s", s.class, r.Method, r.RequestURI, clientIP(r), d.source)
+
+	w.Header().Set("Data-Source", d.source)
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(d.retryAfter))))
+	writeError(w, http.StatusTooManyRequests, codeTooMany, msg)
+}
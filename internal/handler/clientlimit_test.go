@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientLimitIgnoresUnauthenticatedKeys(t *testing.T) {
+	SetClientRateLimit(1, 2)
+	t.Cleanup(func() { SetClientRateLimit(0, 0) })
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		r := httptest.NewRequest("GET", "/api/v3/depth?symbol=BTCUSDT", nil)
+		r.RemoteAddr = "10.0.0.1:40000"
+		r.Header.Set("X-MBX-APIKEY", fmt.Sprintf("random-%d", i))
+		if clientLimit.check(httptest.NewRecorder(), r).allowed {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("%d requests allowed with changing X-MBX-APIKEY headers, want the burst of 2", allowed)
+	}
+}
+
+func TestClientLimitProxyKeys(t *testing.T) {
+	loadTestKeys(t, "")
+	SetClientRateLimit(1, 1)
+	t.Cleanup(func() { SetClientRateLimit(0, 0) })
+
+	check := func(key string) bool {
+		r := httptest.NewRequest("GET", "/api/v3/depth?symbol=BTCUSDT", nil)
+		r.RemoteAddr = "10.0.0.1:40000"
+		r.Header.Set("X-API-Key", key)
+		return clientLimit.check(httptest.NewRecorder(), r).allowed
+	}
+	// Each valid key has its own bucket, an invalid one counts for the IP
+	if !check(testBotKey) || !check(testAdminKey) || !check("not-a-key-0123456789") {
+		t.Errorf("first request of a client rejected")
+	}
+	if check(testBotKey) || check("another-bad-key-0123") {
+		t.Errorf("second request of a client allowed")
+	}
+}
+
+func TestClientLimitBound(t *testing.T) {
+	SetClientRateLimit(1, 1)
+	t.Cleanup(func() { SetClientRateLimit(0, 0) })
+
+	for i := 0; i < maxClientLimiters+100; i++ {
+		r := httptest.NewRequest("GET", "/api/v3/depth?symbol=BTCUSDT", nil)
+		r.RemoteAddr = fmt.Sprintf("10.%d.%d.%d:40000", i>>16&0xff, i>>8&0xff, i&0xff)
+		clientLimit.check(httptest.NewRecorder(), r)
+	}
+
+	clientLimit.mu.Lock()
+	n := len(clientLimit.clients)
+	_, overflow := clientLimit.clients[overflowClient]
+	clientLimit.mu.Unlock()
+	if n > maxClientLimiters+1 || !overflow {
+		t.Errorf("%d clients tracked (overflow %v), want at most %d", n, overflow, maxClientLimiters+1)
+	}
+
+	// Idle clients make room again
+	clientLimit.mu.Lock()
+	clientLimit.gc(time.Now().Add(2 * clientLimiterIdle))
+	n = len(clientLimit.clients)
+	clientLimit.mu.Unlock()
+	if n != 0 {
+		t.Errorf("%d clients left after they idled", n)
+	}
+}
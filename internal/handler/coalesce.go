@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// bufferedResponse is a ResponseWriter that keeps the response in memory so
+// it can be replayed to several clients.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) Flush() {}
+
+// replay writes the buffered response to w.
+func (b *bufferedResponse) replay(w http.ResponseWriter, coalesced bool) {
+	for k, v := range b.header {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	if coalesced {
+		w.Header().Set("X-Proxy-Coalesced", "1")
+	}
+	status := b.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(b.body.Bytes())
+}
+
+type flight struct {
+	done chan struct{}
+	resp *bufferedResponse
+}
+
+// coalescer lets concurrent identical forwards share one upstream round trip.
+type coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+var forwards = &coalescer{flights: make(map[string]*flight)}
+
+// coalesceKey returns the key identical requests share. Only anonymous GET
+// requests are coalesced; signed or API key requests are user specific.
+// Accept-Encoding is passed to Binance, so requests only share a response
+// encoded the way they asked for.
+func coalesceKey(r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet || r.Header.Get("X-MBX-APIKEY") != "" || r.URL.Query().Has("signature") {
+		return "", false
+	}
+	return r.Method + " " + r.URL.RequestURI() + " " + r.Header.Get("Accept-Encoding"), true
+}
+
+// forwardCoalesced forwards the request unless an identical one is already in
+// flight, in which case it waits for and replays that response. The leading
+// request is detached from its client, so a leader hanging up doesn't fail
+// the requests waiting on it; the upstream deadline still applies.
+func (s *Handler) forwardCoalesced(w http.ResponseWriter, r *http.Request, key string) {
+	forwards.mu.Lock()
+	if f, ok := forwards.flights[key]; ok {
+		forwards.mu.Unlock()
+		// Waiting for the leading request is waiting for Binance
+		start := time.Now()
+		select {
+		case <-f.done:
+			addUpstreamTime(r.Context(), time.Since(start))
+			f.resp.replay(w, true)
+		case <-r.Context().Done():
+		}
+		return
+	}
+	f := &flight{done: make(chan struct{}), resp: &bufferedResponse{header: make(http.Header)}}
+	forwards.flights[key] = f
+	forwards.mu.Unlock()
+
+	defer func() {
+		forwards.mu.Lock()
+		delete(forwards.flights, key)
+		forwards.mu.Unlock()
+		close(f.done)
+	}()
+
+	s.forward(f.resp, r.WithContext(context.WithoutCancel(r.Context())))
+	f.resp.replay(w, false)
+}
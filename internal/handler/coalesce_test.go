@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"binance-proxy/internal/replay"
+	"binance-proxy/internal/service"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescedForwardEncoding(t *testing.T) {
+	const body = `{"symbol":"BTCUSDT","price":"61000.00"}`
+	var upstreamRequests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		// keep the first request in flight until the second one arrived
+		time.Sleep(200 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			io.WriteString(w, body)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		io.WriteString(zw, body)
+		zw.Close()
+	}))
+	defer upstream.Close()
+	if err := replay.Redirect(upstream.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{ctx: context.Background(), class: service.SPOT}
+	tests := []struct {
+		name           string
+		acceptEncoding string
+	}{
+		{"gzip", "gzip"},
+		{"identity", ""},
+	}
+	responses := make([]*httptest.ResponseRecorder, len(tests))
+	var wg sync.WaitGroup
+	for i, tt := range tests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest("GET", "/api/v3/ticker/price?symbol=BTCUSDT", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			responses[i] = httptest.NewRecorder()
+			h.reverseProxy(responses[i], r)
+		}()
+	}
+	wg.Wait()
+
+	if n := upstreamRequests.Load(); n != 2 {
+		t.Errorf("upstream got %d requests, want one per encoding", n)
+	}
+	for i, tt := range tests {
+		w := responses[i]
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.name, w.Code)
+		}
+		got := w.Body.String()
+		if tt.acceptEncoding == "gzip" {
+			if w.Header().Get("Content-Encoding") != "gzip" {
+				t.Fatalf("%s: Content-Encoding = %q, want gzip", tt.name, w.Header().Get("Content-Encoding"))
+			}
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%s: %s", tt.name, err)
+			}
+			b, _ := io.ReadAll(zr)
+			got = string(b)
+		} else if ce := w.Header().Get("Content-Encoding"); ce != "" {
+			t.Errorf("%s: Content-Encoding = %q for a client not accepting it", tt.name, ce)
+		}
+		if got != body {
+			t.Errorf("%s: body = %q, want %q", tt.name, got, body)
+		}
+	}
+}
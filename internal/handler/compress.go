@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"binance-proxy/internal/metrics"
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// compressMinSize is the smallest JSON response body compressed, 0 while
+// compression is off.
+var compressMinSize atomic.Int64
+
+// SetCompression compresses JSON responses of at least minSize bytes with
+// gzip or deflate for clients that accept it. A minSize of 0 turns
+// compression off.
+func SetCompression(minSize int) {
+	compressMinSize.Store(int64(minSize))
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} {
+		zw, _ := gzip.NewWriterLevel(io.Discard, gzip.BestSpeed)
+		return zw
+	}}
+	zlibWriters = sync.Pool{New: func() interface{} {
+		zw, _ := zlib.NewWriterLevel(io.Discard, zlib.BestSpeed)
+		return zw
+	}}
+)
+
+// compressEncoding picks the encoding r's response is compressed with, ""
+// for none. Streams and the Prometheus endpoint, which negotiates its own
+// compression, are left alone.
+func compressEncoding(r *http.Request) string {
+	if compressMinSize.Load() == 0 || r.Method == http.MethodHead {
+		return ""
+	}
+	switch r.URL.Path {
+	case "/ws", "/sse", "/metrics":
+		return ""
+	}
+	if acceptsEncoding(r, "gzip") {
+		return "gzip"
+	}
+	if acceptsEncoding(r, "deflate") {
+		return "deflate"
+	}
+	return ""
+}
+
+// acceptsEncoding reports whether the client takes responses in coding.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		if !ok {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err != nil || weight > 0
+	}
+	return false
+}
+
+// compressWriter buffers the start of a response until it knows whether the
+// response is large enough to compress, then streams it compressed or as is.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	zw      io.WriteCloser
+	out     countingWriter
+	in      int64
+}
+
+func newCompressWriter(w http.ResponseWriter, encoding string) *compressWriter {
+	return &compressWriter{ResponseWriter: w, encoding: encoding, minSize: int(compressMinSize.Load())}
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.decided {
+		c.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if c.status == 0 {
+		c.status = code
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if !c.decided {
+		c.buf = append(c.buf, p...)
+		if len(c.buf) < c.minSize {
+			return len(p), nil
+		}
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if c.zw != nil {
+		c.in += int64(len(p))
+		return c.zw.Write(p)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// decide sends the header and the buffered body, compressed if large says
+// the body reached the threshold and the response can be compressed.
+func (c *compressWriter) decide(large bool) error {
+	c.decided = true
+	h := c.Header()
+	if large && c.compressible(h) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", c.encoding)
+		h.Add("Vary", "Accept-Encoding")
+		c.out.w = c.ResponseWriter
+		if c.encoding == "gzip" {
+			zw := gzipWriters.Get().(*gzip.Writer)
+			zw.Reset(&c.out)
+			c.zw = zw
+		} else {
+			zw := zlibWriters.Get().(*zlib.Writer)
+			zw.Reset(&c.out)
+			c.zw = zw
+		}
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+
+	buf := c.buf
+	c.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if c.zw != nil {
+		c.in += int64(len(buf))
+		_, err := c.zw.Write(buf)
+		return err
+	}
+	_, err := c.ResponseWriter.Write(buf)
+	return err
+}
+
+func (c *compressWriter) compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" || !strings.Contains(h.Get("Content-Type"), "json") {
+		return false
+	}
+	return c.status == http.StatusOK || c.status >= http.StatusBadRequest
+}
+
+// Close finishes the response: a body below the threshold is sent as is,
+// a compressed one is flushed and its savings counted.
+func (c *compressWriter) Close() {
+	if !c.decided {
+		if c.status == 0 {
+			return
+		}
+		c.decide(false)
+	}
+	if c.zw == nil {
+		return
+	}
+	c.zw.Close()
+	if c.encoding == "gzip" {
+		gzipWriters.Put(c.zw)
+	} else {
+		zlibWriters.Put(c.zw)
+	}
+	c.zw = nil
+	metrics.CompressedResponses.WithLabelValues(c.encoding).Inc()
+	if saved := c.in - c.out.n; saved > 0 {
+		metrics.CompressionSavedBytes.WithLabelValues(c.encoding).Add(float64(saved))
+	}
+}
+
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		c.decide(len(c.buf) >= c.minSize)
+	}
+	if f, ok := c.zw.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := c.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	c.decided = true
+	return h.Hijack()
+}
+
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"binance-proxy/internal/logcache"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// deprecation describes a proxy specific endpoint or behavior that is on its
+// way out. Responses carry Deprecation (RFC 9745) and, once a removal date is
+// known, Sunset (RFC 8594) headers.
+type deprecation struct {
+	Since     time.Time
+	Sunset    time.Time
+	Successor string
+}
+
+// deprecatedEndpoints lists proxy specific endpoints that are deprecated.
+var deprecatedEndpoints = map[string]deprecation{}
+
+// Legacy behaviors that can be switched off per deployment with --disable-legacy.
+const (
+	LegacyEmptyKlinesOnBan = "empty-200-on-ban"
+)
+
+var legacyBehaviors = map[string]struct {
+	description string
+	deprecation deprecation
+}{
+	LegacyEmptyKlinesOnBan: {
+		description: "klines requests get an empty 200 response during a ban instead of the 429 ban-protection response",
+		deprecation: deprecation{Since: time.Date(2025, 8, 11, 0, 0, 0, 0, time.UTC)},
+	},
+}
+
+var (
+	disabledLegacyMu sync.RWMutex
+	disabledLegacy   = map[string]bool{}
+)
+
+// DisableLegacy switches off the named legacy behaviors.
+func DisableLegacy(names []string) error {
+	disabled := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		b, ok := legacyBehaviors[name]
+		if !ok {
+			return fmt.Errorf("unknown legacy behavior %q, valid values are %s", name, strings.Join(LegacyBehaviors(), ", "))
+		}
+		disabled[name] = true
+		log.Infof("Legacy behavior %s disabled: %s", name, b.description)
+	}
+
+	disabledLegacyMu.Lock()
+	defer disabledLegacyMu.Unlock()
+	disabledLegacy = disabled
+	return nil
+}
+
+// LegacyBehaviors returns the names of all legacy behaviors.
+func LegacyBehaviors() []string {
+	names := make([]string, 0, len(legacyBehaviors))
+	for name := range legacyBehaviors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// legacyEnabled reports whether a legacy behavior is still active. When it is,
+// the response is marked deprecated and the usage is logged.
+func legacyEnabled(w http.ResponseWriter, name string) bool {
+	disabledLegacyMu.RLock()
+	disabled := disabledLegacy[name]
+	disabledLegacyMu.RUnlock()
+	if disabled {
+		return false
+	}
+
+	b := legacyBehaviors[name]
+	setDeprecationHeaders(w, b.deprecation)
+	logcache.LogOncePerDuration("warn", fmt.Sprintf("Deprecated legacy behavior %s in use (%s), disable it with --disable-legacy=%s", name, b.description, name))
+	return true
+}
+
+// markDeprecated adds deprecation headers for deprecated proxy endpoints.
+func markDeprecated(w http.ResponseWriter, r *http.Request) {
+	d, ok := deprecatedEndpoints[r.URL.Path]
+	if !ok {
+		return
+	}
+
+	setDeprecationHeaders(w, d)
+	logcache.LogOncePerDuration("warn", fmt.Sprintf("Deprecated endpoint %s requested by %s", r.URL.Path, clientIP(r)))
+}
+
+func setDeprecationHeaders(w http.ResponseWriter, d deprecation) {
+	w.Header().Set("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+	if !d.Sunset.IsZero() {
+		w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Successor != "" {
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", d.Successor))
+	}
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"binance-proxy/internal/service"
 	"encoding/json"
 	"net/http"
 	"strconv"
@@ -20,20 +21,44 @@ func (s *Handler) depth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	depth := s.srv.Depth(symbol)
+	span := traceLookup(r, "depth.lookup", symbol)
+	depth := s.srv.Depth(r.Context(), symbol)
+	span.SetAttr("binance_proxy.hit", depth != nil)
+	span.End()
 	if depth == nil {
 		s.reverseProxy(w, r)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Data-Source", s.streamSource())
+
+	// Use shared buffer pool
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(depthResponse(depth, limitInt)); err != nil {
+		writeError(w, http.StatusInternalServerError, codeUnknown, "An unknown error occurred in the proxy while encoding the response.")
+		return
+	}
+
+	w.Write(buf.Bytes())
+}
+
+// depthResponse renders up to limit levels of depth in the layout of Binance's
+// REST depth response.
+func depthResponse(depth *service.Depth, limit int) map[string]interface{} {
 	bidsLen := len(depth.Bids)
 	asksLen := len(depth.Asks)
 	minLen := bidsLen
 	if asksLen < minLen {
 		minLen = asksLen
 	}
-	if minLen > limitInt {
-		minLen = limitInt
+	if minLen > limit {
+		minLen = limit
 	}
 
 	// Pre-allocate with exact capacity
@@ -51,28 +76,11 @@ func (s *Handler) depth(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Data-Source", "websocket")
-
-	// Use shared buffer pool
-	buf := GetBuffer()
-	defer PutBuffer(buf)
-
-	encoder := json.NewEncoder(buf)
-	encoder.SetEscapeHTML(false)
-
-	response := map[string]interface{}{
+	return map[string]interface{}{
 		"lastUpdateId": depth.LastUpdateID,
 		"E":            depth.Time,
 		"T":            depth.TradeTime,
 		"bids":         bids,
 		"asks":         asks,
 	}
-
-	if err := encoder.Encode(response); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		return
-	}
-
-	w.Write(buf.Bytes())
 }
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+)
+
+// weakETag derives a weak ETag from a response body.
+func weakETag(body []byte) string {
+	h := fnv.New64a()
+	h.Write(body)
+	return fmt.Sprintf(`W/"%016x"`, h.Sum64())
+}
+
+// notModified reports whether the If-None-Match header of r names etag,
+// comparing weakly as for GET requests.
+func notModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || etag == "" {
+		return false
+	}
+	opaque := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+// writeETagged writes body with etag, or only a 304 Not Modified when the
+// client already has it.
+func writeETagged(w http.ResponseWriter, r *http.Request, etag string, body []byte) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if notModified(r, etag) {
+		w.Header().Del("Content-Length")
+		w.Header().Del("Content-Encoding")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(body)
+}
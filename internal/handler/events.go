@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"encoding/json"
+	"net/http"
+)
+
+// events lists the recent events of this class, such as degradation reports
+// written when a ban is lifted.
+func (s *Handler) events(w http.ResponseWriter) {
+	events := []service.Event{}
+	for _, e := range service.Events() {
+		if e.Class == s.class {
+			events = append(events, e)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"class":  string(s.class),
+		"events": events,
+	})
+}
@@ -1,17 +1,107 @@
 package handler
 
 import (
+	"binance-proxy/internal/service"
+	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func (s *Handler) exchangeInfo(w http.ResponseWriter) {
-	data := s.srv.ExchangeInfo()
+func (s *Handler) exchangeInfo(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var (
+		data      []byte
+		stale     bool
+		updatedAt time.Time
+		gzipped   bool
+	)
+	// The futures exchangeInfo takes no parameters, like Binance's
+	full := len(query) == 0 || s.class != service.SPOT
+	if full && acceptsEncoding(r, "gzip") {
+		data, stale, updatedAt = s.srv.GzipExchangeInfo()
+		gzipped = data != nil
+	} else if full {
+		data, stale, updatedAt = s.srv.ExchangeInfoStale()
+	} else {
+		symbols, permissions, ok := exchangeInfoFilter(query)
+		if ok {
+			data, stale, updatedAt, ok = s.srv.FilteredExchangeInfo(symbols, permissions)
+		}
+		// Unknown symbols and invalid combinations get Binance's own error
+		if !ok {
+			s.reverseProxy(w, r)
+			return
+		}
+	}
 	if data == nil {
-		http.Error(w, "ExchangeInfo not available", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, codeDisconnected, "Internal error; exchangeInfo is not available in the proxy yet. Please try again.")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Data-Source", "cache")
-	w.Write(data)
+	if full {
+		w.Header().Set("Vary", "Accept-Encoding")
+	}
+	if gzipped {
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if stale {
+		w.Header().Set("Data-Source", "snapshot")
+		w.Header().Set("X-Stale", "1")
+	} else {
+		w.Header().Set("Data-Source", "cache")
+	}
+	// Every refresh changes serverTime, so the fetch time identifies the
+	// content without hashing megabytes per request
+	var etag string
+	if !updatedAt.IsZero() {
+		w.Header().Set("Age", strconv.Itoa(int(time.Since(updatedAt).Seconds())))
+		etag = `W/"` + strings.ToLower(string(s.class)) + "-" + strconv.FormatInt(updatedAt.UnixNano(), 36) + `"`
+	}
+	writeETagged(w, r, etag, data)
+}
+
+// exchangeInfoFilter parses the symbol, symbols and permissions parameters
+// of a spot exchangeInfo request. ok is false for other parameters and for
+// combinations Binance rejects.
+func exchangeInfoFilter(query map[string][]string) (symbols, permissions []string, ok bool) {
+	for name, values := range query {
+		if len(values) != 1 {
+			return nil, nil, false
+		}
+		switch name {
+		case "symbol":
+			symbols = append(symbols, strings.ToUpper(values[0]))
+		case "symbols":
+			var list []string
+			if err := json.Unmarshal([]byte(values[0]), &list); err != nil || len(list) == 0 {
+				return nil, nil, false
+			}
+			for _, symbol := range list {
+				symbols = append(symbols, strings.ToUpper(symbol))
+			}
+		case "permissions":
+			// A single permission or a JSON list of them
+			value := values[0]
+			if strings.HasPrefix(value, "[") {
+				if err := json.Unmarshal([]byte(value), &permissions); err != nil || len(permissions) == 0 {
+					return nil, nil, false
+				}
+			} else {
+				permissions = []string{value}
+			}
+			for i, p := range permissions {
+				permissions[i] = strings.ToUpper(p)
+			}
+		default:
+			return nil, nil, false
+		}
+	}
+	if len(query) != 1 {
+		return nil, nil, false
+	}
+	return symbols, permissions, true
 }
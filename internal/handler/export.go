@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// exportHeader names the columns of kline exports.
+var exportHeader = []string{
+	"symbol", "interval", "open_time", "open", "high", "low", "close", "volume",
+	"close_time", "quote_volume", "trades", "taker_buy_volume", "taker_buy_quote_volume",
+}
+
+// exportColumns are the Parquet columns of kline exports, in the order of
+// exportHeader. Prices and volumes are doubles there.
+var exportColumns = func() []parquetColumn {
+	columns := make([]parquetColumn, len(exportHeader))
+	for i, name := range exportHeader {
+		columns[i] = parquetColumn{name: name, typ: parquetDouble, converted: parquetNoConversion}
+		switch name {
+		case "symbol", "interval":
+			columns[i].typ, columns[i].converted = parquetByteArray, parquetUTF8
+		case "open_time", "close_time":
+			columns[i].typ, columns[i].converted = parquetInt64, parquetTimestampMilli
+		case "trades":
+			columns[i].typ = parquetInt64
+		}
+	}
+	return columns
+}()
+
+// exportStream is a cached klines stream to export.
+type exportStream struct {
+	symbol, interval string
+}
+
+// export serves /proxy/v1/export, the cached klines of the selected symbols
+// and intervals as CSV or, with format=parquet, as Parquet. symbols= and
+// intervals= are comma separated and default to all cached ones. Nothing is
+// started or requested from Binance. Streams are written one after the other
+// so only one of them is held in memory.
+func (s *Handler) export(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "parquet" {
+		writeError(w, http.StatusBadRequest, codeInvalidParam, "Parameter 'format' must be csv or parquet.")
+		return
+	}
+	closedOnly := klinesClosedOnly(r)
+
+	symbols, intervals := exportFilter(strings.ToUpper(query.Get("symbols"))), exportFilter(query.Get("intervals"))
+	var streams []exportStream
+	for _, h := range s.srv.StreamHealth() {
+		if h.Kind == service.StreamKline && h.Price == "" && h.Initialized && (symbols == nil || symbols[h.Symbol]) && (intervals == nil || intervals[h.Interval]) {
+			streams = append(streams, exportStream{h.Symbol, h.Interval})
+		}
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		a, b := streams[i], streams[j]
+		if a.symbol != b.symbol {
+			return a.symbol < b.symbol
+		}
+		return service.INTERVAL_2_DURATION[a.interval] < service.INTERVAL_2_DURATION[b.interval]
+	})
+
+	now := time.Now().UnixMilli()
+	klines := func(stream exportStream) []*service.Kline {
+		data := s.srv.CacheSnapshot(stream.symbol, stream.interval).Klines
+		for closedOnly && len(data) > 0 && data[len(data)-1].CloseTime >= now {
+			data = data[:len(data)-1]
+		}
+		return data
+	}
+
+	filename := fmt.Sprintf("klines-%s-%d.%s", strings.ToLower(string(s.class)), now, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Data-Source", "cache")
+	w.Header().Set("X-Proxy-Exported-Streams", strconv.Itoa(len(streams)))
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	var err error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write(exportHeader)
+		for _, stream := range streams {
+			for _, k := range klines(stream) {
+				cw.Write([]string{
+					stream.symbol, stream.interval, strconv.FormatInt(k.OpenTime, 10), k.Open, k.High, k.Low, k.Close, k.Volume,
+					strconv.FormatInt(k.CloseTime, 10), k.QuoteAssetVolume, strconv.FormatInt(k.TradeNum, 10), k.TakerBuyBaseAssetVolume, k.TakerBuyQuoteAssetVolume,
+				})
+			}
+			cw.Flush()
+			if err = cw.Error(); err != nil {
+				break
+			}
+			flush()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		err = exportParquet(w, streams, klines, flush)
+	}
+	if err != nil {
+		log.Debugf("%s kline export aborted: %s", s.class, err)
+	}
+}
+
+// exportParquet writes the klines of streams as a Parquet file with a row
+// group per stream.
+func exportParquet(w http.ResponseWriter, streams []exportStream, klines func(exportStream) []*service.Kline, flush func()) error {
+	p, err := newParquetWriter(w, exportColumns)
+	if err != nil {
+		return err
+	}
+	price := func(v string) float64 {
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	for _, stream := range streams {
+		data := klines(stream)
+		values := make([]*parquetValues, len(exportColumns))
+		for i := range values {
+			values[i] = &parquetValues{}
+		}
+		for _, k := range data {
+			values[0].string(stream.symbol)
+			values[1].string(stream.interval)
+			values[2].int64(k.OpenTime)
+			values[3].double(price(k.Open))
+			values[4].double(price(k.High))
+			values[5].double(price(k.Low))
+			values[6].double(price(k.Close))
+			values[7].double(price(k.Volume))
+			values[8].int64(k.CloseTime)
+			values[9].double(price(k.QuoteAssetVolume))
+			values[10].int64(k.TradeNum)
+			values[11].double(price(k.TakerBuyBaseAssetVolume))
+			values[12].double(price(k.TakerBuyQuoteAssetVolume))
+		}
+		if err := p.writeRowGroup(values, len(data)); err != nil {
+			return err
+		}
+		flush()
+	}
+	return p.close()
+}
+
+// exportFilter parses a comma separated list of export symbols or intervals,
+// nil for all.
+func exportFilter(list string) map[string]bool {
+	if list == "" {
+		return nil
+	}
+	filter := make(map[string]bool)
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			filter[v] = true
+		}
+	}
+	return filter
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"binance-proxy/internal/metrics"
+	"binance-proxy/internal/service"
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// forwardLimit bounds the requests forwarded to Binance at once. Requests
+// beyond the limit wait in a queue of bounded length until a slot frees up or
+// their upstream deadline runs out.
+type forwardLimit struct {
+	slots  chan struct{}
+	queue  int64
+	queued atomic.Int64
+}
+
+var forwardSlots atomic.Pointer[forwardLimit]
+
+// SetForwardLimit allows max forwarded requests at once, with up to queue
+// more waiting for a slot. A max of 0 removes the limit.
+func SetForwardLimit(max, queue int) error {
+	if max < 0 || queue < 0 {
+		return fmt.Errorf("forward limit and queue must not be negative")
+	}
+	if max == 0 {
+		forwardSlots.Store(nil)
+		return nil
+	}
+	forwardSlots.Store(&forwardLimit{slots: make(chan struct{}, max), queue: int64(queue)})
+	return nil
+}
+
+var errForwardQueueFull = fmt.Errorf("forward queue is full")
+
+// acquireForward takes a forwarding slot for a request of class, waiting
+// until ctx ends. The returned function gives the slot back.
+func acquireForward(ctx context.Context, class service.Class) (func(), error) {
+	l := forwardSlots.Load()
+	if l == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		if l.queued.Add(1) > l.queue {
+			l.queued.Add(-1)
+			metrics.ForwardRejections.WithLabelValues(string(class), "queue-full").Inc()
+			return nil, errForwardQueueFull
+		}
+		metrics.ForwardQueue.WithLabelValues(string(class)).Inc()
+		select {
+		case l.slots <- struct{}{}:
+			l.queued.Add(-1)
+			metrics.ForwardQueue.WithLabelValues(string(class)).Dec()
+		case <-ctx.Done():
+			l.queued.Add(-1)
+			metrics.ForwardQueue.WithLabelValues(string(class)).Dec()
+			metrics.ForwardRejections.WithLabelValues(string(class), "timeout").Inc()
+			return nil, ctx.Err()
+		}
+	}
+
+	metrics.ForwardsInFlight.WithLabelValues(string(class)).Inc()
+	return func() {
+		metrics.ForwardsInFlight.WithLabelValues(string(class)).Dec()
+		<-l.slots
+	}, nil
+}
+
+// forwardOverloaded answers a request that found the forward queue full.
+func (s *Handler) forwardOverloaded(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("%s request %s %s from %s rejected, the forward queue is full", s.class, r.Method, r.RequestURI, clientIP(r))
+	w.Header().Set("Data-Source", "proxy-overload")
+	w.Header().Set("Retry-After", "1")
+	writeError(w, http.StatusServiceUnavailable, codeOverloaded, "Server is currently overloaded with other requests. Please try again in a few minutes.")
+}
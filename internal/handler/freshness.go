@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"binance-proxy/internal/metrics"
+	"binance-proxy/internal/service"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// forwardForFreshness forwards a request for a cached endpoint when the
+// client asks for fresher data than the cache can give: always with
+// X-Cache-Bypass or fresh=1, and with X-Max-Age when the cached data is
+// older than that. It reports whether the request was forwarded. Requests
+// the cache has no data for yet go their usual way.
+func (s *Handler) forwardForFreshness(w http.ResponseWriter, r *http.Request) bool {
+	if !isCachedEndpoint(r.URL.Path) {
+		return false
+	}
+
+	reason := ""
+	query := r.URL.Query()
+	if query.Has("fresh") {
+		if fresh, _ := strconv.ParseBool(query.Get("fresh")); fresh {
+			reason = "bypass"
+		}
+		query.Del("fresh")
+		r.URL.RawQuery = query.Encode()
+	}
+	if bypass, _ := strconv.ParseBool(r.Header.Get("X-Cache-Bypass")); bypass {
+		reason = "bypass"
+	}
+	if maxAge, ok := requestMaxAge(r); ok && reason == "" {
+		if age, ok := s.cacheAge(r); ok && age > maxAge {
+			reason = "max-age"
+		}
+	}
+	if reason == "" {
+		return false
+	}
+
+	metrics.CacheBypasses.WithLabelValues(string(s.class), reason).Inc()
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.Tracef("%s %s forwarded for freshness (%s)", s.class, r.URL.Path, reason)
+	}
+	w.Header().Set("X-Proxy-Cache-Bypass", reason)
+	s.reverseProxy(w, r)
+	return true
+}
+
+// requestMaxAge returns the oldest cached data r accepts, from X-Max-Age as
+// a Go duration ("500ms") or plain milliseconds like X-Proxy-Timeout.
+func requestMaxAge(r *http.Request) (time.Duration, bool) {
+	hint := r.Header.Get("X-Max-Age")
+	if hint == "" {
+		return 0, false
+	}
+	d, err := time.ParseDuration(hint)
+	if err != nil {
+		ms, err := strconv.ParseInt(hint, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		d = time.Duration(ms) * time.Millisecond
+	}
+	return d, d >= 0
+}
+
+// cacheAge returns the age of the cached data r would be answered from. It
+// is false when nothing is cached for it, or for endpoints that aren't
+// served from a single stream, like the symbols form of the tickers.
+func (s *Handler) cacheAge(r *http.Request) (time.Duration, bool) {
+	query := r.URL.Query()
+	symbol := query.Get("symbol")
+	switch path := r.URL.Path; {
+	case strings.HasSuffix(path, "/exchangeInfo"):
+		data, _, updatedAt := s.srv.ExchangeInfoStale()
+		return time.Since(updatedAt), data != nil
+	case strings.HasSuffix(path, "/time"):
+		return 0, false
+	case strings.HasSuffix(path, "PriceKlines"):
+		price, symbolParam := klinePrice(path)
+		return s.srv.PriceKlinesAge(price, query.Get(symbolParam), query.Get("interval"))
+	case symbol == "":
+		return 0, false
+	case strings.HasSuffix(path, "/klines"):
+		return s.srv.CacheAge(service.StreamKline, symbol, query.Get("interval"))
+	case strings.HasSuffix(path, "/avgPrice"):
+		return s.srv.CacheAge(service.StreamKline, symbol, "1m")
+	case strings.HasSuffix(path, "/depth"):
+		return s.srv.CacheAge(service.StreamDepth, symbol, "")
+	case strings.HasSuffix(path, "/trades"):
+		return s.srv.CacheAge(service.StreamTrade, symbol, "")
+	case strings.Contains(path, "/ticker/"):
+		return s.srv.CacheAge(service.StreamTicker, symbol, "")
+	}
+	return 0, false
+}
@@ -1,17 +1,23 @@
 package handler
 
 import (
+	"binance-proxy/internal/audit"
 	"binance-proxy/internal/logcache"
+	"binance-proxy/internal/metrics"
 	"binance-proxy/internal/service"
+	"binance-proxy/internal/tracing"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -43,7 +49,7 @@ func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
 	return f(r)
 }
 
-func NewHandler(ctx context.Context, class service.Class, enableFakeKline bool, alwaysShowForwards bool) func(w http.ResponseWriter, r *http.Request) {
+func NewHandler(ctx context.Context, class service.Class, enableFakeKline bool, alwaysShowForwards bool) *Handler {
 	handler := &Handler{
 		srv:                service.NewService(ctx, class),
 		class:              class,
@@ -51,8 +57,10 @@ func NewHandler(ctx context.Context, class service.Class, enableFakeKline bool,
 		alwaysShowForwards: alwaysShowForwards,
 	}
 	handler.ctx, handler.cancel = context.WithCancel(ctx)
+	handler.startRecovery()
+	handler.startSLO()
 
-	return handler.Router
+	return handler
 }
 
 type Handler struct {
@@ -61,24 +69,156 @@ type Handler struct {
 
 	class              service.Class
 	srv                *service.Service
+	recovery           *service.AutoRecovery
+	supervisor         *service.StreamSupervisor
+	sources            sourceStats
+	slo                *sloTracker
 	enableFakeKline    bool
 	alwaysShowForwards bool
 }
 
+func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Router(w, r)
+}
+
+// Shutdown closes downstream websockets and drains the upstream websocket
+// services of this class. It should be called after the HTTP server stopped
+// accepting requests.
+func (s *Handler) Shutdown(ctx context.Context) {
+	s.cancel()
+	if pending := s.srv.Close(ctx); pending > 0 {
+		log.Warnf("%s %d websocket services did not stop before the shutdown timeout", s.class, pending)
+	} else {
+		log.Infof("%s websocket services stopped", s.class)
+	}
+}
+
 func (s *Handler) Router(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
+	rec := &responseRecorder{ResponseWriter: w, start: start}
+	w = rec
+	var compress *compressWriter
+	if encoding := compressEncoding(r); encoding != "" {
+		compress = newCompressWriter(w, encoding)
+		w = compress
+	}
+	id := requestID(r)
+	w.Header().Set("X-Request-ID", id)
+	ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "HTTP "+r.Method, tracing.KindServer)
+	r = r.WithContext(withUpstreamTimer(ctx, &rec.upstream))
 
 	// Record the request in status tracker
 	statusTracker := service.GetStatusTracker()
 	statusTracker.RecordRequest()
+	markDeprecated(w, r)
+	switch {
+	case isProbe(r.URL.Path):
+		s.route(w, r)
+	case isAdminRoute(r.URL.Path) && !s.authorizeAdmin(w, r):
+		// answered by authorizeAdmin
+	case !proxyKeys.authorize(r):
+		if action := auditAction(r); action != "" {
+			s.audit(r, action, audit.OutcomeDenied, nil)
+		}
+		s.rejectUnauthorized(w, r)
+	default:
+		if d := clientLimit.check(w, r); !d.allowed {
+			s.rejectClient(w, r, d)
+		} else if !s.injectChaos(w, r) {
+			s.route(w, r)
+		}
+	}
+	if compress != nil {
+		compress.Close()
+	}
+	duration := time.Since(start)
+	source := responseSource(r, rec)
+	s.observe(r, source, rec, duration)
+	s.slo.record(r, source, rec.Status(), duration, start)
+	s.logAccess(r, rec, id, span.TraceID(), source, start, duration)
+	s.endSpan(span, r, rec, id, source)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("%s request %s %s from %s served in %s (request id %s)", s.class, r.Method, r.RequestURI, clientIP(r), duration, id)
+	}
+}
+
+func (s *Handler) route(w http.ResponseWriter, r *http.Request) {
+	if s.forwardForFreshness(w, r) {
+		return
+	}
+
 	switch r.URL.Path {
 	case "/status":
 		s.status(w)
 
+	case "/healthz":
+		s.healthz(w)
+
+	case "/readyz":
+		s.readyz(w)
+
 	case "/restart":
 		s.restart(w, r)
 
-	case "/api/v3/klines", "/fapi/v1/klines":
+	case "/ws":
+		s.ws(w, r)
+
+	case "/sse":
+		s.sse(w, r)
+
+	case "/capabilities", "/proxy/v1/capabilities":
+		s.capabilities(w)
+
+	case "/openapi.json":
+		s.openAPI(w)
+
+	case "/events":
+		s.events(w)
+
+	case "/admin/config":
+		s.adminConfig(w)
+
+	case "/admin/recovery":
+		s.adminRecovery(w)
+
+	case "/admin/bans":
+		s.adminBans(w, r)
+
+	case "/admin/keys":
+		s.adminKeys(w, r)
+
+	case "/admin/keys/rotate":
+		s.adminKeysRotate(w, r)
+
+	case "/admin/runtime":
+		s.adminRuntime(w, r)
+
+	case "/admin/cache/dump":
+		s.adminCacheDump(w, r)
+
+	case "/admin/cache/load":
+		s.adminCacheLoad(w, r)
+
+	case "/admin/streams":
+		s.adminStreams(w, r)
+
+	case "/metrics":
+		buildInfoMu.RLock()
+		classes := enabledClasses
+		buildInfoMu.RUnlock()
+		service.UpdateBanMetrics(classes...)
+		metrics.Handler().ServeHTTP(w, r)
+
+	case "/stats/sources":
+		s.statsSources(w)
+
+	case "/proxy/v1/klines":
+		s.batchKlines(w, r)
+
+	case "/proxy/v1/export":
+		s.export(w, r)
+
+	case "/api/v3/klines", "/fapi/v1/klines", "/fapi/v1/markPriceKlines", "/fapi/v1/indexPriceKlines":
 		s.klines(w, r)
 
 	case "/api/v3/depth", "/fapi/v1/depth":
@@ -87,14 +227,24 @@ func (s *Handler) Router(w http.ResponseWriter, r *http.Request) {
 	case "/api/v3/ticker/24hr":
 		s.ticker(w, r)
 
+	case "/api/v3/ticker/price", "/fapi/v1/ticker/price":
+		s.tickerPrice(w, r)
+
+	case "/api/v3/avgPrice":
+		s.avgPrice(w, r)
+
+	case "/api/v3/time", "/fapi/v1/time":
+		s.serverTime(w, r)
+
+	case "/api/v3/trades", "/fapi/v1/trades":
+		s.trades(w, r)
+
 	case "/api/v3/exchangeInfo", "/fapi/v1/exchangeInfo":
-		s.exchangeInfo(w)
+		s.exchangeInfo(w, r)
 
 	default:
 		s.reverseProxy(w, r)
 	}
-	duration := time.Since(start)
-	log.Debugf("%s request %s %s from %s served in %s", s.class, r.Method, r.RequestURI, r.RemoteAddr, duration)
 }
 
 // HTTP client with connection pooling for reverse proxy
@@ -114,6 +264,9 @@ func getProxyHTTPClient() *http.Client {
 			ForceAttemptHTTP2:   true,
 			// Connection pooling settings for high throughput
 			MaxConnsPerHost: 50,
+			TLSClientConfig: service.UpstreamTLSConfig(),
+			Proxy:           service.UpstreamProxy,
+			DialContext:     service.UpstreamDialContext,
 		}
 
 		proxyHTTPClient = &http.Client{
@@ -165,7 +318,7 @@ func (s *Handler) reverseProxy(w http.ResponseWriter, r *http.Request) {
 	// Validate handler state
 	if s == nil {
 		log.Errorf("Handler is nil in reverseProxy")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeUnknown, "An unknown error occurred in the proxy while processing the request.")
 		return
 	}
 
@@ -176,7 +329,7 @@ func (s *Handler) reverseProxy(w http.ResponseWriter, r *http.Request) {
 
 	if r == nil {
 		log.Errorf("Request is nil in reverseProxy")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeUnknown, "An unknown error occurred in the proxy while processing the request.")
 		return
 	}
 
@@ -185,7 +338,7 @@ func (s *Handler) reverseProxy(w http.ResponseWriter, r *http.Request) {
 		select {
 		case <-s.ctx.Done():
 			logcache.LogOncePerDuration("warn", "Reverse proxy called but context is cancelled")
-			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			writeError(w, http.StatusServiceUnavailable, codeDisconnected, "Internal error; the proxy is shutting down.")
 			return
 		default:
 			// Context is still valid, continue
@@ -197,44 +350,58 @@ func (s *Handler) reverseProxy(w http.ResponseWriter, r *http.Request) {
 	if banDetector != nil && banDetector.IsBanned(s.class) {
 		banned, recoveryTime := banDetector.GetBanStatus(s.class)
 		if banned {
-			msg := fmt.Sprintf("%s API is banned, returning empty response. Recovery time: %v", s.class, recoveryTime)
+			msg := fmt.Sprintf("%s API is banned, answering with the %s ban policy. Recovery time: %v", s.class, currentBanPolicy(), recoveryTime)
 			logcache.LogOncePerDuration("warn", msg)
-			s.returnEmptyResponse(w, r)
-			return
+			if s.handleBan(w, r) {
+				return
+			}
 		}
 	}
 
-	msg := fmt.Sprintf("%s request %s %s from %s is not cachable", s.class, r.Method, r.RequestURI, r.RemoteAddr)
 	if s.alwaysShowForwards {
-		log.Info(msg)
-	} else {
-		log.Trace(msg)
+		log.Infof("%s request %s %s from %s is not cachable", s.class, r.Method, r.RequestURI, clientIP(r))
+	} else if log.IsLevelEnabled(log.TraceLevel) {
+		log.Tracef("%s request %s %s from %s is not cachable", s.class, r.Method, r.RequestURI, clientIP(r))
+	}
+
+	if key, ok := coalesceKey(r); ok {
+		s.forwardCoalesced(w, r, key)
+		return
 	}
+	s.forward(w, r)
+}
 
-	service.RateWait(s.ctx, s.class, r.Method, r.URL.Path, r.URL.Query())
+// forward sends the request upstream once its weight fits the budget.
+func (s *Handler) forward(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout(r))
+	defer cancel()
+	r = r.WithContext(ctx)
 
-	// Use hardcoded endpoints (current working version)
-	var u *url.URL
-	var err error
-	if s.class == service.SPOT {
-		r.Host = "api.binance.com"
-		u, err = url.Parse("https://api.binance.com")
-	} else {
-		r.Host = "fapi.binance.com"
-		u, err = url.Parse("https://fapi.binance.com")
+	release, err := acquireForward(ctx, s.class)
+	if errors.Is(err, errForwardQueueFull) {
+		s.forwardOverloaded(w, r)
+		return
+	} else if err != nil {
+		log.Debugf("%s request %s %s can't get a forwarding slot within its deadline", s.class, r.Method, r.RequestURI)
+		s.gatewayTimeout(w)
+		return
 	}
+	defer release()
 
-	if err != nil || u == nil {
-		logcache.LogOncePerDuration("error", fmt.Sprintf("Failed to parse URL for %s: %v", s.class, err))
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if err := service.RateWait(ctx, s.class, r.Method, r.URL.Path, r.URL.Query()); err != nil {
+		log.Debugf("%s request %s %s can't get upstream weight within its deadline: %s", s.class, r.Method, r.RequestURI, err)
+		s.gatewayTimeout(w)
 		return
 	}
 
+	u := service.UpstreamURL(s.class)
+	r.Host = u.Host
+
 	// Use custom HTTP client with connection pooling
 	httpClient := getProxyHTTPClient()
 	if httpClient == nil {
 		logcache.LogOncePerDuration("error", "HTTP client is nil, cannot create proxy")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeUnknown, "An unknown error occurred in the proxy while processing the request.")
 		return
 	}
 
@@ -246,7 +413,7 @@ func (s *Handler) reverseProxy(w http.ResponseWriter, r *http.Request) {
 
 	// Use ReverseProxy hooks instead of a custom RoundTripper for ban handling.
 	// Wrap transport to be context-aware and fail fast on canceled requests.
-	baseTransport := transport
+	baseTransport := service.UpstreamTransport(transport)
 	contextAwareTransport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
 		if req == nil {
 			return nil, fmt.Errorf("nil request")
@@ -258,6 +425,10 @@ func (s *Handler) reverseProxy(w http.ResponseWriter, r *http.Request) {
 		}
 		return baseTransport.RoundTrip(req)
 	})
+	contextAwareTransport = timedTransport(contextAwareTransport)
+	if tracing.Enabled() {
+		contextAwareTransport = roundTripperFunc(tracing.Transport(contextAwareTransport).RoundTrip)
+	}
 	// IMPORTANT:
 	// - Do NOT write to the ResponseWriter from RoundTrip; it can cause
 	//   ReverseProxy to see a nil *http.Response and trigger panics or
@@ -280,6 +451,13 @@ func (s *Handler) reverseProxy(w http.ResponseWriter, r *http.Request) {
 			req.URL.Scheme = u.Scheme
 			req.URL.Host = u.Host
 			req.Host = u.Host
+			req.Header.Del("X-Proxy-Timeout")
+			req.Header.Del("X-Cache-Bypass")
+			req.Header.Del("X-Max-Age")
+			req.Header.Del("X-API-Key")
+			if ua := service.UpstreamUserAgent(); ua != "" {
+				req.Header.Set("User-Agent", ua)
+			}
 
 			// Preserve the original path and query
 			// req.URL.Path is already set from the original request
@@ -287,22 +465,14 @@ func (s *Handler) reverseProxy(w http.ResponseWriter, r *http.Request) {
 		Transport:  contextAwareTransport,
 		BufferPool: &bufferPool{},
 		ModifyResponse: func(resp *http.Response) error {
+			metrics.UpstreamResponses.WithLabelValues(string(s.class), strconv.Itoa(resp.StatusCode)).Inc()
+
 			bd := service.GetBanDetector()
-			if bd != nil && bd.CheckResponse(s.class, resp, nil) {
+			if bd != nil && bd.CheckResponse(s.class, resp, nil) && currentBanPolicy() != BanPolicyPassThrough {
 				if resp.Body != nil {
 					resp.Body.Close()
 				}
-				var body []byte
-				switch resp.Request.URL.Path {
-				case "/api/v3/klines", "/fapi/v1/klines":
-					body = []byte("[]")
-				case "/api/v3/depth", "/fapi/v1/depth":
-					body = []byte(`{"lastUpdateId":0,"bids":[],"asks":[]}`)
-				case "/api/v3/ticker/24hr":
-					body = []byte("{}")
-				default:
-					body = []byte("{}")
-				}
+				body := s.banProtectionBody()
 				resp.Header.Set("Content-Type", "application/json")
 				resp.Header.Set("Data-Source", "ban-protection")
 				resp.Header.Set("Cache-Control", "no-store")
@@ -311,47 +481,50 @@ func (s *Handler) reverseProxy(w http.ResponseWriter, r *http.Request) {
 				resp.StatusCode = http.StatusTooManyRequests
 				resp.Status = "429 Too Many Requests"
 				// Populate Retry-After based on ban detector recovery time if available
-				if banned, until := bd.GetBanStatus(s.class); banned {
-					secs := int(time.Until(until).Seconds())
-					if secs < 1 {
-						secs = 30
-					}
-					resp.Header.Set("Retry-After", fmt.Sprintf("%d", secs))
-					resp.Header.Set("X-Backoff-Until", until.Format(time.RFC3339))
-				}
+				s.setBackoffHeaders(resp.Header)
 				resp.Header.Set("X-Proxy-Empty", "1")
 				resp.Body = io.NopCloser(bytes.NewReader(body))
 				resp.ContentLength = int64(len(body))
 				resp.Header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
 				logcache.LogOncePerDuration("warn", fmt.Sprintf("%s API banned/limited; returned synthetic response", s.class))
+				return nil
 			}
-			return nil
+			return s.limitResponse(resp)
 		},
 		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			if errors.Is(err, errResponseTooLarge) {
+				writeResponseTooLarge(rw)
+				return
+			}
+
 			// Always log via logcache to avoid noisy net/http defaults
 			logcache.LogOncePerDuration("error", fmt.Sprintf("%s proxy transport error: %v", s.class, err))
+			metrics.UpstreamResponses.WithLabelValues(string(s.class), "error").Inc()
+
+			// The request's own deadline ran out, that's not a sign of a ban
+			if errors.Is(err, context.DeadlineExceeded) && req.Context().Err() != nil {
+				s.gatewayTimeout(rw)
+				return
+			}
 
 			// If ban detector suggests a backoff, reuse the synthetic empty path
 			bd := service.GetBanDetector()
-			if bd != nil && bd.CheckResponse(s.class, nil, err) {
+			if bd != nil && bd.CheckResponse(s.class, nil, err) && currentBanPolicy() != BanPolicyPassThrough {
 				logcache.LogOncePerDuration("warn", fmt.Sprintf("%s API transport error treated as ban", s.class))
-				s.returnEmptyResponse(rw, req)
+				s.banResponse(rw, req)
 				return
 			}
 
 			// Otherwise, send a single controlled JSON 502 response
-			rw.Header().Set("Content-Type", "application/json")
-			rw.Header().Set("Cache-Control", "no-store")
 			rw.Header().Set("Data-Source", "proxy-error")
-			rw.WriteHeader(http.StatusBadGateway)
-			_, _ = rw.Write([]byte(`{"error":"bad_gateway","message":"upstream fetch failed"}`))
+			writeError(rw, http.StatusBadGateway, codeDisconnected, "Internal error; unable to reach Binance from the proxy. Please try again.")
 		},
 	}
 
 	// Additional safety check before calling ServeHTTP
 	if proxy.Director == nil {
 		logcache.LogOncePerDuration("error", "Proxy director is nil, cannot serve request")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeUnknown, "An unknown error occurred in the proxy while processing the request.")
 		return
 	}
 
@@ -360,7 +533,7 @@ func (s *Handler) reverseProxy(w http.ResponseWriter, r *http.Request) {
 		if panicVal := recover(); panicVal != nil {
 			logcache.LogOncePerDuration("error", fmt.Sprintf("Panic recovered in reverseProxy.ServeHTTP for %s %s: %v", r.Method, r.URL.Path, panicVal))
 			defer func() { recover() }()
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, codeUnknown, "An unknown error occurred in the proxy while processing the request.")
 		}
 	}()
 
@@ -368,13 +541,17 @@ func (s *Handler) reverseProxy(w http.ResponseWriter, r *http.Request) {
 	reqCopy := r.Clone(r.Context())
 	if reqCopy == nil {
 		log.Errorf("Failed to clone request")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeUnknown, "An unknown error occurred in the proxy while processing the request.")
 		return
 	}
 
-	log.Debugf("About to call proxy.ServeHTTP for %s %s", reqCopy.Method, reqCopy.URL.Path)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("About to call proxy.ServeHTTP for %s %s", reqCopy.Method, reqCopy.URL.Path)
+	}
 	proxy.ServeHTTP(w, reqCopy)
-	log.Debugf("Completed proxy.ServeHTTP for %s %s", reqCopy.Method, reqCopy.URL.Path)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("Completed proxy.ServeHTTP for %s %s", reqCopy.Method, reqCopy.URL.Path)
+	}
 }
 
 func (s *Handler) returnEmptyResponse(w http.ResponseWriter, r *http.Request) {
@@ -384,32 +561,11 @@ func (s *Handler) returnEmptyResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-Proxy-Empty", "1")
 
 	// Set backoff headers if we have a recovery time
-	if bd := service.GetBanDetector(); bd != nil {
-		if banned, until := bd.GetBanStatus(s.class); banned {
-			secs := int(time.Until(until).Seconds())
-			if secs < 1 {
-				secs = 30
-			}
-			w.Header().Set("Retry-After", fmt.Sprintf("%d", secs))
-			w.Header().Set("X-Backoff-Until", until.Format(time.RFC3339))
-		}
-	}
-
-	var response []byte
-	switch r.URL.Path {
-	case "/api/v3/klines", "/fapi/v1/klines":
-		response = []byte("[]") // Empty klines array
-	case "/api/v3/depth", "/fapi/v1/depth":
-		response = []byte(`{"lastUpdateId":0,"bids":[],"asks":[]}`)
-	case "/api/v3/ticker/24hr":
-		response = []byte("{}") // Empty ticker object
-	default:
-		response = []byte("{}") // Generic empty response
-	}
+	s.setBackoffHeaders(w.Header())
 
 	// Return 429 to signal clients to slow down/back off
 	w.WriteHeader(http.StatusTooManyRequests)
-	w.Write(response)
+	w.Write(s.banProtectionBody())
 }
 
 func (s *Handler) status(w http.ResponseWriter) {
@@ -442,11 +598,17 @@ func (s *Handler) status(w http.ResponseWriter) {
 		"ban_info": map[string]interface{}{
 			"banned":        isBanned,
 			"recovery_time": nil,
+			"policy":        currentBanPolicy(),
+			"queued":        banQueued.Load(),
 		},
 		"config": map[string]interface{}{
 			"fake_kline_enabled":   s.enableFakeKline,
 			"always_show_forwards": s.alwaysShowForwards,
 		},
+		"duplicate_streams":  service.DuplicateStreams(),
+		"upstream_endpoints": service.UpstreamEndpoints(s.class),
+		"streams":            s.srv.StreamHealth(),
+		"slo":                s.sloStatus(),
 	}
 
 	if isBanned {
@@ -457,46 +619,45 @@ func (s *Handler) status(w http.ResponseWriter) {
 	json.NewEncoder(w).Encode(response)
 }
 
+var restartConfirm atomic.Bool
+
+// SetRestartConfirm makes /restart require ?confirm= with the class of the
+// port, so a restart can't be triggered for the wrong market by accident.
+func SetRestartConfirm(required bool) {
+	restartConfirm.Store(required)
+}
+
+// restart re-initializes the websocket services of the class in-process,
+// like auto recovery does. It takes a POST; like every admin route it is
+// authenticated by authorizeAdmin.
 func (s *Handler) restart(w http.ResponseWriter, r *http.Request) {
-	// Security check - only allow GET requests
-	if r.Method != http.MethodGet {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Write([]byte(`{"error": "only GET method allowed", "status": "failed"}`))
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
 		return
 	}
+	details := map[string]interface{}{"user_agent": r.UserAgent()}
+	if restartConfirm.Load() && !strings.EqualFold(r.URL.Query().Get("confirm"), string(s.class)) {
+		details["error"] = "confirmation missing"
+		s.audit(r, audit.ActionRestart, audit.OutcomeFailed, details)
+		writeError(w, http.StatusBadRequest, codeMandatory, fmt.Sprintf("Mandatory parameter 'confirm' must be %s.", strings.ToLower(string(s.class))))
+		return
+	}
+
+	s.audit(r, audit.ActionRestart, audit.OutcomeSuccess, details)
 
-	log.Warnf("RESTART requested from %s for class %s", r.RemoteAddr, s.class)
+	log.Warnf("Executing restart for class %s...", s.class)
+	s.srv.Reinit()
 
-	// Send immediate response before restart
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	response := map[string]interface{}{
-		"message":   "Restart initiated",
+		"message":   "Websocket services re-initialized",
 		"status":    "success",
 		"class":     string(s.class),
 		"timestamp": time.Now().Format(time.RFC3339),
-		"warning":   "Service will restart in 2 seconds. This will interrupt all active connections.",
+		"warning":   "Every upstream stream of this market reconnects and initializes again through REST. Client connections and the other market are kept.",
 	}
-
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Errorf("Failed to encode restart response: %v", err)
 	}
-
-	// Flush the response to ensure it's sent
-	if flusher, ok := w.(http.Flusher); ok {
-		flusher.Flush()
-	}
-
-	// Give the response time to be sent
-	go func() {
-		time.Sleep(2 * time.Second)
-		log.Warnf("Executing restart for class %s...", s.class)
-
-		// Cancel the context to trigger graceful shutdown
-		s.cancel()
-
-		// Give some time for graceful shutdown, then force exit
-		time.Sleep(3 * time.Second)
-		log.Fatalf("Force restart for class %s", s.class)
-	}()
 }
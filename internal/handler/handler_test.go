@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"binance-proxy/internal/mock"
+	"binance-proxy/internal/replay"
+	"binance-proxy/internal/service"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var cacheHit struct {
+	once    sync.Once
+	handler *Handler
+	err     error
+}
+
+// cacheHitHandler returns a spot handler whose exchangeInfo is cached from a
+// mock Binance, so GET /api/v3/exchangeInfo never leaves the proxy. It's
+// shared by the test binary as the upstream HTTP client is created once.
+func cacheHitHandler(tb testing.TB) *Handler {
+	tb.Helper()
+	cacheHit.once.Do(func() {
+		upstream := httptest.NewServer(mock.NewServer([]string{"BTCUSDT"}))
+		if cacheHit.err = replay.Redirect(upstream.URL); cacheHit.err != nil {
+			return
+		}
+		h := NewHandler(context.Background(), service.SPOT, false, false)
+		for deadline := time.Now().Add(10 * time.Second); h.srv.ExchangeInfo() == nil && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if w := serveCacheHit(h); w.Code != http.StatusOK || w.Header().Get("Data-Source") == "" {
+			cacheHit.err = fmt.Errorf("exchangeInfo not served from the cache: status %d, Data-Source %q", w.Code, w.Header().Get("Data-Source"))
+			return
+		}
+		cacheHit.handler = h
+	})
+	if cacheHit.err != nil {
+		tb.Fatal(cacheHit.err)
+	}
+	return cacheHit.handler
+}
+
+func serveCacheHit(h *Handler) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/api/v3/exchangeInfo", nil)
+	w := httptest.NewRecorder()
+	h.Router(w, r)
+	return w
+}
+
+// withLogLevel runs f with the log level set to level.
+func withLogLevel(level log.Level, f func()) {
+	previous := log.GetLevel()
+	log.SetLevel(level)
+	defer log.SetLevel(previous)
+	f()
+}
+
+// TestRouterCacheHitLogAllocs checks that logging on the cache-hit path
+// allocates nothing at info level: serving a cached response takes as many
+// allocations as with logging turned off entirely.
+func TestRouterCacheHitLogAllocs(t *testing.T) {
+	h := cacheHitHandler(t)
+	allocs := func(level log.Level) (n float64) {
+		withLogLevel(level, func() {
+			n = testing.AllocsPerRun(200, func() { serveCacheHit(h) })
+		})
+		return n
+	}
+
+	off, info, debug := allocs(log.PanicLevel), allocs(log.InfoLevel), allocs(log.DebugLevel)
+	if info > off {
+		t.Errorf("cache hit takes %.0f allocations at info level, %.0f with logging off; info level logging must not allocate", info, off)
+	}
+	// The debug log of every request shows the measurement covers logging
+	if debug <= info {
+		t.Errorf("cache hit takes %.0f allocations at debug level, %.0f at info level; expected the debug log to allocate", debug, info)
+	}
+}
+
+// BenchmarkRouterCacheHit serves a cached response through the router at
+// info level. Its allocations are the ones of the response itself, which
+// TestRouterCacheHitLogAllocs checks don't grow from logging.
+func BenchmarkRouterCacheHit(b *testing.B) {
+	h := cacheHitHandler(b)
+	for _, level := range []log.Level{log.InfoLevel, log.PanicLevel} {
+		b.Run(level.String(), func(b *testing.B) {
+			withLogLevel(level, func() {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					serveCacheHit(h)
+				}
+			})
+		})
+	}
+}
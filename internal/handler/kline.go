@@ -5,24 +5,61 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// closedCandlesOnly leaves the open candle out of kline responses unless a
+// request asks for it with closedOnly=false.
+var closedCandlesOnly atomic.Bool
+
+// SetClosedCandlesOnly makes kline responses hold only closed candles by
+// default, without the open candle and without fake candles.
+func SetClosedCandlesOnly(closed bool) {
+	closedCandlesOnly.Store(closed)
+}
+
+// fakeCandleMarker is put in the unused last field of fake candles.
+var fakeCandleMarker atomic.Value // string
+
+// SetFakeCandleMarker sets the value of the unused last field of fake
+// candles, "0" as in real candles by default, so consumers that can't read
+// the X-Fake-Candle header can still tell them apart.
+func SetFakeCandleMarker(marker string) {
+	fakeCandleMarker.Store(marker)
+}
+
 func (s *Handler) klines(w http.ResponseWriter, r *http.Request) {
+	closedOnly := klinesClosedOnly(r)
+
 	// Check if API is banned
 	banDetector := service.GetBanDetector()
 	if banDetector.IsBanned(s.class) {
-		log.Debugf("%s klines request returning empty due to API ban", s.class)
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Data-Source", "ban-protection")
-		w.Write([]byte("[]"))
-		return
+		switch policy := currentBanPolicy(); policy {
+		case BanPolicyBlockAndQueue, BanPolicyPassThrough:
+			if s.handleBan(w, r) {
+				return
+			}
+		default:
+			if policy == BanPolicyStaleCache && s.serveStale(w, r) {
+				return
+			}
+			if !legacyEnabled(w, LegacyEmptyKlinesOnBan) {
+				s.returnEmptyResponse(w, r)
+				return
+			}
+			log.Debugf("%s klines request returning empty due to API ban", s.class)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Data-Source", "ban-protection")
+			w.Write([]byte("[]"))
+			return
+		}
 	}
 
-	var fakeKlineTimestampOpen int64 = 0
-	symbol := r.URL.Query().Get("symbol")
+	price, symbolParam := klinePrice(r.URL.Path)
+	symbol := r.URL.Query().Get(symbolParam)
 	interval := r.URL.Query().Get("interval")
 	limit := r.URL.Query().Get("limit")
 	if limit == "" {
@@ -30,20 +67,78 @@ func (s *Handler) klines(w http.ResponseWriter, r *http.Request) {
 	}
 	limitInt, err := strconv.Atoi(limit)
 
+	// The open candle kept in the cache doesn't count towards the limit
+	retention := service.KlineRetention(interval)
+	if closedOnly {
+		retention--
+	}
+
 	switch {
-	case err != nil, limitInt <= 0, limitInt > 1000, r.URL.Query().Get("startTime") != "", r.URL.Query().Get("endTime") != "", symbol == "", interval == "":
-		log.Tracef("%s %s@%s kline proxying via REST", s.class, symbol, interval)
+	case err != nil, limitInt <= 0, limitInt > maxKlineLimit(s.class), limitInt > retention, r.URL.Query().Get("startTime") != "", r.URL.Query().Get("endTime") != "", symbol == "", interval == "":
+		if log.IsLevelEnabled(log.TraceLevel) {
+			log.Tracef("%s %s@%s kline proxying via REST", s.class, symbol, interval)
+		}
+		if closedOnly {
+			closeKlinesRequest(w, r, interval, time.Now())
+		}
 		s.reverseProxy(w, r)
 		return
 	}
 
-	data := s.srv.Klines(symbol, interval)
+	span := traceLookup(r, "klines.lookup", symbol)
+	span.SetAttr("binance.interval", interval)
+	data := s.srv.PriceKlines(r.Context(), price, symbol, interval)
+	span.SetAttr("binance_proxy.klines", len(data))
+	span.End()
 	if data == nil {
-		log.Tracef("%s %s@%s kline proxying via REST", s.class, symbol, interval)
+		if log.IsLevelEnabled(log.TraceLevel) {
+			log.Tracef("%s %s@%s kline proxying via REST", s.class, symbol, interval)
+		}
+		if closedOnly {
+			closeKlinesRequest(w, r, interval, time.Now())
+		}
 		s.reverseProxy(w, r)
 		return
 	}
 
+	klines, faked := s.klineRows(symbol, interval, data, limitInt, closedOnly)
+	if closedOnly {
+		w.Header().Set("X-Closed-Only", "1")
+	}
+	if faked {
+		w.Header().Set("X-Fake-Candle", "1")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Data-Source", s.streamSource())
+
+	// Use shared buffer pool
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(klines); err != nil {
+		writeError(w, http.StatusInternalServerError, codeUnknown, "An unknown error occurred in the proxy while encoding the response.")
+		return
+	}
+
+	w.Write(buf.Bytes())
+}
+
+// klineRows returns the rows of up to limit of the latest klines in data,
+// without the open candle with closedOnly, and followed by fake candles when
+// they are enabled and the current candle wasn't received yet.
+func (s *Handler) klineRows(symbol, interval string, data []*service.Kline, limitInt int, closedOnly bool) (klines []interface{}, faked bool) {
+	var fakeKlineTimestampOpen int64 = 0
+	currentTime := time.Now().UnixNano() / 1e6
+	if closedOnly {
+		for len(data) > 0 && data[len(data)-1].CloseTime >= currentTime {
+			data = data[:len(data)-1]
+		}
+	}
+
 	dataLen := len(data)
 	minLen := dataLen
 	if minLen > limitInt {
@@ -51,73 +146,180 @@ func (s *Handler) klines(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Pre-allocate with exact length (not just capacity)
-	klines := make([]interface{}, minLen)
+	klines = make([]interface{}, minLen)
 
 	// Calculate start index once
 	startIdx := dataLen - minLen
 	for i := 0; i < minLen; i++ {
-		dataIdx := startIdx + i
-		klines[i] = []interface{}{
-			data[dataIdx].OpenTime,
-			data[dataIdx].Open,
-			data[dataIdx].High,
-			data[dataIdx].Low,
-			data[dataIdx].Close,
-			data[dataIdx].Volume,
-			data[dataIdx].CloseTime,
-			data[dataIdx].QuoteAssetVolume,
-			data[dataIdx].TradeNum,
-			data[dataIdx].TakerBuyBaseAssetVolume,
-			data[dataIdx].TakerBuyQuoteAssetVolume,
-			"0",
-		}
+		klines[i] = klineRow(data[startIdx+i])
 	}
 
-	currentTime := time.Now().UnixNano() / 1e6
 	if dataLen > 0 && currentTime > data[dataLen-1].CloseTime {
 		fakeKlineTimestampOpen = data[dataLen-1].CloseTime + 1
-		log.Tracef("%s %s@%s kline requested for %s but not yet received", s.class, symbol, interval, strconv.FormatInt(fakeKlineTimestampOpen, 10))
-	}
-
-	if s.enableFakeKline && dataLen > 0 && currentTime > data[dataLen-1].CloseTime {
-		log.Tracef("%s %s@%s kline faking candle for timestamp %s", s.class, symbol, interval, strconv.FormatInt(fakeKlineTimestampOpen, 10))
-		lastData := data[dataLen-1]
-		fakeKline := []interface{}{
-			lastData.CloseTime + 1,
-			lastData.Close,
-			lastData.Close,
-			lastData.Close,
-			lastData.Close,
-			"0.0",
-			lastData.CloseTime + 1 + (lastData.CloseTime - lastData.OpenTime),
-			"0.0",
-			0,
-			"0.0",
-			"0.0",
-			"0",
+		if open, ok := candleOpenTime(interval, time.UnixMilli(currentTime)); ok && open > fakeKlineTimestampOpen {
+			fakeKlineTimestampOpen = open
 		}
+		if log.IsLevelEnabled(log.TraceLevel) {
+			log.Tracef("%s %s@%s kline requested for %s but not yet received", s.class, symbol, interval, strconv.FormatInt(fakeKlineTimestampOpen, 10))
+		}
+	}
 
-		if len(klines) >= minLen {
-			klines[len(klines)-1] = fakeKline
+	if s.enableFakeKline && !closedOnly && dataLen > 0 && currentTime > data[dataLen-1].CloseTime {
+		if log.IsLevelEnabled(log.TraceLevel) {
+			log.Tracef("%s %s@%s kline faking candle for timestamp %s", s.class, symbol, interval, strconv.FormatInt(fakeKlineTimestampOpen, 10))
+		}
+		fake := fakeKlines(data[dataLen-1], interval, fakeKlineTimestampOpen, limitInt)
+		if !fakeCandleFill.Load() && len(klines) > 0 {
+			// A single fake candle takes the place of the last row, as it
+			// always did, so responses keep their length
+			klines[len(klines)-1] = fake[0]
 		} else {
-			klines = append(klines, fakeKline)
+			klines = append(klines, fake...)
+			if len(klines) > limitInt {
+				klines = klines[len(klines)-limitInt:]
+			}
 		}
+		faked = true
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Data-Source", "websocket")
+	return klines, faked
+}
 
-	// Use shared buffer pool
-	buf := GetBuffer()
-	defer PutBuffer(buf)
+// klinePrice is the price of the klines requested at path, empty for trade
+// klines, and the name of the symbol parameter, pair for index price klines.
+func klinePrice(path string) (price, symbolParam string) {
+	switch path {
+	case "/fapi/v1/markPriceKlines":
+		return service.KlinePriceMark, "symbol"
+	case "/fapi/v1/indexPriceKlines":
+		return service.KlinePriceIndex, "pair"
+	}
+	return "", "symbol"
+}
 
-	encoder := json.NewEncoder(buf)
-	encoder.SetEscapeHTML(false)
+// fakeCandleFill fills every interval missed since the last real candle with
+// a fake candle, instead of only the open one.
+var fakeCandleFill atomic.Bool
 
-	if err := encoder.Encode(klines); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+// SetFakeCandleFill makes fake candles fill the whole gap after the last
+// real candle.
+func SetFakeCandleFill(fill bool) {
+	fakeCandleFill.Store(fill)
+}
+
+// fakeKlines returns flat candles at the last close of last for the candle
+// opening at open, preceded by up to limit-1 candles filling the gap since
+// last when --fake-candle-fill is on.
+func fakeKlines(last *service.Kline, interval string, open int64, limit int) []interface{} {
+	marker, _ := fakeCandleMarker.Load().(string)
+	if marker == "" {
+		marker = "0"
+	}
+	row := func(open int64) []interface{} {
+		closeTime := candleStep(interval, open, 1) - 1
+		if closeTime < open {
+			// An interval the proxy doesn't know, keep the length of the last candle
+			closeTime = open + last.CloseTime - last.OpenTime
+		}
+		return []interface{}{open, last.Close, last.Close, last.Close, last.Close, "0.0", closeTime, "0.0", 0, "0.0", "0.0", marker}
+	}
+
+	rows := []interface{}{row(open)}
+	if _, known := service.INTERVAL_2_DURATION[interval]; known && fakeCandleFill.Load() {
+		for prev := candleStep(interval, open, -1); prev > last.OpenTime && len(rows) < limit; prev = candleStep(interval, prev, -1) {
+			rows = append(rows, row(prev))
+		}
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	return rows
+}
+
+// candleStep moves the candle open time open of interval by n candles.
+func candleStep(interval string, open int64, n int) int64 {
+	if interval == "1M" {
+		return time.UnixMilli(open).UTC().AddDate(0, n, 0).UnixMilli()
+	}
+	return open + int64(n)*service.INTERVAL_2_DURATION[interval].Milliseconds()
+}
+
+// klinesClosedOnly reports whether r asks for closed candles only, with the
+// closedOnly parameter or through --closed-candles-only. The parameter is
+// removed, Binance doesn't know it.
+func klinesClosedOnly(r *http.Request) bool {
+	closed := closedCandlesOnly.Load()
+	query := r.URL.Query()
+	if query.Has("closedOnly") {
+		closed, _ = strconv.ParseBool(query.Get("closedOnly"))
+		query.Del("closedOnly")
+		r.URL.RawQuery = query.Encode()
+	}
+	return closed
+}
+
+// closeKlinesRequest ends a klines request that is forwarded before the
+// candle open at now opened, so Binance leaves the open candle out too. An
+// earlier endTime of the client is kept.
+func closeKlinesRequest(w http.ResponseWriter, r *http.Request, interval string, now time.Time) {
+	open, ok := candleOpenTime(interval, now)
+	if !ok {
 		return
 	}
+	end := open - 1
+	query := r.URL.Query()
+	if endTime, err := strconv.ParseInt(query.Get("endTime"), 10, 64); err == nil && endTime < end {
+		end = endTime
+	}
+	query.Set("endTime", strconv.FormatInt(end, 10))
+	r.URL.RawQuery = query.Encode()
+	w.Header().Set("X-Closed-Only", "1")
+}
 
-	w.Write(buf.Bytes())
+// candleOpenTime returns the open time in milliseconds of the candle of
+// interval that is open at now. Binance aligns weeks to Monday and months to
+// their first day, UTC, and all shorter intervals to the Unix epoch.
+func candleOpenTime(interval string, now time.Time) (int64, bool) {
+	now = now.UTC()
+	switch interval {
+	case "1M":
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).UnixMilli(), true
+	case "1w":
+		// The epoch was a Thursday, the first Monday came four days later
+		week, monday := int64(7*24*time.Hour/time.Millisecond), int64(4*24*time.Hour/time.Millisecond)
+		ms := now.UnixMilli()
+		return ms - (ms-monday)%week, true
+	}
+	d, ok := service.INTERVAL_2_DURATION[interval]
+	if !ok {
+		return 0, false
+	}
+	ms, step := now.UnixMilli(), d.Milliseconds()
+	return ms - ms%step, true
+}
+
+// klineRow renders a kline in the array layout of Binance's REST klines response.
+func klineRow(k *service.Kline) []interface{} {
+	return []interface{}{
+		k.OpenTime,
+		k.Open,
+		k.High,
+		k.Low,
+		k.Close,
+		k.Volume,
+		k.CloseTime,
+		k.QuoteAssetVolume,
+		k.TradeNum,
+		k.TakerBuyBaseAssetVolume,
+		k.TakerBuyQuoteAssetVolume,
+		"0",
+	}
+}
+
+// maxKlineLimit is the largest kline limit Binance accepts for class.
+func maxKlineLimit(class service.Class) int {
+	if class == service.FUTURES {
+		return 1500
+	}
+	return 1000
 }
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCandleOpenTime(t *testing.T) {
+	at := func(year int, month time.Month, day, hour, min, sec, ms int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, ms*int(time.Millisecond), time.UTC)
+	}
+	tests := []struct {
+		name     string
+		interval string
+		now      time.Time
+		want     time.Time
+	}{
+		{"1w at Monday midnight", "1w", at(2024, 1, 1, 0, 0, 0, 0), at(2024, 1, 1, 0, 0, 0, 0)},
+		{"1w midweek", "1w", at(2024, 1, 3, 12, 0, 0, 0), at(2024, 1, 1, 0, 0, 0, 0)},
+		{"1w last ms of Sunday", "1w", at(2024, 1, 7, 23, 59, 59, 999), at(2024, 1, 1, 0, 0, 0, 0)},
+		{"1w next Monday", "1w", at(2024, 1, 8, 0, 0, 0, 0), at(2024, 1, 8, 0, 0, 0, 0)},
+		{"1w across new year", "1w", at(2025, 1, 1, 8, 0, 0, 0), at(2024, 12, 30, 0, 0, 0, 0)},
+		{"1w in other zone", "1w", time.Date(2024, 1, 8, 0, 30, 0, 0, time.FixedZone("CET", 3600)), at(2024, 1, 1, 0, 0, 0, 0)},
+		{"1M first ms", "1M", at(2024, 3, 1, 0, 0, 0, 0), at(2024, 3, 1, 0, 0, 0, 0)},
+		{"1M last ms of leap February", "1M", at(2024, 2, 29, 23, 59, 59, 999), at(2024, 2, 1, 0, 0, 0, 0)},
+		{"1M last ms of year", "1M", at(2024, 12, 31, 23, 59, 59, 999), at(2024, 12, 1, 0, 0, 0, 0)},
+		{"1M in other zone", "1M", time.Date(2024, 3, 1, 0, 30, 0, 0, time.FixedZone("CET", 3600)), at(2024, 2, 1, 0, 0, 0, 0)},
+		{"1d", "1d", at(2024, 5, 17, 13, 45, 0, 0), at(2024, 5, 17, 0, 0, 0, 0)},
+		{"4h", "4h", at(2024, 5, 17, 13, 45, 0, 0), at(2024, 5, 17, 12, 0, 0, 0)},
+		{"1m boundary", "1m", at(2024, 5, 17, 13, 45, 0, 0), at(2024, 5, 17, 13, 45, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := candleOpenTime(tt.interval, tt.now)
+			if !ok {
+				t.Fatalf("candleOpenTime(%q) not ok", tt.interval)
+			}
+			if want := tt.want.UnixMilli(); got != want {
+				t.Errorf("candleOpenTime(%q, %s) = %s, want %s", tt.interval, tt.now, time.UnixMilli(got).UTC(), tt.want)
+			}
+		})
+	}
+
+	if _, ok := candleOpenTime("7m", time.Now()); ok {
+		t.Errorf("candleOpenTime(\"7m\") ok for an unknown interval")
+	}
+}
+
+func TestCloseKlinesRequest(t *testing.T) {
+	ms := func(t time.Time) string { return strconv.FormatInt(t.UnixMilli(), 10) }
+	midweek := time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)
+	monday := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	before := func(t time.Time) string { return strconv.FormatInt(t.UnixMilli()-1, 10) }
+
+	tests := []struct {
+		name     string
+		interval string
+		now      time.Time
+		endTime  string // client's endTime, "" for none
+		want     string // endTime sent to Binance, "" for an unchanged request
+	}{
+		{"1w without endTime", "1w", midweek, "", before(monday)},
+		{"1w later endTime", "1w", midweek, ms(midweek), before(monday)},
+		{"1w endTime in the open candle", "1w", midweek, ms(monday), before(monday)},
+		{"1w earlier endTime kept", "1w", midweek, ms(time.Date(2023, 12, 20, 0, 0, 0, 0, time.UTC)), ms(time.Date(2023, 12, 20, 0, 0, 0, 0, time.UTC))},
+		{"1w endTime just before the open candle kept", "1w", midweek, before(monday), before(monday)},
+		{"1w invalid endTime", "1w", midweek, "soon", before(monday)},
+		{"1M at the month boundary", "1M", march, "", before(march)},
+		{"1M later endTime", "1M", march.Add(time.Hour), ms(march.Add(time.Hour)), before(march)},
+		{"1M earlier endTime kept", "1M", march.Add(time.Hour), ms(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)), ms(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))},
+		{"unknown interval", "7m", midweek, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{"symbol": {"BTCUSDT"}, "interval": {tt.interval}, "limit": {"500"}}
+			if tt.endTime != "" {
+				query.Set("endTime", tt.endTime)
+			}
+			r := httptest.NewRequest("GET", "/api/v3/klines?"+query.Encode(), nil)
+			w := httptest.NewRecorder()
+
+			closeKlinesRequest(w, r, tt.interval, tt.now)
+
+			got := r.URL.Query()
+			if tt.want == "" {
+				if r.URL.RawQuery != query.Encode() || w.Header().Get("X-Closed-Only") != "" {
+					t.Errorf("request changed to %q", r.URL.RawQuery)
+				}
+				return
+			}
+			if got.Get("endTime") != tt.want {
+				t.Errorf("endTime = %s, want %s", got.Get("endTime"), tt.want)
+			}
+			if got.Get("symbol") != "BTCUSDT" || got.Get("limit") != "500" || got.Get("interval") != tt.interval {
+				t.Errorf("other parameters changed: %q", r.URL.RawQuery)
+			}
+			if w.Header().Get("X-Closed-Only") != "1" {
+				t.Errorf("X-Closed-Only = %q, want 1", w.Header().Get("X-Closed-Only"))
+			}
+		})
+	}
+}
+
+func TestKlinesClosedOnly(t *testing.T) {
+	t.Cleanup(func() { SetClosedCandlesOnly(false) })
+
+	tests := []struct {
+		name       string
+		defaultOn  bool
+		query      string
+		want       bool
+		wantParams string
+	}{
+		{"default off", false, "symbol=BTCUSDT", false, "symbol=BTCUSDT"},
+		{"default on", true, "symbol=BTCUSDT", true, "symbol=BTCUSDT"},
+		{"parameter on", false, "closedOnly=true&symbol=BTCUSDT", true, "symbol=BTCUSDT"},
+		{"parameter off overrides default", true, "closedOnly=false&symbol=BTCUSDT", false, "symbol=BTCUSDT"},
+		{"invalid parameter", true, "closedOnly=yes&symbol=BTCUSDT", false, "symbol=BTCUSDT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetClosedCandlesOnly(tt.defaultOn)
+			r := httptest.NewRequest("GET", "/api/v3/klines?"+tt.query, nil)
+			if got := klinesClosedOnly(r); got != tt.want {
+				t.Errorf("klinesClosedOnly() = %v, want %v", got, tt.want)
+			}
+			if r.URL.RawQuery != tt.wantParams {
+				t.Errorf("query = %q, want %q", r.URL.RawQuery, tt.wantParams)
+			}
+		})
+	}
+}
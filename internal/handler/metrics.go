@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"binance-proxy/internal/metrics"
+	"binance-proxy/internal/service"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// metricsEndpoint maps a request path to a bounded endpoint label, so clients
+// requesting arbitrary paths can't blow up metric cardinality.
+func metricsEndpoint(path string) string {
+	if isCachedEndpoint(path) || isExtensionEndpoint(path) {
+		return path
+	}
+	return "other"
+}
+
+// degradedSources are Data-Source values of responses that weren't served
+// from fresh data; they are counted in the degradation report.
+var degradedSources = map[string]bool{
+	"ban-protection": true,
+	"proxy-error":    true,
+	"proxy-timeout":  true,
+	"stale-cache":    true,
+	"snapshot":       true,
+}
+
+// isCachedEndpoint reports whether path is served from the cache.
+func isCachedEndpoint(path string) bool {
+	for _, e := range cachedEndpoints {
+		if e.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func isExtensionEndpoint(path string) bool {
+	for _, e := range extensionEndpoints {
+		if e == path {
+			return true
+		}
+	}
+	return false
+}
+
+// responseSource returns the Data-Source of a response, "upstream" for
+// forwarded requests and "proxy" for proxy endpoints without one.
+func responseSource(r *http.Request, rec *responseRecorder) string {
+	if source := rec.Header().Get("Data-Source"); source != "" {
+		return source
+	}
+	if isExtensionEndpoint(r.URL.Path) {
+		return "proxy"
+	}
+	return "upstream"
+}
+
+// streamSource is the Data-Source of responses served from the stream
+// caches, rest-poll while they are refreshed through REST polls.
+func (s *Handler) streamSource() string {
+	if service.Polling(s.class) {
+		return "rest-poll"
+	}
+	return "websocket"
+}
+
+func (s *Handler) observe(r *http.Request, source string, rec *responseRecorder, d time.Duration) {
+	class := string(s.class)
+	endpoint := metricsEndpoint(r.URL.Path)
+	metrics.Requests.WithLabelValues(class, endpoint, source, strconv.Itoa(rec.Status())).Inc()
+	metrics.RequestDuration.WithLabelValues(class, endpoint, source).Observe(d.Seconds())
+
+	countSource(source)
+	if !isExtensionEndpoint(r.URL.Path) {
+		s.sources.record(endpoint, source, time.Now())
+		s.observeSymbol(r, source)
+		if source == "upstream" {
+			metrics.ForwardedRequestDuration.WithLabelValues(class, endpoint).Observe(d.Seconds())
+		} else {
+			metrics.LocalRequestDuration.WithLabelValues(class, endpoint, source).Observe(d.Seconds())
+		}
+	}
+
+	if degradedSources[source] {
+		service.RecordDegradedResponse(s.class, source)
+	}
+}
+
+// maxSources bounds the Data-Source values counted by sourceRequests.
+const maxSources = 64
+
+// sourceRequests counts the requests of all classes by Data-Source.
+var sourceRequests = metrics.NewCounterSet(maxSources, "other")
+
+func countSource(source string) {
+	sourceRequests.Add(source, 1)
+}
+
+// RequestsBySource returns the number of requests served since start by
+// Data-Source, "upstream" for forwarded ones and "proxy" for proxy endpoints.
+func RequestsBySource() map[string]int64 {
+	return sourceRequests.Snapshot()
+}
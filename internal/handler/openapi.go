@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// extensionDoc describes a proxy specific endpoint in the OpenAPI document.
+type extensionDoc struct {
+	methods []string
+	summary string
+}
+
+// extensionDocs holds the methods and summaries of extensionEndpoints.
+var extensionDocs = map[string]extensionDoc{
+	"/status":                {[]string{"get"}, "Health, ban state, streams and configuration of the proxy"},
+	"/healthz":               {[]string{"get"}, "Liveness probe"},
+	"/readyz":                {[]string{"get"}, "Readiness probe, 503 without exchangeInfo or during a ban"},
+	"/restart":               {[]string{"post"}, "Restart all streams of this market"},
+	"/ws":                    {[]string{"get"}, "Websocket fanout of the cached streams"},
+	"/sse":                   {[]string{"get"}, "Server-sent events of the cached streams"},
+	"/capabilities":          {[]string{"get"}, "What this proxy can serve and how much it caches"},
+	"/proxy/v1/capabilities": {[]string{"get"}, "What this proxy can serve and how much it caches"},
+	"/openapi.json":          {[]string{"get"}, "This OpenAPI document"},
+	"/metrics":               {[]string{"get"}, "Prometheus metrics"},
+	"/stats/sources":         {[]string{"get"}, "Requests by endpoint and data source over rolling windows"},
+	"/events":                {[]string{"get"}, "Recent ban, recovery and degradation events"},
+	"/admin/recovery":        {[]string{"get"}, "Auto recovery state and upstream circuit breaker"},
+	"/admin/bans":            {[]string{"get", "post"}, "Ban state, POST clears it"},
+	"/admin/keys":            {[]string{"get", "post", "delete"}, "List, add or revoke proxy API keys"},
+	"/admin/keys/rotate":     {[]string{"post"}, "Replace a proxy API key"},
+	"/admin/config":          {[]string{"get"}, "Effective configuration"},
+	"/admin/runtime":         {[]string{"get", "post"}, "Go runtime memory limit and GC settings"},
+	"/admin/cache/dump":      {[]string{"get"}, "Cached data of a symbol"},
+	"/admin/cache/load":      {[]string{"post"}, "Seed empty caches from a dump"},
+	"/admin/streams":         {[]string{"get", "post"}, "Running streams, POST stops, restarts or resubscribes one"},
+	"/proxy/v1/klines":       {[]string{"get"}, "Klines of many symbols in one response"},
+	"/proxy/v1/export":       {[]string{"get"}, "Cached klines as CSV or Parquet"},
+}
+
+// openAPI serves an OpenAPI 3 document of the endpoints of this port: the
+// cached Binance endpoints with their parameters and the proxy specific
+// ones. Forwarded endpoints aren't listed, Binance documents them.
+func (s *Handler) openAPI(w http.ResponseWriter) {
+	errorResponse := map[string]interface{}{
+		"description": "Binance's error object, also used for errors of the proxy",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": map[string]string{"$ref": "#/components/schemas/Error"}},
+		},
+	}
+	freshness := []interface{}{
+		map[string]interface{}{"name": "fresh", "in": "query", "description": "Forward to Binance instead of answering from the cache", "schema": map[string]string{"type": "boolean"}},
+		map[string]interface{}{"name": "X-Cache-Bypass", "in": "header", "description": "Forward to Binance instead of answering from the cache", "schema": map[string]string{"type": "boolean"}},
+		map[string]interface{}{"name": "X-Max-Age", "in": "header", "description": "Forward to Binance when the cached data is older, a duration or milliseconds", "schema": map[string]string{"type": "string"}},
+	}
+
+	paths := make(map[string]interface{})
+	for _, e := range cachedEndpoints {
+		if !servesClass(e, s.class) {
+			continue
+		}
+		params := append([]interface{}{}, freshness...)
+		for _, name := range e.Params {
+			required := false
+			for _, r := range e.Required {
+				required = required || r == name
+			}
+			params = append(params, map[string]interface{}{"name": name, "in": "query", "required": required, "schema": map[string]string{"type": "string"}})
+		}
+		description := "Served from " + e.Source
+		if e.Limits != "" {
+			description += "; " + e.Limits + ". Other requests are forwarded to Binance"
+		}
+		paths[e.Path] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"tags":        []string{"cached"},
+				"summary":     strings.TrimPrefix(e.Path, "/"),
+				"description": description + ".",
+				"parameters":  params,
+				"responses": map[string]interface{}{
+					"200":     map[string]string{"description": "Binance's response, with the Data-Source header telling where it came from"},
+					"default": errorResponse,
+				},
+			},
+		}
+	}
+	for _, path := range extensionEndpoints {
+		doc := extensionDocs[path]
+		operations := make(map[string]interface{})
+		for _, method := range doc.methods {
+			operations[method] = map[string]interface{}{
+				"tags":      []string{"proxy"},
+				"summary":   doc.summary,
+				"responses": map[string]interface{}{"200": map[string]string{"description": "See the README"}},
+			}
+		}
+		paths[path] = operations
+	}
+
+	buildInfoMu.RLock()
+	info := map[string]string{"title": "binance-proxy " + strings.ToLower(string(s.class)), "version": version}
+	buildInfoMu.RUnlock()
+	if info["version"] == "" {
+		info["version"] = "dev"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    info,
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"code", "msg"},
+					"properties": map[string]interface{}{
+						"code": map[string]string{"type": "integer"},
+						"msg":  map[string]string{"type": "string"},
+					},
+				},
+			},
+		},
+	})
+}
+
+// servesClass reports whether e is served for class.
+func servesClass(e cachedEndpoint, class service.Class) bool {
+	for _, c := range e.Classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
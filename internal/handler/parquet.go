@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Parquet physical and converted types used by the kline export.
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetNoConversion   = -1
+	parquetUTF8           = 0
+	parquetTimestampMilli = 9
+)
+
+// parquetMagic starts and ends every Parquet file.
+var parquetMagic = []byte("PAR1")
+
+// parquetColumn is a required, flat column of a Parquet file.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32
+}
+
+// parquetChunk is what the footer needs to know of a written column chunk.
+type parquetChunk struct {
+	offset, size, values int64
+}
+
+// parquetRowGroup is what the footer needs to know of a written row group.
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int64
+	size   int64
+}
+
+// parquetWriter writes a Parquet file of required flat columns row group by
+// row group, so only the row group being written and the footer metadata are
+// held in memory. Pages are PLAIN encoded and uncompressed; that is all any
+// Parquet reader needs, and the response compression applies on top.
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	columns   []parquetColumn
+	rowGroups []parquetRowGroup
+}
+
+func newParquetWriter(w io.Writer, columns []parquetColumn) (*parquetWriter, error) {
+	p := &parquetWriter{w: w, columns: columns}
+	return p, p.write(parquetMagic)
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// writeRowGroup writes a row group of rows rows, values holding the PLAIN
+// encoded values of each column.
+func (p *parquetWriter) writeRowGroup(values []*parquetValues, rows int) error {
+	if rows == 0 {
+		return nil
+	}
+	rg := parquetRowGroup{rows: int64(rows)}
+	for _, v := range values {
+		var t thriftWriter
+		t.structBegin()
+		t.i32(1, 0) // DATA_PAGE
+		t.i32(2, int32(len(v.buf)))
+		t.i32(3, int32(len(v.buf)))
+		t.fieldStruct(5)
+		t.i32(1, int32(rows))
+		t.i32(2, 0) // PLAIN
+		t.i32(3, 3) // RLE, no levels are written for required columns
+		t.i32(4, 3)
+		t.structEnd()
+		t.structEnd()
+
+		chunk := parquetChunk{offset: p.offset, size: int64(len(t.buf) + len(v.buf)), values: int64(rows)}
+		if err := p.write(t.buf); err != nil {
+			return err
+		}
+		if err := p.write(v.buf); err != nil {
+			return err
+		}
+		rg.chunks = append(rg.chunks, chunk)
+		rg.size += chunk.size
+	}
+	p.rowGroups = append(p.rowGroups, rg)
+	return nil
+}
+
+// close writes the footer.
+func (p *parquetWriter) close() error {
+	var t thriftWriter
+	var rows int64
+	for _, rg := range p.rowGroups {
+		rows += rg.rows
+	}
+
+	t.structBegin()
+	t.i32(1, 1)
+	t.fieldList(2, thriftStruct, len(p.columns)+1)
+	t.structBegin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(p.columns)))
+	t.structEnd()
+	for _, c := range p.columns {
+		t.structBegin()
+		t.i32(1, c.typ)
+		t.i32(3, 0) // REQUIRED
+		t.binary(4, c.name)
+		if c.converted != parquetNoConversion {
+			t.i32(6, c.converted)
+		}
+		t.structEnd()
+	}
+	t.i64(3, rows)
+	t.fieldList(4, thriftStruct, len(p.rowGroups))
+	for _, rg := range p.rowGroups {
+		t.structBegin()
+		t.fieldList(1, thriftStruct, len(rg.chunks))
+		for i, chunk := range rg.chunks {
+			t.structBegin()
+			t.i64(2, chunk.offset)
+			t.fieldStruct(3)
+			t.i32(1, p.columns[i].typ)
+			t.fieldList(2, thriftI32, 1)
+			t.varint(0) // PLAIN
+			t.fieldList(3, thriftBinary, 1)
+			t.bytes(p.columns[i].name)
+			t.i32(4, 0) // UNCOMPRESSED
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.structEnd()
+			t.structEnd()
+		}
+		t.i64(2, rg.size)
+		t.i64(3, rg.rows)
+		t.structEnd()
+	}
+	t.binary(6, "binance-proxy")
+	t.structEnd()
+
+	t.buf = binary.LittleEndian.AppendUint32(t.buf, uint32(len(t.buf)))
+	t.buf = append(t.buf, parquetMagic...)
+	return p.write(t.buf)
+}
+
+// parquetValues collects the PLAIN encoded values of one column chunk.
+type parquetValues struct {
+	buf []byte
+}
+
+func (v *parquetValues) int64(n int64) {
+	v.buf = binary.LittleEndian.AppendUint64(v.buf, uint64(n))
+}
+
+func (v *parquetValues) double(f float64) {
+	v.buf = binary.LittleEndian.AppendUint64(v.buf, math.Float64bits(f))
+}
+
+func (v *parquetValues) string(s string) {
+	v.buf = binary.LittleEndian.AppendUint32(v.buf, uint32(len(s)))
+	v.buf = append(v.buf, s...)
+}
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Thrift compact protocol Parquet metadata is
+// written in.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // last field id of each open struct
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf = binary.AppendUvarint(t.buf, v)
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) structBegin() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) structEnd() {
+	t.buf = append(t.buf, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) bytes(s string) {
+	t.varint(uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.bytes(s)
+}
+
+// fieldStruct begins a struct field, ended with structEnd.
+func (t *thriftWriter) fieldStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.structBegin()
+}
+
+// fieldList begins a list field of n elements, which follow as bare values.
+func (t *thriftWriter) fieldList(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elem)
+	} else {
+		t.buf = append(t.buf, 0xf0|elem)
+		t.varint(uint64(n))
+	}
+}
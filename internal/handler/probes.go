@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// tlsCertExpiry is the expiry of the certificate the proxy serves, nil
+// without TLS.
+var tlsCertExpiry atomic.Pointer[time.Time]
+
+// SetTLSCertExpiry records the expiry of the served certificate for the
+// health report, on startup and after every reload.
+func SetTLSCertExpiry(notAfter time.Time) {
+	tlsCertExpiry.Store(&notAfter)
+}
+
+// HealthDetails returns the upstream connectivity of classes and the expiry
+// of the served certificate, reported by the liveness probes without
+// affecting their status.
+func HealthDetails(classes ...service.Class) map[string]interface{} {
+	upstream := make(map[service.Class]*service.UpstreamCheck)
+	for _, class := range classes {
+		if check := service.UpstreamConnectivity(class); check != nil {
+			upstream[class] = check
+		}
+	}
+	details := map[string]interface{}{"upstream": upstream}
+	if notAfter := tlsCertExpiry.Load(); notAfter != nil {
+		details["tls"] = map[string]interface{}{
+			"not_after":          notAfter.UTC().Format(time.RFC3339),
+			"expires_in_seconds": int64(time.Until(*notAfter).Seconds()),
+		}
+	}
+	return details
+}
+
+// isProbe reports whether path is one of the probe endpoints, which are
+// answered without API keys, client limits or chaos, so Kubernetes probes and
+// load balancer health checks work whatever is configured.
+func isProbe(path string) bool {
+	return path == "/healthz" || path == "/readyz"
+}
+
+// healthz is the liveness probe: the process serves requests.
+func (s *Handler) healthz(w http.ResponseWriter) {
+	if s.ctx.Err() != nil {
+		writeProbe(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "shutting down"})
+		return
+	}
+	body := HealthDetails(s.class)
+	body["status"] = "ok"
+	writeProbe(w, http.StatusOK, body)
+}
+
+// readyz is the readiness probe: the market can be served, with exchangeInfo
+// loaded (a snapshot will do) and Binance not banning the proxy.
+func (s *Handler) readyz(w http.ResponseWriter) {
+	data, _, _ := s.srv.ExchangeInfoStale()
+	exchangeInfo, banned := data != nil, service.GetBanDetector().IsBanned(s.class)
+	status, code := "ready", http.StatusOK
+	if s.ctx.Err() != nil {
+		status, code = "shutting down", http.StatusServiceUnavailable
+	} else if !exchangeInfo || banned {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	writeProbe(w, code, map[string]interface{}{
+		"status":        status,
+		"class":         s.class,
+		"exchange_info": exchangeInfo,
+		"banned":        banned,
+	})
+}
+
+func writeProbe(w http.ResponseWriter, code int, body map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
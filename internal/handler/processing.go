@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type upstreamTimeKey struct{}
+
+// withUpstreamTimer returns ctx collecting the time spent waiting for
+// Binance in t.
+func withUpstreamTimer(ctx context.Context, t *atomic.Int64) context.Context {
+	return context.WithValue(ctx, upstreamTimeKey{}, t)
+}
+
+// addUpstreamTime counts d as time spent waiting for Binance in the request
+// of ctx.
+func addUpstreamTime(ctx context.Context, d time.Duration) {
+	if t, ok := ctx.Value(upstreamTimeKey{}).(*atomic.Int64); ok {
+		t.Add(int64(d))
+	}
+}
+
+// setProcessingTime sets the X-Proxy-Processing-Ms header to the time the
+// request spent in the proxy so far, not counting the wait for Binance.
+func (r *responseRecorder) setProcessingTime() {
+	if r.start.IsZero() {
+		return
+	}
+	d := time.Since(r.start) - time.Duration(r.upstream.Load())
+	r.Header().Set("X-Proxy-Processing-Ms", strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 3, 64))
+}
+
+// timedTransport counts the time until the response headers arrive as
+// upstream time of the request.
+func timedTransport(base http.RoundTripper) roundTripperFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := base.RoundTrip(req)
+		addUpstreamTime(req.Context(), time.Since(start))
+		return resp, err
+	}
+}
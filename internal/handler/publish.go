@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"binance-proxy/internal/publish"
+	"binance-proxy/internal/service"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// publishedMessage is the payload of a published update; data has the same
+// layout as on /ws.
+type publishedMessage struct {
+	Class    string      `json:"class"`
+	Stream   string      `json:"stream"`
+	Symbol   string      `json:"symbol"`
+	Interval string      `json:"interval,omitempty"`
+	Time     int64       `json:"time"`
+	Data     interface{} `json:"data"`
+}
+
+// publishStreams holds the stream kinds that are published.
+var publishStreams = map[string]bool{}
+
+// SetPublishStreams selects the stream kinds (kline, depth, ticker) whose
+// updates are published and starts passing them to the publisher. Klines
+// are only published once closed.
+func SetPublishStreams(kinds []string) error {
+	streams := make(map[string]bool)
+	for _, kind := range kinds {
+		switch kind = strings.TrimSpace(kind); kind {
+		case service.StreamKline, service.StreamDepth, service.StreamTicker:
+			streams[kind] = true
+		case "":
+		default:
+			return fmt.Errorf("unsupported publish stream %q, expected kline, depth or ticker", kind)
+		}
+	}
+	publishStreams = streams
+	service.AddUpdateSink(publishUpdate)
+	return nil
+}
+
+func publishUpdate(u service.Update) {
+	if !publishStreams[u.Stream] {
+		return
+	}
+	if k, ok := u.Data.(*service.Kline); ok && !k.Final {
+		return
+	}
+
+	b, err := json.Marshal(publishedMessage{
+		Class:    string(u.Class),
+		Stream:   u.Stream,
+		Symbol:   u.Symbol,
+		Interval: u.Interval,
+		Time:     time.Now().UnixMilli(),
+		Data:     wsPayload(u),
+	})
+	if err != nil {
+		return
+	}
+	subject := strings.ToLower(string(u.Class)) + "." + u.Stream + "." + u.Symbol
+	if u.Interval != "" {
+		subject += "." + u.Interval
+	}
+	publish.Send(subject, b)
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"runtime"
+	"sync"
+)
+
+// baseGoroutines is the goroutine headroom on top of the websocket services,
+// covering the HTTP servers, their connections and background loops.
+const baseGoroutines = 100
+
+var (
+	recoveryMu       sync.RWMutex
+	recoveryConfig   *service.RecoveryConfig
+	supervisorConfig *service.SupervisorConfig
+)
+
+// SetAutoRecovery enables automatic re-initialization of the websocket
+// services of every handler created afterwards; nil disables it.
+func SetAutoRecovery(config *service.RecoveryConfig) {
+	recoveryMu.Lock()
+	defer recoveryMu.Unlock()
+	recoveryConfig = config
+}
+
+// SetStreamSupervisor enables the restart of failing websocket services of
+// every handler created afterwards; nil disables it.
+func SetStreamSupervisor(config *service.SupervisorConfig) {
+	recoveryMu.Lock()
+	defer recoveryMu.Unlock()
+	supervisorConfig = config
+}
+
+// startRecovery runs the stream supervisor and the auto recovery of the
+// handler if they are enabled.
+func (s *Handler) startRecovery() {
+	recoveryMu.RLock()
+	config, supervision := recoveryConfig, supervisorConfig
+	recoveryMu.RUnlock()
+	if supervision != nil {
+		s.supervisor = service.NewStreamSupervisor(s.srv, *supervision)
+		go s.supervisor.Run(s.ctx)
+	}
+	if config == nil {
+		return
+	}
+
+	base := runtime.NumGoroutine() + baseGoroutines
+	s.recovery = service.NewAutoRecovery(s.class, *config,
+		func() service.HealthSignals { return s.srv.HealthSignals(config.StaleAfter, base) },
+		s.srv.Reinit,
+	)
+	go s.recovery.Run(s.ctx)
+}
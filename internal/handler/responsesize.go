@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"binance-proxy/internal/logcache"
+	"binance-proxy/internal/metrics"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// maxResponseSize caps forwarded response bodies in bytes, 0 for no limit.
+var maxResponseSize atomic.Int64
+
+// errResponseTooLarge aborts a forwarded response over maxResponseSize.
+var errResponseTooLarge = errors.New("upstream response exceeds the maximum response size")
+
+// SetMaxResponseSize caps the body of forwarded responses at n bytes. Larger
+// responses are answered with 502 instead. 0 disables the limit.
+func SetMaxResponseSize(n int64) {
+	maxResponseSize.Store(n)
+}
+
+// limitResponse enforces maxResponseSize on a forwarded response before any
+// of it is sent downstream. Bodies without a Content-Length are read into
+// memory up to the limit to find their size.
+func (s *Handler) limitResponse(resp *http.Response) error {
+	limit := maxResponseSize.Load()
+	if limit <= 0 || resp.Body == nil {
+		return nil
+	}
+
+	if resp.ContentLength > limit {
+		resp.Body.Close()
+		return s.responseTooLarge(resp, resp.ContentLength)
+	}
+	if resp.ContentLength >= 0 {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > limit {
+		return s.responseTooLarge(resp, -1)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+func (s *Handler) responseTooLarge(resp *http.Response, size int64) error {
+	metrics.ResponsesTooLarge.WithLabelValues(string(s.class), metricsEndpoint(resp.Request.URL.Path)).Inc()
+	if size >= 0 {
+		logcache.LogOncePerDuration("warn", fmt.Sprintf("%s response of %d bytes for %s exceeds the maximum response size of %d bytes", s.class, size, resp.Request.URL.Path, maxResponseSize.Load()))
+	} else {
+		logcache.LogOncePerDuration("warn", fmt.Sprintf("%s response for %s exceeds the maximum response size of %d bytes", s.class, resp.Request.URL.Path, maxResponseSize.Load()))
+	}
+	return errResponseTooLarge
+}
+
+// writeResponseTooLarge answers a request whose upstream response was
+// dropped by limitResponse.
+func writeResponseTooLarge(w http.ResponseWriter) {
+	w.Header().Set("Data-Source", "proxy-error")
+	writeError(w, http.StatusBadGateway, codeDisconnected, fmt.Sprintf("Upstream response exceeds the proxy's maximum response size of %d bytes; narrow the request with filters such as startTime, endTime or limit.", maxResponseSize.Load()))
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"binance-proxy/internal/audit"
+	"binance-proxy/internal/tool"
+	"encoding/json"
+	"math"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+)
+
+// adminRuntime reports the memory settings and usage of the Go runtime on
+// GET. POST changes them until the next restart: memory_limit_mb sets the
+// soft memory limit (0 removes it) and gc_percent the GC target (-1 turns
+// the collector off until the memory limit).
+func (s *Handler) adminRuntime(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		q := r.URL.Query()
+		var limit, gcPercent *int64
+		for name, v := range map[string]**int64{"memory_limit_mb": &limit, "gc_percent": &gcPercent} {
+			if raw := q.Get(name); raw != "" {
+				n, err := strconv.ParseInt(raw, 10, 64)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, codeInvalidParam, "Invalid parameter '"+name+"'.")
+					return
+				}
+				*v = &n
+			}
+		}
+		if (limit != nil && (*limit < 0 || *limit > math.MaxInt64>>20)) || (gcPercent != nil && *gcPercent < -1) {
+			writeError(w, http.StatusBadRequest, codeInvalidParam, "Parameter 'memory_limit_mb' must not be negative and 'gc_percent' must be -1 or more.")
+			return
+		}
+		if limit == nil && gcPercent == nil {
+			writeError(w, http.StatusBadRequest, codeMandatory, "Mandatory parameter 'memory_limit_mb' or 'gc_percent' was not sent, was empty/null, or malformed.")
+			return
+		}
+		if limit != nil {
+			if *limit == 0 {
+				debug.SetMemoryLimit(math.MaxInt64)
+			} else {
+				debug.SetMemoryLimit(*limit << 20)
+			}
+		}
+		if gcPercent != nil {
+			debug.SetGCPercent(int(*gcPercent))
+		}
+		details := map[string]interface{}{}
+		if limit != nil {
+			details["memory_limit_mb"] = *limit
+		}
+		if gcPercent != nil {
+			details["gc_percent"] = *gcPercent
+		}
+		s.audit(r, audit.ActionRuntimeChange, audit.OutcomeSuccess, details)
+	default:
+		writeMethodNotAllowed(w, "GET, POST")
+		return
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	samples := []metrics.Sample{{Name: "/gc/gomemlimit:bytes"}, {Name: "/gc/gogc:percent"}}
+	metrics.Read(samples)
+	limit := int64(samples[0].Value.Uint64())
+	gcPercent := int64(samples[1].Value.Uint64()) // -1 when the collector is off
+
+	response := map[string]interface{}{
+		"memory_limit_bytes": nil,
+		"gc_percent":         gcPercent,
+		"heap_alloc_bytes":   ms.HeapAlloc,
+		"sys_bytes":          ms.Sys,
+		"num_gc":             ms.NumGC,
+		"goroutines":         runtime.NumGoroutine(),
+		"container_limit":    nil,
+	}
+	if limit != math.MaxInt64 {
+		response["memory_limit_bytes"] = limit
+	}
+	if cgroup, ok := tool.CgroupMemoryLimit(); ok {
+		response["container_limit"] = cgroup
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"binance-proxy/internal/metrics"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sloBucket is the resolution of the SLO windows.
+	sloBucket = time.Minute
+	// sloBuckets covers the longest window, six hours.
+	sloBuckets = int(6 * time.Hour / sloBucket)
+	// sloUpdateInterval is how often the SLO gauges are recomputed.
+	sloUpdateInterval = 15 * time.Second
+)
+
+// sloWindows are the windows the SLOs are evaluated over.
+var sloWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"30m", 30 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+}
+
+// sloAlerts are multi-window burn rate alerts: an alert fires when the error
+// budget burns faster than rate in both the long and the short window, the
+// short one making the alert stop soon after the problem does.
+var sloAlerts = []struct {
+	severity    string
+	long, short string
+	rate        float64
+}{
+	{"page", "1h", "5m", 14.4},
+	{"ticket", "6h", "30m", 6},
+}
+
+// sloBadSources are Data-Source values of responses that count against the
+// availability SLO even without a 5xx status.
+var sloBadSources = map[string]bool{
+	"ban-protection": true,
+	"proxy-error":    true,
+	"proxy-timeout":  true,
+}
+
+type sloConfig struct {
+	availability  float64
+	latency       time.Duration
+	latencyTarget float64
+}
+
+var sloSettings atomic.Pointer[sloConfig]
+
+// SetSLO enables SLO tracking for the cached endpoints of handlers created
+// afterwards: availability is the target share of requests answered without
+// an error, latencyTarget the target share answered within latency. A target
+// of 0 disables that objective.
+func SetSLO(availability float64, latency time.Duration, latencyTarget float64) {
+	if availability <= 0 && latencyTarget <= 0 {
+		sloSettings.Store(nil)
+		return
+	}
+	sloSettings.Store(&sloConfig{availability: availability, latency: latency, latencyTarget: latencyTarget})
+}
+
+type sloCounts struct {
+	start  int64 // start of the bucket in sloBucket units
+	total  int64
+	errors int64
+	slow   int64
+}
+
+// sloTracker counts the requests of the cached endpoints in a ring of
+// buckets covering the longest window.
+type sloTracker struct {
+	config *sloConfig
+
+	mu      sync.Mutex
+	buckets [sloBuckets]sloCounts
+}
+
+func (t *sloTracker) record(r *http.Request, source string, status int, d time.Duration, now time.Time) {
+	if t == nil || r.Method != http.MethodGet || isExtensionEndpoint(r.URL.Path) || metricsEndpoint(r.URL.Path) == "other" {
+		return
+	}
+	slot := now.UnixNano() / int64(sloBucket)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := &t.buckets[slot%int64(sloBuckets)]
+	if b.start != slot {
+		*b = sloCounts{start: slot}
+	}
+	b.total++
+	if status >= 500 || sloBadSources[source] {
+		b.errors++
+	}
+	if d > t.config.latency {
+		b.slow++
+	}
+}
+
+// sum adds up the buckets of the last d.
+func (t *sloTracker) sum(d time.Duration, now time.Time) sloCounts {
+	slot := now.UnixNano() / int64(sloBucket)
+	oldest := slot - int64(d/sloBucket) + 1
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var sum sloCounts
+	for i := range t.buckets {
+		b := &t.buckets[i]
+		if b.start < oldest || b.start > slot {
+			continue
+		}
+		sum.total += b.total
+		sum.errors += b.errors
+		sum.slow += b.slow
+	}
+	return sum
+}
+
+type sloWindowStats struct {
+	Requests int64   `json:"requests"`
+	Bad      int64   `json:"bad"`
+	Ratio    float64 `json:"good_ratio"`
+	BurnRate float64 `json:"burn_rate"`
+}
+
+type sloObjective struct {
+	Target    float64                   `json:"target"`
+	Threshold string                    `json:"threshold,omitempty"`
+	Windows   map[string]sloWindowStats `json:"windows"`
+	// BudgetRemaining is the share of the error budget of the longest
+	// window that is left, negative once it is overspent.
+	BudgetRemaining float64  `json:"budget_remaining"`
+	Alerts          []string `json:"alerts"`
+}
+
+// report evaluates the objectives over every window.
+func (t *sloTracker) report(now time.Time) map[string]*sloObjective {
+	sums := make(map[string]sloCounts, len(sloWindows))
+	for _, w := range sloWindows {
+		sums[w.name] = t.sum(w.d, now)
+	}
+
+	objectives := map[string]*sloObjective{}
+	evaluate := func(name string, target float64, bad func(sloCounts) int64) *sloObjective {
+		o := &sloObjective{Target: target, Windows: make(map[string]sloWindowStats, len(sloWindows)), Alerts: []string{}}
+		for _, w := range sloWindows {
+			c := sums[w.name]
+			st := sloWindowStats{Requests: c.total, Bad: bad(c), Ratio: 1}
+			if c.total > 0 {
+				st.Ratio = 1 - float64(st.Bad)/float64(c.total)
+				st.BurnRate = (1 - st.Ratio) / (1 - target)
+			}
+			o.Windows[w.name] = st
+		}
+		o.BudgetRemaining = 1 - o.Windows[sloWindows[len(sloWindows)-1].name].BurnRate
+		for _, a := range sloAlerts {
+			if o.Windows[a.long].BurnRate >= a.rate && o.Windows[a.short].BurnRate >= a.rate {
+				o.Alerts = append(o.Alerts, a.severity)
+			}
+		}
+		objectives[name] = o
+		return o
+	}
+	if t.config.availability > 0 {
+		evaluate("availability", t.config.availability, func(c sloCounts) int64 { return c.errors })
+	}
+	if t.config.latencyTarget > 0 {
+		o := evaluate("latency", t.config.latencyTarget, func(c sloCounts) int64 { return c.slow })
+		o.Threshold = t.config.latency.String()
+	}
+	return objectives
+}
+
+// startSLO tracks the SLOs of the handler if they are enabled and keeps the
+// SLO gauges up to date.
+func (s *Handler) startSLO() {
+	config := sloSettings.Load()
+	if config == nil {
+		return
+	}
+	s.slo = &sloTracker{config: config}
+
+	go func() {
+		t := time.NewTicker(sloUpdateInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case now := <-t.C:
+				s.updateSLOMetrics(now)
+			}
+		}
+	}()
+}
+
+func (s *Handler) updateSLOMetrics(now time.Time) {
+	class := string(s.class)
+	for name, o := range s.slo.report(now) {
+		for window, st := range o.Windows {
+			metrics.SLOGoodRatio.WithLabelValues(class, name, window).Set(st.Ratio)
+			metrics.SLOBurnRate.WithLabelValues(class, name, window).Set(st.BurnRate)
+		}
+		metrics.SLOBudgetRemaining.WithLabelValues(class, name).Set(math.Max(o.BudgetRemaining, -1))
+		for _, a := range sloAlerts {
+			firing := 0.0
+			for _, severity := range o.Alerts {
+				if severity == a.severity {
+					firing = 1
+				}
+			}
+			metrics.SLOAlert.WithLabelValues(class, name, a.severity).Set(firing)
+		}
+	}
+}
+
+// sloStatus is the slo section of /status, nil when SLOs are disabled.
+func (s *Handler) sloStatus() interface{} {
+	if s.slo == nil {
+		return nil
+	}
+	return s.slo.report(time.Now())
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"binance-proxy/internal/metrics"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sourceStatsBucket is the resolution of the sliding windows.
+	sourceStatsBucket = 10 * time.Second
+	// sourceStatsBuckets covers the longest window, one hour.
+	sourceStatsBuckets = int(time.Hour / sourceStatsBucket)
+)
+
+// sourceStatsWindows are the windows reported by /stats/sources.
+var sourceStatsWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+}
+
+// maxSourceKeys bounds the endpoint and Data-Source pairs counted per
+// bucket; further pairs are counted as other.
+const maxSourceKeys = 256
+
+type sourceKey struct {
+	endpoint, source string
+}
+
+var otherSourceKey = sourceKey{"other", "other"}
+
+type sourceBucket struct {
+	start  int64 // start of the bucket in sourceStatsBucket units
+	counts *metrics.CounterSet[sourceKey]
+}
+
+// sourceStats counts requests by endpoint and Data-Source in a ring of
+// buckets covering the last hour. A bucket is replaced as a whole when its
+// slot comes round again, so recording and summing take no locks.
+type sourceStats struct {
+	buckets [sourceStatsBuckets]atomic.Pointer[sourceBucket]
+}
+
+func (st *sourceStats) record(endpoint, source string, now time.Time) {
+	slot := now.UnixNano() / int64(sourceStatsBucket)
+	p := &st.buckets[slot%int64(sourceStatsBuckets)]
+
+	for {
+		b := p.Load()
+		if b != nil && b.start == slot {
+			b.counts.Add(sourceKey{endpoint, source}, 1)
+			return
+		}
+		if b != nil && b.start > slot {
+			// the clock went back past this bucket
+			return
+		}
+		fresh := &sourceBucket{start: slot, counts: metrics.NewCounterSet(maxSourceKeys, otherSourceKey)}
+		if p.CompareAndSwap(b, fresh) {
+			fresh.counts.Add(sourceKey{endpoint, source}, 1)
+			return
+		}
+	}
+}
+
+// sum adds up the buckets of the last d.
+func (st *sourceStats) sum(d time.Duration, now time.Time) map[sourceKey]int64 {
+	slot := now.UnixNano() / int64(sourceStatsBucket)
+	oldest := slot - int64(d/sourceStatsBucket) + 1
+
+	sums := make(map[sourceKey]int64)
+	for i := range st.buckets {
+		b := st.buckets[i].Load()
+		if b == nil || b.start < oldest || b.start > slot {
+			continue
+		}
+		for k, n := range b.counts.Snapshot() {
+			sums[k] += n
+		}
+	}
+	return sums
+}
+
+type endpointSources struct {
+	Total int64            `json:"total"`
+	Local float64          `json:"local_ratio"`
+	By    map[string]int64 `json:"sources"`
+}
+
+// statsSources reports the requests per endpoint and Data-Source for each
+// window. local_ratio is the share of requests answered without forwarding
+// them to Binance.
+func (s *Handler) statsSources(w http.ResponseWriter) {
+	now := time.Now()
+	windows := make(map[string]map[string]*endpointSources, len(sourceStatsWindows))
+	for _, window := range sourceStatsWindows {
+		endpoints := make(map[string]*endpointSources)
+		for k, n := range s.sources.sum(window.d, now) {
+			e, ok := endpoints[k.endpoint]
+			if !ok {
+				e = &endpointSources{By: make(map[string]int64)}
+				endpoints[k.endpoint] = e
+			}
+			e.Total += n
+			e.By[k.source] += n
+		}
+		for _, e := range endpoints {
+			e.Local = float64(e.Total-e.By["upstream"]) / float64(e.Total)
+		}
+		windows[window.name] = endpoints
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"class":   string(s.class),
+		"windows": windows,
+	})
+}
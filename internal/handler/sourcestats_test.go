@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSourceStatsWindows(t *testing.T) {
+	var st sourceStats
+	now := time.Unix(1_700_000_000, 0)
+
+	st.record("/api/v3/klines", "websocket", now.Add(-2*time.Hour)) // overwritten by the ring
+	st.record("/api/v3/klines", "websocket", now.Add(-30*time.Minute))
+	st.record("/api/v3/klines", "upstream", now.Add(-3*time.Minute))
+	st.record("/api/v3/klines", "websocket", now.Add(-10*time.Second))
+	st.record("/api/v3/klines", "websocket", now)
+	st.record("/api/v3/depth", "websocket", now)
+
+	tests := []struct {
+		window time.Duration
+		want   map[sourceKey]int64
+	}{
+		{time.Minute, map[sourceKey]int64{
+			{"/api/v3/klines", "websocket"}: 2,
+			{"/api/v3/depth", "websocket"}:  1,
+		}},
+		{5 * time.Minute, map[sourceKey]int64{
+			{"/api/v3/klines", "websocket"}: 2,
+			{"/api/v3/klines", "upstream"}:  1,
+			{"/api/v3/depth", "websocket"}:  1,
+		}},
+		{time.Hour, map[sourceKey]int64{
+			{"/api/v3/klines", "websocket"}: 3,
+			{"/api/v3/klines", "upstream"}:  1,
+			{"/api/v3/depth", "websocket"}:  1,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.window.String(), func(t *testing.T) {
+			got := st.sum(tt.window, now)
+			if len(got) != len(tt.want) {
+				t.Fatalf("sum(%s) = %v, want %v", tt.window, got, tt.want)
+			}
+			for k, n := range tt.want {
+				if got[k] != n {
+					t.Errorf("sum(%s)[%v] = %d, want %d", tt.window, k, got[k], n)
+				}
+			}
+		})
+	}
+}
+
+func TestSourceStatsBound(t *testing.T) {
+	var st sourceStats
+	now := time.Unix(1_700_000_000, 0)
+	for i := 0; i < maxSourceKeys+10; i++ {
+		st.record(fmt.Sprintf("/path/%d", i), "upstream", now)
+	}
+
+	got := st.sum(time.Minute, now)
+	if len(got) != maxSourceKeys+1 {
+		t.Errorf("sum() has %d keys, want %d", len(got), maxSourceKeys+1)
+	}
+	if got[otherSourceKey] != 10 {
+		t.Errorf("sum()[other] = %d, want 10", got[otherSourceKey])
+	}
+}
+
+func TestSourceStatsConcurrent(t *testing.T) {
+	const (
+		goroutines = 8
+		records    = 1000
+	)
+	var st sourceStats
+	start := time.Unix(1_700_000_000, 0)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < records; i++ {
+				// spread over a few buckets so they are replaced concurrently
+				st.record("/api/v3/klines", "websocket", start.Add(time.Duration(i/250)*sourceStatsBucket))
+			}
+		}()
+	}
+	wg.Wait()
+
+	now := start.Add(3 * sourceStatsBucket)
+	if got := st.sum(time.Hour, now)[sourceKey{"/api/v3/klines", "websocket"}]; got != goroutines*records {
+		t.Errorf("counted %d requests, want %d", got, goroutines*records)
+	}
+}
+
+func BenchmarkSourceStatsRecord(b *testing.B) {
+	var st sourceStats
+	now := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			st.record("/api/v3/klines", "websocket", now)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	sseMaxSymbols   = 50
+	ssePingInterval = 15 * time.Second
+)
+
+// sseKline is the data of a kline event.
+type sseKline struct {
+	Symbol   string        `json:"symbol"`
+	Interval string        `json:"interval"`
+	Kline    []interface{} `json:"kline"`
+}
+
+// sse streams closed candles and ticker updates as server-sent events, for
+// clients that can't use websockets. The symbols parameter lists the symbols,
+// intervals the kline intervals to send closed candles of and ticker=true
+// adds the 24hr ticker of each symbol:
+//
+//	/sse?symbols=BTCUSDT,ETHUSDT&intervals=5m,1h&ticker=true
+func (s *Handler) sse(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, codeUnknown, "An unknown error occurred in the proxy; the connection doesn't support streaming.")
+		return
+	}
+
+	query := r.URL.Query()
+	symbols := splitList(query.Get("symbols"))
+	intervals := splitList(query.Get("intervals"))
+	ticker := query.Get("ticker") == "true"
+	if len(symbols) == 0 {
+		writeError(w, http.StatusBadRequest, codeMandatory, "Mandatory parameter 'symbols' was not sent, was empty/null, or malformed.")
+		return
+	}
+	if len(symbols) > sseMaxSymbols {
+		writeError(w, http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("Parameter 'symbols' must list at most %d symbols per stream.", sseMaxSymbols))
+		return
+	}
+	if len(intervals) == 0 && !ticker {
+		writeError(w, http.StatusBadRequest, codeMandatory, "Mandatory parameter 'intervals' or 'ticker=true' was not sent, was empty/null, or malformed.")
+		return
+	}
+
+	updates := make(chan service.Update, 256)
+	var subs []func()
+	defer func() {
+		for _, unsubscribe := range subs {
+			unsubscribe()
+		}
+	}()
+	subscribe := func(kind, symbol, interval string) bool {
+		unsubscribe, err := s.srv.Subscribe(kind, strings.ToUpper(symbol), interval, updates)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("Invalid stream of %s: %s.", symbol, err))
+			return false
+		}
+		subs = append(subs, unsubscribe)
+		return true
+	}
+	for _, symbol := range symbols {
+		for _, interval := range intervals {
+			if !subscribe(service.StreamKline, symbol, interval) {
+				return
+			}
+		}
+		if ticker && !subscribe(service.StreamTicker, symbol, "") {
+			return
+		}
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	log.Debugf("%s server-sent events stream opened from %s", s.class, clientIP(r))
+	defer log.Debugf("%s server-sent events stream from %s closed", s.class, clientIP(r))
+
+	ping := time.NewTicker(ssePingInterval)
+	defer ping.Stop()
+	drop := chaosDrop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-drop:
+			log.Debugf("%s server-sent events stream from %s dropped by chaos mode", s.class, clientIP(r))
+			return
+		case <-r.Context().Done():
+			return
+		case u := <-updates:
+			event, data := ssePayload(u)
+			if event == "" {
+				continue
+			}
+			b, err := json.Marshal(data)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// ssePayload returns the event name and data of an update, or no event for
+// updates of candles that are still open.
+func ssePayload(u service.Update) (string, interface{}) {
+	switch data := u.Data.(type) {
+	case *service.Kline:
+		if !data.Final {
+			return "", nil
+		}
+		return "kline", sseKline{Symbol: u.Symbol, Interval: u.Interval, Kline: klineRow(data)}
+	case *service.Ticker24hr:
+		return "ticker", data
+	}
+	return "", nil
+}
+
+// splitList splits a comma separated parameter, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"binance-proxy/internal/metrics"
+	"binance-proxy/internal/service"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metricsSymbolLimit is the number of symbols labelled individually in the
+// per-symbol metrics, 0 turning them off.
+var metricsSymbolLimit atomic.Int64
+
+// SetMetricsSymbols sets how many symbols get their own label in the
+// per-symbol request metrics. Symbols are labelled in the order they are
+// first requested; once n are, the others are counted as "other".
+func SetMetricsSymbols(n int) {
+	metricsSymbolLimit.Store(int64(n))
+}
+
+var (
+	metricsSymbols     sync.Map // symbol -> struct{}
+	metricsSymbolCount atomic.Int64
+)
+
+// cacheSources are Data-Source values of responses answered from cached
+// data.
+var cacheSources = map[string]bool{
+	"websocket":   true,
+	"rest-poll":   true,
+	"cache":       true,
+	"stale-cache": true,
+	"snapshot":    true,
+	"clock":       true,
+}
+
+// metricsSymbol maps a requested symbol to its label, "other" once the
+// limit of labelled symbols is reached.
+func metricsSymbol(symbol string, limit int64) string {
+	if _, ok := metricsSymbols.Load(symbol); ok {
+		return symbol
+	}
+	for {
+		n := metricsSymbolCount.Load()
+		if n >= limit {
+			return "other"
+		}
+		if metricsSymbolCount.CompareAndSwap(n, n+1) {
+			break
+		}
+	}
+	if _, loaded := metricsSymbols.LoadOrStore(symbol, struct{}{}); loaded {
+		metricsSymbolCount.Add(-1)
+	}
+	return symbol
+}
+
+// metricsInterval maps a requested kline interval to a bounded label.
+func metricsInterval(interval string) string {
+	if _, ok := service.INTERVAL_2_DURATION[interval]; ok || interval == "" {
+		return interval
+	}
+	return "other"
+}
+
+// observeSymbol counts a request with a symbol as a cache hit, a miss of a
+// cached endpoint or a forward of another one, and the weight of forwarded
+// ones. Answers the proxy made up, like ban protection, aren't counted.
+func (s *Handler) observeSymbol(r *http.Request, source string) {
+	limit := metricsSymbolLimit.Load()
+	if limit <= 0 {
+		return
+	}
+	symbolParam := "symbol"
+	if strings.HasSuffix(r.URL.Path, "PriceKlines") {
+		_, symbolParam = klinePrice(r.URL.Path)
+	}
+	query := r.URL.Query()
+	symbol := strings.ToUpper(query.Get(symbolParam))
+	if symbol == "" {
+		return
+	}
+
+	result := "hit"
+	switch {
+	case source == "upstream" && isCachedEndpoint(r.URL.Path):
+		result = "miss"
+	case source == "upstream":
+		result = "forward"
+	case !cacheSources[source]:
+		return
+	}
+
+	class, symbol, interval := string(s.class), metricsSymbol(symbol, limit), metricsInterval(query.Get("interval"))
+	metrics.SymbolRequests.WithLabelValues(class, symbol, interval, result).Inc()
+	if result != "hit" {
+		weight := service.RequestWeight(r.Method, r.URL.Path, query)
+		metrics.SymbolUpstreamWeight.WithLabelValues(class, symbol, interval).Add(float64(weight))
+	}
+}
@@ -1,43 +1,281 @@
 package handler
 
 import (
+	"binance-proxy/internal/service"
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// tickerPrice is a row of /api/v3/ticker/price.
+type tickerPrice struct {
+	Symbol string `json:"symbol"`
+	Price  string `json:"price"`
+}
+
+// priceRow returns the price ticker of symbol from its cached last traded
+// price, nil when there is none.
+func (s *Handler) priceRow(symbol string) interface{} {
+	if p := s.srv.CachedLastPrice(symbol); p != nil {
+		return tickerPrice{Symbol: p.Symbol, Price: p.Price}
+	}
+	return nil
+}
+
+// futuresTickerPrice is the response of /fapi/v1/ticker/price.
+type futuresTickerPrice struct {
+	Symbol string `json:"symbol"`
+	Price  string `json:"price"`
+	Time   int64  `json:"time"`
+}
+
+// futuresPriceRow is priceRow for /fapi/v1/ticker/price, whose time is the
+// close time of the 24hr ticker.
+func (s *Handler) futuresPriceRow(symbol string) interface{} {
+	if p := s.srv.CachedLastPrice(symbol); p != nil {
+		return futuresTickerPrice{Symbol: p.Symbol, Price: p.Price, Time: p.Time}
+	}
+	return nil
+}
+
+// ticker24hrRow returns the cached 24hr ticker of symbol, nil when there is
+// none.
+func (s *Handler) ticker24hrRow(symbol string) interface{} {
+	if t := s.srv.CachedTicker(symbol); t != nil {
+		return t
+	}
+	return nil
+}
+
 func (s *Handler) ticker(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("symbols") {
+		s.tickers(w, r, s.ticker24hrRow)
+		return
+	}
 	symbol := r.URL.Query().Get("symbol")
 
 	if symbol == "" {
-		log.Tracef("%s ticker24hr without symbol request proxying via REST", s.class)
+		if log.IsLevelEnabled(log.TraceLevel) {
+			log.Tracef("%s ticker24hr without symbol request proxying via REST", s.class)
+		}
 		s.reverseProxy(w, r)
 		return
 	}
 
-	ticker := s.srv.Ticker(symbol)
+	span := traceLookup(r, "ticker.lookup", symbol)
+	ticker := s.srv.Ticker(r.Context(), symbol)
+	span.SetAttr("binance_proxy.hit", ticker != nil)
+	span.End()
 	if ticker == nil {
-		log.Tracef("%s ticker24hr for %s proxying via REST", s.class, symbol)
+		if log.IsLevelEnabled(log.TraceLevel) {
+			log.Tracef("%s ticker24hr for %s proxying via REST", s.class, symbol)
+		}
+		s.reverseProxy(w, r)
+		return
+	} else {
+		if log.IsLevelEnabled(log.TraceLevel) {
+			log.Tracef("%s ticker24hr for %s delivering via websocket cache", s.class, symbol)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Data-Source", s.streamSource())
+	s.writeJSON(w, r, ticker)
+}
+
+// tickerPrice serves the price ticker of a symbol from the last traded price
+// of the 24hr ticker cache when its stream has data; otherwise the request is forwarded and the stream
+// started. Futures have no symbols form, which Binance is left to answer.
+func (s *Handler) tickerPrice(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if s.class == service.SPOT && query.Has("symbols") {
+		s.tickers(w, r, s.priceRow)
+		return
+	}
+	symbol := query.Get("symbol")
+	if symbol == "" || len(query) > 1 {
 		s.reverseProxy(w, r)
 		return
+	}
+
+	span := traceLookup(r, "ticker.lookup", symbol)
+	var row interface{}
+	if s.class == service.FUTURES {
+		row = s.futuresPriceRow(symbol)
 	} else {
-		log.Tracef("%s ticker24hr for %s delivering via websocket cache", s.class, symbol)
+		row = s.priceRow(symbol)
+	}
+	span.SetAttr("binance_proxy.hit", row != nil)
+	span.End()
+	if row == nil {
+		if log.IsLevelEnabled(log.TraceLevel) {
+			log.Tracef("%s price ticker for %s proxying via REST", s.class, symbol)
+		}
+		s.reverseProxy(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Data-Source", s.streamSource())
+	s.writeJSON(w, r, row)
+}
+
+// avgPrice serves the average price of a spot symbol computed from its 1m
+// klines; otherwise the request is forwarded.
+func (s *Handler) avgPrice(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	symbol := query.Get("symbol")
+	if s.class != service.SPOT || symbol == "" || len(query) > 1 {
+		s.reverseProxy(w, r)
+		return
+	}
+
+	span := traceLookup(r, "klines.lookup", symbol)
+	price := s.srv.AvgPrice(r.Context(), symbol)
+	span.SetAttr("binance_proxy.hit", price != nil)
+	span.End()
+	if price == nil {
+		if log.IsLevelEnabled(log.TraceLevel) {
+			log.Tracef("%s average price for %s proxying via REST", s.class, symbol)
+		}
+		s.reverseProxy(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Data-Source", s.streamSource())
+	s.writeJSON(w, r, price)
+}
+
+// serverTime answers Binance's server time from the local clock corrected by
+// the offset measured by the time sync; before the first sync the request is
+// forwarded.
+func (s *Handler) serverTime(w http.ResponseWriter, r *http.Request) {
+	now, ok := service.ServerTime(s.class)
+	if !ok || len(r.URL.Query()) > 0 {
+		s.reverseProxy(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Data-Source", "clock")
+	s.writeJSON(w, r, map[string]int64{"serverTime": now.UnixMilli()})
+}
+
+// tickers serves the symbols=["BTCUSDT","ETHUSDT"] form of the ticker
+// endpoints. Symbols whose ticker is cached are served locally, the others
+// are requested from Binance in a single request and their streams started.
+// Rows follow the order of the symbols parameter; row returns the cached row
+// of a symbol, nil when there is none.
+func (s *Handler) tickers(w http.ResponseWriter, r *http.Request, row func(symbol string) interface{}) {
+	query := r.URL.Query()
+	var symbols []string
+	if err := json.Unmarshal([]byte(query.Get("symbols")), &symbols); err != nil || len(symbols) == 0 {
+		s.reverseProxy(w, r)
+		return
+	}
+	// MINI tickers and other parameters are left to Binance
+	for name, values := range query {
+		if name != "symbols" && (name != "type" || values[0] != "FULL") {
+			s.reverseProxy(w, r)
+			return
+		}
 	}
 
+	span := traceLookup(r, "ticker.lookup", query.Get("symbols"))
+	rows := make([]interface{}, len(symbols))
+	var missing []string
+	for i, symbol := range symbols {
+		if cached := row(symbol); cached != nil {
+			rows[i] = cached
+		} else {
+			missing = append(missing, symbol)
+		}
+	}
+	span.SetAttr("binance_proxy.hit", len(missing) == 0)
+	span.End()
+
+	if len(missing) > 0 {
+		if log.IsLevelEnabled(log.TraceLevel) {
+			log.Tracef("%s tickers for %d of %d symbols proxying via REST", s.class, len(missing), len(symbols))
+		}
+		fetched, resp := s.fetchTickers(r, missing)
+		if fetched == nil {
+			resp.replay(w, false)
+			return
+		}
+		for i, symbol := range symbols {
+			if rows[i] == nil {
+				if raw, ok := fetched[symbol]; ok {
+					rows[i] = raw
+				}
+			}
+		}
+	} else {
+		w.Header().Set("Data-Source", s.streamSource())
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Data-Source", "websocket")
+	w.Header().Set("X-Proxy-Cached-Symbols", strconv.Itoa(len(symbols)-len(missing)))
+
+	result := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if row != nil {
+			result = append(result, row)
+		}
+	}
+	s.writeJSON(w, r, result)
+}
 
+// fetchTickers requests the tickers of symbols from Binance, returning them
+// by symbol. When Binance doesn't answer with a list of tickers it returns
+// nil and the response, to be passed on to the client.
+func (s *Handler) fetchTickers(r *http.Request, symbols []string) (map[string]json.RawMessage, *bufferedResponse) {
+	list, _ := json.Marshal(symbols)
+	query := url.Values{"symbols": []string{string(list)}}
+	if t := r.URL.Query().Get("type"); t != "" {
+		query.Set("type", t)
+	}
+	sub := r.Clone(r.Context())
+	sub.URL.RawQuery = query.Encode()
+	sub.RequestURI = sub.URL.RequestURI()
+	// Let the transport decompress, the rows are merged with cached ones
+	sub.Header.Del("Accept-Encoding")
+
+	resp := &bufferedResponse{header: make(http.Header)}
+	s.reverseProxy(resp, sub)
+	if resp.status != http.StatusOK {
+		return nil, resp
+	}
+	var rows []json.RawMessage
+	if err := json.Unmarshal(resp.body.Bytes(), &rows); err != nil {
+		return nil, resp
+	}
+	fetched := make(map[string]json.RawMessage, len(rows))
+	for _, raw := range rows {
+		var row struct {
+			Symbol string `json:"symbol"`
+		}
+		if json.Unmarshal(raw, &row) == nil {
+			fetched[row.Symbol] = raw
+		}
+	}
+	return fetched, resp
+}
+
+// writeJSON encodes v as the response body, with a weak ETag that lets
+// polling clients revalidate it.
+func (s *Handler) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
 	buf := GetBuffer()
 	defer PutBuffer(buf)
 
 	encoder := json.NewEncoder(buf)
 	encoder.SetEscapeHTML(false)
-
-	if err := encoder.Encode(ticker); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	if err := encoder.Encode(v); err != nil {
+		writeError(w, http.StatusInternalServerError, codeUnknown, "An unknown error occurred in the proxy while encoding the response.")
 		return
 	}
-
-	w.Write(buf.Bytes())
+	writeETagged(w, r, weakETag(buf.Bytes()), buf.Bytes())
 }
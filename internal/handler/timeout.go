@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	upstreamTimeoutMu  sync.RWMutex
+	upstreamTimeout    = 60 * time.Second
+	maxUpstreamTimeout = 70 * time.Second
+)
+
+// SetUpstreamTimeout sets the default deadline for forwarded requests and the
+// upper bound clients may request through the X-Proxy-Timeout header.
+func SetUpstreamTimeout(def, max time.Duration) {
+	upstreamTimeoutMu.Lock()
+	defer upstreamTimeoutMu.Unlock()
+	upstreamTimeout = def
+	maxUpstreamTimeout = max
+}
+
+// requestTimeout returns the upstream deadline budget for r. Clients can pick
+// their own with X-Proxy-Timeout, either a Go duration ("2.5s") or plain
+// milliseconds, bounded by the configured maximum.
+func requestTimeout(r *http.Request) time.Duration {
+	upstreamTimeoutMu.RLock()
+	def, max := upstreamTimeout, maxUpstreamTimeout
+	upstreamTimeoutMu.RUnlock()
+
+	hint := r.Header.Get("X-Proxy-Timeout")
+	if hint == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(hint)
+	if err != nil {
+		ms, err := strconv.ParseInt(hint, 10, 64)
+		if err != nil {
+			return def
+		}
+		d = time.Duration(ms) * time.Millisecond
+	}
+	if d <= 0 {
+		return def
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+func (s *Handler) gatewayTimeout(w http.ResponseWriter) {
+	w.Header().Set("Data-Source", "proxy-timeout")
+	writeError(w, http.StatusGatewayTimeout, codeTimeout, "Timeout waiting for response from backend server. Send status unknown; execution status unknown.")
+}
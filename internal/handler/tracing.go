@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"binance-proxy/internal/tracing"
+	"net/http"
+)
+
+// endSpan finishes the server span of a request.
+func (s *Handler) endSpan(span *tracing.Span, r *http.Request, rec *responseRecorder, id, source string) {
+	if span == nil {
+		return
+	}
+	// Binance's REST paths carry no identifiers, they name the span as is
+	span.SetName(r.Method + " " + r.URL.Path)
+	span.SetAttr("http.request.method", r.Method)
+	span.SetAttr("url.path", r.URL.Path)
+	span.SetAttr("http.response.status_code", rec.Status())
+	span.SetAttr("client.address", clientIP(r))
+	span.SetAttr("binance.class", string(s.class))
+	span.SetAttr("binance_proxy.request_id", id)
+	span.SetAttr("binance_proxy.data_source", source)
+	if rec.Status() >= 500 {
+		span.Fail("status %d", rec.Status())
+	}
+	span.End()
+}
+
+// traceLookup starts the span of a cache lookup for a request.
+func traceLookup(r *http.Request, name, symbol string) *tracing.Span {
+	_, span := tracing.Start(r.Context(), name, tracing.KindInternal)
+	span.SetAttr("binance.symbol", symbol)
+	return span
+}
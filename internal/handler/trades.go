@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// futuresTrade is a trade in the layout of /fapi/v1/trades, which has no
+// isBestMatch field.
+type futuresTrade struct {
+	ID            int64  `json:"id"`
+	Price         string `json:"price"`
+	Quantity      string `json:"qty"`
+	QuoteQuantity string `json:"quoteQty"`
+	Time          int64  `json:"time"`
+	IsBuyerMaker  bool   `json:"isBuyerMaker"`
+}
+
+func (s *Handler) trades(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	limit := r.URL.Query().Get("limit")
+	if limit == "" {
+		limit = "500"
+	}
+
+	// Larger limits than the retention and fromId lookups go to Binance
+	limitInt, err := strconv.Atoi(limit)
+	switch {
+	case err != nil, symbol == "", limitInt <= 0, limitInt > service.TradesRetention(), r.URL.Query().Has("fromId"):
+		s.reverseProxy(w, r)
+		return
+	}
+
+	span := traceLookup(r, "trades.lookup", symbol)
+	trades := s.srv.Trades(r.Context(), symbol, limitInt)
+	span.SetAttr("binance_proxy.hit", len(trades) == limitInt)
+	span.End()
+	// Streams that just started during a ban hold fewer trades than asked for
+	if len(trades) < limitInt {
+		s.reverseProxy(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Data-Source", "websocket")
+
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+
+	var resp interface{} = trades
+	if s.class == service.FUTURES {
+		rows := make([]futuresTrade, len(trades))
+		for i, t := range trades {
+			rows[i] = futuresTrade{ID: t.ID, Price: t.Price, Quantity: t.Quantity, QuoteQuantity: t.QuoteQuantity, Time: t.Time, IsBuyerMaker: t.IsBuyerMaker}
+		}
+		resp = rows
+	}
+	if err := encoder.Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, codeUnknown, "An unknown error occurred in the proxy while encoding the response.")
+		return
+	}
+
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,333 @@
+package handler
+
+import (
+	"binance-proxy/internal/service"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	wsMaxStreams   = 200
+	wsWriteTimeout = 10 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsPingInterval = 30 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin:     checkWsOrigin,
+}
+
+// wsOrigins are the origins allowed to open downstream websockets besides
+// the proxy's own, "*" allowing any.
+var wsOrigins atomic.Pointer[map[string]bool]
+
+// SetWsAllowedOrigins sets the origins, such as https://dashboard.example.com,
+// whose browser pages may open downstream websockets besides pages served by
+// the proxy itself. "*" allows any origin.
+func SetWsAllowedOrigins(origins []string) error {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		origin = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" || u.RawQuery != "" || u.User != nil) {
+				return fmt.Errorf("invalid websocket origin %q, expected scheme://host[:port]", origin)
+			}
+		}
+		allowed[origin] = true
+	}
+	wsOrigins.Store(&allowed)
+	if len(allowed) > 0 {
+		log.Infof("Downstream websockets accept %d origins besides the proxy's own", len(allowed))
+	}
+	return nil
+}
+
+// checkWsOrigin accepts websocket handshakes without an Origin header, as
+// sent by bots and other non-browser clients, from the proxy's own origin
+// and from the allowed origins. Other browser pages are refused, so a site
+// a user visits can't read the proxy's streams through their browser.
+func checkWsOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	allowed := wsOrigins.Load()
+	if allowed == nil {
+		return false
+	}
+	return (*allowed)["*"] || (*allowed)[strings.ToLower(u.Scheme+"://"+u.Host)]
+}
+
+// wsRequest mirrors the subscription messages accepted by Binance's websocket API.
+type wsRequest struct {
+	Method string   `json:"method"`
+	Params []string `json:"params"`
+	ID     int64    `json:"id"`
+
+	invalid bool
+}
+
+type wsClient struct {
+	h       *Handler
+	conn    *websocket.Conn
+	updates chan service.Update
+	subs    map[string]func() // stream name -> unsubscribe
+}
+
+// ws serves a downstream websocket. Clients subscribe to streams named like
+// Binance's (btcusdt@kline_5m, btcusdt@depth, btcusdt@ticker) either with
+// ?streams=a/b/c or SUBSCRIBE/UNSUBSCRIBE messages, and receive updates as
+// {"stream":...,"data":...} pushed from the proxy's upstream websockets.
+// With cross-class streams, names prefixed with spot: or futures: select the
+// other market.
+func (s *Handler) ws(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debugf("%s websocket upgrade from %s failed: %s", s.class, clientIP(r), err)
+		return
+	}
+	log.Debugf("%s downstream websocket opened from %s", s.class, clientIP(r))
+
+	c := &wsClient{
+		h:       s,
+		conn:    conn,
+		updates: make(chan service.Update, 256),
+		subs:    make(map[string]func()),
+	}
+	defer func() {
+		for _, unsubscribe := range c.subs {
+			unsubscribe()
+		}
+		conn.Close()
+		log.Debugf("%s downstream websocket from %s closed", s.class, clientIP(r))
+	}()
+
+	if streams := r.URL.Query().Get("streams"); streams != "" {
+		if err := c.subscribe(strings.Split(streams, "/")); err != nil {
+			c.writeJSON(wsError(2, err.Error(), nil))
+			return
+		}
+	}
+
+	requests := make(chan wsRequest)
+	done := make(chan struct{})
+	defer close(done)
+	go c.readLoop(requests, done)
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	drop := chaosDrop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-drop:
+			log.Debugf("%s downstream websocket from %s dropped by chaos mode", s.class, clientIP(r))
+			return
+		case req, ok := <-requests:
+			if !ok {
+				return
+			}
+			if c.writeJSON(c.handleRequest(req)) != nil {
+				return
+			}
+		case u := <-c.updates:
+			if c.writeJSON(map[string]interface{}{"stream": c.streamName(u), "data": wsPayload(u)}) != nil {
+				return
+			}
+		case <-ping.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsClient) readLoop(requests chan<- wsRequest, done <-chan struct{}) {
+	defer close(requests)
+
+	c.conn.SetReadLimit(64 * 1024)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		var req wsRequest
+		if err := c.conn.ReadJSON(&req); err != nil {
+			if _, ok := err.(*json.SyntaxError); !ok {
+				return
+			}
+			req = wsRequest{invalid: true}
+		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+
+		select {
+		case requests <- req:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (c *wsClient) handleRequest(req wsRequest) interface{} {
+	if req.invalid {
+		return wsError(3, "Invalid JSON", nil)
+	}
+
+	var err error
+	var result interface{}
+	switch strings.ToUpper(req.Method) {
+	case "SUBSCRIBE":
+		err = c.subscribe(req.Params)
+	case "UNSUBSCRIBE":
+		c.unsubscribe(req.Params)
+	case "LIST_SUBSCRIPTIONS":
+		streams := make([]string, 0, len(c.subs))
+		for name := range c.subs {
+			streams = append(streams, name)
+		}
+		result = streams
+	default:
+		err = fmt.Errorf("unknown method %q", req.Method)
+	}
+
+	if err != nil {
+		return wsError(2, err.Error(), req.ID)
+	}
+	return map[string]interface{}{"result": result, "id": req.ID}
+}
+
+func wsError(code int, msg string, id interface{}) map[string]interface{} {
+	return map[string]interface{}{"error": map[string]interface{}{"code": code, "msg": msg}, "id": id}
+}
+
+func (c *wsClient) subscribe(streams []string) error {
+	for _, raw := range streams {
+		class, kind, symbol, interval, err := c.parseStream(strings.TrimSpace(raw))
+		if err != nil {
+			return err
+		}
+
+		name := c.streamName(service.Update{Class: class, Stream: kind, Symbol: symbol, Interval: interval})
+		if _, ok := c.subs[name]; ok {
+			continue
+		}
+		if len(c.subs) >= wsMaxStreams {
+			return fmt.Errorf("too many streams, at most %d are allowed per connection", wsMaxStreams)
+		}
+
+		unsubscribe, err := c.h.srv.SubscribeClass(class, kind, symbol, interval, c.updates)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		c.subs[name] = unsubscribe
+	}
+
+	return nil
+}
+
+func (c *wsClient) unsubscribe(streams []string) {
+	for _, raw := range streams {
+		class, kind, symbol, interval, err := c.parseStream(strings.TrimSpace(raw))
+		if err != nil {
+			continue
+		}
+
+		name := c.streamName(service.Update{Class: class, Stream: kind, Symbol: symbol, Interval: interval})
+		if unsubscribe, ok := c.subs[name]; ok {
+			unsubscribe()
+			delete(c.subs, name)
+		}
+	}
+}
+
+func (c *wsClient) writeJSON(v interface{}) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.conn.WriteJSON(v)
+}
+
+// parseStreamName splits a Binance style stream name into its parts.
+func parseStreamName(name string) (kind, symbol, interval string, err error) {
+	symbol, stream, ok := strings.Cut(name, "@")
+	if !ok || symbol == "" {
+		return "", "", "", fmt.Errorf("invalid stream name %q", name)
+	}
+	symbol = strings.ToUpper(symbol)
+
+	switch {
+	case strings.HasPrefix(stream, "kline_"):
+		// Intervals are case sensitive (1m vs 1M), so take them from the raw name
+		return service.StreamKline, symbol, strings.TrimPrefix(stream, "kline_"), nil
+	case stream == "depth":
+		return service.StreamDepth, symbol, "", nil
+	case stream == "ticker":
+		return service.StreamTicker, symbol, "", nil
+	}
+
+	return "", "", "", fmt.Errorf("unsupported stream %q", name)
+}
+
+// parseStream is parseStreamName for names that may be prefixed with the
+// class of another market, like spot:btcusdt@ticker on the futures port.
+func (c *wsClient) parseStream(name string) (class service.Class, kind, symbol, interval string, err error) {
+	class = c.h.class
+	if prefix, rest, ok := strings.Cut(name, ":"); ok {
+		if class, err = service.ParseClass(prefix); err != nil {
+			return "", "", "", "", fmt.Errorf("invalid stream name %q", name)
+		}
+		name = rest
+	}
+	kind, symbol, interval, err = parseStreamName(name)
+	return class, kind, symbol, interval, err
+}
+
+// streamName names an update for this client, with the class prefix for
+// streams of another market.
+func (c *wsClient) streamName(u service.Update) string {
+	if u.Class != "" && u.Class != c.h.class {
+		return strings.ToLower(string(u.Class)) + ":" + streamName(u)
+	}
+	return streamName(u)
+}
+
+func streamName(u service.Update) string {
+	name := strings.ToLower(u.Symbol) + "@" + u.Stream
+	if u.Stream == service.StreamKline {
+		name += "_" + u.Interval
+	}
+	return name
+}
+
+// wsPayload renders an update in the same layout as the matching REST endpoint.
+func wsPayload(u service.Update) interface{} {
+	switch data := u.Data.(type) {
+	case *service.Kline:
+		return klineRow(data)
+	case *service.Depth:
+		return depthResponse(data, 20)
+	}
+	return u.Data
+}
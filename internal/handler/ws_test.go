@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckWsOrigin(t *testing.T) {
+	t.Cleanup(func() { SetWsAllowedOrigins(nil) })
+
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no origin", nil, "", true},
+		{"same origin", nil, "http://localhost:8090", true},
+		{"same origin over TLS", nil, "https://LOCALHOST:8090", true},
+		{"other port", nil, "http://localhost:8091", false},
+		{"other site", nil, "https://evil.example", false},
+		{"invalid origin", nil, "://", false},
+		{"allowed", []string{"https://dash.example.com"}, "https://dash.example.com", true},
+		{"allowed case and slash", []string{"HTTPS://Dash.example.com/"}, "https://dash.example.com", true},
+		{"allowed other scheme", []string{"https://dash.example.com"}, "http://dash.example.com", false},
+		{"not allowed", []string{"https://dash.example.com"}, "https://evil.example", false},
+		{"any", []string{"*"}, "https://evil.example", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetWsAllowedOrigins(tt.allowed); err != nil {
+				t.Fatalf("SetWsAllowedOrigins(%q) failed: %s", tt.allowed, err)
+			}
+			r := httptest.NewRequest("GET", "http://localhost:8090/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := checkWsOrigin(r); got != tt.want {
+				t.Errorf("checkWsOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetWsAllowedOriginsInvalid(t *testing.T) {
+	t.Cleanup(func() { SetWsAllowedOrigins(nil) })
+
+	for _, origin := range []string{"dash.example.com", "https://", "https://dash.example.com/app", "https://user@dash.example.com"} {
+		if err := SetWsAllowedOrigins([]string{origin}); err == nil {
+			t.Errorf("SetWsAllowedOrigins(%q) accepted an invalid origin", origin)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// influxWriter writes candles in line protocol to the InfluxDB v2 write API,
+// which InfluxDB 3 serves as well.
+type influxWriter struct {
+	url         string
+	host        string
+	token       string
+	measurement string
+	client      *http.Client
+}
+
+func newInfluxWriter(u *url.URL) (*influxWriter, error) {
+	bucket := strings.Trim(u.Path, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid InfluxDB URL, expected influxdb://[token@]host:8086/bucket")
+	}
+	measurement := u.Query().Get("measurement")
+	if measurement == "" {
+		measurement = "klines"
+	}
+
+	scheme := "http"
+	if u.Scheme == "influxdbs" {
+		scheme = "https"
+	}
+	query := url.Values{"bucket": []string{bucket}, "precision": []string{"ms"}}
+	if org := u.Query().Get("org"); org != "" {
+		query.Set("org", org)
+	}
+	w := &influxWriter{
+		url:         scheme + "://" + u.Host + "/api/v2/write?" + query.Encode(),
+		host:        scheme + "://" + u.Host + "/" + bucket,
+		measurement: escapeInflux(measurement, ", "),
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}
+	if u.User != nil {
+		w.token = u.User.Username()
+	}
+	return w, nil
+}
+
+func (w *influxWriter) name() string {
+	return w.host
+}
+
+func (w *influxWriter) write(ctx context.Context, candles []candle) error {
+	var body bytes.Buffer
+	for _, c := range candles {
+		k := c.kline
+		fmt.Fprintf(&body, "%s,class=%s,symbol=%s,interval=%s open=%s,high=%s,low=%s,close=%s,volume=%s,quote_volume=%s,taker_buy_volume=%s,taker_buy_quote_volume=%s,trades=%di,close_time=%di %d\n",
+			w.measurement,
+			escapeInflux(string(c.class), ",= "), escapeInflux(c.symbol, ",= "), escapeInflux(c.interval, ",= "),
+			influxFloat(k.Open), influxFloat(k.High), influxFloat(k.Low), influxFloat(k.Close),
+			influxFloat(k.Volume), influxFloat(k.QuoteAssetVolume),
+			influxFloat(k.TakerBuyBaseAssetVolume), influxFloat(k.TakerBuyQuoteAssetVolume),
+			k.TradeNum, k.CloseTime, k.OpenTime)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (w *influxWriter) close() {}
+
+// escapeInflux backslash escapes the characters of chars in a measurement
+// or tag.
+func escapeInflux(s, chars string) string {
+	if !strings.ContainsAny(s, chars) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// influxFloat returns a decimal string from Binance as a float field value;
+// anything that isn't a plain number is written as 0.
+func influxFloat(s string) string {
+	if s == "" || strings.Trim(s, "0123456789.-") != "" {
+		return "0"
+	}
+	return s
+}
@@ -0,0 +1,238 @@
+package history
+
+import (
+	"binance-proxy/internal/service"
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// The SCRAM-SHA-256 exchange of RFC 7677, section 3.
+func TestSCRAMClientRFC7677(t *testing.T) {
+	c := &scramClient{password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO", firstBare: "n=user,r=rOprNGfwEbeRWgbNEkqO"}
+	if first := c.clientFirst(); first != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Errorf("client-first-message = %q", first)
+	}
+
+	final, err := c.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="; final != want {
+		t.Errorf("client-final-message = %q, want %q", final, want)
+	}
+	if !c.verify("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=") {
+		t.Errorf("valid server signature rejected")
+	}
+	if c.verify("v=AAAATRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=") {
+		t.Errorf("invalid server signature accepted")
+	}
+
+	if _, err := c.clientFinal("r=someoneElsesNonce,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"); err == nil {
+		t.Errorf("server nonce not starting with the client nonce accepted")
+	}
+}
+
+// fakePostgres plays the server side of a connection, reusing the framing of
+// postgresWriter.
+type fakePostgres struct {
+	*postgresWriter
+}
+
+func newFakePostgres(t *testing.T) (*postgresWriter, <-chan *fakePostgres) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	servers := make(chan *fakePostgres, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		servers <- &fakePostgres{&postgresWriter{conn: conn, r: bufio.NewReader(conn)}}
+	}()
+
+	u, _ := url.Parse("postgres://postgres:postgres@" + l.Addr().String() + "/market?table=candles")
+	w, err := newPostgresWriter(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.close)
+	return w, servers
+}
+
+// startup reads the startup message and returns its parameters.
+func (s *fakePostgres) startup() (map[string]string, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(s.r, size[:]); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(size[:])-4)
+	if _, err := io.ReadFull(s.r, body); err != nil {
+		return nil, err
+	}
+	if v := binary.BigEndian.Uint32(body); v != 3<<16 {
+		return nil, errors.New("unexpected protocol version")
+	}
+	params := make(map[string]string)
+	fields := strings.Split(strings.TrimRight(string(body[4:]), "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		params[fields[i]] = fields[i+1]
+	}
+	return params, nil
+}
+
+// expect reads a message of type typ and returns its body.
+func (s *fakePostgres) expect(typ byte) ([]byte, error) {
+	got, body, err := s.receive()
+	if err == nil && got != typ {
+		err = errors.New("got message " + string(got) + ", want " + string(typ))
+	}
+	return body, err
+}
+
+func (s *fakePostgres) ready() error {
+	return s.send('Z', []byte("I"))
+}
+
+// serveInsert answers the Parse, Bind, Execute and Sync of an INSERT,
+// returning the query and its arguments, or a server error when fail is set.
+func (s *fakePostgres) serveInsert(fail bool) (query string, args []string, err error) {
+	parse, err := s.expect('P')
+	if err != nil {
+		return "", nil, err
+	}
+	query, _, _ = strings.Cut(strings.TrimPrefix(string(parse), "\x00"), "\x00")
+
+	bind, err := s.expect('B')
+	if err != nil {
+		return "", nil, err
+	}
+	// unnamed portal and statement, no format codes
+	bind = bind[4:]
+	n := int(binary.BigEndian.Uint16(bind))
+	bind = bind[2:]
+	for range n {
+		size := binary.BigEndian.Uint32(bind)
+		args = append(args, string(bind[4:4+size]))
+		bind = bind[4+size:]
+	}
+
+	if _, err := s.expect('E'); err != nil {
+		return "", nil, err
+	}
+	if _, err := s.expect('S'); err != nil {
+		return "", nil, err
+	}
+	if fail {
+		s.send('E', []byte("SERROR\x00C22P02\x00Minvalid input syntax for type numeric\x00\x00"))
+	} else {
+		s.send('1', nil)
+		s.send('2', nil)
+		s.send('C', []byte("INSERT 0 2\x00"))
+	}
+	return query, args, s.ready()
+}
+
+func TestPostgresWriter(t *testing.T) {
+	w, servers := newFakePostgres(t)
+	candles := []candle{
+		{service.SPOT, "BTCUSDT", "1m", &service.Kline{OpenTime: 1700000000000, Open: "37000.01", High: "37010", Low: "36990.5", Close: "37005", Volume: "12.5",
+			CloseTime: 1700000059999, QuoteAssetVolume: "462500", TradeNum: 321, TakerBuyBaseAssetVolume: "6", TakerBuyQuoteAssetVolume: "222000"}},
+		{service.FUTURES, "ETH'); DROP TABLE candles; --", "5m", &service.Kline{OpenTime: 1700000100000, Open: "1", High: "1", Low: "1", Close: "1", Volume: "1",
+			CloseTime: 1700000399999, QuoteAssetVolume: "1", TradeNum: 1, TakerBuyBaseAssetVolume: "1", TakerBuyQuoteAssetVolume: "1"}},
+	}
+
+	type result struct {
+		query string
+		args  []string
+		err   error
+	}
+	results := make(chan result, 2)
+	go func() {
+		s := <-servers
+		err := func() error {
+			params, err := s.startup()
+			if err != nil {
+				return err
+			}
+			if params["user"] != "postgres" || params["database"] != "market" {
+				return errors.New("unexpected startup parameters")
+			}
+
+			// MD5 authentication, salted with 01020304
+			salt := []byte{1, 2, 3, 4}
+			if err := s.send('R', append([]byte{0, 0, 0, 5}, salt...)); err != nil {
+				return err
+			}
+			password, err := s.expect('p')
+			if err != nil {
+				return err
+			}
+			// md5 of "postgres" + "postgres" as stored in pg_authid
+			sum := md5.Sum(append([]byte("3175bce1d3201d16594cebf9d7eb3f9d"), salt...))
+			if string(password) != "md5"+hex.EncodeToString(sum[:])+"\x00" {
+				return errors.New("wrong MD5 password " + string(password))
+			}
+			s.send('R', []byte{0, 0, 0, 0})
+			s.ready()
+
+			if _, err := s.expect('Q'); err != nil { // CREATE TABLE
+				return err
+			}
+			s.send('C', []byte("CREATE TABLE\x00"))
+			s.ready()
+			return nil
+		}()
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		for _, fail := range []bool{false, true} {
+			query, args, err := s.serveInsert(fail)
+			results <- result{query, args, err}
+		}
+	}()
+
+	if err := w.write(context.Background(), candles); err != nil {
+		t.Fatal(err)
+	}
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if !strings.HasPrefix(r.query, "INSERT INTO candles (") || !strings.Contains(r.query, "$28::numeric)") || strings.Contains(r.query, "ETH") {
+		t.Errorf("query = %q", r.query)
+	}
+	want := []string{"SPOT", "BTCUSDT", "1m", "1700000000000", "37000.01", "37010", "36990.5", "37005", "12.5", "1700000059999", "462500", "321", "6", "222000"}
+	if len(r.args) != 2*postgresColumns || strings.Join(r.args[:postgresColumns], " ") != strings.Join(want, " ") {
+		t.Errorf("arguments = %q", r.args)
+	}
+	if r.args[postgresColumns+1] != candles[1].symbol {
+		t.Errorf("symbol argument = %q, want %q", r.args[postgresColumns+1], candles[1].symbol)
+	}
+
+	// A rejected statement keeps the connection
+	var pgErr *postgresError
+	if err := w.write(context.Background(), candles); !errors.As(err, &pgErr) || pgErr.code != "22P02" {
+		t.Errorf("write error = %v, want the server error", err)
+	}
+	if r := <-results; r.err != nil {
+		t.Fatal(r.err)
+	}
+	if w.conn == nil {
+		t.Errorf("connection closed after a server error")
+	}
+}
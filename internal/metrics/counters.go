@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// CounterSet counts events by key with atomic counters. At most max keys are
+// tracked; events of further keys are counted under the overflow key, so
+// keys derived from client input can't grow the set without bound. Add and
+// Snapshot don't take locks once a key is known.
+type CounterSet[K comparable] struct {
+	max      int64
+	overflow K
+	size     atomic.Int64
+	counts   sync.Map // K -> *atomic.Int64
+}
+
+// NewCounterSet creates a CounterSet tracking up to max keys besides
+// overflow.
+func NewCounterSet[K comparable](max int, overflow K) *CounterSet[K] {
+	return &CounterSet[K]{max: int64(max), overflow: overflow}
+}
+
+// Add adds n to the counter of key.
+func (c *CounterSet[K]) Add(key K, n int64) {
+	v, ok := c.counts.Load(key)
+	if !ok {
+		v = c.slot(key)
+	}
+	v.(*atomic.Int64).Add(n)
+}
+
+// slot returns the counter of a key seen for the first time, the overflow
+// counter once the set is full.
+func (c *CounterSet[K]) slot(key K) interface{} {
+	if key != c.overflow {
+		if c.size.Add(1) <= c.max {
+			v, loaded := c.counts.LoadOrStore(key, new(atomic.Int64))
+			if loaded {
+				c.size.Add(-1)
+			}
+			return v
+		}
+		c.size.Add(-1)
+	}
+	v, _ := c.counts.LoadOrStore(c.overflow, new(atomic.Int64))
+	return v
+}
+
+// Snapshot returns the counters by key. Counters are read one by one, so
+// events added meanwhile may be partly included.
+func (c *CounterSet[K]) Snapshot() map[K]int64 {
+	counts := make(map[K]int64)
+	c.counts.Range(func(k, v interface{}) bool {
+		counts[k.(K)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	return counts
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCounterSetCounts(t *testing.T) {
+	c := NewCounterSet(4, "other")
+	c.Add("a", 1)
+	c.Add("a", 2)
+	c.Add("b", 5)
+
+	got := c.Snapshot()
+	want := map[string]int64{"a": 3, "b": 5}
+	if len(got) != len(want) {
+		t.Fatalf("Snapshot() = %v, want %v", got, want)
+	}
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("Snapshot()[%q] = %d, want %d", k, got[k], n)
+		}
+	}
+}
+
+func TestCounterSetBound(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		keys []string
+		want map[string]int64
+	}{
+		{"below", 3, []string{"a", "b", "a"}, map[string]int64{"a": 2, "b": 1}},
+		{"at", 2, []string{"a", "b", "b"}, map[string]int64{"a": 1, "b": 2}},
+		{"above", 2, []string{"a", "b", "c", "d", "a"}, map[string]int64{"a": 2, "b": 1, "other": 2}},
+		{"overflow key itself", 1, []string{"other", "a", "b"}, map[string]int64{"a": 1, "other": 2}},
+		{"zero", 0, []string{"a", "b"}, map[string]int64{"other": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCounterSet(tt.max, "other")
+			for _, k := range tt.keys {
+				c.Add(k, 1)
+			}
+			got := c.Snapshot()
+			if len(got) != len(tt.want) {
+				t.Fatalf("Snapshot() = %v, want %v", got, tt.want)
+			}
+			for k, n := range tt.want {
+				if got[k] != n {
+					t.Errorf("Snapshot()[%q] = %d, want %d", k, got[k], n)
+				}
+			}
+		})
+	}
+}
+
+func TestCounterSetConcurrent(t *testing.T) {
+	const (
+		goroutines = 16
+		adds       = 2000
+		max        = 10
+	)
+	c := NewCounterSet(max, "other")
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				c.Add(fmt.Sprintf("key-%d", (g*adds+i)%50), 1)
+				if i%100 == 0 {
+					c.Snapshot()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	got := c.Snapshot()
+	if len(got) > max+1 {
+		t.Errorf("Snapshot() has %d keys, want at most %d", len(got), max+1)
+	}
+	var total int64
+	for _, n := range got {
+		total += n
+	}
+	if total != goroutines*adds {
+		t.Errorf("counted %d events, want %d", total, goroutines*adds)
+	}
+}
+
+// BenchmarkCounterSetAdd adds to a few known keys from parallel goroutines,
+// the steady state of the request counters.
+func BenchmarkCounterSetAdd(b *testing.B) {
+	c := NewCounterSet(64, "other")
+	keys := []string{"/api/v3/klines", "/api/v3/depth", "/api/v3/ticker/24hr", "/fapi/v1/klines"}
+	for _, k := range keys {
+		c.Add(k, 1)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Add(keys[i%len(keys)], 1)
+			i++
+		}
+	})
+}
+
+// BenchmarkMutexMapAdd is the mutex guarded map CounterSet replaces, for
+// comparison with BenchmarkCounterSetAdd.
+func BenchmarkMutexMapAdd(b *testing.B) {
+	var mu sync.Mutex
+	counts := map[string]int64{}
+	keys := []string{"/api/v3/klines", "/api/v3/depth", "/api/v3/ticker/24hr", "/fapi/v1/klines"}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			mu.Lock()
+			counts[keys[i%len(keys)]]++
+			mu.Unlock()
+			i++
+		}
+	})
+}
+
+// BenchmarkCounterSetAddSnapshot adds while another goroutine keeps taking
+// snapshots, as /metrics scrapes do under load.
+func BenchmarkCounterSetAddSnapshot(b *testing.B) {
+	c := NewCounterSet(64, "other")
+	keys := []string{"/api/v3/klines", "/api/v3/depth", "/api/v3/ticker/24hr", "/fapi/v1/klines"}
+	for _, k := range keys {
+		c.Add(k, 1)
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				c.Snapshot()
+			}
+		}
+	}()
+	defer close(done)
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Add(keys[i%len(keys)], 1)
+			i++
+		}
+	})
+}
@@ -0,0 +1,328 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "binance_proxy"
+
+var (
+	// Requests counts downstream requests by the data source that served them
+	// (websocket, cache, upstream, ban-protection, ...).
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Downstream requests served, by class, endpoint, data source and status code.",
+	}, []string{"class", "endpoint", "source", "code"})
+
+	// RequestDuration observes downstream response latencies.
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Downstream response latency, by class, endpoint and data source.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"class", "endpoint", "source"})
+
+	// SymbolRequests counts requests of symbols by whether the cache served
+	// them. Symbols beyond the cardinality cap share symbol="other".
+	SymbolRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "symbol_requests_total",
+		Help:      "Downstream requests with a symbol, by class, symbol, interval and result (hit, miss, forward).",
+	}, []string{"class", "symbol", "interval", "result"})
+
+	// SymbolUpstreamWeight counts the weight of forwarded requests by symbol.
+	SymbolUpstreamWeight = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "symbol_upstream_weight_total",
+		Help:      "Estimated Binance weight of forwarded requests with a symbol, by class, symbol and interval.",
+	}, []string{"class", "symbol", "interval"})
+
+	// LocalRequestDuration observes the latencies of responses the proxy
+	// answered itself, from its caches or with a synthetic answer, in buckets
+	// fine enough for sub-millisecond answers.
+	LocalRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "local_request_duration_seconds",
+		Help:      "Latency of responses answered without Binance, by class, endpoint and data source.",
+		Buckets:   []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"class", "endpoint", "source"})
+
+	// ForwardedRequestDuration observes the latencies of forwarded requests,
+	// including the wait for weight and a forwarding slot, up to the longest
+	// upstream deadline.
+	ForwardedRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "forwarded_request_duration_seconds",
+		Help:      "Latency of requests forwarded to Binance, by class and endpoint.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"class", "endpoint"})
+
+	// UpstreamResponses counts responses received for requests forwarded to Binance.
+	UpstreamResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_responses_total",
+		Help:      "Responses received from Binance for forwarded requests, by class and status code.",
+	}, []string{"class", "code"})
+
+	// ResponsesTooLarge counts forwarded responses dropped for exceeding the
+	// maximum response size.
+	ResponsesTooLarge = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_responses_too_large_total",
+		Help:      "Forwarded responses dropped for exceeding the maximum response size, by class and endpoint.",
+	}, []string{"class", "endpoint"})
+
+	// CacheBypasses counts requests for cached endpoints forwarded because the
+	// client asked for fresher data.
+	CacheBypasses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_bypasses_total",
+		Help:      "Requests for cached endpoints forwarded on the client's request, by class and reason (bypass, max-age).",
+	}, []string{"class", "reason"})
+
+	// ActiveStreams tracks upstream websocket services currently running.
+	ActiveStreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_streams",
+		Help:      "Upstream websocket services currently running, by class and stream kind.",
+	}, []string{"class", "stream"})
+
+	// StreamReconnects counts upstream websocket reconnect attempts.
+	StreamReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_reconnects_total",
+		Help:      "Upstream websocket reconnect attempts, by class and stream kind.",
+	}, []string{"class", "stream"})
+
+	// CompressedResponses counts responses compressed by the proxy.
+	CompressedResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "compressed_responses_total",
+		Help:      "Responses compressed by the proxy, by encoding.",
+	}, []string{"encoding"})
+
+	// CompressionSavedBytes counts the bytes response compression saved.
+	CompressionSavedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "compression_saved_bytes_total",
+		Help:      "Response bytes saved by compression, by encoding.",
+	}, []string{"encoding"})
+
+	// ExchangeInfoAge is the time since the cached exchangeInfo was fetched.
+	ExchangeInfoAge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "exchange_info_age_seconds",
+		Help:      "Seconds since the served exchangeInfo was fetched from Binance, by class.",
+	}, []string{"class"})
+
+	// UpstreamWeightUsed is the weight Binance reported as used in the current
+	// minute.
+	UpstreamWeightUsed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_weight_used",
+		Help:      "Request weight used in the current minute as reported by Binance's X-MBX-USED-WEIGHT-1M header, by class.",
+	}, []string{"class"})
+
+	// UpstreamWeightLimit is the per-minute weight limit of Binance.
+	UpstreamWeightLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_weight_limit",
+		Help:      "Request weight Binance allows per minute, by class.",
+	}, []string{"class"})
+
+	// UpstreamWeightApproaching is 1 while the used weight is above 90% of
+	// the limit.
+	UpstreamWeightApproaching = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_weight_limit_approaching",
+		Help:      "1 while the used weight of the current minute is above 90% of the limit, by class.",
+	}, []string{"class"})
+
+	// BanActive is 1 while upstream requests are suspended for a ban.
+	BanActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ban_active",
+		Help:      "1 while upstream requests are suspended because Binance banned or rate limited the proxy, by class.",
+	}, []string{"class"})
+
+	// BanRemaining is the time left until a ban ends.
+	BanRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ban_remaining_seconds",
+		Help:      "Seconds until upstream requests resume after a ban, 0 without one, by class.",
+	}, []string{"class"})
+
+	// ForwardsInFlight is the number of requests being forwarded to Binance.
+	ForwardsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "forwards_in_flight",
+		Help:      "Requests currently forwarded to Binance, by class.",
+	}, []string{"class"})
+
+	// ForwardQueue is the number of requests waiting for a forwarding slot.
+	ForwardQueue = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "forward_queue_depth",
+		Help:      "Requests waiting for a slot to be forwarded to Binance, by class.",
+	}, []string{"class"})
+
+	// ForwardRejections counts requests that didn't get a forwarding slot.
+	ForwardRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "forward_rejections_total",
+		Help:      "Requests answered without being forwarded because the forward queue was full or their deadline ran out in it, by class and reason.",
+	}, []string{"class", "reason"})
+
+	// SourceIPDials counts connections to Binance by the local address they
+	// were bound to.
+	SourceIPDials = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_source_ip_dials_total",
+		Help:      "Connections dialed to Binance from each configured source IP, by class and source_ip.",
+	}, []string{"class", "source_ip"})
+
+	// ActiveEndpoint marks the upstream REST endpoint requests are sent to.
+	ActiveEndpoint = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_endpoint_active",
+		Help:      "1 for the upstream REST endpoint of a class requests are sent to, by class and host.",
+	}, []string{"class", "host"})
+
+	// EndpointLatency is the moving average of the health probe latency of
+	// each upstream REST endpoint.
+	EndpointLatency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_endpoint_latency_seconds",
+		Help:      "Moving average of the ping latency of upstream REST endpoints, by class and host.",
+	}, []string{"class", "host"})
+
+	// ClockOffset is the measured offset of Binance's clock from the local
+	// one.
+	ClockOffset = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_clock_offset_seconds",
+		Help:      "Binance server time minus the local clock, measured by the time sync, by class.",
+	}, []string{"class"})
+
+	// EndpointFailovers counts switches to another upstream REST endpoint.
+	EndpointFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_endpoint_failovers_total",
+		Help:      "Switches to another upstream REST endpoint after the active one failed, by class.",
+	}, []string{"class"})
+
+	// RESTPolling reports the classes whose streams are refreshed through
+	// REST polls because websockets are blocked.
+	RESTPolling = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rest_polling",
+		Help:      "1 while the klines, depth and tickers of a class are polled through REST instead of websockets.",
+	}, []string{"class"})
+
+	// SLOGoodRatio is the share of good requests of an SLO per window.
+	SLOGoodRatio = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "slo_good_ratio",
+		Help:      "Share of cached endpoint requests meeting the objective, by class, objective and window.",
+	}, []string{"class", "slo", "window"})
+
+	// SLOBurnRate is how fast an SLO's error budget is spent per window,
+	// 1 spending it exactly over the window.
+	SLOBurnRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "slo_burn_rate",
+		Help:      "Error budget burn rate, by class, objective and window.",
+	}, []string{"class", "slo", "window"})
+
+	// SLOBudgetRemaining is the share of the 6h error budget left.
+	SLOBudgetRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "slo_error_budget_remaining",
+		Help:      "Share of the error budget of the last 6 hours left, by class and objective.",
+	}, []string{"class", "slo"})
+
+	// SLOAlert is 1 while a burn rate alert fires.
+	SLOAlert = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "slo_alert",
+		Help:      "1 while the multi-window burn rate alert of the severity fires, by class, objective and severity.",
+	}, []string{"class", "slo", "severity"})
+
+	// StreamSupervisorRestarts counts websocket services restarted by the
+	// stream supervisor.
+	StreamSupervisorRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_supervisor_restarts_total",
+		Help:      "Upstream websocket services restarted by the stream supervisor, by class and stream kind (class for a re-initialization of the whole class).",
+	}, []string{"class", "stream"})
+
+	// StreamsIdleClosed counts upstream websocket services closed for idling.
+	StreamsIdleClosed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "streams_idle_closed_total",
+		Help:      "Upstream websocket services closed because no client requested them, by class and stream kind.",
+	}, []string{"class", "stream"})
+
+	// InitTimeouts counts requests that stopped waiting for a stream to
+	// initialize.
+	InitTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_init_timeouts_total",
+		Help:      "Requests forwarded because their stream didn't initialize within --init-wait, by class and stream kind.",
+	}, []string{"class", "stream"})
+
+	// StaleStreamRequests counts requests forwarded because their stream
+	// went silent.
+	StaleStreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stale_stream_requests_total",
+		Help:      "Requests forwarded to Binance because the websocket of their stream went silent, by class and stream kind.",
+	}, []string{"class", "stream"})
+
+	// StaleStreamResubscribes counts silent streams that were resubscribed.
+	StaleStreamResubscribes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stale_stream_resubscribes_total",
+		Help:      "Upstream websocket services replaced because they went silent, by class and stream kind.",
+	}, []string{"class", "stream"})
+
+	// DepthResyncs counts depth books dropped because an update broke the
+	// sequence of update IDs.
+	DepthResyncs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "depth_resyncs_total",
+		Help:      "Depth books dropped and resynced because an update didn't follow the previous update ID, by class.",
+	}, []string{"class"})
+
+	// StreamsDelistedClosed counts upstream websocket services closed because
+	// their symbol was delisted or halted.
+	StreamsDelistedClosed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "streams_delisted_closed_total",
+		Help:      "Upstream websocket services closed because their symbol was delisted or halted, by class and stream kind.",
+	}, []string{"class", "stream"})
+
+	// PublishedMessages counts market data messages handed to the publisher.
+	PublishedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "published_messages_total",
+		Help:      "Market data messages for the NATS or Kafka publisher, by result (sent, failed, dropped).",
+	}, []string{"result"})
+
+	// HistoryCandles counts closed candles for the history database.
+	HistoryCandles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "history_candles_total",
+		Help:      "Closed candles for the InfluxDB or PostgreSQL history, by result (written, dropped).",
+	}, []string{"result"})
+)
+
+// Handler returns the Prometheus exposition handler for the default registry.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
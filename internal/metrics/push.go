@@ -0,0 +1,442 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pushJob is the job label of pushed metrics unless the URL names another.
+const pushJob = "binance-proxy"
+
+// pusher sends a snapshot of the registry to a push target.
+type pusher interface {
+	push(ctx context.Context) error
+	name() string
+}
+
+type pushLoop struct {
+	pusher   pusher
+	interval time.Duration
+	refresh  func()
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var (
+	currentPush   *pushLoop
+	currentPushMu sync.Mutex
+)
+
+// StartPush pushes the metrics every interval to rawURL, for setups without a
+// scraper reaching the proxy: pushgateway://[user:password@]host:9091[/job]
+// (pushgateways:// for HTTPS), remotewrite://[user:password@]host:port/path
+// for a Prometheus remote write endpoint (remotewrites:// for HTTPS), or
+// statsd://host:8125 and dogstatsd://host:8125 for a statsd or Datadog
+// agent. refresh is called before every push to update gauges that are
+// otherwise set on scrape. An empty URL disables pushing.
+func StartPush(rawURL string, interval time.Duration, refresh func()) error {
+	if rawURL == "" {
+		return nil
+	}
+	if interval <= 0 {
+		return fmt.Errorf("metrics push interval must be positive")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid metrics push URL, expected pushgateway://host:9091, remotewrite://host:port/path or statsd://host:8125")
+	}
+
+	instance, _ := os.Hostname()
+	var p pusher
+	switch u.Scheme {
+	case "pushgateway", "pushgateways":
+		p = newPushgateway(u, instance)
+	case "remotewrite", "remotewrites":
+		p = newRemoteWrite(u, instance)
+	case "statsd", "dogstatsd":
+		p, err = newStatsd(u)
+	default:
+		err = fmt.Errorf("unsupported metrics push URL scheme %q, expected pushgateway, pushgateways, remotewrite, remotewrites, statsd or dogstatsd", u.Scheme)
+	}
+	if err != nil {
+		return err
+	}
+
+	l := &pushLoop{pusher: p, interval: interval, refresh: refresh, stop: make(chan struct{}), done: make(chan struct{})}
+	go l.run()
+
+	currentPushMu.Lock()
+	currentPush = l
+	currentPushMu.Unlock()
+	return nil
+}
+
+// PushTarget describes the push target without credentials, for logs.
+func PushTarget() string {
+	currentPushMu.Lock()
+	defer currentPushMu.Unlock()
+	if currentPush == nil {
+		return ""
+	}
+	return currentPush.pusher.name()
+}
+
+// ShutdownPush stops pushing after a last push of the final values, giving
+// up when ctx is done.
+func ShutdownPush(ctx context.Context) {
+	currentPushMu.Lock()
+	l := currentPush
+	currentPush = nil
+	currentPushMu.Unlock()
+	if l == nil {
+		return
+	}
+
+	close(l.stop)
+	<-l.done
+	if err := l.push(ctx); err != nil {
+		log.Warnf("Pushing the final metrics to %s failed (error: %s).", l.pusher.name(), err)
+	}
+}
+
+func (l *pushLoop) run() {
+	defer close(l.done)
+	t := time.NewTicker(l.interval)
+	defer t.Stop()
+
+	failing := false
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-t.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), l.interval)
+		err := l.push(ctx)
+		cancel()
+		if err != nil && !failing {
+			log.Warnf("Pushing metrics to %s failed, retrying every %s (error: %s).", l.pusher.name(), l.interval, err)
+		} else if err == nil && failing {
+			log.Infof("Pushing metrics to %s works again", l.pusher.name())
+		}
+		failing = err != nil
+	}
+}
+
+func (l *pushLoop) push(ctx context.Context) error {
+	if l.refresh != nil {
+		l.refresh()
+	}
+	return l.pusher.push(ctx)
+}
+
+// pushTargetName is the URL of a push target without credentials.
+func pushTargetName(u *url.URL) string {
+	v := *u
+	v.User = nil
+	return v.String()
+}
+
+// pushgateway replaces the metrics of the job and instance on a Prometheus
+// Pushgateway.
+type pushgateway struct {
+	pusher *push.Pusher
+	target string
+}
+
+func newPushgateway(u *url.URL, instance string) *pushgateway {
+	scheme := "http"
+	if u.Scheme == "pushgateways" {
+		scheme = "https"
+	}
+	job := strings.Trim(u.Path, "/")
+	if job == "" {
+		job = pushJob
+	}
+	p := push.New(scheme+"://"+u.Host, job).Gatherer(prometheus.DefaultGatherer).Grouping("instance", instance)
+	if u.User != nil {
+		password, _ := u.User.Password()
+		p = p.BasicAuth(u.User.Username(), password)
+	}
+	return &pushgateway{pusher: p, target: pushTargetName(u)}
+}
+
+func (p *pushgateway) name() string {
+	return p.target
+}
+
+func (p *pushgateway) push(ctx context.Context) error {
+	return p.pusher.PushContext(ctx)
+}
+
+// sample is one series of a gathered metric family.
+type sample struct {
+	name    string
+	labels  []*dto.LabelPair
+	value   float64
+	counter bool
+	// bucket marks histogram buckets and summary quantiles, which statsd has
+	// no type for.
+	bucket bool
+}
+
+// gatherSamples flattens the registry into series the way the Prometheus
+// text format does, with _bucket, _sum and _count series for histograms
+// and summaries.
+func gatherSamples() ([]sample, error) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	var samples []sample
+	for _, f := range families {
+		name := f.GetName()
+		for _, m := range f.GetMetric() {
+			labels := m.GetLabel()
+			with := func(name, value string) []*dto.LabelPair {
+				return append(append([]*dto.LabelPair{}, labels...), &dto.LabelPair{Name: &name, Value: &value})
+			}
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				samples = append(samples, sample{name: name, labels: labels, value: m.GetCounter().GetValue(), counter: true})
+			case dto.MetricType_GAUGE:
+				samples = append(samples, sample{name: name, labels: labels, value: m.GetGauge().GetValue()})
+			case dto.MetricType_UNTYPED:
+				samples = append(samples, sample{name: name, labels: labels, value: m.GetUntyped().GetValue()})
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					samples = append(samples, sample{name: name + "_bucket", labels: with("le", formatFloat(b.GetUpperBound())), value: float64(b.GetCumulativeCount()), counter: true, bucket: true})
+				}
+				samples = append(samples,
+					sample{name: name + "_bucket", labels: with("le", "+Inf"), value: float64(h.GetSampleCount()), counter: true, bucket: true},
+					sample{name: name + "_sum", labels: labels, value: h.GetSampleSum(), counter: true},
+					sample{name: name + "_count", labels: labels, value: float64(h.GetSampleCount()), counter: true})
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					samples = append(samples, sample{name: name, labels: with("quantile", formatFloat(q.GetQuantile())), value: q.GetValue(), bucket: true})
+				}
+				samples = append(samples,
+					sample{name: name + "_sum", labels: labels, value: s.GetSampleSum(), counter: true},
+					sample{name: name + "_count", labels: labels, value: float64(s.GetSampleCount()), counter: true})
+			}
+		}
+	}
+	return samples, nil
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// remoteWrite sends the series to a Prometheus remote write endpoint as a
+// snappy compressed protobuf WriteRequest.
+type remoteWrite struct {
+	url      string
+	target   string
+	user     *url.Userinfo
+	instance string
+	client   *http.Client
+}
+
+func newRemoteWrite(u *url.URL, instance string) *remoteWrite {
+	scheme := "http"
+	if u.Scheme == "remotewrites" {
+		scheme = "https"
+	}
+	v := *u
+	v.Scheme, v.User = scheme, nil
+	return &remoteWrite{url: v.String(), target: pushTargetName(u), user: u.User, instance: instance, client: &http.Client{}}
+}
+
+func (w *remoteWrite) name() string {
+	return w.target
+}
+
+func (w *remoteWrite) push(ctx context.Context) error {
+	samples, err := gatherSamples()
+	if err != nil {
+		return err
+	}
+
+	request := writeRequest(samples, w.instance, time.Now().UnixMilli())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(snappy.Encode(nil, request)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if w.user != nil {
+		password, _ := w.user.Password()
+		req.SetBasicAuth(w.user.Username(), password)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// writeRequest encodes samples taken at now (in milliseconds) as a
+// prometheus.WriteRequest protobuf.
+func writeRequest(samples []sample, instance string, now int64) []byte {
+	var request []byte
+	for _, s := range samples {
+		labels := map[string]string{"__name__": s.name, "job": pushJob, "instance": instance}
+		for _, l := range s.labels {
+			labels[l.GetName()] = l.GetValue()
+		}
+		names := make([]string, 0, len(labels))
+		for name := range labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		// prometheus.TimeSeries{labels: 1, samples: 2}
+		var series []byte
+		for _, name := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, labels[name])
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var point []byte
+		point = protowire.AppendTag(point, 1, protowire.Fixed64Type)
+		point = protowire.AppendFixed64(point, math.Float64bits(s.value))
+		point = protowire.AppendTag(point, 2, protowire.VarintType)
+		point = protowire.AppendVarint(point, uint64(now))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, point)
+
+		// prometheus.WriteRequest{timeseries: 1}
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+	return request
+}
+
+// statsd sends counters as deltas since the last push and gauges as values
+// over UDP. dogstatsd:// sends the labels as Datadog tags, statsd:// appends
+// their values to the metric name. Histogram buckets and summary quantiles
+// are left out, their sums and counts are sent as counters.
+type statsd struct {
+	conn   net.Conn
+	target string
+	tags   bool
+	last   map[string]float64
+}
+
+func newStatsd(u *url.URL) (*statsd, error) {
+	conn, err := net.Dial("udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &statsd{conn: conn, target: pushTargetName(u), tags: u.Scheme == "dogstatsd", last: make(map[string]float64)}, nil
+}
+
+func (s *statsd) name() string {
+	return s.target
+}
+
+// statsdPacket is the largest UDP payload sent, safe for common MTUs.
+const statsdPacket = 1432
+
+func (s *statsd) push(ctx context.Context) error {
+	samples, err := gatherSamples()
+	if err != nil {
+		return err
+	}
+
+	var packet bytes.Buffer
+	send := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := s.conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+	for _, m := range samples {
+		if m.bucket || math.IsNaN(m.value) {
+			continue
+		}
+		name, tags := m.name, make([]string, 0, len(m.labels))
+		for _, l := range m.labels {
+			if s.tags {
+				tags = append(tags, statsdName(l.GetName())+":"+strings.NewReplacer(",", "_", "|", "_").Replace(l.GetValue()))
+			} else if l.GetValue() != "" {
+				name += "." + statsdName(l.GetValue())
+			}
+		}
+		line := ""
+		if m.counter {
+			key := name + "|" + strings.Join(tags, ",")
+			delta := m.value - s.last[key]
+			s.last[key] = m.value
+			if delta <= 0 {
+				continue
+			}
+			line = name + ":" + formatFloat(delta) + "|c"
+		} else {
+			line = name + ":" + formatFloat(m.value) + "|g"
+		}
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdPacket {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	return send()
+}
+
+// statsdName replaces the characters statsd uses as separators.
+func statsdName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', ' ', '/', '.':
+			return '_'
+		}
+		return r
+	}, strings.Trim(s, "/"))
+}
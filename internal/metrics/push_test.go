@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteSeries is a decoded prometheus.TimeSeries with one sample.
+type remoteWriteSeries struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+// decodeWriteRequest decodes a prometheus.WriteRequest, failing the test on
+// invalid protobuf.
+func decodeWriteRequest(t *testing.T, b []byte) []remoteWriteSeries {
+	t.Helper()
+	// fields calls f for each field of the message in b
+	fields := func(b []byte, f func(num protowire.Number, typ protowire.Type, v []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatalf("invalid tag: %s", protowire.ParseError(n))
+			}
+			b = b[n:]
+			if n = f(num, typ, b); n < 0 {
+				t.Fatalf("invalid field %d: %s", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	bytesField := func(typ protowire.Type, b []byte, v *[]byte) int {
+		if typ != protowire.BytesType {
+			t.Fatalf("wire type %d, want bytes", typ)
+		}
+		var n int
+		*v, n = protowire.ConsumeBytes(b)
+		return n
+	}
+
+	var series []remoteWriteSeries
+	fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		var ts []byte
+		n := bytesField(typ, b, &ts)
+		if num != 1 || n < 0 {
+			t.Fatalf("WriteRequest field %d", num)
+		}
+		s := remoteWriteSeries{labels: make(map[string]string)}
+		fields(ts, func(num protowire.Number, typ protowire.Type, b []byte) int {
+			var msg []byte
+			n := bytesField(typ, b, &msg)
+			switch num {
+			case 1: // Label{name: 1, value: 2}
+				var name, value []byte
+				fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					if num == 1 {
+						return bytesField(typ, b, &name)
+					}
+					return bytesField(typ, b, &value)
+				})
+				s.labels[string(name)] = string(value)
+			case 2: // Sample{value: 1, timestamp: 2}
+				fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					if num == 1 {
+						v, n := protowire.ConsumeFixed64(b)
+						s.value = math.Float64frombits(v)
+						return n
+					}
+					v, n := protowire.ConsumeVarint(b)
+					s.timestamp = int64(v)
+					return n
+				})
+			default:
+				t.Fatalf("TimeSeries field %d", num)
+			}
+			return n
+		})
+		series = append(series, s)
+		return n
+	})
+	return series
+}
+
+func TestRemoteWrite(t *testing.T) {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "binance_proxy_test_remote_write", Help: "Test gauge."}, []string{"class"})
+	prometheus.MustRegister(g)
+	t.Cleanup(func() { prometheus.Unregister(g) })
+	g.WithLabelValues("SPOT").Set(42.5)
+
+	var got []remoteWriteSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		if r.URL.Path != "/api/v1/write" || user != "writer" || password != "secret" {
+			t.Errorf("request to %s as %s:%s", r.URL.Path, user, password)
+		}
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("request headers %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		request, err := snappy.Decode(nil, body)
+		if err != nil {
+			t.Errorf("invalid snappy body: %s", err)
+			return
+		}
+		got = decodeWriteRequest(t, request)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	u.Scheme = "remotewrite"
+	u.User = url.UserPassword("writer", "secret")
+	u.Path = "/api/v1/write"
+	if err := newRemoteWrite(u, "proxy-1").push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, s := range got {
+		if s.timestamp <= 0 || s.labels["job"] != pushJob || s.labels["instance"] != "proxy-1" {
+			t.Fatalf("series %v at %d", s.labels, s.timestamp)
+		}
+		if s.labels["__name__"] == "binance_proxy_test_remote_write" {
+			found = true
+			if s.labels["class"] != "SPOT" || s.value != 42.5 {
+				t.Errorf("series %v = %v, want class SPOT = 42.5", s.labels, s.value)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("test gauge missing from %d series", len(got))
+	}
+}
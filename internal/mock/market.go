@@ -0,0 +1,295 @@
+package mock
+
+import (
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// knownPrices start the usual symbols near realistic prices; other symbols
+// get a price derived from their name.
+var knownPrices = map[string]float64{
+	"BTCUSDT": 60000,
+	"ETHUSDT": 3000,
+	"BNBUSDT": 600,
+	"SOLUSDT": 150,
+	"XRPUSDT": 0.5,
+}
+
+var quoteAssets = []string{"USDT", "USDC", "FDUSD", "BUSD", "BTC", "ETH", "BNB", "EUR", "TRY"}
+
+// symbol is a market whose prices are a deterministic function of time, so
+// every request for the same candle gets the same answer.
+type symbol struct {
+	name     string
+	base     string
+	quote    string
+	price    float64
+	seed     uint64
+	decimals int
+}
+
+func newSymbol(name string) *symbol {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	s := &symbol{name: name, base: name, quote: "USDT", seed: h.Sum64()}
+	for _, quote := range quoteAssets {
+		if base, ok := strings.CutSuffix(name, quote); ok && base != "" {
+			s.base, s.quote = base, quote
+			break
+		}
+	}
+	if price, ok := knownPrices[name]; ok {
+		s.price = price
+	} else {
+		s.price = 1 + float64(s.seed%10000)/100
+	}
+	switch {
+	case s.price >= 100:
+		s.decimals = 2
+	case s.price >= 1:
+		s.decimals = 4
+	default:
+		s.decimals = 6
+	}
+	return s
+}
+
+// unit returns a deterministic value in [0, 1) for the symbol and n.
+func (s *symbol) unit(n int64, salt uint64) float64 {
+	x := s.seed ^ uint64(n)*0x9e3779b97f4a7c15 ^ salt*0xbf58476d1ce4e5b9
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11) / (1 << 53)
+}
+
+// priceAt is the price at ms, with a daily and an hourly wave and some noise
+// per second.
+func (s *symbol) priceAt(ms int64) float64 {
+	sec := ms / 1000
+	phase := float64(s.seed%628) / 100
+	x := float64(sec)
+	return s.price * (1 +
+		0.03*math.Sin(2*math.Pi*x/86400+phase) +
+		0.01*math.Sin(2*math.Pi*x/3600+phase*2) +
+		0.002*(s.unit(sec, 1)-0.5))
+}
+
+func (s *symbol) tick() float64 {
+	return math.Pow10(-s.decimals)
+}
+
+func (s *symbol) formatPrice(p float64) string {
+	return strconv.FormatFloat(p, 'f', s.decimals, 64)
+}
+
+func formatQty(q float64) string {
+	return strconv.FormatFloat(q, 'f', 5, 64)
+}
+
+// candle is a kline, closed once now passed closeTime.
+type candle struct {
+	openTime, closeTime                        int64
+	open, high, low, close                     float64
+	volume, quoteVolume, takerBuy, takerBuyQte float64
+	trades                                     int64
+	final                                      bool
+}
+
+func (s *symbol) candle(iv interval, openTime, now int64) candle {
+	closeTime := iv.next(openTime) - 1
+	end := min(closeTime, now)
+	c := candle{openTime: openTime, closeTime: closeTime, final: now > closeTime}
+	c.open = s.priceAt(openTime)
+	c.close = s.priceAt(end)
+	c.high = math.Max(c.open, c.close) * (1 + 0.003*s.unit(openTime, 2))
+	c.low = math.Min(c.open, c.close) * (1 - 0.003*s.unit(openTime, 3))
+
+	minutes := float64(closeTime+1-openTime) / 60000
+	elapsed := float64(end+1-openTime) / float64(closeTime+1-openTime)
+	c.volume = 1000 / s.price * minutes * (0.5 + s.unit(openTime, 4)) * elapsed
+	c.quoteVolume = c.volume * (c.open + c.close) / 2
+	c.takerBuy = c.volume * (0.4 + 0.2*s.unit(openTime, 5))
+	c.takerBuyQte = c.takerBuy * (c.open + c.close) / 2
+	c.trades = int64(minutes*20*(0.5+s.unit(openTime, 6))*elapsed) + 1
+	return c
+}
+
+// priceCandle is c as a mark or index price candle, which has no volumes.
+// The price is sampled every second.
+func priceCandle(c candle) candle {
+	c.volume, c.quoteVolume, c.takerBuy, c.takerBuyQte = 0, 0, 0, 0
+	c.trades = (c.closeTime + 1 - c.openTime) / 1000
+	return c
+}
+
+func (s *symbol) klineRow(c candle) []interface{} {
+	return []interface{}{
+		c.openTime, s.formatPrice(c.open), s.formatPrice(c.high), s.formatPrice(c.low), s.formatPrice(c.close),
+		formatQty(c.volume), c.closeTime, formatQty(c.quoteVolume), c.trades,
+		formatQty(c.takerBuy), formatQty(c.takerBuyQte), "0",
+	}
+}
+
+// klines returns up to limit candles like Binance: from startTime on, the
+// last ones before endTime, or the latest ones.
+func (s *symbol) klines(iv interval, startTime, endTime int64, limit int, now int64) []candle {
+	if endTime == 0 || endTime > now {
+		endTime = now
+	}
+	var opens []int64
+	if startTime > 0 {
+		for t := iv.align(startTime); t <= endTime && len(opens) < limit; t = iv.next(t) {
+			if t >= startTime {
+				opens = append(opens, t)
+			}
+		}
+	} else {
+		for t := iv.align(endTime); len(opens) < limit; t = iv.prev(t) {
+			opens = append(opens, t)
+		}
+		for i, j := 0, len(opens)-1; i < j; i, j = i+1, j-1 {
+			opens[i], opens[j] = opens[j], opens[i]
+		}
+	}
+
+	candles := make([]candle, len(opens))
+	for i, t := range opens {
+		candles[i] = s.candle(iv, t, now)
+	}
+	return candles
+}
+
+type level struct {
+	price, qty float64
+}
+
+// depth returns the order book around the price at now, updated every 100ms.
+func (s *symbol) depth(now int64, levels int) (updateID int64, bids, asks []level) {
+	updateID = now / 100
+	mid := s.priceAt(now)
+	tick := s.tick()
+	for i := 0; i < levels; i++ {
+		step := tick * float64(i+1) * (1 + float64(int64(s.price)/1000))
+		bids = append(bids, level{price: mid - step, qty: 10 / s.price * (0.1 + 2*s.unit(updateID, uint64(10+i)))})
+		asks = append(asks, level{price: mid + step, qty: 10 / s.price * (0.1 + 2*s.unit(updateID, uint64(100+i)))})
+	}
+	return updateID, bids, asks
+}
+
+func (s *symbol) levelRows(levels []level) [][2]string {
+	rows := make([][2]string, len(levels))
+	for i, l := range levels {
+		rows[i] = [2]string{s.formatPrice(l.price), formatQty(l.qty)}
+	}
+	return rows
+}
+
+// tradeInterval is the spacing of the mock trades.
+const tradeInterval = 250
+
+type trade struct {
+	id           int64
+	price, qty   float64
+	time         int64
+	isBuyerMaker bool
+}
+
+func (s *symbol) trade(id int64) trade {
+	ms := id * tradeInterval
+	t := trade{id: id, time: ms, qty: 100 / s.price * (0.05 + s.unit(id, 20)), isBuyerMaker: s.unit(id, 21) < 0.5}
+	t.price = s.priceAt(ms)
+	if t.isBuyerMaker {
+		t.price -= s.tick()
+	} else {
+		t.price += s.tick()
+	}
+	return t
+}
+
+// trades returns the latest limit trades up to now, oldest first.
+func (s *symbol) trades(limit int, now int64) []trade {
+	last := now / tradeInterval
+	trades := make([]trade, 0, limit)
+	for id := last - int64(limit) + 1; id <= last; id++ {
+		trades = append(trades, s.trade(id))
+	}
+	return trades
+}
+
+// stats24h summarizes the last 24 hours from hourly samples.
+type stats24h struct {
+	open, high, low, last, volume, quoteVolume float64
+	openTime, closeTime, firstID, lastID       int64
+}
+
+func (s *symbol) stats(now int64) stats24h {
+	st := stats24h{openTime: now - 24*3600*1000, closeTime: now}
+	st.open = s.priceAt(st.openTime)
+	st.last = s.priceAt(now)
+	st.high, st.low = math.Max(st.open, st.last), math.Min(st.open, st.last)
+	for t := st.openTime; t < now; t += 3600 * 1000 {
+		p := s.priceAt(t)
+		st.high, st.low = math.Max(st.high, p), math.Min(st.low, p)
+	}
+	st.volume = 1000 / s.price * 1440
+	st.quoteVolume = st.volume * (st.open + st.last) / 2
+	st.firstID, st.lastID = st.openTime/tradeInterval, now/tradeInterval
+	return st
+}
+
+// interval is a kline interval, aligned like Binance's.
+type interval struct {
+	name   string
+	d      time.Duration
+	months int
+}
+
+var intervals = map[string]interval{}
+
+func init() {
+	for name, d := range map[string]time.Duration{
+		"1s": time.Second, "1m": time.Minute, "3m": 3 * time.Minute, "5m": 5 * time.Minute,
+		"15m": 15 * time.Minute, "30m": 30 * time.Minute, "1h": time.Hour, "2h": 2 * time.Hour,
+		"4h": 4 * time.Hour, "6h": 6 * time.Hour, "8h": 8 * time.Hour, "12h": 12 * time.Hour,
+		"1d": 24 * time.Hour, "3d": 72 * time.Hour, "1w": 7 * 24 * time.Hour,
+	} {
+		intervals[name] = interval{name: name, d: d}
+	}
+	intervals["1M"] = interval{name: "1M", months: 1}
+}
+
+// weekOffset moves weekly candles to start on Mondays; the epoch was a
+// Thursday.
+const weekOffset = 3 * 24 * 3600 * 1000
+
+func (iv interval) align(ms int64) int64 {
+	if iv.months > 0 {
+		t := time.UnixMilli(ms).UTC()
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	}
+	d := iv.d.Milliseconds()
+	if iv.name == "1w" {
+		return ms - (ms+weekOffset)%d
+	}
+	return ms - ms%d
+}
+
+func (iv interval) next(open int64) int64 {
+	if iv.months > 0 {
+		return time.UnixMilli(open).UTC().AddDate(0, iv.months, 0).UnixMilli()
+	}
+	return open + iv.d.Milliseconds()
+}
+
+func (iv interval) prev(open int64) int64 {
+	if iv.months > 0 {
+		return time.UnixMilli(open).UTC().AddDate(0, -iv.months, 0).UnixMilli()
+	}
+	return open - iv.d.Milliseconds()
+}
@@ -0,0 +1,335 @@
+// Package mock is a stand-in for Binance that serves deterministic klines,
+// depth, tickers, trades and exchangeInfo plus matching websocket streams,
+// for testing the proxy and bots offline. Prices follow a fixed function of
+// time per symbol, so a candle looks the same however often it is requested.
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server serves the spot (/api/v3) and futures (/fapi/v1) REST endpoints and
+// the websocket streams under /spot and /futures, the layout the proxy's
+// --mock-upstream expects.
+type Server struct {
+	symbols map[string]*symbol
+	names   []string
+	now     func() time.Time
+	mux     *http.ServeMux
+}
+
+// NewServer returns a mock for the given symbols.
+func NewServer(symbols []string) *Server {
+	s := &Server{symbols: make(map[string]*symbol), now: time.Now, mux: http.NewServeMux()}
+	for _, name := range symbols {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" || s.symbols[name] != nil {
+			continue
+		}
+		s.symbols[name] = newSymbol(name)
+		s.names = append(s.names, name)
+	}
+
+	for _, prefix := range []string{"/api/v3", "/fapi/v1"} {
+		futures := prefix == "/fapi/v1"
+		s.mux.HandleFunc(prefix+"/ping", func(w http.ResponseWriter, r *http.Request) { writeJSON(w, struct{}{}) })
+		s.mux.HandleFunc(prefix+"/time", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, map[string]int64{"serverTime": s.now().UnixMilli()})
+		})
+		s.mux.HandleFunc(prefix+"/exchangeInfo", func(w http.ResponseWriter, r *http.Request) { s.exchangeInfo(w, futures) })
+		s.mux.HandleFunc(prefix+"/klines", func(w http.ResponseWriter, r *http.Request) { s.klines(w, r, futures) })
+		s.mux.HandleFunc(prefix+"/depth", s.depth)
+		s.mux.HandleFunc(prefix+"/trades", func(w http.ResponseWriter, r *http.Request) { s.trades(w, r, futures) })
+		s.mux.HandleFunc(prefix+"/ticker/24hr", s.ticker24hr)
+		s.mux.HandleFunc(prefix+"/ticker/price", s.tickerPrice)
+		s.mux.HandleFunc(prefix+"/ticker/bookTicker", s.bookTicker)
+	}
+	s.mux.HandleFunc("/fapi/v1/markPriceKlines", func(w http.ResponseWriter, r *http.Request) { s.priceKlines(w, r, "symbol") })
+	s.mux.HandleFunc("/fapi/v1/indexPriceKlines", func(w http.ResponseWriter, r *http.Request) { s.priceKlines(w, r, "pair") })
+	// go-binance still asks for spot trades under the old /api/v1 path.
+	s.mux.HandleFunc("/api/v1/trades", func(w http.ResponseWriter, r *http.Request) { s.trades(w, r, false) })
+	s.mux.HandleFunc("/spot/", s.serveWS)
+	s.mux.HandleFunc("/futures/", s.serveWS)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, -1, "Endpoint not supported by the mock.")
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "msg": msg})
+}
+
+// lookup returns the symbol of the request, answering Binance's error when
+// it is missing or unknown.
+func (s *Server) lookup(w http.ResponseWriter, name string) *symbol {
+	if name == "" {
+		writeError(w, http.StatusBadRequest, -1102, "Mandatory parameter 'symbol' was not sent, was empty/null, or malformed.")
+		return nil
+	}
+	sym := s.symbols[name]
+	if sym == nil {
+		writeError(w, http.StatusBadRequest, -1121, "Invalid symbol.")
+	}
+	return sym
+}
+
+// selected returns the symbols of a ticker request: symbol, symbols or all.
+func (s *Server) selected(w http.ResponseWriter, r *http.Request) (syms []*symbol, single bool, ok bool) {
+	query := r.URL.Query()
+	if name := query.Get("symbol"); name != "" {
+		sym := s.lookup(w, name)
+		return []*symbol{sym}, true, sym != nil
+	}
+	names := s.names
+	if list := query.Get("symbols"); list != "" {
+		if err := json.Unmarshal([]byte(list), &names); err != nil {
+			writeError(w, http.StatusBadRequest, -1100, "Illegal characters found in parameter 'symbols'.")
+			return nil, false, false
+		}
+	}
+	for _, name := range names {
+		sym := s.lookup(w, name)
+		if sym == nil {
+			return nil, false, false
+		}
+		syms = append(syms, sym)
+	}
+	return syms, false, true
+}
+
+func intParam(r *http.Request, name string, def int64) int64 {
+	if v, err := strconv.ParseInt(r.URL.Query().Get(name), 10, 64); err == nil {
+		return v
+	}
+	return def
+}
+
+func (s *Server) exchangeInfo(w http.ResponseWriter, futures bool) {
+	limit := 6000
+	if futures {
+		limit = 2400
+	}
+	symbols := make([]map[string]interface{}, 0, len(s.names))
+	for _, name := range s.names {
+		sym := s.symbols[name]
+		info := map[string]interface{}{
+			"symbol":              sym.name,
+			"status":              "TRADING",
+			"baseAsset":           sym.base,
+			"baseAssetPrecision":  8,
+			"quoteAsset":          sym.quote,
+			"quotePrecision":      8,
+			"quoteAssetPrecision": 8,
+			"orderTypes":          []string{"LIMIT", "MARKET"},
+			"filters": []map[string]string{
+				{"filterType": "PRICE_FILTER", "minPrice": sym.formatPrice(sym.tick()), "maxPrice": "1000000", "tickSize": sym.formatPrice(sym.tick())},
+				{"filterType": "LOT_SIZE", "minQty": "0.00001", "maxQty": "9000", "stepSize": "0.00001"},
+			},
+		}
+		if futures {
+			info["pair"] = sym.name
+			info["contractType"] = "PERPETUAL"
+			info["marginAsset"] = sym.quote
+			info["pricePrecision"] = sym.decimals
+			info["quantityPrecision"] = 5
+		} else {
+			info["isSpotTradingAllowed"] = true
+			info["isMarginTradingAllowed"] = false
+			info["permissions"] = []string{"SPOT"}
+		}
+		symbols = append(symbols, info)
+	}
+	writeJSON(w, map[string]interface{}{
+		"timezone":   "UTC",
+		"serverTime": s.now().UnixMilli(),
+		"rateLimits": []map[string]interface{}{
+			{"rateLimitType": "REQUEST_WEIGHT", "interval": "MINUTE", "intervalNum": 1, "limit": limit},
+		},
+		"exchangeFilters": []interface{}{},
+		"symbols":         symbols,
+	})
+}
+
+func (s *Server) klines(w http.ResponseWriter, r *http.Request, futures bool) {
+	maxLimit := int64(1000)
+	if futures {
+		maxLimit = 1500
+	}
+	sym, candles := s.candles(w, r, "symbol", maxLimit)
+	if sym == nil {
+		return
+	}
+	rows := make([][]interface{}, len(candles))
+	for i, c := range candles {
+		rows[i] = sym.klineRow(c)
+	}
+	writeJSON(w, rows)
+}
+
+// priceKlines serves mark or index price klines, the trade candles without
+// volumes and with the number of price samples as trades.
+func (s *Server) priceKlines(w http.ResponseWriter, r *http.Request, param string) {
+	sym, candles := s.candles(w, r, param, 1500)
+	if sym == nil {
+		return
+	}
+	rows := make([][]interface{}, len(candles))
+	for i, c := range candles {
+		rows[i] = sym.klineRow(priceCandle(c))
+	}
+	writeJSON(w, rows)
+}
+
+// candles returns the candles asked for by a klines request, or a nil symbol
+// after writing the error.
+func (s *Server) candles(w http.ResponseWriter, r *http.Request, param string, maxLimit int64) (*symbol, []candle) {
+	sym := s.lookup(w, r.URL.Query().Get(param))
+	if sym == nil {
+		return nil, nil
+	}
+	iv, ok := intervals[r.URL.Query().Get("interval")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, -1120, "Invalid interval.")
+		return nil, nil
+	}
+	limit := min(max(intParam(r, "limit", 500), 1), maxLimit)
+	return sym, sym.klines(iv, intParam(r, "startTime", 0), intParam(r, "endTime", 0), int(limit), s.now().UnixMilli())
+}
+
+func (s *Server) depth(w http.ResponseWriter, r *http.Request) {
+	sym := s.lookup(w, r.URL.Query().Get("symbol"))
+	if sym == nil {
+		return
+	}
+	limit := min(max(intParam(r, "limit", 100), 1), 1000)
+	now := s.now().UnixMilli()
+	updateID, bids, asks := sym.depth(now, int(limit))
+	writeJSON(w, map[string]interface{}{
+		"lastUpdateId": updateID,
+		"E":            now,
+		"T":            now,
+		"bids":         sym.levelRows(bids),
+		"asks":         sym.levelRows(asks),
+	})
+}
+
+func (s *Server) trades(w http.ResponseWriter, r *http.Request, futures bool) {
+	sym := s.lookup(w, r.URL.Query().Get("symbol"))
+	if sym == nil {
+		return
+	}
+	limit := min(max(intParam(r, "limit", 500), 1), 1000)
+	trades := sym.trades(int(limit), s.now().UnixMilli())
+	rows := make([]map[string]interface{}, len(trades))
+	for i, t := range trades {
+		rows[i] = map[string]interface{}{
+			"id":           t.id,
+			"price":        sym.formatPrice(t.price),
+			"qty":          formatQty(t.qty),
+			"quoteQty":     formatQty(t.price * t.qty),
+			"time":         t.time,
+			"isBuyerMaker": t.isBuyerMaker,
+		}
+		if !futures {
+			rows[i]["isBestMatch"] = true
+		}
+	}
+	writeJSON(w, rows)
+}
+
+func (s *Server) ticker24hr(w http.ResponseWriter, r *http.Request) {
+	syms, single, ok := s.selected(w, r)
+	if !ok {
+		return
+	}
+	now := s.now().UnixMilli()
+	rows := make([]map[string]interface{}, len(syms))
+	for i, sym := range syms {
+		st := sym.stats(now)
+		rows[i] = map[string]interface{}{
+			"symbol":             sym.name,
+			"priceChange":        sym.formatPrice(st.last - st.open),
+			"priceChangePercent": strconv.FormatFloat((st.last-st.open)/st.open*100, 'f', 3, 64),
+			"weightedAvgPrice":   sym.formatPrice((st.open + st.last) / 2),
+			"prevClosePrice":     sym.formatPrice(st.open),
+			"lastPrice":          sym.formatPrice(st.last),
+			"lastQty":            formatQty(sym.trade(st.lastID).qty),
+			"bidPrice":           sym.formatPrice(st.last - sym.tick()),
+			"bidQty":             "1.00000",
+			"askPrice":           sym.formatPrice(st.last + sym.tick()),
+			"askQty":             "1.00000",
+			"openPrice":          sym.formatPrice(st.open),
+			"highPrice":          sym.formatPrice(st.high),
+			"lowPrice":           sym.formatPrice(st.low),
+			"volume":             formatQty(st.volume),
+			"quoteVolume":        formatQty(st.quoteVolume),
+			"openTime":           st.openTime,
+			"closeTime":          st.closeTime,
+			"firstId":            st.firstID,
+			"lastId":             st.lastID,
+			"count":              st.lastID - st.firstID + 1,
+		}
+	}
+	if single {
+		writeJSON(w, rows[0])
+		return
+	}
+	writeJSON(w, rows)
+}
+
+func (s *Server) tickerPrice(w http.ResponseWriter, r *http.Request) {
+	syms, single, ok := s.selected(w, r)
+	if !ok {
+		return
+	}
+	now := s.now().UnixMilli()
+	rows := make([]map[string]string, len(syms))
+	for i, sym := range syms {
+		rows[i] = map[string]string{"symbol": sym.name, "price": sym.formatPrice(sym.priceAt(now))}
+	}
+	if single {
+		writeJSON(w, rows[0])
+		return
+	}
+	writeJSON(w, rows)
+}
+
+func (s *Server) bookTicker(w http.ResponseWriter, r *http.Request) {
+	syms, single, ok := s.selected(w, r)
+	if !ok {
+		return
+	}
+	now := s.now().UnixMilli()
+	rows := make([]map[string]string, len(syms))
+	for i, sym := range syms {
+		_, bids, asks := sym.depth(now, 1)
+		rows[i] = map[string]string{
+			"symbol":   sym.name,
+			"bidPrice": sym.formatPrice(bids[0].price),
+			"bidQty":   formatQty(bids[0].qty),
+			"askPrice": sym.formatPrice(asks[0].price),
+			"askQty":   formatQty(asks[0].qty),
+		}
+	}
+	if single {
+		writeJSON(w, rows[0])
+		return
+	}
+	writeJSON(w, rows)
+}
@@ -0,0 +1,286 @@
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// pingInterval matches Binance's spot streams; clients drop connections
+// that stay without pings for a minute.
+const pingInterval = 20 * time.Second
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// streamer produces the messages of a stream every period.
+type streamer struct {
+	period time.Duration
+	next   func(now int64) []interface{}
+}
+
+// serveWS serves /<class>/ws/<stream> and /<class>/ws connections and
+// combined /<class>/stream?streams= connections, which all take SUBSCRIBE and
+// UNSUBSCRIBE requests like Binance's.
+func (s *Server) serveWS(w http.ResponseWriter, r *http.Request) {
+	class, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	futures := class == "futures"
+	var streams []string
+	combined := rest == "stream"
+	switch {
+	case combined:
+		if list := r.URL.Query().Get("streams"); list != "" {
+			streams = strings.Split(list, "/")
+		}
+	case strings.HasPrefix(rest, "ws/"):
+		streams = []string{strings.TrimPrefix(rest, "ws/")}
+	case rest == "ws":
+		// no stream until the client subscribes to one
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	var writeMu sync.Mutex
+	send := func(v interface{}) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(v)
+	}
+	go func() {
+		t := time.NewTicker(pingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+			}
+		}
+	}()
+
+	running := make(map[string]context.CancelFunc)
+	start := func(name string) {
+		if _, ok := running[name]; ok {
+			return
+		}
+		st, ok := s.streamer(futures, name)
+		if !ok {
+			return
+		}
+		streamCtx, stop := context.WithCancel(ctx)
+		running[name] = stop
+		go func() {
+			t := time.NewTicker(st.period)
+			defer t.Stop()
+			for {
+				for _, msg := range st.next(s.now().UnixMilli()) {
+					if combined {
+						msg = map[string]interface{}{"stream": name, "data": msg}
+					}
+					if send(msg) != nil {
+						cancel()
+						return
+					}
+				}
+				select {
+				case <-streamCtx.Done():
+					return
+				case <-t.C:
+				}
+			}
+		}()
+	}
+	for _, name := range streams {
+		start(name)
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var request struct {
+			Method string   `json:"method"`
+			Params []string `json:"params"`
+			ID     int64    `json:"id"`
+		}
+		if json.Unmarshal(data, &request) != nil {
+			continue
+		}
+		for _, name := range request.Params {
+			switch request.Method {
+			case "SUBSCRIBE":
+				start(name)
+			case "UNSUBSCRIBE":
+				if stop, ok := running[name]; ok {
+					stop()
+					delete(running, name)
+				}
+			}
+		}
+		send(map[string]interface{}{"result": nil, "id": request.ID})
+	}
+}
+
+// streamer returns the generator of a stream such as btcusdt@kline_5m,
+// btcusdt@depth20@100ms, btcusdt@ticker, btcusdt@bookTicker or
+// btcusdt@trade. Other streams aren't supported and stay silent.
+func (s *Server) streamer(futures bool, name string) (streamer, bool) {
+	symbolName, kind, _ := strings.Cut(name, "@")
+	sym := s.symbols[strings.ToUpper(symbolName)]
+	if sym == nil {
+		return streamer{}, false
+	}
+
+	switch {
+	case strings.HasPrefix(kind, "kline_"):
+		iv, ok := intervals[strings.TrimPrefix(kind, "kline_")]
+		if !ok {
+			return streamer{}, false
+		}
+		return s.klineStreamer(sym, iv, ""), true
+
+	case futures && (strings.HasPrefix(kind, "markPriceKline_") || strings.HasPrefix(kind, "indexPriceKline_")):
+		price, name, _ := strings.Cut(kind, "PriceKline_")
+		iv, ok := intervals[name]
+		if !ok {
+			return streamer{}, false
+		}
+		return s.klineStreamer(sym, iv, price), true
+
+	case strings.HasPrefix(kind, "depth"):
+		spec, rate, _ := strings.Cut(strings.TrimPrefix(kind, "depth"), "@")
+		levels, err := strconv.Atoi(spec)
+		if err != nil || levels < 1 {
+			return streamer{}, false
+		}
+		period := time.Second
+		if rate == "100ms" {
+			period = 100 * time.Millisecond
+		} else if rate == "500ms" || futures && rate == "" {
+			period = 250 * time.Millisecond
+		}
+		// Futures updates follow the update ID of the previous one sent
+		var prev int64
+		return streamer{period: period, next: func(now int64) []interface{} {
+			updateID, bids, asks := sym.depth(now, levels)
+			if futures {
+				if prev == 0 {
+					prev = updateID - 1
+				}
+				first := prev + 1
+				pu := prev
+				prev = updateID
+				return []interface{}{map[string]interface{}{
+					"e": "depthUpdate", "E": now, "T": now, "s": sym.name,
+					"U": first, "u": updateID, "pu": pu,
+					"b": sym.levelRows(bids), "a": sym.levelRows(asks),
+				}}
+			}
+			return []interface{}{map[string]interface{}{
+				"lastUpdateId": updateID, "bids": sym.levelRows(bids), "asks": sym.levelRows(asks),
+			}}
+		}}, true
+
+	case kind == "ticker":
+		return streamer{period: time.Second, next: func(now int64) []interface{} {
+			st := sym.stats(now)
+			return []interface{}{map[string]interface{}{
+				"e": "24hrTicker", "E": now, "s": sym.name,
+				"p": sym.formatPrice(st.last - st.open),
+				"P": strconv.FormatFloat((st.last-st.open)/st.open*100, 'f', 3, 64),
+				"w": sym.formatPrice((st.open + st.last) / 2),
+				"x": sym.formatPrice(st.open), "c": sym.formatPrice(st.last), "Q": formatQty(sym.trade(st.lastID).qty),
+				"b": sym.formatPrice(st.last - sym.tick()), "B": "1.00000",
+				"a": sym.formatPrice(st.last + sym.tick()), "A": "1.00000",
+				"o": sym.formatPrice(st.open), "h": sym.formatPrice(st.high), "l": sym.formatPrice(st.low),
+				"v": formatQty(st.volume), "q": formatQty(st.quoteVolume),
+				"O": st.openTime, "C": st.closeTime, "F": st.firstID, "L": st.lastID, "n": st.lastID - st.firstID + 1,
+			}}
+		}}, true
+
+	case kind == "bookTicker":
+		return streamer{period: 100 * time.Millisecond, next: func(now int64) []interface{} {
+			updateID, bids, asks := sym.depth(now, 1)
+			return []interface{}{map[string]interface{}{
+				"e": "bookTicker", "E": now, "T": now, "u": updateID, "s": sym.name,
+				"b": sym.formatPrice(bids[0].price), "B": formatQty(bids[0].qty),
+				"a": sym.formatPrice(asks[0].price), "A": formatQty(asks[0].qty),
+			}}
+		}}, true
+
+	case kind == "trade":
+		var last int64
+		return streamer{period: tradeInterval * time.Millisecond, next: func(now int64) []interface{} {
+			id := now / tradeInterval
+			if last == 0 {
+				last = id - 1
+			}
+			var msgs []interface{}
+			for ; last < id; last++ {
+				t := sym.trade(last + 1)
+				msgs = append(msgs, map[string]interface{}{
+					"e": "trade", "E": now, "s": sym.name, "t": t.id,
+					"p": sym.formatPrice(t.price), "q": formatQty(t.qty), "T": t.time,
+					"m": t.isBuyerMaker, "M": true,
+				})
+			}
+			return msgs
+		}}, true
+	}
+	return streamer{}, false
+}
+
+// klineStreamer sends the current candle every second, preceded by the
+// closed one when a new candle started. With price mark or index it sends
+// the price candles of the markPriceKline or indexPriceKline stream.
+func (s *Server) klineStreamer(sym *symbol, iv interval, price string) streamer {
+	var lastOpen int64
+	candleAt := sym.candle
+	if price != "" {
+		candleAt = func(iv interval, openTime, now int64) candle { return priceCandle(sym.candle(iv, openTime, now)) }
+	}
+	event := func(c candle, now int64) interface{} {
+		e := map[string]interface{}{
+			"e": "kline", "E": now, "s": sym.name,
+			"k": map[string]interface{}{
+				"t": c.openTime, "T": c.closeTime, "s": sym.name, "i": iv.name,
+				"f": c.openTime / tradeInterval, "L": min(c.closeTime, now) / tradeInterval,
+				"o": sym.formatPrice(c.open), "c": sym.formatPrice(c.close),
+				"h": sym.formatPrice(c.high), "l": sym.formatPrice(c.low),
+				"v": formatQty(c.volume), "n": c.trades, "x": c.final,
+				"q": formatQty(c.quoteVolume), "V": formatQty(c.takerBuy), "Q": formatQty(c.takerBuyQte), "B": "0",
+			},
+		}
+		if price != "" {
+			e["e"], e["ps"] = price+"Price_kline", sym.name
+		}
+		return e
+	}
+	return streamer{period: time.Second, next: func(now int64) []interface{} {
+		var msgs []interface{}
+		open := iv.align(now)
+		if lastOpen != 0 && lastOpen != open {
+			msgs = append(msgs, event(candleAt(iv, lastOpen, now), now))
+		}
+		lastOpen = open
+		return append(msgs, event(candleAt(iv, open, now), now))
+	}}
+}
@@ -0,0 +1,207 @@
+// Package notify delivers operational events (bans, recoveries, websocket
+// error spikes, listings and delistings) to chat webhooks.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// Event kinds.
+const (
+	KindBan         = "ban"
+	KindRecovery    = "recovery"
+	KindStreamError = "stream-errors"
+	KindDelisting   = "delisting"
+	KindListing     = "listing"
+)
+
+// queueSize bounds the events waiting for delivery; more are dropped.
+const queueSize = 100
+
+// Event is a notification.
+type Event struct {
+	Kind    string    `json:"kind"`
+	Class   string    `json:"class"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+func (e Event) text() string {
+	return fmt.Sprintf("[binance-proxy] %s %s: %s", e.Class, e.Kind, e.Message)
+}
+
+// target formats events for one webhook.
+type target struct {
+	url     string
+	payload func(e Event) interface{}
+}
+
+// newTarget picks the payload format from the webhook host: Slack, Discord,
+// Telegram (https://api.telegram.org/bot<token>/sendMessage?chat_id=<id>) or
+// the plain event as JSON for anything else.
+func newTarget(raw string) (target, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return target{}, fmt.Errorf("invalid webhook URL, expected an http or https URL")
+	}
+
+	t := target{url: raw}
+	switch {
+	case u.Host == "hooks.slack.com":
+		t.payload = func(e Event) interface{} { return map[string]string{"text": e.text()} }
+	case strings.HasSuffix(u.Host, "discord.com") || strings.HasSuffix(u.Host, "discordapp.com"):
+		t.payload = func(e Event) interface{} { return map[string]string{"content": e.text()} }
+	case u.Host == "api.telegram.org":
+		chatID := u.Query().Get("chat_id")
+		if chatID == "" {
+			return target{}, fmt.Errorf("telegram webhook URL needs a chat_id parameter")
+		}
+		q := u.Query()
+		q.Del("chat_id")
+		u.RawQuery = q.Encode()
+		t.url = u.String()
+		t.payload = func(e Event) interface{} { return map[string]string{"chat_id": chatID, "text": e.text()} }
+	default:
+		t.payload = func(e Event) interface{} { return e }
+	}
+	return t, nil
+}
+
+// redacted returns the webhook without path and query, which hold its secret.
+func (t target) redacted() string {
+	if u, err := url.Parse(t.url); err == nil {
+		return u.Scheme + "://" + u.Host
+	}
+	return "webhook"
+}
+
+type notifier struct {
+	targets  []target
+	cooldown time.Duration
+	limiter  *rate.Limiter
+	queue    chan Event
+	client   *http.Client
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time // kind+class -> last delivery
+}
+
+var (
+	current   *notifier
+	currentMu sync.RWMutex
+)
+
+// Configure starts delivering events to the given webhooks. The same kind of
+// event for the same class is sent at most once per cooldown, and all
+// deliveries together at most perMinute times a minute. No URLs disables
+// notifications.
+func Configure(urls []string, cooldown time.Duration, perMinute float64) error {
+	var targets []target
+	for _, raw := range urls {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		t, err := newTarget(raw)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, t)
+	}
+
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	if len(targets) == 0 {
+		current = nil
+		return nil
+	}
+
+	n := &notifier{
+		targets:  targets,
+		cooldown: cooldown,
+		limiter:  rate.NewLimiter(rate.Limit(perMinute/60), max(1, int(perMinute))),
+		queue:    make(chan Event, queueSize),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		lastSent: make(map[string]time.Time),
+	}
+	go n.deliver()
+	current = n
+	return nil
+}
+
+// Enabled reports whether webhooks are configured.
+func Enabled() bool {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current != nil
+}
+
+// Send queues an event for delivery without blocking. Events within the
+// cooldown of the previous one of the same kind and class are dropped.
+func Send(kind, class, format string, args ...interface{}) {
+	currentMu.RLock()
+	n := current
+	currentMu.RUnlock()
+	if n == nil {
+		return
+	}
+
+	e := Event{Kind: kind, Class: class, Message: fmt.Sprintf(format, args...), Time: time.Now()}
+
+	n.mu.Lock()
+	key := kind + "/" + class
+	if last, ok := n.lastSent[key]; ok && e.Time.Sub(last) < n.cooldown {
+		n.mu.Unlock()
+		return
+	}
+	n.lastSent[key] = e.Time
+	n.mu.Unlock()
+
+	select {
+	case n.queue <- e:
+	default:
+		log.Warnf("Notification queue full, dropping %s %s notification", class, kind)
+	}
+}
+
+func (n *notifier) deliver() {
+	for e := range n.queue {
+		if !n.limiter.Allow() {
+			log.Warnf("Notification rate limit reached, dropping %s %s notification", e.Class, e.Kind)
+			continue
+		}
+		for _, t := range n.targets {
+			if err := n.post(t, e); err != nil {
+				log.Warnf("Notification to %s failed (error: %s).", t.redacted(), err)
+			}
+		}
+	}
+}
+
+func (n *notifier) post(t target, e Event) error {
+	body, err := json.Marshal(t.payload(e))
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if uerr, ok := err.(*url.Error); ok {
+		return uerr.Err // the URL holds the webhook secret
+	} else if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
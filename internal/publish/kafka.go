@@ -0,0 +1,425 @@
+package publish
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kafka protocol versions used; both are understood by brokers from 1.0 up
+// to 4.x.
+const (
+	kafkaProduceKey      = 0
+	kafkaProduceVersion  = 3
+	kafkaMetadataKey     = 3
+	kafkaMetadataVersion = 4
+
+	kafkaTimeout  = 10 * time.Second
+	kafkaClientID = "binance-proxy"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaSender produces to one topic with acks from the partition leaders.
+// Messages are spread over the partitions by a hash of their subject.
+type kafkaSender struct {
+	bootstrap []string
+	topic     string
+
+	brokers       map[int32]string // node id -> address
+	leaders       []int32          // partition -> leader node id
+	conns         map[int32]*kafkaConn
+	correlationID int32
+}
+
+type kafkaConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newKafkaSender(u *url.URL) (*kafkaSender, error) {
+	topic := strings.Trim(u.Path, "/")
+	if topic == "" || strings.ContainsAny(topic, "/ ") {
+		return nil, fmt.Errorf("invalid Kafka topic %q, expected kafka://host:port/topic", topic)
+	}
+	var bootstrap []string
+	for _, addr := range strings.Split(u.Host, ",") {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "9092")
+		}
+		bootstrap = append(bootstrap, addr)
+	}
+	return &kafkaSender{bootstrap: bootstrap, topic: topic, conns: make(map[int32]*kafkaConn)}, nil
+}
+
+func (s *kafkaSender) name() string {
+	return "kafka://" + strings.Join(s.bootstrap, ",") + "/" + s.topic
+}
+
+func (s *kafkaSender) send(msgs []message) error {
+	if len(s.leaders) == 0 {
+		if err := s.refreshMetadata(); err != nil {
+			return err
+		}
+	}
+
+	partitions := make(map[int32][]message)
+	for _, m := range msgs {
+		h := fnv.New32a()
+		h.Write([]byte(m.subject))
+		p := int32(h.Sum32() % uint32(len(s.leaders)))
+		partitions[p] = append(partitions[p], m)
+	}
+	byLeader := make(map[int32]map[int32][]message)
+	for p, pm := range partitions {
+		leader := s.leaders[p]
+		if byLeader[leader] == nil {
+			byLeader[leader] = make(map[int32][]message)
+		}
+		byLeader[leader][p] = pm
+	}
+
+	for leader, pm := range byLeader {
+		if err := s.produce(leader, pm); err != nil {
+			// Leaders may have moved; look them up again on the next send
+			s.close()
+			s.leaders = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshMetadata looks up the brokers and the partition leaders of the
+// topic from the first reachable bootstrap broker.
+func (s *kafkaSender) refreshMetadata() error {
+	var lastErr error
+	for _, addr := range s.bootstrap {
+		c, err := dialKafka(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		err = s.metadata(c)
+		c.conn.Close()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+func (s *kafkaSender) metadata(c *kafkaConn) error {
+	var body kafkaWriter
+	body.int32(1)
+	body.string(s.topic)
+	body.bool(false) // allow_auto_topic_creation
+	resp, err := s.roundTrip(c, kafkaMetadataKey, kafkaMetadataVersion, body.buf)
+	if err != nil {
+		return err
+	}
+
+	r := &kafkaReader{buf: resp}
+	r.int32() // throttle_time_ms
+	brokers := make(map[int32]string)
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		id := r.int32()
+		host := r.string()
+		port := r.int32()
+		r.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.string() // cluster_id
+	r.int32()  // controller_id
+
+	var leaders []int32
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		if code := r.int16(); code != 0 {
+			return fmt.Errorf("topic %s: %w", s.topic, kafkaError(code))
+		}
+		r.string() // name
+		r.bool()   // is_internal
+		partitions := r.int32()
+		if partitions <= 0 {
+			return fmt.Errorf("topic %s has no partitions", s.topic)
+		}
+		leaders = make([]int32, partitions)
+		for i := int32(0); i < partitions && r.err == nil; i++ {
+			r.int16() // error_code
+			p := r.int32()
+			leader := r.int32()
+			r.skipArray(4) // replica_nodes
+			r.skipArray(4) // isr_nodes
+			if p >= 0 && p < partitions {
+				leaders[p] = leader
+			}
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("invalid metadata response: %w", r.err)
+	}
+	if leaders == nil {
+		return fmt.Errorf("topic %s not found", s.topic)
+	}
+	for p, leader := range leaders {
+		if _, ok := brokers[leader]; !ok {
+			return fmt.Errorf("partition %d of %s has no leader", p, s.topic)
+		}
+	}
+
+	s.brokers = brokers
+	s.leaders = leaders
+	return nil
+}
+
+// produce sends the messages of each partition as one record batch.
+func (s *kafkaSender) produce(leader int32, partitions map[int32][]message) error {
+	c, ok := s.conns[leader]
+	if !ok {
+		var err error
+		if c, err = dialKafka(s.brokers[leader]); err != nil {
+			return err
+		}
+		s.conns[leader] = c
+	}
+
+	var body kafkaWriter
+	body.int16(-1) // transactional_id
+	body.int16(1)  // acks from the leader
+	body.int32(int32(kafkaTimeout / time.Millisecond))
+	body.int32(1)
+	body.string(s.topic)
+	body.int32(int32(len(partitions)))
+	for p, msgs := range partitions {
+		body.int32(p)
+		batch := recordBatch(msgs, time.Now())
+		body.int32(int32(len(batch)))
+		body.buf = append(body.buf, batch...)
+	}
+	resp, err := s.roundTrip(c, kafkaProduceKey, kafkaProduceVersion, body.buf)
+	if err != nil {
+		return err
+	}
+
+	r := &kafkaReader{buf: resp}
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		r.string() // name
+		for n := r.int32(); n > 0 && r.err == nil; n-- {
+			p := r.int32()
+			code := r.int16()
+			r.int64() // base_offset
+			r.int64() // log_append_time_ms
+			if code != 0 && r.err == nil {
+				return fmt.Errorf("partition %d of %s: %w", p, s.topic, kafkaError(code))
+			}
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("invalid produce response: %w", r.err)
+	}
+	return nil
+}
+
+func (s *kafkaSender) roundTrip(c *kafkaConn, key, version int16, body []byte) ([]byte, error) {
+	s.correlationID++
+	id := s.correlationID
+
+	var req kafkaWriter
+	req.int32(0) // size, set below
+	req.int16(key)
+	req.int16(version)
+	req.int32(id)
+	req.string(kafkaClientID)
+	req.buf = append(req.buf, body...)
+	binary.BigEndian.PutUint32(req.buf, uint32(len(req.buf)-4))
+
+	c.conn.SetDeadline(time.Now().Add(kafkaTimeout + 5*time.Second))
+	if _, err := c.conn.Write(req.buf); err != nil {
+		return nil, err
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > 64<<20 {
+		return nil, fmt.Errorf("invalid Kafka response size %d", n)
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	if got := int32(binary.BigEndian.Uint32(resp)); got != id {
+		return nil, fmt.Errorf("Kafka response for request %d, expected %d", got, id)
+	}
+	return resp[4:], nil
+}
+
+func (s *kafkaSender) close() {
+	for id, c := range s.conns {
+		c.conn.Close()
+		delete(s.conns, id)
+	}
+}
+
+func dialKafka(addr string) (*kafkaConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, kafkaTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaConn{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// recordBatch encodes msgs as an uncompressed v2 record batch.
+func recordBatch(msgs []message, now time.Time) []byte {
+	ts := now.UnixMilli()
+
+	var records []byte
+	var rec []byte
+	for i, m := range msgs {
+		rec = rec[:0]
+		rec = append(rec, 0) // attributes
+		rec = binary.AppendVarint(rec, 0)
+		rec = binary.AppendVarint(rec, int64(i))
+		rec = binary.AppendVarint(rec, int64(len(m.subject)))
+		rec = append(rec, m.subject...)
+		rec = binary.AppendVarint(rec, int64(len(m.value)))
+		rec = append(rec, m.value...)
+		rec = binary.AppendVarint(rec, 0) // headers
+		records = binary.AppendVarint(records, int64(len(rec)))
+		records = append(records, rec...)
+	}
+
+	// The CRC covers everything from the attributes on
+	var tail kafkaWriter
+	tail.int16(0) // attributes
+	tail.int32(int32(len(msgs) - 1))
+	tail.int64(ts)
+	tail.int64(ts)
+	tail.int64(-1) // producer_id
+	tail.int16(-1) // producer_epoch
+	tail.int32(-1) // base_sequence
+	tail.int32(int32(len(msgs)))
+	tail.buf = append(tail.buf, records...)
+
+	var batch kafkaWriter
+	batch.int64(0)                                // base_offset
+	batch.int32(int32(4 + 1 + 4 + len(tail.buf))) // batch_length
+	batch.int32(-1)                               // partition_leader_epoch
+	batch.buf = append(batch.buf, 2)              // magic
+	batch.int32(int32(crc32.Checksum(tail.buf, crc32c)))
+	batch.buf = append(batch.buf, tail.buf...)
+	return batch.buf
+}
+
+type kafkaWriter struct {
+	buf []byte
+}
+
+func (w *kafkaWriter) int16(v int16) { w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(v)) }
+func (w *kafkaWriter) int32(v int32) { w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(v)) }
+func (w *kafkaWriter) int64(v int64) { w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(v)) }
+
+func (w *kafkaWriter) bool(v bool) {
+	if v {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// kafkaReader decodes a response, remembering the first error so fields can
+// be read without checking each one.
+type kafkaReader struct {
+	buf []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf) < n {
+		r.err = io.ErrUnexpectedEOF
+		r.buf = nil
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) bool() bool {
+	b := r.next(1)
+	return b != nil && b[0] != 0
+}
+
+// string reads a string or nullable string.
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+func (r *kafkaReader) skipArray(elemSize int) {
+	if n := r.int32(); n > 0 {
+		r.next(int(n) * elemSize)
+	}
+}
+
+// kafkaError names the error codes a producer is likely to see.
+func kafkaError(code int16) error {
+	switch code {
+	case 3:
+		return errors.New("unknown topic or partition")
+	case 5:
+		return errors.New("leader not available")
+	case 6:
+		return errors.New("not leader for partition")
+	case 7:
+		return errors.New("request timed out")
+	case 10:
+		return errors.New("message too large")
+	case 29:
+		return errors.New("topic authorization failed")
+	}
+	return fmt.Errorf("Kafka error code %d", code)
+}
@@ -0,0 +1,290 @@
+package publish
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCRC32C(t *testing.T) {
+	// check value of CRC-32C (RFC 3720, appendix B.4)
+	if got := crc32.Checksum([]byte("123456789"), crc32c); got != 0xe3069283 {
+		t.Errorf("CRC-32C of 123456789 = %#x, want 0xe3069283", got)
+	}
+}
+
+// decodeRecordBatch decodes a v2 record batch as written by recordBatch,
+// checking its framing and CRC.
+func decodeRecordBatch(b []byte) ([]message, int64, error) {
+	r := &kafkaReader{buf: b}
+	r.int64() // base_offset
+	if n := r.int32(); int(n) != len(b)-12 {
+		return nil, 0, fmt.Errorf("batch_length %d for %d bytes", n, len(b)-12)
+	}
+	r.int32() // partition_leader_epoch
+	if magic := r.next(1); magic == nil || magic[0] != 2 {
+		return nil, 0, errors.New("not a v2 record batch")
+	}
+	if crc := uint32(r.int32()); crc != crc32.Checksum(r.buf, crc32c) {
+		return nil, 0, errors.New("CRC mismatch")
+	}
+	if attributes := r.int16(); attributes != 0 {
+		return nil, 0, fmt.Errorf("attributes %#x, want an uncompressed batch", attributes)
+	}
+	lastOffsetDelta := r.int32()
+	ts := r.int64()
+	if maxTS := r.int64(); maxTS != ts {
+		return nil, 0, fmt.Errorf("max_timestamp %d, first_timestamp %d", maxTS, ts)
+	}
+	r.int64() // producer_id
+	r.int16() // producer_epoch
+	r.int32() // base_sequence
+	count := r.int32()
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if lastOffsetDelta != count-1 {
+		return nil, 0, fmt.Errorf("last_offset_delta %d for %d records", lastOffsetDelta, count)
+	}
+
+	varint := func() int64 {
+		v, n := binary.Varint(r.buf)
+		if n <= 0 {
+			r.err = io.ErrUnexpectedEOF
+			return 0
+		}
+		r.buf = r.buf[n:]
+		return v
+	}
+	var msgs []message
+	for i := int32(0); i < count && r.err == nil; i++ {
+		length := varint()
+		end := len(r.buf) - int(length)
+		r.next(1) // attributes
+		varint()  // timestamp_delta
+		if delta := varint(); delta != int64(i) {
+			return nil, 0, fmt.Errorf("record %d has offset_delta %d", i, delta)
+		}
+		key := r.next(int(varint()))
+		value := r.next(int(varint()))
+		if headers := varint(); headers != 0 {
+			return nil, 0, fmt.Errorf("record %d has %d headers", i, headers)
+		}
+		if len(r.buf) != end {
+			return nil, 0, fmt.Errorf("record %d length %d doesn't match its fields", i, length)
+		}
+		msgs = append(msgs, message{subject: string(key), value: value})
+	}
+	if r.err == nil && len(r.buf) != 0 {
+		r.err = fmt.Errorf("%d bytes after the records", len(r.buf))
+	}
+	return msgs, ts, r.err
+}
+
+func TestRecordBatch(t *testing.T) {
+	now := time.UnixMilli(1700000000123)
+	msgs := []message{
+		{"binance.kline.SPOT.BTCUSDT.1m", []byte(`{"t":1700000000000,"c":"37005.00"}`)},
+		{"binance.ticker.SPOT.ETHUSDT", make([]byte, 300)}, // varint lengths of two bytes
+	}
+	got, ts, err := decodeRecordBatch(recordBatch(msgs, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts != now.UnixMilli() {
+		t.Errorf("first_timestamp = %d, want %d", ts, now.UnixMilli())
+	}
+	if len(got) != len(msgs) {
+		t.Fatalf("decoded %d records, want %d", len(got), len(msgs))
+	}
+	for i := range msgs {
+		if got[i].subject != msgs[i].subject || string(got[i].value) != string(msgs[i].value) {
+			t.Errorf("record %d = %q, want %q", i, got[i].subject, msgs[i].subject)
+		}
+	}
+}
+
+// fakeBroker answers Metadata with two partitions led by itself and records
+// the messages of each Produce request by partition.
+type fakeBroker struct {
+	t    *testing.T
+	l    net.Listener
+	code int16 // error code of the produce responses
+
+	mu       sync.Mutex
+	produced map[int32][]message
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{t: t, l: l, produced: make(map[int32][]message)}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(br, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(br, req); err != nil {
+			return
+		}
+		r := &kafkaReader{buf: req}
+		key, version, id := r.int16(), r.int16(), r.int32()
+		if client := r.string(); client != kafkaClientID {
+			b.t.Errorf("client_id = %q", client)
+		}
+
+		var resp kafkaWriter
+		resp.int32(0)
+		resp.int32(id)
+		switch {
+		case key == kafkaMetadataKey && version == kafkaMetadataVersion:
+			b.metadata(r, &resp)
+		case key == kafkaProduceKey && version == kafkaProduceVersion:
+			b.produce(r, &resp)
+		default:
+			b.t.Errorf("unexpected request %d v%d", key, version)
+			return
+		}
+		if r.err != nil || len(r.buf) != 0 {
+			b.t.Errorf("request %d: %d bytes left, error %v", key, len(r.buf), r.err)
+		}
+		binary.BigEndian.PutUint32(resp.buf, uint32(len(resp.buf)-4))
+		if _, err := conn.Write(resp.buf); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) metadata(r *kafkaReader, resp *kafkaWriter) {
+	if n, topic := r.int32(), r.string(); n != 1 || topic != "candles" {
+		b.t.Errorf("metadata requested for %d topics, %q", n, topic)
+	}
+	r.bool() // allow_auto_topic_creation
+
+	host, port, _ := net.SplitHostPort(b.l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	resp.int32(0) // throttle_time_ms
+	resp.int32(1)
+	resp.int32(7)
+	resp.string(host)
+	resp.int32(int32(p))
+	resp.int16(-1) // rack
+	resp.string("cluster")
+	resp.int32(7) // controller_id
+	resp.int32(1)
+	resp.int16(0)
+	resp.string("candles")
+	resp.bool(false)
+	resp.int32(2)
+	for _, p := range []int32{1, 0} {
+		resp.int16(0)
+		resp.int32(p)
+		resp.int32(7) // leader
+		resp.int32(1) // replica_nodes
+		resp.int32(7)
+		resp.int32(1) // isr_nodes
+		resp.int32(7)
+	}
+}
+
+func (b *fakeBroker) produce(r *kafkaReader, resp *kafkaWriter) {
+	r.string() // transactional_id
+	if acks := r.int16(); acks != 1 {
+		b.t.Errorf("acks = %d, want 1", acks)
+	}
+	r.int32() // timeout_ms
+	r.int32() // topics
+	topic := r.string()
+
+	resp.int32(1)
+	resp.string(topic)
+	n := r.int32()
+	resp.int32(n)
+	for ; n > 0 && r.err == nil; n-- {
+		p := r.int32()
+		msgs, _, err := decodeRecordBatch(r.next(int(r.int32())))
+		if err != nil {
+			b.t.Errorf("partition %d: %s", p, err)
+		}
+		b.mu.Lock()
+		if b.code == 0 {
+			b.produced[p] = append(b.produced[p], msgs...)
+		}
+		resp.int32(p)
+		resp.int16(b.code)
+		b.mu.Unlock()
+		resp.int64(0)  // base_offset
+		resp.int64(-1) // log_append_time_ms
+	}
+	resp.int32(0) // throttle_time_ms
+}
+
+func TestKafkaSender(t *testing.T) {
+	b := newFakeBroker(t)
+	u, _ := url.Parse("kafka://" + b.l.Addr().String() + "/candles")
+	s, err := newKafkaSender(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.close)
+
+	var msgs []message
+	for _, subject := range []string{"binance.kline.SPOT.BTCUSDT.1m", "binance.kline.SPOT.ETHUSDT.1m", "binance.depth.FUTURES.BTCUSDT", "binance.ticker.SPOT.BNBUSDT"} {
+		msgs = append(msgs, message{subject, []byte(`{"subject":"` + subject + `"}`)})
+	}
+	if err := s.send(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.leaders) != 2 || s.brokers[7] != b.l.Addr().String() {
+		t.Errorf("metadata: leaders %v, brokers %v", s.leaders, s.brokers)
+	}
+
+	b.mu.Lock()
+	for _, m := range msgs {
+		h := fnv.New32a()
+		h.Write([]byte(m.subject))
+		p := int32(h.Sum32() % 2)
+		found := false
+		for _, got := range b.produced[p] {
+			found = found || (got.subject == m.subject && string(got.value) == string(m.value))
+		}
+		if !found {
+			t.Errorf("%s not produced to partition %d", m.subject, p)
+		}
+	}
+	b.code = 6 // not leader for partition
+	b.mu.Unlock()
+
+	if err := s.send(msgs[:1]); err == nil || s.leaders != nil {
+		t.Errorf("send error = %v, leaders %v; want the error and the leaders looked up again", err, s.leaders)
+	}
+}
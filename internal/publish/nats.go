@@ -0,0 +1,178 @@
+package publish
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const natsTimeout = 10 * time.Second
+
+// natsSender publishes over the NATS client protocol. Messages go to the
+// subject prefix of the URL followed by the message subject.
+type natsSender struct {
+	addr    string
+	prefix  string
+	connect []byte
+
+	mu   sync.Mutex // guards conn and writes to it
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+func newNATSSender(u *url.URL) (*natsSender, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix == "" {
+		prefix = "binance"
+	}
+	if strings.ContainsAny(prefix, " \t\r\n*>") {
+		return nil, fmt.Errorf("invalid NATS subject prefix %q", prefix)
+	}
+
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "binance-proxy", "lang": "go", "protocol": 0}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			opts["user"] = u.User.Username()
+			opts["pass"] = password
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	connect, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &natsSender{
+		addr:    addr,
+		prefix:  prefix,
+		connect: []byte("CONNECT " + string(connect) + "\r\n"),
+	}, nil
+}
+
+func (s *natsSender) name() string {
+	return "nats://" + s.addr + "/" + s.prefix
+}
+
+func (s *natsSender) send(msgs []message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.dial(); err != nil {
+			return err
+		}
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+	for _, m := range msgs {
+		fmt.Fprintf(s.w, "PUB %s.%s %d\r\n", s.prefix, m.subject, len(m.value))
+		s.w.Write(m.value)
+		s.w.WriteString("\r\n")
+	}
+	if err := s.w.Flush(); err != nil {
+		s.closeLocked()
+		return err
+	}
+	return nil
+}
+
+// dial connects and sends CONNECT after the server's INFO line.
+func (s *natsSender) dial() error {
+	conn, err := net.DialTimeout("tcp", s.addr, natsTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(natsTimeout))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting %q", strings.TrimSpace(line))
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	json.Unmarshal([]byte(line[len("INFO "):]), &info)
+	if info.TLSRequired {
+		conn.Close()
+		return fmt.Errorf("NATS server requires TLS, which isn't supported")
+	}
+	if _, err := conn.Write(append(s.connect, "PING\r\n"...)); err != nil {
+		conn.Close()
+		return err
+	}
+	// The server answers PONG once CONNECT is accepted, or -ERR
+	line, err = r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "PONG") {
+		conn.Close()
+		return fmt.Errorf("NATS server refused the connection: %s", strings.TrimSpace(line))
+	}
+	conn.SetDeadline(time.Time{})
+
+	s.conn = conn
+	s.w = bufio.NewWriterSize(conn, 64*1024)
+	go s.readLoop(conn, r)
+	return nil
+}
+
+// readLoop answers the server's keepalive PINGs and logs its errors.
+func (s *natsSender) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			s.mu.Lock()
+			if s.conn == conn {
+				s.closeLocked()
+			}
+			s.mu.Unlock()
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			s.mu.Lock()
+			if s.conn == conn {
+				s.conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+				s.w.WriteString("PONG\r\n")
+				s.w.Flush()
+			}
+			s.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Warnf("NATS server %s reported %s", s.addr, strings.TrimSpace(line))
+		}
+	}
+}
+
+func (s *natsSender) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.w.Flush()
+		s.closeLocked()
+	}
+}
+
+func (s *natsSender) closeLocked() {
+	s.conn.Close()
+	s.conn = nil
+	s.w = nil
+}
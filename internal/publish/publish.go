@@ -0,0 +1,188 @@
+// Package publish forwards market data messages to a NATS subject or a Kafka
+// topic, turning the proxy into a feed for downstream pipelines. Messages
+// are queued and sent in batches by a background goroutine; when the broker
+// is unreachable or can't keep up they are dropped rather than stall the
+// websockets producing them.
+package publish
+
+import (
+	"binance-proxy/internal/logcache"
+	"binance-proxy/internal/metrics"
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// queueSize bounds the messages waiting to be sent; more are dropped.
+	queueSize = 16384
+	// batchSize is the most messages sent at once.
+	batchSize = 500
+	// flushInterval is the longest a message waits for its batch.
+	flushInterval = 100 * time.Millisecond
+	// retryInterval is the pause before reconnecting after a failed send.
+	retryInterval = 5 * time.Second
+)
+
+// message is a payload with the subject it is published under. Kafka uses
+// the subject as message key, so one stream always lands in one partition.
+type message struct {
+	subject string
+	value   []byte
+}
+
+// sender delivers batches of messages to a broker, connecting on demand.
+type sender interface {
+	send(msgs []message) error
+	close()
+	name() string
+}
+
+type publisher struct {
+	sender sender
+	queue  chan message
+	done   chan struct{}
+}
+
+var (
+	current   *publisher
+	currentMu sync.RWMutex
+)
+
+// Configure starts publishing to the broker of rawURL, either
+// nats://[user:password@]host:4222/subject.prefix or
+// kafka://broker:9092[,broker:9092...]/topic. An empty URL disables
+// publishing.
+func Configure(rawURL string) error {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	if current != nil {
+		close(current.queue)
+		current = nil
+	}
+	if rawURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid publish URL, expected nats://host:port/subject or kafka://host:port/topic")
+	}
+	var s sender
+	switch u.Scheme {
+	case "nats":
+		s, err = newNATSSender(u)
+	case "kafka":
+		s, err = newKafkaSender(u)
+	default:
+		err = fmt.Errorf("unsupported publish URL scheme %q, expected nats or kafka", u.Scheme)
+	}
+	if err != nil {
+		return err
+	}
+
+	p := &publisher{sender: s, queue: make(chan message, queueSize), done: make(chan struct{})}
+	go p.run()
+	current = p
+	return nil
+}
+
+// Enabled reports whether messages are published.
+func Enabled() bool {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current != nil
+}
+
+// Target describes the configured broker without credentials, for logs.
+func Target() string {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	if current == nil {
+		return ""
+	}
+	return current.sender.name()
+}
+
+// Send queues value for publishing under subject without blocking.
+func Send(subject string, value []byte) {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	if current == nil {
+		return
+	}
+
+	select {
+	case current.queue <- message{subject: subject, value: value}:
+	default:
+		metrics.PublishedMessages.WithLabelValues("dropped").Inc()
+		logcache.LogOncePerDuration("warn", "Publish queue full, dropping market data messages")
+	}
+}
+
+// Shutdown sends the queued messages, giving up when ctx is done.
+func Shutdown(ctx context.Context) {
+	currentMu.Lock()
+	p := current
+	current = nil
+	if p != nil {
+		close(p.queue)
+	}
+	currentMu.Unlock()
+	if p == nil {
+		return
+	}
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		log.Warnf("Publishing the queued market data to %s did not finish in time", p.sender.name())
+	}
+}
+
+func (p *publisher) run() {
+	defer close(p.done)
+	defer p.sender.close()
+
+	batch := make([]message, 0, batchSize)
+	timer := time.NewTimer(flushInterval)
+	defer timer.Stop()
+	var retryAt time.Time
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if time.Now().Before(retryAt) {
+			metrics.PublishedMessages.WithLabelValues("dropped").Add(float64(len(batch)))
+		} else if err := p.sender.send(batch); err != nil {
+			retryAt = time.Now().Add(retryInterval)
+			metrics.PublishedMessages.WithLabelValues("failed").Add(float64(len(batch)))
+			logcache.LogOncePerDuration("warn", fmt.Sprintf("Publishing market data to %s failed (error: %s).", p.sender.name(), err))
+		} else {
+			metrics.PublishedMessages.WithLabelValues("sent").Add(float64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case m, ok := <-p.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, m)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+			timer.Reset(flushInterval)
+		}
+	}
+}
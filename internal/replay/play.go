@@ -0,0 +1,230 @@
+package replay
+
+import (
+	"binance-proxy/internal/logcache"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// replayPingInterval keeps replayed connections alive once a recording has
+// been played to its end.
+const replayPingInterval = time.Minute
+
+// maxLineSize bounds a line of a recording; exchangeInfo responses are a few
+// megabytes.
+const maxLineSize = 64 << 20
+
+type player struct {
+	dir   string
+	speed float64
+
+	mu     sync.Mutex
+	rest   map[string][]*restEntry
+	served map[string]int
+}
+
+func loadPlayer(dir string, speed float64) (*player, error) {
+	f, err := os.Open(filepath.Join(dir, "rest.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("no recording in %s: %w", dir, err)
+	}
+	defer f.Close()
+
+	p := &player{dir: dir, speed: speed, rest: make(map[string][]*restEntry), served: make(map[string]int)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxLineSize)
+	for n := 1; scanner.Scan(); n++ {
+		e := &restEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("rest.jsonl line %d: %w", n, err)
+		}
+		key := e.Method + " " + e.URL
+		p.rest[key] = append(p.rest[key], e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("rest.jsonl: %w", err)
+	}
+	log.Infof("Replaying %d recorded REST requests from %s", len(p.rest), dir)
+	return p, nil
+}
+
+// RoundTrip answers with the recorded responses of the request in the order
+// they were recorded, repeating the last one.
+func (p *player) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.Method + " " + requestKey(req)
+	p.mu.Lock()
+	entries := p.rest[key]
+	var e *restEntry
+	if len(entries) > 0 {
+		e = entries[min(p.served[key], len(entries)-1)]
+		p.served[key]++
+	}
+	p.mu.Unlock()
+
+	if e == nil {
+		logcache.LogOncePerDuration("warn", fmt.Sprintf("No recorded response for %s, answering 404", key))
+		e = &restEntry{
+			Status: http.StatusNotFound,
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   json.RawMessage(`{"code":-1,"msg":"no recorded response"}`),
+		}
+	}
+
+	body := []byte(e.Body)
+	if e.Body == nil {
+		body = []byte(e.BodyText)
+	}
+	header := e.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// serveWS plays the recorded streams of a connection. Every connection
+// starts the recording of its streams from the beginning.
+func (p *player) serveWS(w http.ResponseWriter, req *http.Request) {
+	e, streams, ok := route(req)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+
+	var writeMu sync.Mutex
+	send := func(data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(websocket.TextMessage, data)
+	}
+	go func() {
+		t := time.NewTicker(replayPingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+			}
+		}
+	}()
+
+	players := make(map[string]context.CancelFunc)
+	start := func(stream string) {
+		if _, ok := players[stream]; ok || !validStream(stream) {
+			return
+		}
+		streamCtx, stop := context.WithCancel(ctx)
+		players[stream] = stop
+		go p.playStream(streamCtx, e.class, stream, func(data []byte) error {
+			if !e.combined {
+				return send(data)
+			}
+			msg, err := json.Marshal(struct {
+				Stream string          `json:"stream"`
+				Data   json.RawMessage `json:"data"`
+			}{stream, data})
+			if err != nil {
+				return err
+			}
+			return send(msg)
+		})
+	}
+	for _, stream := range streams {
+		start(stream)
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var request struct {
+			Method string   `json:"method"`
+			Params []string `json:"params"`
+			ID     int64    `json:"id"`
+		}
+		if !e.combined || json.Unmarshal(data, &request) != nil {
+			continue
+		}
+		for _, stream := range request.Params {
+			switch request.Method {
+			case "SUBSCRIBE":
+				start(stream)
+			case "UNSUBSCRIBE":
+				if stop, ok := players[stream]; ok {
+					stop()
+					delete(players, stream)
+				}
+			}
+		}
+		send([]byte(`{"result":null,"id":` + strconv.FormatInt(request.ID, 10) + `}`))
+	}
+}
+
+// playStream sends the recorded messages of a stream with their original
+// spacing divided by the replay speed.
+func (p *player) playStream(ctx context.Context, class, stream string, send func([]byte) error) {
+	f, err := os.Open(filepath.Join(p.dir, "ws", class, stream+".jsonl"))
+	if err != nil {
+		log.Debugf("No recording of the %s %s stream, it stays silent.", class, stream)
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxLineSize)
+	prev := int64(-1)
+	for scanner.Scan() {
+		var e wsEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if prev >= 0 && e.T > prev {
+			t := time.NewTimer(time.Duration(float64(e.T-prev) * float64(time.Millisecond) / p.speed))
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+		}
+		prev = e.T
+		if ctx.Err() != nil || send(e.Data) != nil {
+			return
+		}
+	}
+	log.Debugf("Replay of the %s %s stream finished.", class, stream)
+}
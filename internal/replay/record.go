@@ -0,0 +1,198 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// restEntry is a line of rest.jsonl. Body holds JSON responses as they are,
+// BodyText anything else.
+type restEntry struct {
+	T        int64           `json:"t"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Status   int             `json:"status"`
+	Header   http.Header     `json:"header,omitempty"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	BodyText string          `json:"body_text,omitempty"`
+}
+
+// wsEntry is a line of a stream file, t being milliseconds since the
+// recording started.
+type wsEntry struct {
+	T    int64           `json:"t"`
+	Data json.RawMessage `json:"data"`
+}
+
+var upgrader = websocket.Upgrader{
+	// Only the proxy itself connects over the loopback interface
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+type recorder struct {
+	dir   string
+	start time.Time
+
+	mu      sync.Mutex
+	rest    *os.File
+	streams map[string]*os.File
+}
+
+func (r *recorder) writeREST(e *restEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rest != nil {
+		r.rest.Write(append(line, '\n'))
+	}
+}
+
+func (r *recorder) writeMessage(class, stream string, data []byte) {
+	if !validStream(stream) || !json.Valid(data) {
+		return
+	}
+	line, err := json.Marshal(wsEntry{T: time.Since(r.start).Milliseconds(), Data: data})
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.streams == nil {
+		return
+	}
+	key := class + "/" + stream
+	f, ok := r.streams[key]
+	if !ok {
+		f, err = os.OpenFile(filepath.Join(r.dir, "ws", class, stream+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Errorf("Recording %s %s failed (error: %s).", class, stream, err)
+			return
+		}
+		r.streams[key] = f
+	}
+	f.Write(append(line, '\n'))
+}
+
+func (r *recorder) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rest.Close()
+	r.rest = nil
+	for _, f := range r.streams {
+		f.Close()
+	}
+	r.streams = nil
+}
+
+// serveWS relays a stream connection to Binance, recording the messages
+// Binance sends.
+func (r *recorder) serveWS(w http.ResponseWriter, req *http.Request) {
+	e, streams, ok := route(req)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	target := e.upstream + "/" + streams[0]
+	if e.combined {
+		target = e.upstream + req.URL.Query().Get("streams")
+	}
+
+	up, _, err := websocket.DefaultDialer.DialContext(req.Context(), target, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer up.Close()
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// SUBSCRIBE and UNSUBSCRIBE requests of combined connections
+	go func() {
+		defer up.Close()
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if up.WriteMessage(mt, data) != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		mt, data, err := up.ReadMessage()
+		if err != nil {
+			return
+		}
+		if e.combined {
+			var msg struct {
+				Stream string          `json:"stream"`
+				Data   json.RawMessage `json:"data"`
+			}
+			if json.Unmarshal(data, &msg) == nil && msg.Stream != "" {
+				r.writeMessage(e.class, msg.Stream, msg.Data)
+			}
+		} else {
+			r.writeMessage(e.class, streams[0], data)
+		}
+		if conn.WriteMessage(mt, data) != nil {
+			return
+		}
+	}
+}
+
+// recordingTransport records the responses of upstream REST requests.
+type recordingTransport struct {
+	base http.RoundTripper
+	rec  *recorder
+}
+
+func (t recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Let the transport decompress so the recording is readable
+	req = req.Clone(req.Context())
+	req.Header.Del("Accept-Encoding")
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	e := &restEntry{
+		T:      time.Since(t.rec.start).Milliseconds(),
+		Method: req.Method,
+		URL:    requestKey(req),
+		Status: resp.StatusCode,
+		Header: resp.Header.Clone(),
+	}
+	e.Header.Del("Content-Length")
+	if json.Valid(body) {
+		e.Body = body
+	} else {
+		e.BodyText = string(body)
+	}
+	t.rec.writeREST(e)
+	return resp, nil
+}
@@ -0,0 +1,207 @@
+// Package replay records the proxy's upstream traffic to a directory and
+// serves it back instead of Binance, so bot integration tests and backtests
+// can run against recorded market data without touching the exchange. It
+// can also send all upstream traffic to a mock server instead.
+//
+// REST responses are captured at the HTTP transport. Websocket messages are
+// captured by a relay on the loopback interface that the upstream stream URLs
+// are pointed at; in replay mode the same endpoint plays the recording back.
+// A directory holds rest.jsonl and a ws/<class>/<stream>.jsonl file per
+// stream, both with one JSON object per line.
+package replay
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	spot "github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+	log "github.com/sirupsen/logrus"
+)
+
+// endpoint is an upstream websocket base URL routed through the local server.
+type endpoint struct {
+	class    string
+	target   *string
+	path     string
+	combined bool
+	upstream string // the original base URL
+}
+
+var (
+	endpoints = []*endpoint{
+		{class: "spot", target: &spot.BaseWsMainURL, path: "/spot/ws"},
+		{class: "spot", target: &spot.BaseCombinedMainURL, path: "/spot/stream", combined: true},
+		{class: "futures", target: &futures.BaseWsMainUrl, path: "/futures/ws"},
+		{class: "futures", target: &futures.BaseCombinedMainURL, path: "/futures/stream", combined: true},
+	}
+
+	rec      *recorder
+	play     *player
+	redirect *url.URL
+)
+
+// Record starts capturing upstream REST responses and websocket messages to
+// dir. It must be called before any upstream connection is made.
+func Record(dir string) error {
+	for _, class := range []string{"spot", "futures"} {
+		if err := os.MkdirAll(filepath.Join(dir, "ws", class), 0o755); err != nil {
+			return err
+		}
+	}
+	rest, err := os.OpenFile(filepath.Join(dir, "rest.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	r := &recorder{dir: dir, start: time.Now(), rest: rest, streams: make(map[string]*os.File)}
+	if err := serve(r.serveWS); err != nil {
+		rest.Close()
+		return err
+	}
+	rec = r
+	return nil
+}
+
+// Replay serves the recording in dir instead of Binance. Websocket messages
+// keep their original spacing divided by speed; REST responses are answered
+// right away.
+func Replay(dir string, speed float64) error {
+	if speed <= 0 {
+		return fmt.Errorf("replay speed must be positive")
+	}
+	p, err := loadPlayer(dir, speed)
+	if err != nil {
+		return err
+	}
+	if err := serve(p.serveWS); err != nil {
+		return err
+	}
+	play = p
+	return nil
+}
+
+// Redirect sends the upstream REST requests and websocket streams to the
+// server at rawURL, such as the one of the mock subcommand, instead of
+// Binance. The server must serve the websocket streams under
+// /<spot|futures>/ws and /<spot|futures>/stream.
+func Redirect(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid upstream URL %q, expected http://host:port", rawURL)
+	}
+	wsBase := "ws://" + u.Host
+	if u.Scheme == "https" {
+		wsBase = "wss://" + u.Host
+	}
+	pointStreams(wsBase)
+	redirect = u
+	return nil
+}
+
+// Transport wraps the transport of upstream REST requests to record or
+// replay them; without either mode it returns base.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	switch {
+	case rec != nil:
+		return recordingTransport{base: base, rec: rec}
+	case play != nil:
+		return play
+	case redirect != nil:
+		return redirectTransport{base: base, target: redirect}
+	}
+	return base
+}
+
+// Close flushes the recording.
+func Close() {
+	if rec != nil {
+		rec.close()
+	}
+}
+
+// serve starts the local websocket endpoint and points the upstream stream
+// URLs at it.
+func serve(handler http.HandlerFunc) error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := http.Serve(ln, handler); err != nil {
+			log.Errorf("Replay websocket endpoint stopped (error: %s).", err)
+		}
+	}()
+
+	pointStreams("ws://" + ln.Addr().String())
+	return nil
+}
+
+// pointStreams replaces the upstream websocket base URLs with the endpoints
+// of the server at base.
+func pointStreams(base string) {
+	for _, e := range endpoints {
+		e.upstream = *e.target
+		local := base + e.path
+		if e.combined {
+			local += "?streams="
+		}
+		*e.target = local
+	}
+}
+
+// route returns the endpoint of a local websocket request and the streams it
+// names.
+func route(r *http.Request) (*endpoint, []string, bool) {
+	for _, e := range endpoints {
+		if e.combined && r.URL.Path == e.path {
+			var streams []string
+			if s := r.URL.Query().Get("streams"); s != "" {
+				streams = strings.Split(s, "/")
+			}
+			return e, streams, true
+		}
+		if stream, ok := strings.CutPrefix(r.URL.Path, e.path+"/"); ok && !e.combined && validStream(stream) {
+			return e, []string{stream}, true
+		}
+	}
+	return nil, nil, false
+}
+
+// validStream keeps stream names usable as file names.
+func validStream(stream string) bool {
+	return stream != "" && !strings.ContainsAny(stream, `/\`) && !strings.Contains(stream, "..")
+}
+
+// requestKey identifies a REST request in a recording, leaving out the
+// parameters of signed requests that change with every call.
+func requestKey(req *http.Request) string {
+	query := req.URL.Query()
+	for _, name := range []string{"timestamp", "signature", "recvWindow"} {
+		query.Del(name)
+	}
+	key := req.URL.Host + req.URL.Path
+	if len(query) > 0 {
+		key += "?" + query.Encode()
+	}
+	return key
+}
+
+// redirectTransport sends requests to target instead of Binance.
+type redirectTransport struct {
+	base   http.RoundTripper
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	req.Host = t.target.Host
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"math/big"
+	"time"
+)
+
+// avgPriceMins is the window of Binance's average price, the avgPriceMins of
+// the PERCENT_PRICE_BY_SIDE filter.
+const avgPriceMins = 5
+
+// AvgPrice is the response of /api/v3/avgPrice.
+type AvgPrice struct {
+	Mins      int    `json:"mins"`
+	Price     string `json:"price"`
+	CloseTime int64  `json:"closeTime"`
+}
+
+// AvgPrice computes the volume weighted average price of symbol over the last
+// avgPriceMins minutes from its 1m klines, which are streamed like for a
+// klines request. The window is taken in whole candles, so it reaches up to a
+// minute further back than Binance's. It returns nil while the klines aren't
+// available or if nothing traded in the window.
+func (s *Service) AvgPrice(ctx context.Context, symbol string) *AvgPrice {
+	klines := s.Klines(ctx, symbol, "1m")
+	if len(klines) == 0 {
+		return nil
+	}
+
+	now := time.Now().UnixMilli()
+	from := now - int64(avgPriceMins*time.Minute/time.Millisecond)
+	var volume, quoteVolume big.Rat
+	for i := len(klines) - 1; i >= 0 && klines[i].CloseTime >= from; i-- {
+		if v, ok := new(big.Rat).SetString(klines[i].Volume); ok {
+			volume.Add(&volume, v)
+		}
+		if q, ok := new(big.Rat).SetString(klines[i].QuoteAssetVolume); ok {
+			quoteVolume.Add(&quoteVolume, q)
+		}
+	}
+	if volume.Sign() == 0 {
+		return nil
+	}
+
+	closeTime := klines[len(klines)-1].CloseTime
+	if closeTime > now {
+		closeTime = now
+	}
+	return &AvgPrice{
+		Mins:      avgPriceMins,
+		Price:     new(big.Rat).Quo(&quoteVolume, &volume).FloatString(8),
+		CloseTime: closeTime,
+	}
+}
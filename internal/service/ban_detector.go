@@ -10,9 +10,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"binance-proxy/internal/logcache"
+	"binance-proxy/internal/notify"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -73,6 +75,7 @@ func (bd *BanDetector) IsBanned(class Class) bool {
 			// Recovery time passed, clear ban
 			bd.spotBanned = false
 			log.Infof("%s API ban lifted, resuming normal operation", class)
+			incidents.end(class, incidentBan, "ban-lifted", bd.spotRecoveryTime)
 		}
 	} else {
 		if bd.futuresBanned && now.Before(bd.futuresRecoveryTime) {
@@ -81,6 +84,7 @@ func (bd *BanDetector) IsBanned(class Class) bool {
 			// Recovery time passed, clear ban
 			bd.futuresBanned = false
 			log.Infof("%s API ban lifted, resuming normal operation", class)
+			incidents.end(class, incidentBan, "ban-lifted", bd.futuresRecoveryTime)
 		}
 	}
 
@@ -101,7 +105,7 @@ func (bd *BanDetector) CheckResponse(class Class, resp *http.Response, err error
 		if bd.isApproachingWeightLimit(class) {
 			waitTime := bd.getWeightResetTime()
 			if waitTime > 0 {
-				bd.setBanned(class, now.Add(waitTime))
+				bd.setBanned(class, now.Add(waitTime), "weight-limit")
 				logcache.LogOncePerDuration("warn", fmt.Sprintf("%s API weight limit approaching, suspending requests until %v", class, bd.getRecoveryTime(class)))
 				return true
 			}
@@ -122,7 +126,7 @@ func (bd *BanDetector) CheckResponse(class Class, resp *http.Response, err error
 			} else {
 				log.Errorf("%s API IP banned (418), suspending requests until %v", class, banUntil)
 			}
-			bd.setBanned(class, banUntil)
+			bd.setBanned(class, banUntil, "ip-ban-418")
 			bd.resetBackoffCount(class) // Reset backoff on explicit ban
 			return true
 		case 429: // Rate limit exceeded
@@ -134,11 +138,11 @@ func (bd *BanDetector) CheckResponse(class Class, resp *http.Response, err error
 			} else {
 				log.Warnf("%s API rate limited (429), suspending requests until %v", class, banUntil)
 			}
-			bd.setBanned(class, banUntil)
+			bd.setBanned(class, banUntil, "rate-limit-429")
 			bd.resetBackoffCount(class) // Reset backoff on explicit rate limit
 			return true
 		case 403: // Forbidden
-			bd.setBanned(class, now.Add(5*time.Minute))
+			bd.setBanned(class, now.Add(5*time.Minute), "forbidden-403")
 			log.Warnf("%s API access forbidden (403), suspending requests until %v", class, bd.getRecoveryTime(class))
 			return true
 		}
@@ -157,7 +161,7 @@ func (bd *BanDetector) CheckResponse(class Class, resp *http.Response, err error
 			errorCount := bd.getErrorCount(class)
 			if errorCount >= 5 {
 				backoffDuration := bd.getExponentialBackoff(class)
-				bd.setBanned(class, now.Add(backoffDuration))
+				bd.setBanned(class, now.Add(backoffDuration), "connection-errors")
 				bd.resetErrorCount(class)
 				log.Warnf("%s API connection issues detected (%d errors), suspending requests for %v until %v", class, errorCount, backoffDuration, bd.getRecoveryTime(class))
 				return true
@@ -243,31 +247,27 @@ func (bd *BanDetector) updateWeightInfo(class Class, resp *http.Response) {
 		if used := resp.Header.Get("X-MBX-USED-WEIGHT-1M"); used != "" {
 			if weight, err := strconv.Atoi(used); err == nil {
 				bd.spotWeightUsed = weight
+				spotBudget.report(weight, time.Now())
 			}
 		} else {
 			// Fallback: estimate weight usage (most kline requests are weight 1)
 			bd.spotWeightUsed += 1
 		}
 
-		// Set default limit if not set
-		if bd.spotWeightLimit == 0 {
-			bd.spotWeightLimit = 1200 // Default spot weight limit per minute
-		}
+		bd.spotWeightLimit = WeightLimit(SPOT)
 	} else {
 		// Futures API headers
 		if used := resp.Header.Get("X-MBX-USED-WEIGHT-1M"); used != "" {
 			if weight, err := strconv.Atoi(used); err == nil {
 				bd.futuresWeightUsed = weight
+				futuresBudget.report(weight, time.Now())
 			}
 		} else {
 			// Fallback: estimate weight usage
 			bd.futuresWeightUsed += 1
 		}
 
-		// Set default limit if not set
-		if bd.futuresWeightLimit == 0 {
-			bd.futuresWeightLimit = 2400 // Default futures weight limit per minute
-		}
+		bd.futuresWeightLimit = WeightLimit(FUTURES)
 	}
 
 	// Reset weight counters every minute
@@ -309,11 +309,33 @@ func (bd *BanDetector) resetBackoffCount(class Class) {
 	}
 }
 
-func (bd *BanDetector) setBanned(class Class, recoveryTime time.Time) {
+// banEvents counts the bans of all classes since start.
+var banEvents atomic.Int64
+
+// BanEvents returns the number of bans detected since start.
+func BanEvents() int64 {
+	return banEvents.Load()
+}
+
+// setBanned suspends the class until recoveryTime and opens an incident for
+// the degradation report.
+func (bd *BanDetector) setBanned(class Class, recoveryTime time.Time, cause string) {
+	banned, until := bd.spotBanned, bd.spotRecoveryTime
+	if class != SPOT {
+		banned, until = bd.futuresBanned, bd.futuresRecoveryTime
+	}
+	if !banned || time.Now().After(until) {
+		banEvents.Add(1)
+		notify.Send(notify.KindBan, string(class), "upstream requests suspended until %s (%s)", recoveryTime.Format(time.RFC3339), cause)
+	}
+
 	if class == SPOT {
+		incidents.begin(class, incidentBan, cause, bd.spotWeightUsed, bd.spotWeightLimit)
+
 		bd.spotBanned = true
 		bd.spotRecoveryTime = recoveryTime
 	} else {
+		incidents.begin(class, incidentBan, cause, bd.futuresWeightUsed, bd.futuresWeightLimit)
 		bd.futuresBanned = true
 		bd.futuresRecoveryTime = recoveryTime
 	}
@@ -368,16 +390,20 @@ func (bd *BanDetector) GetBanStatus(class Class) (bool, time.Time) {
 	return bd.futuresBanned, bd.futuresRecoveryTime
 }
 
+// weightLimitThreshold is the share of the weight limit above which upstream
+// requests are suspended until the next minute.
+const weightLimitThreshold = 0.9
+
 func (bd *BanDetector) isApproachingWeightLimit(class Class) bool {
 	if class == SPOT {
 		if bd.spotWeightLimit > 0 {
 			usage := float64(bd.spotWeightUsed) / float64(bd.spotWeightLimit)
-			return usage > 0.9 // 90% threshold
+			return usage > weightLimitThreshold
 		}
 	} else {
 		if bd.futuresWeightLimit > 0 {
 			usage := float64(bd.futuresWeightUsed) / float64(bd.futuresWeightLimit)
-			return usage > 0.9 // 90% threshold
+			return usage > weightLimitThreshold
 		}
 	}
 	return false
@@ -399,3 +425,104 @@ func (bd *BanDetector) GetWeightInfo(class Class) (used int, limit int, resetTim
 	}
 	return bd.futuresWeightUsed, bd.futuresWeightLimit, bd.futuresWeightReset
 }
+
+// BreakerState describes the ban detector of a class in circuit breaker
+// terms: open while upstream requests are suspended, closed otherwise.
+type BreakerState struct {
+	Name         string    `json:"name"`
+	State        string    `json:"state"`
+	Failures     int       `json:"failures"`
+	BackoffCount int       `json:"backoff_count"`
+	LastFailure  time.Time `json:"last_failure"`
+	NextRetry    time.Time `json:"next_retry"`
+}
+
+// Breaker returns the breaker view of the ban state of the class.
+func (bd *BanDetector) Breaker(class Class) BreakerState {
+	bd.mu.RLock()
+	defer bd.mu.RUnlock()
+
+	b := BreakerState{Name: "upstream-" + strings.ToLower(string(class)), State: "closed"}
+	banned, until := bd.spotBanned, bd.spotRecoveryTime
+	b.Failures, b.BackoffCount, b.LastFailure = bd.spotErrorCount, bd.spotBackoffCount, bd.lastSpotError
+	if class != SPOT {
+		banned, until = bd.futuresBanned, bd.futuresRecoveryTime
+		b.Failures, b.BackoffCount, b.LastFailure = bd.futuresErrorCount, bd.futuresBackoffCount, bd.lastFuturesError
+	}
+	if banned && time.Now().Before(until) {
+		b.State = "open"
+		b.NextRetry = until
+	}
+	return b
+}
+
+// BanState is the full ban detector state of a class.
+type BanState struct {
+	Class        Class     `json:"class"`
+	Banned       bool      `json:"banned"`
+	RecoveryTime time.Time `json:"recovery_time"`
+	WeightUsed   int       `json:"weight_used"`
+	WeightLimit  int       `json:"weight_limit"`
+	WeightReset  time.Time `json:"weight_reset"`
+	ErrorCount   int       `json:"error_count"`
+	LastError    time.Time `json:"last_error"`
+	BackoffCount int       `json:"backoff_count"`
+}
+
+// State returns the ban state of the class.
+func (bd *BanDetector) State(class Class) BanState {
+	bd.mu.RLock()
+	defer bd.mu.RUnlock()
+
+	if class == SPOT {
+		return BanState{
+			Class:        class,
+			Banned:       bd.spotBanned && time.Now().Before(bd.spotRecoveryTime),
+			RecoveryTime: bd.spotRecoveryTime,
+			WeightUsed:   bd.spotWeightUsed,
+			WeightLimit:  bd.spotWeightLimit,
+			WeightReset:  bd.spotWeightReset,
+			ErrorCount:   bd.spotErrorCount,
+			LastError:    bd.lastSpotError,
+			BackoffCount: bd.spotBackoffCount,
+		}
+	}
+	return BanState{
+		Class:        class,
+		Banned:       bd.futuresBanned && time.Now().Before(bd.futuresRecoveryTime),
+		RecoveryTime: bd.futuresRecoveryTime,
+		WeightUsed:   bd.futuresWeightUsed,
+		WeightLimit:  bd.futuresWeightLimit,
+		WeightReset:  bd.futuresWeightReset,
+		ErrorCount:   bd.futuresErrorCount,
+		LastError:    bd.lastFuturesError,
+		BackoffCount: bd.futuresBackoffCount,
+	}
+}
+
+// Clear lifts a ban of the class and resets its error and backoff counters,
+// for bans detected by mistake. It reports whether the class was banned.
+func (bd *BanDetector) Clear(class Class) bool {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+
+	now := time.Now()
+	var banned bool
+	if class == SPOT {
+		banned = bd.spotBanned && now.Before(bd.spotRecoveryTime)
+		bd.spotBanned = false
+		bd.spotRecoveryTime = time.Time{}
+	} else {
+		banned = bd.futuresBanned && now.Before(bd.futuresRecoveryTime)
+		bd.futuresBanned = false
+		bd.futuresRecoveryTime = time.Time{}
+	}
+	bd.resetErrorCount(class)
+	bd.resetBackoffCount(class)
+
+	if banned {
+		log.Warnf("%s API ban cleared manually, resuming normal operation", class)
+		incidents.end(class, incidentBan, "ban-cleared", now)
+	}
+	return banned
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"binance-proxy/internal/metrics"
+	"time"
+)
+
+// UpdateBanMetrics sets the weight and ban gauges of the classes from the
+// ban detector. The ban detector only changes on upstream responses, so the
+// gauges are refreshed right before they are scraped.
+func UpdateBanMetrics(classes ...Class) {
+	now := time.Now()
+	for _, class := range classes {
+		state := globalBanDetector.State(class)
+		used, limit := state.WeightUsed, WeightLimit(class)
+		if now.After(state.WeightReset) {
+			// the minute of the last reported weight is over
+			used = 0
+		}
+
+		approaching, banned, remaining := 0.0, 0.0, 0.0
+		if limit > 0 && float64(used)/float64(limit) > weightLimitThreshold {
+			approaching = 1
+		}
+		if state.Banned {
+			banned, remaining = 1, state.RecoveryTime.Sub(now).Seconds()
+		}
+
+		c := string(class)
+		metrics.UpstreamWeightUsed.WithLabelValues(c).Set(float64(used))
+		metrics.UpstreamWeightLimit.WithLabelValues(c).Set(float64(limit))
+		metrics.UpstreamWeightApproaching.WithLabelValues(c).Set(approaching)
+		metrics.BanActive.WithLabelValues(c).Set(banned)
+		metrics.BanRemaining.WithLabelValues(c).Set(remaining)
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Stream kinds that can be subscribed to through Service.Subscribe.
+const (
+	StreamKline  = "kline"
+	StreamDepth  = "depth"
+	StreamTicker = "ticker"
+)
+
+// Update is a single message pushed from an upstream websocket to downstream
+// subscribers. Data is a *Kline, *Depth or *Ticker24hr depending on Stream.
+type Update struct {
+	Class    Class
+	Stream   string
+	Symbol   string
+	Interval string
+	Data     interface{}
+}
+
+type streamKey struct {
+	kind string
+	si   symbolInterval
+}
+
+// updateBroker fans out websocket updates to downstream subscribers.
+type updateBroker struct {
+	mu   sync.RWMutex
+	subs map[streamKey]map[chan<- Update]struct{}
+}
+
+var broker = &updateBroker{subs: make(map[streamKey]map[chan<- Update]struct{})}
+
+var (
+	updateSinksMu sync.Mutex
+	updateSinks   atomic.Pointer[[]func(Update)]
+)
+
+// AddUpdateSink has every websocket update passed to sink, whether or not it
+// has subscribers. Sinks run on the upstream websocket's goroutine and must
+// not block.
+func AddUpdateSink(sink func(Update)) {
+	updateSinksMu.Lock()
+	defer updateSinksMu.Unlock()
+
+	var sinks []func(Update)
+	if current := updateSinks.Load(); current != nil {
+		sinks = append(sinks, *current...)
+	}
+	sinks = append(sinks, sink)
+	updateSinks.Store(&sinks)
+}
+
+func (b *updateBroker) subscribe(key streamKey, ch chan<- Update) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs[key] == nil {
+		b.subs[key] = make(map[chan<- Update]struct{})
+	}
+	b.subs[key][ch] = struct{}{}
+}
+
+func (b *updateBroker) unsubscribe(key streamKey, ch chan<- Update) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subs[key], ch)
+	if len(b.subs[key]) == 0 {
+		delete(b.subs, key)
+	}
+}
+
+func (b *updateBroker) hasSubscribers(kind string, si symbolInterval) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.subs[streamKey{kind: kind, si: si}]) > 0
+}
+
+// publish delivers data to every subscriber of the stream without blocking;
+// subscribers that can't keep up miss updates rather than stall the upstream.
+func (b *updateBroker) publish(kind string, si *symbolInterval, data interface{}) {
+	key := streamKey{kind: kind, si: *si}
+	if sinks := updateSinks.Load(); sinks != nil {
+		u := Update{Class: si.Class, Stream: kind, Symbol: si.Symbol, Interval: si.Interval, Data: data}
+		for _, sink := range *sinks {
+			sink(u)
+		}
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	subs := b.subs[key]
+	if len(subs) == 0 {
+		return
+	}
+
+	u := Update{Class: si.Class, Stream: kind, Symbol: si.Symbol, Interval: si.Interval, Data: data}
+	for ch := range subs {
+		select {
+		case ch <- u:
+		default:
+		}
+	}
+}
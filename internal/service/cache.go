@@ -0,0 +1,201 @@
+package service
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CacheSnapshot holds the cached data of one symbol. Fields are nil when the
+// corresponding stream isn't running or hasn't received data yet.
+type CacheSnapshot struct {
+	Klines []*Kline
+	Depth  *Depth
+	Ticker *Ticker24hr
+}
+
+// CacheSnapshot returns what is cached for symbol (and interval for klines)
+// without starting streams, waiting for initialization or counting as a
+// request against the idle timeout.
+func (s *Service) CacheSnapshot(symbol, interval string) CacheSnapshot {
+	var snap CacheSnapshot
+	if interval != "" {
+		if srv, ok := s.klinesSrv.Load(*NewSymbolInterval(s.class, symbol, interval)); ok {
+			snap.Klines = srv.(*KlinesSrv).snapshot()
+		}
+	}
+	si := *NewSymbolInterval(s.class, symbol, "")
+	if srv, ok := s.depthSrv.Load(si); ok {
+		snap.Depth = srv.(*DepthSrv).snapshot()
+	}
+	if srv, ok := s.tickerSrv.Load(si); ok {
+		snap.Ticker = srv.(*TickerSrv).snapshot()
+	}
+	return snap
+}
+
+func (s *KlinesSrv) snapshot() []*Kline {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+	return s.klinesArr
+}
+
+func (s *DepthSrv) snapshot() *Depth {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+	return s.depth
+}
+
+func (s *TickerSrv) snapshot() *Ticker24hr {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+	if s.ticker24hr == nil {
+		return nil
+	}
+	return s.mergedTicker()
+}
+
+// lastPrice returns the last traded price of the 24hr ticker, which unlike
+// the merged ticker isn't replaced by bookTicker prices.
+func (s *TickerSrv) lastPrice() *LastPrice {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+	if s.ticker24hr == nil {
+		return nil
+	}
+	return &LastPrice{Symbol: s.ticker24hr.Symbol, Price: s.ticker24hr.LastPrice, Time: s.ticker24hr.CloseTime}
+}
+
+// LoadCache seeds the empty caches of symbol with previously exported data,
+// for instance from another instance, so a fresh instance can serve during a
+// ban window when REST initialization is impossible. The data is validated
+// against exchangeInfo; exchangeInfo is used to seed it when none was fetched
+// yet. It returns the kinds of data that were loaded.
+func (s *Service) LoadCache(symbol, interval string, snap CacheSnapshot, exchangeInfo []byte) (loaded []string, err error) {
+	if exchangeInfo != nil && s.exchangeInfoSrv.seed(exchangeInfo) {
+		loaded = append(loaded, "exchangeInfo")
+		log.Infof("%s exchangeInfo seeded from loaded cache", s.class)
+	}
+
+	status, ok, err := s.exchangeInfoSrv.symbolStatus(symbol)
+	switch {
+	case err != nil:
+		return loaded, fmt.Errorf("invalid exchangeInfo: %w", err)
+	case !ok:
+		return loaded, ErrExchangeInfoUnavailable
+	case status == "":
+		return loaded, fmt.Errorf("symbol %s is not listed in %s exchangeInfo", symbol, s.class)
+	}
+	if err := validateSnapshot(symbol, interval, snap); err != nil {
+		return loaded, err
+	}
+
+	if len(snap.Klines) > 0 {
+		if s.klinesSrvFor(NewSymbolInterval(s.class, symbol, interval)).seed(snap.Klines) {
+			loaded = append(loaded, StreamKline)
+		}
+	}
+	si := NewSymbolInterval(s.class, symbol, "")
+	if snap.Depth != nil && s.depthSrvFor(si).seed(snap.Depth) {
+		loaded = append(loaded, StreamDepth)
+	}
+	if snap.Ticker != nil && s.tickerSrvFor(si).seed(snap.Ticker) {
+		loaded = append(loaded, StreamTicker)
+	}
+
+	log.Infof("%s %s cache loaded: %v", s.class, symbol, loaded)
+	return loaded, nil
+}
+
+// ErrExchangeInfoUnavailable is returned by LoadCache when the data can't be
+// validated because no exchangeInfo was fetched or loaded yet.
+var ErrExchangeInfoUnavailable = errors.New("exchangeInfo not available yet, include it to seed it")
+
+func validateSnapshot(symbol, interval string, snap CacheSnapshot) error {
+	if len(snap.Klines) > 0 {
+		if _, ok := INTERVAL_2_DURATION[interval]; !ok {
+			return fmt.Errorf("unsupported interval %q", interval)
+		}
+		if n := KlineRetention(interval); len(snap.Klines) > n {
+			return fmt.Errorf("at most %d klines of %s can be loaded, got %d", n, interval, len(snap.Klines))
+		}
+		for i, k := range snap.Klines {
+			if k.CloseTime <= k.OpenTime {
+				return fmt.Errorf("kline %d closes before it opens", i)
+			}
+			if i > 0 && k.OpenTime <= snap.Klines[i-1].OpenTime {
+				return fmt.Errorf("klines are not in ascending open time order at %d", i)
+			}
+		}
+	}
+
+	if d := snap.Depth; d != nil && len(d.Bids) > 0 && len(d.Asks) > 0 {
+		bid, errBid := strconv.ParseFloat(d.Bids[0].Price, 64)
+		ask, errAsk := strconv.ParseFloat(d.Asks[0].Price, 64)
+		if errBid != nil || errAsk != nil {
+			return errors.New("depth contains invalid prices")
+		}
+		if bid >= ask {
+			return errors.New("depth is crossed, best bid is not below best ask")
+		}
+	}
+
+	if snap.Ticker != nil && snap.Ticker.Symbol != symbol {
+		return fmt.Errorf("ticker is for %s, not %s", snap.Ticker.Symbol, symbol)
+	}
+	return nil
+}
+
+// seed stores klines for initialization during bans and serves them until
+// the stream delivers data. It reports whether the cache was empty.
+func (s *KlinesSrv) seed(klines []*Kline) bool {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	s.seeded = klines
+	if s.klinesArr != nil {
+		return false
+	}
+	s.klinesArr = klines
+	s.initDone()
+	return true
+}
+
+// seedList returns the seeded klines as a new list.
+func (s *KlinesSrv) seedList() *list.List {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+
+	l := list.New()
+	for _, k := range s.seeded {
+		l.PushBack(k)
+	}
+	return l
+}
+
+func (s *DepthSrv) seed(depth *Depth) bool {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	if s.depth != nil {
+		return false
+	}
+	s.depth = depth
+	s.initDone()
+	return true
+}
+
+func (s *TickerSrv) seed(ticker *Ticker24hr) bool {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	if s.ticker24hr != nil {
+		return false
+	}
+	s.ticker24hr = ticker
+	s.initDone()
+	return true
+}
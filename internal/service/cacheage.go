@@ -0,0 +1,103 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// CacheAge returns how long ago the cache of the stream of kind (StreamKline,
+// StreamDepth, StreamTicker or StreamTrade), symbol and, for klines, interval
+// last received data. It is false when the stream isn't running or hasn't
+// received anything yet. Streams aren't started and the lookup doesn't count
+// as a request against the idle timeout.
+func (s *Service) CacheAge(kind, symbol, interval string) (time.Duration, bool) {
+	m := map[string]*sync.Map{StreamKline: &s.klinesSrv, StreamDepth: &s.depthSrv, StreamTicker: &s.tickerSrv, StreamTrade: &s.tradesSrv}[kind]
+	if m == nil {
+		return 0, false
+	}
+	if kind != StreamKline {
+		interval = ""
+	}
+	return cacheAge(m, NewSymbolInterval(s.class, symbol, interval))
+}
+
+// PriceKlinesAge is CacheAge for mark or index price klines.
+func (s *Service) PriceKlinesAge(price, symbol, interval string) (time.Duration, bool) {
+	si := NewSymbolInterval(s.class, symbol, interval)
+	si.Price = price
+	return cacheAge(&s.klinesSrv, si)
+}
+
+func cacheAge(m *sync.Map, si *symbolInterval) (time.Duration, bool) {
+	v, ok := m.Load(*si)
+	if !ok {
+		return 0, false
+	}
+	var last time.Time
+	switch srv := v.(type) {
+	case *KlinesSrv:
+		last = srv.LastMessage()
+	case *DepthSrv:
+		last = srv.LastMessage()
+	case *TickerSrv:
+		last = srv.LastMessage()
+	case *TradesSrv:
+		last = srv.LastMessage()
+	}
+	if last.IsZero() {
+		return 0, false
+	}
+	return time.Since(last), true
+}
+
+// CacheSize is how much one kind of stream holds in memory.
+type CacheSize struct {
+	Streams int `json:"streams"`
+	// Entries are the cached candles, order book levels, tickers or trades
+	Entries int `json:"entries"`
+}
+
+// CacheSizes returns the size of the caches of the class by stream kind.
+func (s *Service) CacheSizes() map[string]CacheSize {
+	sizes := make(map[string]CacheSize)
+	count := func(kind string, entries int) {
+		size := sizes[kind]
+		size.Streams++
+		size.Entries += entries
+		sizes[kind] = size
+	}
+	s.klinesSrv.Range(func(_, v interface{}) bool {
+		srv := v.(*KlinesSrv)
+		srv.rw.RLock()
+		count(StreamKline, len(srv.klinesArr))
+		srv.rw.RUnlock()
+		return true
+	})
+	s.depthSrv.Range(func(_, v interface{}) bool {
+		entries := 0
+		if depth := v.(*DepthSrv).snapshot(); depth != nil {
+			entries = len(depth.Bids) + len(depth.Asks)
+		}
+		count(StreamDepth, entries)
+		return true
+	})
+	s.tickerSrv.Range(func(_, v interface{}) bool {
+		srv := v.(*TickerSrv)
+		srv.rw.RLock()
+		entries := 0
+		if srv.ticker24hr != nil {
+			entries = 1
+		}
+		srv.rw.RUnlock()
+		count(StreamTicker, entries)
+		return true
+	})
+	s.tradesSrv.Range(func(_, v interface{}) bool {
+		srv := v.(*TradesSrv)
+		srv.rw.RLock()
+		count(StreamTrade, len(srv.trades))
+		srv.rw.RUnlock()
+		return true
+	})
+	return sizes
+}
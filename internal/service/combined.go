@@ -0,0 +1,444 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	spot "github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// combinedMaxStreams is Binance's cap on streams per connection.
+	combinedMaxStreams = 1024
+	// combinedFlushInterval is the pause between SUBSCRIBE/UNSUBSCRIBE
+	// requests. Binance allows 5 incoming messages per second per connection,
+	// so one request per interval stays at the limit; the pongs answering its
+	// pings every few minutes are not worth a margin.
+	combinedFlushInterval = 200 * time.Millisecond
+	// combinedReadTimeout closes connections that delivered neither data nor
+	// pings for this long.
+	combinedReadTimeout = 5 * time.Minute
+)
+
+var combinedEnabled atomic.Bool
+
+// SetCombinedStreams switches websocket services between a connection per
+// stream and multiplexing all streams of a class over shared connections.
+func SetCombinedStreams(enabled bool) {
+	combinedEnabled.Store(enabled)
+}
+
+// streamDialWait paces stream (re)connects through the dial limiter. With
+// combined streams only real dials are paced, in combinedStreams.serve.
+func streamDialWait(ctx context.Context) error {
+	if combinedEnabled.Load() {
+		return ctx.Err()
+	}
+	return DialWait(ctx)
+}
+
+func combinedEndpoint(class Class) string {
+	if class == SPOT {
+		if spot.UseTestnet {
+			return spot.BaseCombinedTestnetURL
+		}
+		return spot.BaseCombinedMainURL
+	}
+	if futures.UseTestnet {
+		return futures.BaseCombinedTestnetURL
+	}
+	return futures.BaseCombinedMainURL
+}
+
+// combinedStreams multiplexes upstream streams over /stream connections, at
+// most combinedMaxStreams per connection.
+type combinedStreams struct {
+	mu      sync.Mutex
+	conns   map[Class][]*combinedConn
+	dialing map[Class]chan struct{} // closed when the dial in progress ends
+}
+
+var combined = &combinedStreams{
+	conns:   make(map[Class][]*combinedConn),
+	dialing: make(map[Class]chan struct{}),
+}
+
+type combinedSub struct {
+	handler    func(data []byte)
+	errHandler func(err error)
+	doneC      chan struct{}
+	closeOnce  sync.Once
+}
+
+func (sub *combinedSub) close() {
+	sub.closeOnce.Do(func() { close(sub.doneC) })
+}
+
+type combinedConn struct {
+	class Class
+	ws    *websocket.Conn
+	done  chan struct{}
+
+	mu      sync.Mutex
+	subs    map[string][]*combinedSub
+	pending map[string]bool // stream -> true to subscribe, false to unsubscribe
+	dead    bool
+	nextID  int64
+}
+
+// serve subscribes to the given stream and returns channels with the same
+// semantics as the go-binance Ws*Serve functions: doneC is closed when the
+// subscription ends and a send on stopC ends it.
+func (m *combinedStreams) serve(ctx context.Context, class Class, stream string, handler func(data []byte), errHandler func(err error)) (doneC, stopC chan struct{}, err error) {
+	sub := &combinedSub{handler: handler, errHandler: errHandler, doneC: make(chan struct{})}
+
+	c, err := m.attach(ctx, class, stream, sub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stopC = make(chan struct{}, 1)
+	go func() {
+		select {
+		case <-stopC:
+			c.detach(stream, sub)
+		case <-c.done:
+		}
+		sub.close()
+	}()
+
+	return sub.doneC, stopC, nil
+}
+
+// attach adds sub to a live connection with room left, dialing a new one
+// when there is none. Only one connection per class is dialed at a time, the
+// other callers wait for it instead of opening connections of their own.
+func (m *combinedStreams) attach(ctx context.Context, class Class, stream string, sub *combinedSub) (*combinedConn, error) {
+	for {
+		m.mu.Lock()
+		for _, c := range m.conns[class] {
+			if c.add(stream, sub) {
+				m.mu.Unlock()
+				return c, nil
+			}
+		}
+		if dialing, ok := m.dialing[class]; ok {
+			m.mu.Unlock()
+			select {
+			case <-dialing:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		dialing := make(chan struct{})
+		m.dialing[class] = dialing
+		m.mu.Unlock()
+
+		c, err := m.dial(ctx, class, stream, sub)
+
+		m.mu.Lock()
+		delete(m.dialing, class)
+		if err == nil {
+			m.conns[class] = append(m.conns[class], c)
+			log.Debugf("%s combined websocket connected, %d connections open", class, len(m.conns[class]))
+		}
+		m.mu.Unlock()
+		close(dialing)
+		if err != nil {
+			return nil, err
+		}
+
+		go c.readLoop()
+		go c.flushLoop()
+
+		return c, nil
+	}
+}
+
+// dial opens a connection subscribed to stream for sub.
+func (m *combinedStreams) dial(ctx context.Context, class Class, stream string, sub *combinedSub) (*combinedConn, error) {
+	if err := DialWait(ctx); err != nil {
+		return nil, err
+	}
+	ws, _, err := upstreamDialer().DialContext(ctx, combinedEndpoint(class)+stream, upstreamHeader())
+	if err != nil {
+		return nil, err
+	}
+
+	return &combinedConn{
+		class:   class,
+		ws:      ws,
+		done:    make(chan struct{}),
+		subs:    map[string][]*combinedSub{stream: {sub}},
+		pending: make(map[string]bool),
+	}, nil
+}
+
+func (m *combinedStreams) remove(c *combinedConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conns := m.conns[c.class]
+	for i, cc := range conns {
+		if cc == c {
+			m.conns[c.class] = append(conns[:i:i], conns[i+1:]...)
+			break
+		}
+	}
+}
+
+// add registers sub for stream if the connection is alive and has room.
+func (c *combinedConn) add(stream string, sub *combinedSub) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dead {
+		return false
+	}
+	if _, ok := c.subs[stream]; !ok {
+		if len(c.subs) >= combinedMaxStreams {
+			return false
+		}
+		c.pending[stream] = true
+	}
+	c.subs[stream] = append(c.subs[stream], sub)
+	return true
+}
+
+func (c *combinedConn) detach(stream string, sub *combinedSub) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subs := c.subs[stream]
+	for i, s := range subs {
+		if s == sub {
+			subs = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(subs) > 0 {
+		c.subs[stream] = subs
+		return
+	}
+
+	delete(c.subs, stream)
+	c.pending[stream] = false
+	if len(c.subs) == 0 && !c.dead {
+		// Last stream gone, the read loop cleans up once the socket is closed
+		c.dead = true
+		c.ws.Close()
+	}
+}
+
+// subscribers returns all subscriptions of the connection. Callers hold mu.
+func (c *combinedConn) subscribers() []*combinedSub {
+	var subs []*combinedSub
+	for _, list := range c.subs {
+		subs = append(subs, list...)
+	}
+	return subs
+}
+
+// flushLoop sends the queued subscription changes, one request per
+// combinedFlushInterval, taking turns between SUBSCRIBE and UNSUBSCRIBE while
+// both are queued.
+func (c *combinedConn) flushLoop() {
+	t := time.NewTicker(combinedFlushInterval)
+	defer t.Stop()
+
+	subscribe := true
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+		}
+
+		c.mu.Lock()
+		var add, remove []string
+		for stream, sub := range c.pending {
+			if sub {
+				add = append(add, stream)
+			} else {
+				remove = append(remove, stream)
+			}
+		}
+		if subscribe && len(add) == 0 || !subscribe && len(remove) == 0 {
+			subscribe = !subscribe // nothing queued for this turn
+		}
+		method, params := "SUBSCRIBE", add
+		if !subscribe {
+			method, params = "UNSUBSCRIBE", remove
+		}
+		for _, stream := range params {
+			delete(c.pending, stream)
+		}
+		c.mu.Unlock()
+
+		if len(params) == 0 {
+			continue
+		}
+		subscribe = !subscribe
+		c.nextID++
+		req := map[string]interface{}{"method": method, "params": params, "id": c.nextID}
+		if err := c.ws.WriteJSON(req); err != nil {
+			log.Debugf("%s combined websocket %s failed: %s", c.class, method, err)
+			c.ws.Close()
+			return
+		}
+	}
+}
+
+func (c *combinedConn) readLoop() {
+	defer func() {
+		c.mu.Lock()
+		c.dead = true
+		subs := c.subscribers()
+		c.mu.Unlock()
+
+		combined.remove(c)
+		close(c.done)
+		c.ws.Close()
+
+		for _, sub := range subs {
+			sub.close()
+		}
+	}()
+
+	c.ws.SetReadDeadline(time.Now().Add(combinedReadTimeout))
+	c.ws.SetPingHandler(func(data string) error {
+		c.ws.SetReadDeadline(time.Now().Add(combinedReadTimeout))
+		err := c.ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(10*time.Second))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+
+	for {
+		_, message, err := c.ws.ReadMessage()
+		if err != nil {
+			c.mu.Lock()
+			subs := c.subscribers()
+			c.mu.Unlock()
+			for _, sub := range subs {
+				sub.errHandler(err)
+			}
+			return
+		}
+		c.ws.SetReadDeadline(time.Now().Add(combinedReadTimeout))
+
+		var msg struct {
+			Stream string          `json:"stream"`
+			Data   json.RawMessage `json:"data"`
+			ID     int64           `json:"id"`
+			Error  *struct {
+				Code int    `json:"code"`
+				Msg  string `json:"msg"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Debugf("%s combined websocket sent invalid JSON: %s", c.class, err)
+			continue
+		}
+		if msg.Error != nil {
+			log.Warnf("%s combined websocket request %d failed: %d %s", c.class, msg.ID, msg.Error.Code, msg.Error.Msg)
+			continue
+		}
+		if msg.Stream == "" {
+			continue // subscription acknowledgement
+		}
+
+		c.mu.Lock()
+		subs := c.subs[msg.Stream]
+		c.mu.Unlock()
+		for _, sub := range subs {
+			sub.handler(msg.Data)
+		}
+	}
+}
+
+// Stream names and event decoding for the websocket services.
+
+func klineStreamName(si *symbolInterval) string {
+	return fmt.Sprintf("%s@kline_%s", strings.ToLower(si.Symbol), si.Interval)
+}
+
+func depthStreamName(si *symbolInterval) string {
+	return fmt.Sprintf("%s@depth20@100ms", strings.ToLower(si.Symbol))
+}
+
+// decodePriceLevels converts [["price","qty"],...] into Bid/Ask values.
+func decodePriceLevels(levels [][2]string) []spot.Bid {
+	out := make([]spot.Bid, len(levels))
+	for i, l := range levels {
+		out[i] = spot.Bid{Price: l[0], Quantity: l[1]}
+	}
+	return out
+}
+
+func decodeSpotPartialDepth(symbol string, data []byte) (*spot.WsPartialDepthEvent, error) {
+	var raw struct {
+		LastUpdateID int64       `json:"lastUpdateId"`
+		Bids         [][2]string `json:"bids"`
+		Asks         [][2]string `json:"asks"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return &spot.WsPartialDepthEvent{
+		Symbol:       symbol,
+		LastUpdateID: raw.LastUpdateID,
+		Bids:         decodePriceLevels(raw.Bids),
+		Asks:         decodePriceLevels(raw.Asks),
+	}, nil
+}
+
+func decodeFuturesDepth(data []byte) (*futures.WsDepthEvent, error) {
+	var raw struct {
+		Event            string      `json:"e"`
+		Time             int64       `json:"E"`
+		TransactionTime  int64       `json:"T"`
+		Symbol           string      `json:"s"`
+		FirstUpdateID    int64       `json:"U"`
+		LastUpdateID     int64       `json:"u"`
+		PrevLastUpdateID int64       `json:"pu"`
+		Bids             [][2]string `json:"b"`
+		Asks             [][2]string `json:"a"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return &futures.WsDepthEvent{
+		Event:            raw.Event,
+		Time:             raw.Time,
+		TransactionTime:  raw.TransactionTime,
+		Symbol:           raw.Symbol,
+		FirstUpdateID:    raw.FirstUpdateID,
+		LastUpdateID:     raw.LastUpdateID,
+		PrevLastUpdateID: raw.PrevLastUpdateID,
+		Bids:             decodePriceLevels(raw.Bids),
+		Asks:             decodePriceLevels(raw.Asks),
+	}, nil
+}
+
+// serveJSON subscribes to a stream whose events decode with encoding/json.
+func serveJSON[E any](ctx context.Context, class Class, stream string, handler func(*E), errHandler func(error)) (doneC, stopC chan struct{}, err error) {
+	return combined.serve(ctx, class, stream, func(data []byte) {
+		event := new(E)
+		if err := json.Unmarshal(data, event); err != nil {
+			errHandler(err)
+			return
+		}
+		handler(event)
+	}, errHandler)
+}
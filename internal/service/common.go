@@ -1,6 +1,11 @@
 package service
 
-import "time"
+import (
+	"binance-proxy/internal/metrics"
+	"context"
+	"sync/atomic"
+	"time"
+)
 
 var INTERVAL_2_DURATION = map[string]time.Duration{
 	"1m":  1 * time.Minute,
@@ -24,6 +29,9 @@ type symbolInterval struct {
 	Class    Class
 	Symbol   string
 	Interval string
+	// Price is the price of mark or index price klines, empty for all other
+	// streams
+	Price string
 }
 type Class string
 
@@ -33,3 +41,107 @@ var FUTURES Class = "FUTURES"
 func NewSymbolInterval(class Class, symbol, interval string) *symbolInterval {
 	return &symbolInterval{Class: class, Symbol: symbol, Interval: interval}
 }
+
+// streamClock tracks when a websocket service started and last received a
+// message, for detecting streams that went silent, and takes requests to
+// reconnect its websocket.
+type streamClock struct {
+	started      time.Time
+	resubscribed time.Time    // when the service replaced a stale one, zero otherwise
+	lastMessage  atomic.Int64 // unix nanoseconds
+	messages     atomic.Int64
+	reconnects   atomic.Int64
+	resubscribeC chan struct{}
+}
+
+func newStreamClock() streamClock {
+	return streamClock{started: time.Now(), resubscribeC: make(chan struct{}, 1)}
+}
+
+// Resubscribe makes the service drop its websocket and connect again, the
+// same way it reconnects after a disconnect.
+func (c *streamClock) Resubscribe() {
+	select {
+	case c.resubscribeC <- struct{}{}:
+	default:
+	}
+}
+
+// inherit carries the silence of the stale service old over to the service
+// replacing it at now, before it is started.
+func (c *streamClock) inherit(old *streamClock, now time.Time) {
+	c.started = old.started
+	c.resubscribed = now
+	c.lastMessage.Store(old.lastMessage.Load())
+}
+
+// clock returns the clock of the service embedding it.
+func (c *streamClock) clock() *streamClock {
+	return c
+}
+
+func (c *streamClock) touch() {
+	c.lastMessage.Store(time.Now().UnixNano())
+	c.messages.Add(1)
+}
+
+// LastMessage returns when the last websocket message arrived, zero if none did.
+func (c *streamClock) LastMessage() time.Time {
+	if n := c.lastMessage.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// silentSince returns the time of the last message, or the start if none
+// arrived yet.
+func (c *streamClock) silentSince() time.Time {
+	if last := c.LastMessage(); !last.IsZero() {
+		return last
+	}
+	return c.started
+}
+
+// stale reports whether the stream has been silent for longer than after.
+func (c *streamClock) stale(now time.Time, after time.Duration) bool {
+	return now.Sub(c.silentSince()) > after
+}
+
+// initWait bounds how long a request waits for a stream to initialize, 0
+// waits as long as it takes.
+var initWait atomic.Int64
+
+// SetInitWait makes requests give up waiting for a stream to initialize
+// after d, so they are forwarded instead of hanging while REST initialization
+// stalls, for instance during a ban. 0 waits as long as it takes, or until
+// the request ends.
+func SetInitWait(d time.Duration) {
+	initWait.Store(int64(d))
+}
+
+// awaitInit waits until initCtx is done and reports whether it is, giving up
+// after the configured wait or when ctx, the context of the request waiting,
+// ends first.
+func awaitInit(ctx, initCtx context.Context, class Class, kind string) bool {
+	select {
+	case <-initCtx.Done():
+		return true
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if wait := time.Duration(initWait.Load()); wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-initCtx.Done():
+		return true
+	case <-ctx.Done():
+		return false
+	case <-timeout:
+	}
+	metrics.InitTimeouts.WithLabelValues(string(class), kind).Inc()
+	return false
+}
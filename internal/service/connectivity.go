@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	spot "github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConnectivityResult is the outcome of the last check of an upstream target.
+type ConnectivityResult struct {
+	Target    string  `json:"target"`
+	Reachable bool    `json:"reachable"`
+	RTTMs     float64 `json:"rtt_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	CheckedAt string  `json:"checked_at,omitempty"`
+}
+
+// UpstreamCheck is the connectivity of a market to Binance's REST API and
+// websocket streams.
+type UpstreamCheck struct {
+	REST      *ConnectivityResult `json:"rest,omitempty"`
+	Websocket *ConnectivityResult `json:"websocket,omitempty"`
+}
+
+var (
+	connectivityMu sync.RWMutex
+	connectivity   = map[Class]*UpstreamCheck{}
+)
+
+// StartConnectivityChecks pings the REST API of each class and opens and
+// closes a websocket to its stream host every interval until ctx ends, for
+// the health report. REST pings are skipped during a ban so they don't
+// extend it.
+func StartConnectivityChecks(ctx context.Context, classes []Class, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			for _, class := range classes {
+				checkConnectivity(ctx, class)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// UpstreamConnectivity returns the last connectivity check of class, nil
+// before the first one.
+func UpstreamConnectivity(class Class) *UpstreamCheck {
+	connectivityMu.RLock()
+	defer connectivityMu.RUnlock()
+	return connectivity[class]
+}
+
+func checkConnectivity(ctx context.Context, class Class) {
+	host, path, wsURL := spotPrimaryHost, "/api/v3/ping", spot.BaseWsMainURL
+	if class == FUTURES {
+		host, path, wsURL = futuresPrimaryHost, "/fapi/v1/ping", futures.BaseWsMainUrl
+	}
+
+	connectivityMu.RLock()
+	check := UpstreamCheck{}
+	if last := connectivity[class]; last != nil {
+		check = *last
+	}
+	connectivityMu.RUnlock()
+	lastREST, lastWebsocket := check.REST, check.Websocket
+
+	if !GetBanDetector().IsBanned(class) && initRateWait(ctx, class, path, nil) == nil {
+		rest := checkTarget(ctx, "https://"+host+path, func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+path, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := getHTTPClient().Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("ping answered %s", resp.Status)
+			}
+			return nil
+		})
+		check.REST = &rest
+	}
+	ws := checkTarget(ctx, wsURL, func(ctx context.Context) error {
+		conn, _, err := upstreamDialer().DialContext(ctx, wsURL, nil)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+	check.Websocket = &ws
+	if ctx.Err() != nil {
+		return
+	}
+
+	connectivityMu.Lock()
+	connectivity[class] = &check
+	connectivityMu.Unlock()
+	logReachability(class, "REST API", lastREST, check.REST)
+	logReachability(class, "websocket host", lastWebsocket, check.Websocket)
+}
+
+// logReachability logs when a target became unreachable or reachable again.
+func logReachability(class Class, kind string, last, now *ConnectivityResult) {
+	if now == nil || (last != nil && last.Reachable == now.Reachable) {
+		return
+	}
+	if !now.Reachable {
+		log.Warnf("%s upstream %s %s is unreachable (error: %s)", class, kind, now.Target, now.Error)
+	} else if last != nil {
+		log.Infof("%s upstream %s %s is reachable again", class, kind, now.Target)
+	}
+}
+
+// checkTarget runs check within endpointProbeTimeout and measures it.
+func checkTarget(ctx context.Context, target string, check func(context.Context) error) ConnectivityResult {
+	ctx, cancel := context.WithTimeout(ctx, endpointProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	err := check(ctx)
+	r := ConnectivityResult{Target: target, Reachable: err == nil, CheckedAt: time.Now().Format(time.RFC3339)}
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.RTTMs = float64(time.Since(start).Microseconds()) / 1000
+	}
+	return r
+}
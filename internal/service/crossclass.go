@@ -0,0 +1,138 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// services holds the running service of each class, for streams that are
+// looked at or shared across classes.
+var services sync.Map // map[Class]*Service
+
+var crossClassEnabled atomic.Bool
+
+// SetCrossClassStreams allows downstream websockets of one class to subscribe
+// to the streams of the other class, served from the same upstream websocket
+// the other class uses.
+func SetCrossClassStreams(enabled bool) {
+	crossClassEnabled.Store(enabled)
+}
+
+// ParseClass returns the class named like "spot" or "futures".
+func ParseClass(name string) (Class, error) {
+	switch class := Class(strings.ToUpper(name)); class {
+	case SPOT, FUTURES:
+		return class, nil
+	}
+	return "", fmt.Errorf("unknown class %q", name)
+}
+
+// SubscribeClass is Subscribe for a stream of the given class. Streams of
+// another class need SetCrossClassStreams; they are never substituted for
+// this class's own, spot and futures prices differ.
+func (s *Service) SubscribeClass(class Class, kind, symbol, interval string, ch chan<- Update) (unsubscribe func(), err error) {
+	if class == s.class {
+		return s.Subscribe(kind, symbol, interval, ch)
+	}
+	if !crossClassEnabled.Load() {
+		return nil, fmt.Errorf("%s streams are not shared with %s, see --cross-class-streams", class, s.class)
+	}
+	other, ok := services.Load(class)
+	if !ok {
+		return nil, fmt.Errorf("%s is not proxied", class)
+	}
+	return other.(*Service).Subscribe(kind, symbol, interval, ch)
+}
+
+// DuplicateStream is an upstream stream for the same symbol running in more
+// than one class.
+type DuplicateStream struct {
+	Kind     string   `json:"kind"`
+	Symbol   string   `json:"symbol"`
+	Interval string   `json:"interval,omitempty"`
+	Classes  []string `json:"classes"`
+}
+
+type duplicateKey struct {
+	kind, symbol, interval string
+}
+
+// DuplicateStreams lists the streams of the same kind, symbol and interval
+// that run for both spot and futures.
+func DuplicateStreams() []DuplicateStream {
+	classes := make(map[duplicateKey][]string)
+	services.Range(func(_, v interface{}) bool {
+		s := v.(*Service)
+		for kind, m := range map[string]*sync.Map{StreamKline: &s.klinesSrv, StreamDepth: &s.depthSrv, StreamTicker: &s.tickerSrv, StreamTrade: &s.tradesSrv} {
+			m.Range(func(k, _ interface{}) bool {
+				si := k.(symbolInterval)
+				if si.Price != "" { // futures only
+					return true
+				}
+				key := duplicateKey{kind, si.Symbol, si.Interval}
+				classes[key] = append(classes[key], string(si.Class))
+				return true
+			})
+		}
+		return true
+	})
+
+	var duplicates []DuplicateStream
+	for key, c := range classes {
+		if len(c) < 2 {
+			continue
+		}
+		sort.Strings(c)
+		duplicates = append(duplicates, DuplicateStream{Kind: key.kind, Symbol: key.symbol, Interval: key.interval, Classes: c})
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		a, b := duplicates[i], duplicates[j]
+		if a.Symbol != b.Symbol {
+			return a.Symbol < b.Symbol
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Interval < b.Interval
+	})
+	return duplicates
+}
+
+// reportDuplicate logs when a stream that just started also runs in another
+// class.
+func reportDuplicate(kind string, si *symbolInterval) {
+	if si.Price != "" {
+		return
+	}
+	services.Range(func(k, v interface{}) bool {
+		class := k.(Class)
+		if class == si.Class {
+			return true
+		}
+		other := NewSymbolInterval(class, si.Symbol, si.Interval)
+		var m *sync.Map
+		switch kind {
+		case StreamKline:
+			m = &v.(*Service).klinesSrv
+		case StreamDepth:
+			m = &v.(*Service).depthSrv
+		case StreamTicker:
+			m = &v.(*Service).tickerSrv
+		case StreamTrade:
+			m = &v.(*Service).tradesSrv
+		}
+		if _, ok := m.Load(*other); ok {
+			name := si.Symbol
+			if si.Interval != "" {
+				name += "@" + si.Interval
+			}
+			log.Infof("%s %s %s stream also runs for %s, see duplicate_streams in /status", si.Class, name, kind, class)
+		}
+		return true
+	})
+}
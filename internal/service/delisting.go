@@ -0,0 +1,139 @@
+package service
+
+import (
+	"binance-proxy/internal/metrics"
+	"binance-proxy/internal/notify"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// haltedStatus reports whether a symbol with the exchangeInfo status no
+// longer has live streams: spot symbols in BREAK and futures contracts that
+// were closed.
+func haltedStatus(status string) bool {
+	return status == "BREAK" || status == "CLOSE"
+}
+
+// symbolStatuses maps the symbols of an exchangeInfo to their status.
+func symbolStatuses(data []byte) (map[string]string, error) {
+	var info struct {
+		Symbols []struct {
+			Symbol string `json:"symbol"`
+			Status string `json:"status"`
+		} `json:"symbols"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]string, len(info.Symbols))
+	for _, sym := range info.Symbols {
+		statuses[sym.Symbol] = sym.Status
+	}
+	return statuses, nil
+}
+
+// updateListings diffs the symbol statuses of a refreshed exchangeInfo
+// against the previous refresh. It returns the symbols that started trading
+// and the symbols that disappeared or were halted since. Symbols stay
+// delisted until they are listed again with another status. Callers hold rw.
+func (s *ExchangeInfoSrv) updateListings(data []byte) (listed, delisted []string) {
+	statuses, err := symbolStatuses(data)
+	if err != nil {
+		log.Warnf("%s exchangeInfo symbols can't be compared (error: %s).", s.si.Class, err)
+		return nil, nil
+	}
+
+	halted := make(map[string]bool)
+	for symbol, status := range statuses {
+		if haltedStatus(status) {
+			halted[symbol] = true
+		}
+	}
+	for symbol := range s.delisted {
+		if _, ok := statuses[symbol]; !ok {
+			halted[symbol] = true
+		}
+	}
+	// The first refresh has nothing to compare with, symbols halted before
+	// are only kept from being streamed
+	if s.statuses != nil {
+		for symbol := range s.statuses {
+			if _, ok := statuses[symbol]; !ok {
+				halted[symbol] = true
+			}
+			if halted[symbol] && !s.delisted[symbol] {
+				delisted = append(delisted, symbol)
+			}
+		}
+		for symbol, status := range statuses {
+			if status == "TRADING" && s.statuses[symbol] != "TRADING" {
+				listed = append(listed, symbol)
+			}
+		}
+	}
+	sort.Strings(listed)
+	sort.Strings(delisted)
+
+	s.statuses = statuses
+	s.delisted = halted
+	return listed, delisted
+}
+
+// isDelisted reports whether symbol was removed from exchangeInfo or halted.
+func (s *ExchangeInfoSrv) isDelisted(symbol string) bool {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+	return s.delisted[symbol]
+}
+
+// stopDelisted is called after every exchangeInfo refresh with the symbols
+// delisted by it. It stops the websocket services of all delisted symbols,
+// dropping their caches, so they aren't reconnected forever.
+func (s *Service) stopDelisted(newly []string) {
+	if len(newly) > 0 {
+		log.Warnf("%s symbols delisted or halted: %s", s.class, strings.Join(newly, ", "))
+	}
+
+	stopped := 0
+	stop := func(m, lastGet *sync.Map, kind string) {
+		m.Range(func(k, v interface{}) bool {
+			si := k.(symbolInterval)
+			if !s.exchangeInfoSrv.isDelisted(si.Symbol) {
+				return true
+			}
+			if _, loaded := m.LoadAndDelete(si); !loaded {
+				return true
+			}
+			lastGet.Delete(si)
+			v.(interface{ Stop() }).Stop()
+			streamStopped(si.Class, kind)
+			metrics.StreamsDelistedClosed.WithLabelValues(string(si.Class), kind).Inc()
+			log.Infof("%s %s %s websocket closed, symbol is delisted", si.Class, si.Symbol, kind)
+			stopped++
+			return true
+		})
+	}
+	stop(&s.klinesSrv, &s.lastGetKlines, StreamKline)
+	stop(&s.depthSrv, &s.lastGetDepth, StreamDepth)
+	stop(&s.tickerSrv, &s.lastGetTicker, StreamTicker)
+	stop(&s.tradesSrv, &s.lastGetTrades, StreamTrade)
+
+	if len(newly) == 0 {
+		return
+	}
+	list := newly
+	if len(list) > 10 {
+		list = append(list[:10:10], fmt.Sprintf("and %d more", len(newly)-10))
+	}
+	message := fmt.Sprintf("%s delisted or halted, %d streams stopped", strings.Join(list, ", "), stopped)
+	incidents.mu.Lock()
+	incidents.record(Event{Time: time.Now(), Class: s.class, Type: "delisting", Message: message})
+	incidents.mu.Unlock()
+	notify.Send(notify.KindDelisting, string(s.class), "%s", message)
+}
@@ -1,10 +1,11 @@
 package service
 
 import (
-	"binance-proxy/internal/tool"
+	"binance-proxy/internal/metrics"
 	"context"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -15,15 +16,23 @@ import (
 
 type DepthSrv struct {
 	rw sync.RWMutex
+	streamClock
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	initCtx  context.Context
 	initDone context.CancelFunc
+	stopped  chan struct{}
 
 	si    *symbolInterval
+	tier  func() Tier
 	depth *Depth
+	// chained is set once the book came from the websocket, whose following
+	// updates must continue its update IDs
+	chained bool
+	// resync has the next connection seed the book with a REST snapshot
+	resync atomic.Bool
 }
 
 type Depth struct {
@@ -34,8 +43,8 @@ type Depth struct {
 	Asks         []futures.Ask
 }
 
-func NewDepthSrv(ctx context.Context, si *symbolInterval) *DepthSrv {
-	s := &DepthSrv{si: si}
+func NewDepthSrv(ctx context.Context, si *symbolInterval, tier func() Tier) *DepthSrv {
+	s := &DepthSrv{streamClock: newStreamClock(), si: si, tier: tier, stopped: make(chan struct{})}
 	s.ctx, s.cancel = context.WithCancel(ctx)
 	s.initCtx, s.initDone = context.WithCancel(context.Background())
 
@@ -44,12 +53,33 @@ func NewDepthSrv(ctx context.Context, si *symbolInterval) *DepthSrv {
 
 func (s *DepthSrv) Start() {
 	go func() {
-		for d := tool.NewDelayIterator(); ; d.Delay() {
+		defer close(s.stopped)
+
+		reconnect := false
+		for d := retryIterator(ScheduleReconnect); ; d.Delay() {
+			if reconnect {
+				metrics.StreamReconnects.WithLabelValues(string(s.si.Class), StreamDepth).Inc()
+				s.reconnects.Add(1)
+				incidents.countReconnect(s.si.Class)
+				if !awaitReconnect(s.ctx, s.tier()) {
+					return
+				}
+			}
+			reconnect = true
+
 			s.rw.Lock()
 			s.depth = nil
+			s.chained = false
 			s.rw.Unlock()
 
+			if streamDialWait(s.ctx) != nil {
+				return
+			}
+			if s.resync.Swap(false) && !Polling(s.si.Class) {
+				s.seedSnapshot()
+			}
 			doneC, stopC, err := s.connect()
+			dialResult(s.si.Class, err)
 			if err != nil {
 				log.Errorf("%s %s depth websocket connection error: %s.", s.si.Class, s.si.Symbol, err)
 				continue
@@ -61,7 +91,13 @@ func (s *DepthSrv) Start() {
 			select {
 			case <-s.ctx.Done():
 				stopC <- struct{}{}
+				<-doneC
 				return
+			case <-s.resubscribeC:
+				log.Infof("%s %s depth websocket resubscribing on request.", s.si.Class, s.si.Symbol)
+				stopC <- struct{}{}
+				<-doneC
+				continue
 			case <-doneC:
 			}
 
@@ -74,7 +110,35 @@ func (s *DepthSrv) Stop() {
 	s.cancel()
 }
 
+// Stopped is closed once the websocket loop has exited after Stop.
+func (s *DepthSrv) Stopped() <-chan struct{} {
+	return s.stopped
+}
+
 func (s *DepthSrv) connect() (doneC, stopC chan struct{}, err error) {
+	if Polling(s.si.Class) {
+		return pollServe(s.ctx, s.pollDepth, s.errHandler)
+	}
+	if combinedEnabled.Load() {
+		return combined.serve(s.ctx, s.si.Class, depthStreamName(s.si), func(data []byte) {
+			if s.si.Class == SPOT {
+				event, err := decodeSpotPartialDepth(s.si.Symbol, data)
+				if err != nil {
+					s.errHandler(err)
+					return
+				}
+				s.wsHandler(event)
+			} else {
+				event, err := decodeFuturesDepth(data)
+				if err != nil {
+					s.errHandler(err)
+					return
+				}
+				s.wsHandlerFutures(event)
+			}
+		}, s.errHandler)
+	}
+
 	if s.si.Class == SPOT {
 		return spot.WsPartialDepthServe100Ms(s.si.Symbol, "20", s.wsHandler, s.errHandler)
 	} else {
@@ -82,8 +146,10 @@ func (s *DepthSrv) connect() (doneC, stopC chan struct{}, err error) {
 	}
 }
 
-func (s *DepthSrv) GetDepth() *Depth {
-	<-s.initCtx.Done()
+func (s *DepthSrv) GetDepth(ctx context.Context) *Depth {
+	if !awaitInit(ctx, s.initCtx, s.si.Class, StreamDepth) {
+		return nil
+	}
 	s.rw.RLock()
 	defer s.rw.RUnlock()
 
@@ -91,43 +157,51 @@ func (s *DepthSrv) GetDepth() *Depth {
 }
 
 func (s *DepthSrv) wsHandlerFutures(event *futures.WsDepthEvent) {
-	s.rw.Lock()
-	defer s.rw.Unlock()
-
-	if s.depth == nil {
-		defer s.initDone()
-	}
-
-	s.depth = &Depth{
+	s.update(&Depth{
 		LastUpdateID: event.LastUpdateID,
 		Time:         event.Time,
 		TradeTime:    event.TransactionTime,
 		Bids:         event.Bids,
 		Asks:         event.Asks,
-	}
-	log.Tracef("%s %s depth websocket message received", s.si.Class, s.si.Symbol)
+	}, event.PrevLastUpdateID, false)
 }
 
 func (s *DepthSrv) wsHandler(event *spot.WsPartialDepthEvent) {
+	s.update(&Depth{
+		LastUpdateID: event.LastUpdateID,
+		Time:         time.Now().UnixNano() / 1e6,
+		TradeTime:    time.Now().UnixNano() / 1e6,
+		Bids:         event.Bids,
+		Asks:         event.Asks,
+	}, 0, false)
+}
+
+// update replaces the book with the one of a websocket event, or of a REST
+// snapshot. prev is the update ID the event follows, only known for futures.
+func (s *DepthSrv) update(depth *Depth, prev int64, snapshot bool) {
+	s.touch()
 	s.rw.Lock()
 	defer s.rw.Unlock()
 
+	if s.resync.Load() {
+		return
+	}
 	if s.depth == nil {
 		defer s.initDone()
+	} else if !snapshot && !s.inSequence(depth, prev) {
+		return
 	}
+	s.chained = !snapshot
 
-	s.depth = &Depth{
-		LastUpdateID: event.LastUpdateID,
-		Time:         time.Now().UnixNano() / 1e6,
-		TradeTime:    time.Now().UnixNano() / 1e6,
-		Bids:         event.Bids,
-		Asks:         event.Asks,
+	s.depth = depth
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.Tracef("%s %s depth websocket message received", s.si.Class, s.si.Symbol)
 	}
-	log.Tracef("%s %s depth websocket message received", s.si.Class, s.si.Symbol)
-
+	broker.publish(StreamDepth, s.si, s.depth)
 }
 
 func (s *DepthSrv) errHandler(err error) {
+	countStreamError(StreamDepth, s.si)
 	msg := err.Error()
 	switch {
 	case strings.Contains(msg, "context canceled"):
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"binance-proxy/internal/metrics"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// inSequence reports whether the websocket update depth continues the book,
+// following Binance's rules for a local order book: a futures update must
+// follow the update ID of the previous one, a spot update must not go back.
+// Updates already contained in a REST snapshot the book was seeded with are
+// dropped. A break of the sequence drops the book and has the stream resynced
+// instead of serving it. Callers hold rw.
+func (s *DepthSrv) inSequence(depth *Depth, prev int64) bool {
+	if !s.chained {
+		return depth.LastUpdateID >= s.depth.LastUpdateID
+	}
+	if prev != 0 && prev == s.depth.LastUpdateID || prev == 0 && depth.LastUpdateID >= s.depth.LastUpdateID {
+		return true
+	}
+
+	metrics.DepthResyncs.WithLabelValues(string(s.si.Class)).Inc()
+	if prev != 0 {
+		log.Warnf("%s %s depth update %d follows %d instead of %d, dropping the book and resyncing.", s.si.Class, s.si.Symbol, depth.LastUpdateID, prev, s.depth.LastUpdateID)
+	} else {
+		log.Warnf("%s %s depth update %d is older than %d, dropping the book and resyncing.", s.si.Class, s.si.Symbol, depth.LastUpdateID, s.depth.LastUpdateID)
+	}
+	s.depth = nil
+	s.resync.Store(true)
+	s.Resubscribe()
+	return false
+}
+
+// seedSnapshot seeds the book of a resync with a REST snapshot before the
+// websocket connects again, so requests are served from the cache meanwhile.
+// Without a snapshot, e.g. during a ban, the first websocket update seeds it.
+func (s *DepthSrv) seedSnapshot() {
+	if err := s.pollDepth(s.ctx); err != nil {
+		log.Warnf("%s %s depth snapshot for the resync failed (error: %s), waiting for the websocket.", s.si.Class, s.si.Symbol, err)
+		return
+	}
+	log.Infof("%s %s depth book resynced from a REST snapshot.", s.si.Class, s.si.Symbol)
+}
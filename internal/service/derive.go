@@ -0,0 +1,168 @@
+package service
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// deriveSource is the interval derived klines are built from.
+const deriveSource = "1m"
+
+// derivedIntervals holds the kline intervals built from the 1m stream.
+var derivedIntervals atomic.Pointer[map[string]bool]
+
+// SetDerivedIntervals builds the klines of the given intervals from the 1m
+// stream of their symbol instead of opening a websocket per interval. Their
+// history is still initialized through REST. An interval must fit into the
+// 1m kline retention, so its open candle can be rebuilt from cached minutes.
+func SetDerivedIntervals(intervals []string) error {
+	set := make(map[string]bool)
+	for _, interval := range intervals {
+		interval = strings.TrimSpace(interval)
+		if interval == "" {
+			continue
+		}
+		d, ok := INTERVAL_2_DURATION[interval]
+		if !ok || interval == deriveSource || interval == "1w" || interval == "1M" {
+			return fmt.Errorf("invalid derived interval %q, only minute, hour and day intervals above 1m can be derived", interval)
+		}
+		if minutes := int(d / time.Minute); minutes > KlineRetention(deriveSource) {
+			return fmt.Errorf("derived interval %s needs a 1m kline retention of at least %d", interval, minutes)
+		}
+		set[interval] = true
+	}
+	derivedIntervals.Store(&set)
+	return nil
+}
+
+// isDerived reports whether the klines of interval are built from 1m klines.
+func isDerived(interval string) bool {
+	set := derivedIntervals.Load()
+	return set != nil && (*set)[interval]
+}
+
+// deriveServe feeds the service with candles aggregated from the 1m stream
+// of its symbol, which is started if needed and kept open while this one
+// runs. It follows the protocol of the websocket serve functions.
+func (s *KlinesSrv) deriveServe() (doneC, stopC chan struct{}, err error) {
+	v, ok := services.Load(s.si.Class)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s service not running", s.si.Class)
+	}
+	svc := v.(*Service)
+	source := NewSymbolInterval(s.si.Class, s.si.Symbol, deriveSource)
+	key := streamKey{kind: StreamKline, si: *source}
+	updates := make(chan Update, 16)
+	broker.subscribe(key, updates)
+	svc.klinesSrvFor(source)
+
+	doneC = make(chan struct{})
+	stopC = make(chan struct{}, 1)
+	go func() {
+		defer close(doneC)
+		defer broker.unsubscribe(key, updates)
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-stopC:
+				return
+			case u := <-updates:
+				src, ok := svc.klinesSrv.Load(*source)
+				if !ok {
+					continue
+				}
+				// The 1m cache is published before the update is, no need
+				// to wait for its initialization
+				src.(*KlinesSrv).rw.RLock()
+				minutes := src.(*KlinesSrv).klinesArr
+				src.(*KlinesSrv).rw.RUnlock()
+				if k := deriveKline(minutes, u.Data.(*Kline), s.si.Interval); k != nil {
+					s.wsHandler(k)
+				}
+			}
+		}
+	}()
+	return doneC, stopC, nil
+}
+
+// deriveKline builds the candle of interval that contains the 1m candle
+// update from the cached 1m candles, nil if they don't reach back to its
+// open. The candle is final once its last minute is.
+func deriveKline(minutes []*Kline, update *Kline, interval string) *Kline {
+	step := INTERVAL_2_DURATION[interval].Milliseconds()
+	open := update.OpenTime - update.OpenTime%step
+	closeTime := open + step - 1
+
+	first := sort.Search(len(minutes), func(i int) bool { return minutes[i].OpenTime >= open })
+	if first == len(minutes) || minutes[first].OpenTime != open {
+		return nil
+	}
+	last := first
+	for last+1 < len(minutes) && minutes[last+1].OpenTime <= closeTime {
+		last++
+	}
+	window := minutes[first : last+1]
+
+	k := &Kline{
+		OpenTime:  open,
+		Open:      window[0].Open,
+		High:      window[0].High,
+		Low:       window[0].Low,
+		Close:     window[len(window)-1].Close,
+		CloseTime: closeTime,
+		Final:     update.Final && update.CloseTime == closeTime,
+	}
+	var volume, quoteVolume, takerBase, takerQuote decimalSum
+	for _, m := range window {
+		if decimalLess(k.High, m.High) {
+			k.High = m.High
+		}
+		if decimalLess(m.Low, k.Low) {
+			k.Low = m.Low
+		}
+		volume.add(m.Volume)
+		quoteVolume.add(m.QuoteAssetVolume)
+		takerBase.add(m.TakerBuyBaseAssetVolume)
+		takerQuote.add(m.TakerBuyQuoteAssetVolume)
+		k.TradeNum += m.TradeNum
+	}
+	k.Volume = volume.String()
+	k.QuoteAssetVolume = quoteVolume.String()
+	k.TakerBuyBaseAssetVolume = takerBase.String()
+	k.TakerBuyQuoteAssetVolume = takerQuote.String()
+	return k
+}
+
+// decimalLess compares two decimal strings numerically.
+func decimalLess(a, b string) bool {
+	x, okA := new(big.Rat).SetString(a)
+	y, okB := new(big.Rat).SetString(b)
+	return okA && okB && x.Cmp(y) < 0
+}
+
+// decimalSum adds decimal strings exactly and prints the sum with as many
+// decimals as the most precise of them, like Binance pads its numbers.
+type decimalSum struct {
+	sum      big.Rat
+	decimals int
+}
+
+func (d *decimalSum) add(value string) {
+	x, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return
+	}
+	d.sum.Add(&d.sum, x)
+	if _, fraction, found := strings.Cut(value, "."); found && len(fraction) > d.decimals {
+		d.decimals = len(fraction)
+	}
+}
+
+func (d *decimalSum) String() string {
+	return d.sum.FloatString(d.decimals)
+}
@@ -0,0 +1,376 @@
+package service
+
+import (
+	"binance-proxy/internal/metrics"
+	"binance-proxy/internal/replay"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Primary REST hosts of Binance. Requests to them are sent to the active
+// endpoint of their class.
+const (
+	spotPrimaryHost    = "api.binance.com"
+	futuresPrimaryHost = "fapi.binance.com"
+)
+
+// endpointProbeTimeout bounds a health probe of an endpoint.
+const endpointProbeTimeout = 5 * time.Second
+
+// endpointLatencyWeight is the weight of a new probe in the moving average
+// of an endpoint's latency.
+const endpointLatencyWeight = 0.3
+
+// EndpointStatus is the health of an upstream REST endpoint as shown in
+// /status.
+type EndpointStatus struct {
+	Host      string  `json:"host"`
+	Active    bool    `json:"active"`
+	Healthy   bool    `json:"healthy"`
+	LatencyMs float64 `json:"latency_ms"`
+	LastError string  `json:"last_error,omitempty"`
+	CheckedAt string  `json:"checked_at,omitempty"`
+}
+
+type endpoint struct {
+	host      string
+	healthy   bool
+	latency   time.Duration
+	lastError string
+	checkedAt time.Time
+}
+
+// endpointPool is the ordered list of REST hosts of a class, one of which
+// is active at a time.
+type endpointPool struct {
+	class Class
+	path  string // probed path
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	active    int
+}
+
+var (
+	endpointPoolsMu sync.RWMutex
+	endpointPools   = map[Class]*endpointPool{}
+)
+
+// SetUpstreamEndpoints sets the REST hosts requests of class fail over
+// between, the first one being active at startup. Requests to Binance's
+// primary host are sent to the active endpoint.
+func SetUpstreamEndpoints(class Class, hosts []string) error {
+	pool := &endpointPool{class: class, path: "/api/v3/ping"}
+	if class == FUTURES {
+		pool.path = "/fapi/v1/ping"
+	}
+	seen := map[string]bool{}
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+		host = strings.TrimSuffix(host, "/")
+		if host == "" || seen[host] {
+			continue
+		}
+		if strings.ContainsAny(host, "/?#@ ") {
+			return fmt.Errorf("invalid %s upstream endpoint %q, expected a host name", class, host)
+		}
+		seen[host] = true
+		pool.endpoints = append(pool.endpoints, &endpoint{host: host, healthy: true})
+	}
+	if len(pool.endpoints) == 0 {
+		return fmt.Errorf("no %s upstream endpoint configured", class)
+	}
+
+	endpointPoolsMu.Lock()
+	defer endpointPoolsMu.Unlock()
+	endpointPools[class] = pool
+	metrics.ActiveEndpoint.WithLabelValues(string(class), pool.endpoints[0].host).Set(1)
+	return nil
+}
+
+// UpstreamURL returns the base URL of Binance's REST API of class. Requests
+// to it sent through UpstreamTransport reach the active endpoint of class,
+// or the mock or replayed Binance when one is set.
+func UpstreamURL(class Class) *url.URL {
+	if class == FUTURES {
+		return &url.URL{Scheme: "https", Host: futuresPrimaryHost}
+	}
+	return &url.URL{Scheme: "https", Host: spotPrimaryHost}
+}
+
+// UpstreamTransport wraps the transport of upstream REST requests with the
+// endpoint failover and the record, replay or mock redirect.
+func UpstreamTransport(base http.RoundTripper) http.RoundTripper {
+	return replay.Transport(EndpointTransport(base))
+}
+
+func poolFor(host string) *endpointPool {
+	var class Class
+	switch host {
+	case spotPrimaryHost:
+		class = SPOT
+	case futuresPrimaryHost:
+		class = FUTURES
+	default:
+		return nil
+	}
+	endpointPoolsMu.RLock()
+	defer endpointPoolsMu.RUnlock()
+	return endpointPools[class]
+}
+
+// UpstreamEndpoints returns the health of the REST endpoints of class, nil
+// when none are configured.
+func UpstreamEndpoints(class Class) []EndpointStatus {
+	endpointPoolsMu.RLock()
+	pool := endpointPools[class]
+	endpointPoolsMu.RUnlock()
+	if pool == nil {
+		return nil
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	out := make([]EndpointStatus, len(pool.endpoints))
+	for i, e := range pool.endpoints {
+		out[i] = EndpointStatus{
+			Host:      e.host,
+			Active:    i == pool.active,
+			Healthy:   e.healthy,
+			LatencyMs: float64(e.latency.Microseconds()) / 1000,
+			LastError: e.lastError,
+		}
+		if !e.checkedAt.IsZero() {
+			out[i].CheckedAt = e.checkedAt.Format(time.RFC3339)
+		}
+	}
+	return out
+}
+
+func (p *endpointPool) current() (int, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active, p.endpoints[p.active].host
+}
+
+// fail marks endpoint i unhealthy and, if it is active, switches to the
+// healthy endpoint with the lowest latency. It reports whether another
+// endpoint became active.
+func (p *endpointPool) fail(i int, reason string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.endpoints[i].healthy = false
+	p.endpoints[i].lastError = reason
+	if i != p.active {
+		return false
+	}
+	return p.switchLocked(reason)
+}
+
+// switchLocked makes the best healthy endpoint other than the active one
+// active. Callers must hold p.mu.
+func (p *endpointPool) switchLocked(reason string) bool {
+	next := -1
+	for j, e := range p.endpoints {
+		if j == p.active || !e.healthy {
+			continue
+		}
+		if next < 0 || e.latency < p.endpoints[next].latency {
+			next = j
+		}
+	}
+	if next < 0 {
+		return false
+	}
+	from := p.endpoints[p.active].host
+	to := p.endpoints[next].host
+	p.active = next
+	metrics.ActiveEndpoint.WithLabelValues(string(p.class), from).Set(0)
+	metrics.ActiveEndpoint.WithLabelValues(string(p.class), to).Set(1)
+	metrics.EndpointFailovers.WithLabelValues(string(p.class)).Inc()
+	log.Warnf("%s upstream endpoint %s failed (%s), failing over to %s", p.class, from, reason, to)
+	return true
+}
+
+// endpointFailure returns why a response or error shows that the endpoint,
+// rather than the request, failed: a network error, a geo-block (451, or 403
+// without Binance's JSON body) or a gateway error. Empty means it didn't.
+func endpointFailure(req *http.Request, resp *http.Response, err error) string {
+	if err != nil {
+		if req.Context().Err() != nil || errors.Is(err, context.Canceled) {
+			return ""
+		}
+		return err.Error()
+	}
+	switch resp.StatusCode {
+	case http.StatusUnavailableForLegalReasons:
+		return "451 geo-blocked"
+	case http.StatusForbidden:
+		if !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+			return "403 blocked"
+		}
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return resp.Status
+	}
+	return ""
+}
+
+// endpointTransport sends requests for Binance's primary REST hosts to the
+// active endpoint of their class. When the endpoint fails, GET requests are
+// retried on the endpoint failed over to.
+type endpointTransport struct {
+	base http.RoundTripper
+}
+
+// EndpointTransport wraps the transport of upstream REST requests with the
+// endpoint failover.
+func EndpointTransport(base http.RoundTripper) http.RoundTripper {
+	return endpointTransport{base: base}
+}
+
+// probeKey marks health probes, which must reach the endpoint they name.
+type probeKey struct{}
+
+func (t endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	pool := poolFor(req.URL.Host)
+	if pool == nil || req.Context().Value(probeKey{}) != nil {
+		return t.base.RoundTrip(req)
+	}
+
+	retry := req.Method == http.MethodGet || req.Method == http.MethodHead
+	for attempt := 0; ; attempt++ {
+		i, host := pool.current()
+		r := req.Clone(req.Context())
+		r.URL.Host = host
+		r.Host = host
+		resp, err := t.base.RoundTrip(r)
+
+		reason := endpointFailure(req, resp, err)
+		if reason == "" {
+			return resp, err
+		}
+		if !pool.fail(i, reason) || !retry || attempt+1 >= len(pool.endpoints) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+// StartEndpointProbes checks the health and latency of every configured
+// endpoint each interval until ctx ends. A failed active endpoint is left
+// for the healthy one with the lowest latency.
+func StartEndpointProbes(ctx context.Context, interval time.Duration) {
+	endpointPoolsMu.RLock()
+	pools := make([]*endpointPool, 0, len(endpointPools))
+	for _, pool := range endpointPools {
+		if len(pool.endpoints) > 1 {
+			pools = append(pools, pool)
+		}
+	}
+	endpointPoolsMu.RUnlock()
+	if len(pools) == 0 || interval <= 0 {
+		return
+	}
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			for _, pool := range pools {
+				pool.probe(ctx)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// probe pings every endpoint of the pool. The endpoints share the proxy's IP
+// limits, so pings are charged to the weight budget and probing stops while
+// the class is banned.
+func (p *endpointPool) probe(ctx context.Context) {
+	p.mu.Lock()
+	hosts := make([]string, len(p.endpoints))
+	for i, e := range p.endpoints {
+		hosts[i] = e.host
+	}
+	p.mu.Unlock()
+
+	for i, host := range hosts {
+		if GetBanDetector().IsBanned(p.class) {
+			return
+		}
+		if err := initRateWait(ctx, p.class, p.path, nil); err != nil {
+			return
+		}
+		latency, err := probeEndpoint(ctx, p.class, host, p.path)
+		if ctx.Err() != nil {
+			return
+		}
+
+		p.mu.Lock()
+		e := p.endpoints[i]
+		e.checkedAt = time.Now()
+		if err != nil {
+			e.healthy = false
+			e.lastError = err.Error()
+		} else {
+			if !e.healthy {
+				log.Infof("%s upstream endpoint %s is healthy again", p.class, host)
+			}
+			e.healthy = true
+			e.lastError = ""
+			if e.latency == 0 {
+				e.latency = latency
+			} else {
+				e.latency = time.Duration(endpointLatencyWeight*float64(latency) + (1-endpointLatencyWeight)*float64(e.latency))
+			}
+		}
+		metrics.EndpointLatency.WithLabelValues(string(p.class), host).Set(e.latency.Seconds())
+		p.mu.Unlock()
+	}
+
+	p.mu.Lock()
+	if !p.endpoints[p.active].healthy {
+		p.switchLocked(p.endpoints[p.active].lastError)
+	}
+	p.mu.Unlock()
+}
+
+// probeEndpoint pings host and returns the round trip time. Bans and weight
+// headers in the answer are passed to the ban detector.
+func probeEndpoint(ctx context.Context, class Class, host, path string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, probeKey{}, true), endpointProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := getHTTPClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+	GetBanDetector().CheckResponse(class, resp, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("ping answered %s", resp.Status)
+	}
+	return latency, nil
+}
@@ -1,14 +1,18 @@
 package service
 
 import (
+	"binance-proxy/internal/metrics"
+	"binance-proxy/internal/tracing"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"sync"
 	"time"
 
-	"binance-proxy/internal/tool"
-
 	log "github.com/sirupsen/logrus"
 )
 
@@ -24,8 +28,22 @@ type ExchangeInfoSrv struct {
 	refreshDur   time.Duration
 	si           *symbolInterval
 	exchangeInfo []byte
+	stale        bool               // exchangeInfo is a snapshot, no refresh succeeded yet
+	updatedAt    time.Time          // when exchangeInfo was fetched, zero if unknown
+	initDeadline time.Time          // end of the wait for the first exchangeInfo
+	index        *exchangeInfoIndex // of exchangeInfo, built on the first filtered request
+	gzipped      []byte             // exchangeInfo compressed, on the first gzip request
+	lastSaved    time.Time
+
+	statuses  map[string]string // symbol statuses of the last refresh
+	delisted  map[string]bool   // symbols removed from exchangeInfo or halted
+	onRefresh func(listed, delisted []string)
 }
 
+// exchangeInfoInitWait bounds how long startup and requests wait for the
+// first exchangeInfo while Binance can't be reached.
+const exchangeInfoInitWait = 10 * time.Second
+
 // HTTP client pool for connection reuse
 var (
 	httpClientOnce sync.Once
@@ -40,10 +58,13 @@ func getHTTPClient() *http.Client {
 			IdleConnTimeout:     90 * time.Second,
 			DisableCompression:  false,
 			ForceAttemptHTTP2:   true,
+			TLSClientConfig:     UpstreamTLSConfig(),
+			Proxy:               UpstreamProxy,
+			DialContext:         UpstreamDialContext,
 		}
 
 		httpClient = &http.Client{
-			Transport: transport,
+			Transport: tracing.Transport(userAgentTransport{base: UpstreamTransport(transport)}),
 			Timeout:   30 * time.Second,
 		}
 	})
@@ -58,49 +79,159 @@ func NewExchangeInfoSrv(ctx context.Context, si *symbolInterval) *ExchangeInfoSr
 	log.Tracef("%s exchangeInfo initialization with refresh of %.0fs.", s.si.Class, s.refreshDur.Seconds())
 	s.ctx, s.cancel = context.WithCancel(ctx)
 	s.initCtx, s.initDone = context.WithCancel(context.Background())
+	s.initDeadline = time.Now().Add(exchangeInfoInitWait)
 
 	return s
 }
 
+// Start fetches exchangeInfo and keeps it refreshed. With a snapshot
+// available it returns at once and serves the snapshot until the first
+// refresh succeeds; otherwise it waits up to exchangeInfoInitWait for the
+// first refresh, which keeps being retried in the background.
 func (s *ExchangeInfoSrv) Start() {
-	s.reTryRefreshExchangeInfo()
-
+	snapshot := s.loadSnapshot()
 	go func() {
-		rTimer := time.NewTimer(s.refreshDur)
-		for {
-			rTimer.Reset(s.refreshDur)
-			select {
-			case <-s.ctx.Done():
-				rTimer.Stop()
-				return
-			case <-rTimer.C:
-			}
-
-			s.reTryRefreshExchangeInfo()
-		}
+		s.reTryRefreshExchangeInfo()
+		s.refreshLoop()
 	}()
+	if snapshot {
+		return
+	}
+
+	if !s.waitInit() {
+		log.Warnf("%s exchangeInfo not available yet, starting without it until a refresh succeeds", s.si.Class)
+	}
+}
+
+// waitInit waits for the first exchangeInfo until the end of the startup
+// wait and reports whether there is one.
+func (s *ExchangeInfoSrv) waitInit() bool {
+	select {
+	case <-s.initCtx.Done():
+		return true
+	default:
+	}
+	wait := time.Until(s.initDeadline)
+	if wait <= 0 {
+		return false
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-s.initCtx.Done():
+		return true
+	case <-s.ctx.Done():
+	case <-t.C:
+	}
+	return false
+}
+
+func (s *ExchangeInfoSrv) refreshLoop() {
+	rTimer := time.NewTimer(s.refreshDur)
+	for {
+		rTimer.Reset(s.refreshDur)
+		select {
+		case <-s.ctx.Done():
+			rTimer.Stop()
+			return
+		case <-rTimer.C:
+		}
+
+		s.reTryRefreshExchangeInfo()
+	}
+}
+
+// loadSnapshot serves a saved or embedded exchangeInfo until the first
+// refresh and reports whether there was one.
+func (s *ExchangeInfoSrv) loadSnapshot() bool {
+	data, source, modTime := readSnapshot(s.si.Class)
+	if data == nil {
+		return false
+	}
+
+	s.rw.Lock()
+	defer s.rw.Unlock()
+	s.exchangeInfo = data
+	s.index = nil
+	s.gzipped = nil
+	s.stale = true
+	s.updatedAt = modTime
+	applyRateLimits(s.si.Class, data)
+	s.initDone()
+	log.Infof("%s exchangeInfo served from %s until the first refresh", s.si.Class, source)
+	return true
 }
 
 // Nothing to do
 func (s *ExchangeInfoSrv) Stop() {}
 
 func (s *ExchangeInfoSrv) GetExchangeInfo() []byte {
-	<-s.initCtx.Done()
+	data, _, _ := s.getExchangeInfo()
+	return data
+}
+
+// getExchangeInfo is GetExchangeInfo that also reports whether the data is
+// a snapshot from before the first refresh and when it was fetched. The
+// cached data is returned at once, also while refreshes fail; before the
+// first one it waits no longer than Start does and may return nil.
+func (s *ExchangeInfoSrv) getExchangeInfo() (data []byte, stale bool, updatedAt time.Time) {
+	s.waitInit()
 	s.rw.RLock()
 	defer s.rw.RUnlock()
+	return s.exchangeInfo, s.stale, s.updatedAt
+}
+
+// getGzipExchangeInfo is getExchangeInfo compressed with gzip. The copy is
+// compressed once per refresh.
+func (s *ExchangeInfoSrv) getGzipExchangeInfo() (gz []byte, stale bool, updatedAt time.Time) {
+	s.waitInit()
+
+	s.rw.RLock()
+	gz, stale, updatedAt = s.gzipped, s.stale, s.updatedAt
+	s.rw.RUnlock()
+	if gz != nil {
+		return gz, stale, updatedAt
+	}
+
+	s.rw.Lock()
+	defer s.rw.Unlock()
+	if s.gzipped == nil && s.exchangeInfo != nil {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write(s.exchangeInfo)
+		zw.Close()
+		s.gzipped = b.Bytes()
+		log.Debugf("%s exchangeInfo compressed from %d to %d bytes", s.si.Class, len(s.exchangeInfo), len(s.gzipped))
+	}
+	return s.gzipped, s.stale, s.updatedAt
+}
 
-	return s.exchangeInfo
+// updateAgeMetric sets the age of the cached exchangeInfo in the metrics.
+func (s *ExchangeInfoSrv) updateAgeMetric() {
+	s.rw.RLock()
+	updatedAt := s.updatedAt
+	s.rw.RUnlock()
+	if !updatedAt.IsZero() {
+		metrics.ExchangeInfoAge.WithLabelValues(string(s.si.Class)).Set(time.Since(updatedAt).Seconds())
+	}
 }
 
 func (s *ExchangeInfoSrv) reTryRefreshExchangeInfo() {
-	for d := tool.NewDelayIterator(); ; d.Delay() {
+	for d := retryIterator(ScheduleExchangeInfo); ; d.Delay() {
 		if s.refreshExchangeInfo() == nil {
 			break
 		}
 	}
 }
 
-func (s *ExchangeInfoSrv) refreshExchangeInfo() error {
+func (s *ExchangeInfoSrv) refreshExchangeInfo() (err error) {
+	ctx, span := tracing.Start(s.ctx, "exchangeinfo.refresh", tracing.KindInternal)
+	span.SetAttr("binance.class", string(s.si.Class))
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
 	// Check if API is banned
 	banDetector := GetBanDetector()
 	if banDetector.IsBanned(s.si.Class) {
@@ -111,15 +242,15 @@ func (s *ExchangeInfoSrv) refreshExchangeInfo() error {
 	var url string
 	if s.si.Class == SPOT {
 		url = "https://api.binance.com/api/v3/exchangeInfo"
-		RateWait(s.ctx, s.si.Class, http.MethodGet, "/api/v3/exchangeInfo", nil)
+		initRateWait(ctx, s.si.Class, "/api/v3/exchangeInfo", nil)
 	} else {
 		url = "https://fapi.binance.com/fapi/v1/exchangeInfo"
-		RateWait(s.ctx, s.si.Class, http.MethodGet, "/fapi/v1/exchangeInfo", nil)
+		initRateWait(ctx, s.si.Class, "/fapi/v1/exchangeInfo", nil)
 	}
 
 	// Use pooled HTTP client instead of http.Get()
 	client := getHTTPClient()
-	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		log.Errorf("%s exchangeInfo request creation failed, error: %s.", s.si.Class, err)
 		return err
@@ -141,11 +272,24 @@ func (s *ExchangeInfoSrv) refreshExchangeInfo() error {
 	}
 	defer resp.Body.Close()
 
+	// Keep serving the cached exchangeInfo instead of an error body
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("status %s", resp.Status)
+		log.Errorf("%s exchangeInfo refresh failed, error: %s.", s.si.Class, err)
+		return err
+	}
+
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
 
+	var listed, delisted []string
+	defer func() {
+		if err == nil && s.onRefresh != nil {
+			s.onRefresh(listed, delisted)
+		}
+	}()
 	s.rw.Lock()
 	defer s.rw.Unlock()
 
@@ -153,9 +297,69 @@ func (s *ExchangeInfoSrv) refreshExchangeInfo() error {
 		defer s.initDone()
 	}
 
+	if s.stale {
+		log.Infof("%s exchangeInfo refreshed, snapshot replaced", s.si.Class)
+	}
 	s.exchangeInfo = data
+	s.index = nil
+	s.gzipped = nil
+	s.stale = false
+	s.updatedAt = time.Now()
+	listed, delisted = s.updateListings(data)
+	applyRateLimits(s.si.Class, data)
+	if time.Since(s.lastSaved) > snapshotSaveInterval {
+		if err := saveSnapshot(s.si.Class, data); err != nil {
+			log.Warnf("%s exchangeInfo snapshot not saved (error: %s).", s.si.Class, err)
+		}
+		s.lastSaved = time.Now()
+	}
 
 	log.Debugf("%s exchangeInfo refreshed sucessfully.", s.si.Class)
 
 	return nil
 }
+
+// seed sets exchangeInfo obtained elsewhere if none was fetched yet and
+// reports whether it was used.
+func (s *ExchangeInfoSrv) seed(data []byte) bool {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	if s.exchangeInfo != nil {
+		return false
+	}
+	s.exchangeInfo = data
+	s.index = nil
+	s.gzipped = nil
+	s.updatedAt = time.Now()
+	applyRateLimits(s.si.Class, data)
+	s.initDone()
+	return true
+}
+
+// symbolStatus returns the trading status of symbol from the current
+// exchangeInfo without waiting for it. loaded is false when there is none yet.
+func (s *ExchangeInfoSrv) symbolStatus(symbol string) (status string, loaded bool, err error) {
+	s.rw.RLock()
+	data := s.exchangeInfo
+	s.rw.RUnlock()
+	if data == nil {
+		return "", false, nil
+	}
+
+	var info struct {
+		Symbols []struct {
+			Symbol string `json:"symbol"`
+			Status string `json:"status"`
+		} `json:"symbols"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return "", true, err
+	}
+	for _, sym := range info.Symbols {
+		if sym.Symbol == symbol {
+			return sym.Status, true, nil
+		}
+	}
+	return "", true, nil
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// exchangeInfoIndex locates the symbols of a cached exchangeInfo, so
+// filtered subsets are cut out of it without decoding it again.
+type exchangeInfoIndex struct {
+	data []byte
+	// symStart and symEnd delimit the symbols array, brackets included
+	symStart, symEnd int
+	symbols          []indexedSymbol
+	bySymbol         map[string]int
+}
+
+type indexedSymbol struct {
+	raw         json.RawMessage
+	permissions map[string]bool
+}
+
+func indexExchangeInfo(data []byte) (*exchangeInfoIndex, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("exchangeInfo is not a JSON object")
+	}
+
+	x := &exchangeInfoIndex{data: data, symStart: -1, bySymbol: map[string]int{}}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if key != "symbols" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+			return nil, fmt.Errorf("exchangeInfo symbols is not an array")
+		}
+		x.symStart = int(dec.InputOffset()) - 1
+		for dec.More() {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, err
+			}
+			var sym struct {
+				Symbol         string     `json:"symbol"`
+				Permissions    []string   `json:"permissions"`
+				PermissionSets [][]string `json:"permissionSets"`
+			}
+			if err := json.Unmarshal(raw, &sym); err != nil {
+				return nil, err
+			}
+			perms := map[string]bool{}
+			for _, p := range sym.Permissions {
+				perms[p] = true
+			}
+			for _, set := range sym.PermissionSets {
+				for _, p := range set {
+					perms[p] = true
+				}
+			}
+			x.bySymbol[sym.Symbol] = len(x.symbols)
+			x.symbols = append(x.symbols, indexedSymbol{raw: raw, permissions: perms})
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		x.symEnd = int(dec.InputOffset())
+	}
+	if x.symStart < 0 {
+		return nil, fmt.Errorf("exchangeInfo has no symbols")
+	}
+	return x, nil
+}
+
+// filter returns the exchangeInfo with only the given symbols, in their
+// order, or only the symbols having one of permissions. ok is false if a
+// symbol isn't listed.
+func (x *exchangeInfoIndex) filter(symbols, permissions []string) (data []byte, ok bool) {
+	var selected []json.RawMessage
+	if len(symbols) > 0 {
+		seen := map[string]bool{}
+		for _, symbol := range symbols {
+			i, listed := x.bySymbol[symbol]
+			if !listed {
+				return nil, false
+			}
+			if !seen[symbol] {
+				seen[symbol] = true
+				selected = append(selected, x.symbols[i].raw)
+			}
+		}
+	} else {
+		for _, sym := range x.symbols {
+			for _, p := range permissions {
+				if sym.permissions[p] {
+					selected = append(selected, sym.raw)
+					break
+				}
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	b.Grow(x.symStart + len(x.data) - x.symEnd + 2 + len(selected)*2048)
+	b.Write(x.data[:x.symStart])
+	b.WriteByte('[')
+	for i, raw := range selected {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(raw)
+	}
+	b.WriteByte(']')
+	b.Write(x.data[x.symEnd:])
+	return b.Bytes(), true
+}
+
+// getFilteredExchangeInfo is getExchangeInfo narrowed to symbols or
+// permissions. ok is false when there is no exchangeInfo to filter or a
+// symbol isn't listed in it.
+func (s *ExchangeInfoSrv) getFilteredExchangeInfo(symbols, permissions []string) (data []byte, stale bool, updatedAt time.Time, ok bool) {
+	s.waitInit()
+
+	s.rw.RLock()
+	x, stale, updatedAt := s.index, s.stale, s.updatedAt
+	s.rw.RUnlock()
+	if x == nil {
+		s.rw.Lock()
+		if s.index == nil && s.exchangeInfo != nil {
+			var err error
+			if s.index, err = indexExchangeInfo(s.exchangeInfo); err != nil {
+				log.Warnf("%s exchangeInfo can't be filtered (error: %s).", s.si.Class, err)
+			}
+		}
+		x, stale, updatedAt = s.index, s.stale, s.updatedAt
+		s.rw.Unlock()
+	}
+	if x == nil {
+		return nil, false, time.Time{}, false
+	}
+
+	data, ok = x.filter(symbols, permissions)
+	return data, stale, updatedAt, ok
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxEvents is the number of events kept for the /events endpoint.
+const maxEvents = 100
+
+// Event is a notable state change of the proxy, such as the end of a ban.
+type Event struct {
+	Time    time.Time    `json:"time"`
+	Class   Class        `json:"class"`
+	Type    string       `json:"type"`
+	Message string       `json:"message"`
+	Report  *Degradation `json:"report,omitempty"`
+}
+
+// Degradation summarizes how the proxy behaved while a class was degraded,
+// from the moment a ban or backoff was triggered until it was lifted.
+type Degradation struct {
+	Cause            string           `json:"cause"`
+	Start            time.Time        `json:"start"`
+	End              time.Time        `json:"end"`
+	Duration         string           `json:"duration"`
+	WeightUsed       int              `json:"weight_used"`
+	WeightLimit      int              `json:"weight_limit"`
+	Responses        map[string]int64 `json:"responses"`
+	StreamReconnects int64            `json:"stream_reconnects"`
+}
+
+// Incident kinds; a class can have one running incident of each kind.
+const (
+	incidentBan      = "ban"
+	incidentRecovery = "recovery"
+)
+
+type incidentKey struct {
+	class Class
+	kind  string
+}
+
+type incidentTracker struct {
+	mu     sync.Mutex
+	active map[incidentKey]*Degradation
+	events []Event
+}
+
+var incidents = &incidentTracker{active: make(map[incidentKey]*Degradation)}
+
+// begin opens an incident for the class unless one of the same kind is
+// already running, in which case the original cause and weight are kept.
+func (t *incidentTracker) begin(class Class, kind, cause string, weightUsed, weightLimit int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := incidentKey{class, kind}
+	if _, ok := t.active[key]; ok {
+		return
+	}
+	t.active[key] = &Degradation{
+		Cause:       cause,
+		Start:       time.Now(),
+		WeightUsed:  weightUsed,
+		WeightLimit: weightLimit,
+		Responses:   make(map[string]int64),
+	}
+}
+
+// end closes the running incident of the class and kind, logs its summary and
+// records it as an event.
+func (t *incidentTracker) end(class Class, kind, eventType string, end time.Time) {
+	t.mu.Lock()
+	key := incidentKey{class, kind}
+	d, ok := t.active[key]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.active, key)
+
+	if end.Before(d.Start) {
+		end = d.Start
+	}
+	d.End = end
+	d.Duration = end.Sub(d.Start).Round(time.Second).String()
+	t.record(Event{Time: time.Now(), Class: class, Type: eventType, Message: string(class) + " degradation ended", Report: d})
+	t.mu.Unlock()
+
+	log.WithFields(log.Fields{
+		"class":             class,
+		"event":             eventType,
+		"cause":             d.Cause,
+		"duration":          d.Duration,
+		"weight_used":       d.WeightUsed,
+		"weight_limit":      d.WeightLimit,
+		"responses":         d.Responses,
+		"stream_reconnects": d.StreamReconnects,
+	}).Infof("%s degradation report", class)
+}
+
+// record appends an event, dropping the oldest ones. Callers hold mu.
+func (t *incidentTracker) record(e Event) {
+	t.events = append(t.events, e)
+	if len(t.events) > maxEvents {
+		t.events = append(t.events[:0:0], t.events[len(t.events)-maxEvents:]...)
+	}
+}
+
+func (t *incidentTracker) countResponse(class Class, source string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, d := range t.active {
+		if key.class == class {
+			d.Responses[source]++
+		}
+	}
+}
+
+func (t *incidentTracker) countReconnect(class Class) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, d := range t.active {
+		if key.class == class {
+			d.StreamReconnects++
+		}
+	}
+}
+
+// RecordDegradedResponse counts a response that was not served from fresh
+// data, keyed by its Data-Source, towards the running incident of the class.
+func RecordDegradedResponse(class Class, source string) {
+	incidents.countResponse(class, source)
+}
+
+// Events returns the most recent events, oldest first.
+func Events() []Event {
+	incidents.mu.Lock()
+	defer incidents.mu.Unlock()
+
+	events := make([]Event, len(incidents.events))
+	copy(events, incidents.events)
+	return events
+}
@@ -1,11 +1,12 @@
 package service
 
 import (
-	"binance-proxy/internal/tool"
+	"binance-proxy/internal/metrics"
+	"binance-proxy/internal/tracing"
 	"container/list"
 	"context"
-	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -27,24 +28,32 @@ type Kline struct {
 	TradeNum                 int64
 	TakerBuyBaseAssetVolume  string
 	TakerBuyQuoteAssetVolume string
+
+	// Final marks websocket updates of a candle that has closed.
+	Final bool
 }
 
 type KlinesSrv struct {
 	rw sync.RWMutex
+	streamClock
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	initCtx  context.Context
 	initDone context.CancelFunc
+	stopped  chan struct{}
 
 	si         *symbolInterval
+	tier       func() Tier
 	klinesList *list.List
 	klinesArr  []*Kline
+	seeded     []*Kline // loaded through LoadCache, used while REST init is banned
+	retention  int      // klines kept
 }
 
-func NewKlinesSrv(ctx context.Context, si *symbolInterval) *KlinesSrv {
-	s := &KlinesSrv{si: si}
+func NewKlinesSrv(ctx context.Context, si *symbolInterval, tier func() Tier) *KlinesSrv {
+	s := &KlinesSrv{streamClock: newStreamClock(), si: si, tier: tier, stopped: make(chan struct{}), retention: KlineRetention(si.Interval)}
 	s.ctx, s.cancel = context.WithCancel(ctx)
 	s.initCtx, s.initDone = context.WithCancel(context.Background())
 
@@ -53,12 +62,29 @@ func NewKlinesSrv(ctx context.Context, si *symbolInterval) *KlinesSrv {
 
 func (s *KlinesSrv) Start() {
 	go func() {
-		for d := tool.NewDelayIterator(); ; d.Delay() {
+		defer close(s.stopped)
+
+		reconnect := false
+		for d := retryIterator(ScheduleReconnect); ; d.Delay() {
+			if reconnect {
+				metrics.StreamReconnects.WithLabelValues(string(s.si.Class), StreamKline).Inc()
+				s.reconnects.Add(1)
+				incidents.countReconnect(s.si.Class)
+				if !awaitReconnect(s.ctx, s.tier()) {
+					return
+				}
+			}
+			reconnect = true
+
 			s.rw.Lock()
 			s.klinesList = nil
 			s.rw.Unlock()
 
+			if streamDialWait(s.ctx) != nil {
+				return
+			}
 			doneC, stopC, err := s.connect()
+			dialResult(s.si.Class, err)
 			if err != nil {
 				log.Errorf("%s %s@%s kline websocket connection error: %s.", s.si.Class, s.si.Symbol, s.si.Interval, err)
 				continue
@@ -68,7 +94,13 @@ func (s *KlinesSrv) Start() {
 			select {
 			case <-s.ctx.Done():
 				stopC <- struct{}{}
+				<-doneC
 				return
+			case <-s.resubscribeC:
+				log.Infof("%s %s@%s kline websocket resubscribing on request.", s.si.Class, s.si.Symbol, s.si.Interval)
+				stopC <- struct{}{}
+				<-doneC
+				continue
 			case <-doneC:
 			}
 			log.Warnf("%s %s@%s kline websocket disconnected, trying to reconnect.", s.si.Class, s.si.Symbol, s.si.Interval)
@@ -80,7 +112,13 @@ func (s *KlinesSrv) Stop() {
 	s.cancel()
 }
 
+// Stopped is closed once the websocket loop has exited after Stop.
+func (s *KlinesSrv) Stopped() <-chan struct{} {
+	return s.stopped
+}
+
 func (s *KlinesSrv) errHandler(err error) {
+	countStreamError(StreamKline, s.si)
 	if strings.Contains(err.Error(), "context canceled") {
 		log.Warnf("%s %s@%s kline websocket context canceled, will restart connection.", s.si.Class, s.si.Symbol, s.si.Interval)
 	} else {
@@ -89,6 +127,22 @@ func (s *KlinesSrv) errHandler(err error) {
 }
 
 func (s *KlinesSrv) connect() (doneC, stopC chan struct{}, err error) {
+	if s.si.Price == "" && isDerived(s.si.Interval) {
+		return s.deriveServe()
+	}
+	if Polling(s.si.Class) {
+		return pollServe(s.ctx, s.pollKlines, s.errHandler)
+	}
+	if s.si.Price != "" {
+		return s.priceServe()
+	}
+	if combinedEnabled.Load() {
+		if s.si.Class == SPOT {
+			return serveJSON(s.ctx, s.si.Class, klineStreamName(s.si), func(event *spot.WsKlineEvent) { s.wsHandler(event) }, s.errHandler)
+		}
+		return serveJSON(s.ctx, s.si.Class, klineStreamName(s.si), func(event *futures.WsKlineEvent) { s.wsHandler(event) }, s.errHandler)
+	}
+
 	if s.si.Class == SPOT {
 		return spot.WsKlineServe(s.si.Symbol,
 			s.si.Interval,
@@ -105,99 +159,66 @@ func (s *KlinesSrv) connect() (doneC, stopC chan struct{}, err error) {
 }
 
 func (s *KlinesSrv) initKlineData() {
+	ctx, span := tracing.Start(s.ctx, "klines.init", tracing.KindInternal)
+	span.SetAttr("binance.class", string(s.si.Class))
+	span.SetAttr("binance.symbol", s.si.Symbol)
+	span.SetAttr("binance.interval", s.si.Interval)
+	defer span.End()
+
 	// Check if API is banned
 	banDetector := GetBanDetector()
 	if banDetector.IsBanned(s.si.Class) {
+		span.SetAttr("binance.banned", true)
 		log.Debugf("%s %s@%s kline initialization skipped due to API ban", s.si.Class, s.si.Symbol, s.si.Interval)
 
-		// Create empty klines list to prevent repeated initialization attempts
-		s.klinesList = list.New()
+		// Fall back to seeded klines, or an empty list to prevent repeated
+		// initialization attempts
+		s.klinesList = s.seedList()
 		defer s.initDone()
 		return
 	}
 
-	var klines interface{}
-	var err error
 	log.Debugf("%s %s@%s kline initialization through REST.", s.si.Class, s.si.Symbol, s.si.Interval)
-	for d := tool.NewDelayIterator(); ; d.Delay() {
+	for d := retryIterator(ScheduleKlineInit); ; d.Delay() {
 		// Check ban status before each attempt
 		if banDetector.IsBanned(s.si.Class) {
 			log.Debugf("%s %s@%s kline initialization stopped due to API ban", s.si.Class, s.si.Symbol, s.si.Interval)
-			s.klinesList = list.New()
+			s.klinesList = s.seedList()
 			defer s.initDone()
 			return
 		}
 
-		var resp *http.Response
-		if s.si.Class == SPOT {
-			RateWait(s.ctx, s.si.Class, http.MethodGet, "/api/v3/klines", url.Values{
-				"limit": []string{"1000"},
-			})
-			client := spot.NewClient("", "")
-			klines, err = client.NewKlinesService().
-				Symbol(s.si.Symbol).Interval(s.si.Interval).Limit(1000).
-				Do(s.ctx)
-		} else {
-			RateWait(s.ctx, s.si.Class, http.MethodGet, "/fapi/v1/klines", url.Values{
-				"limit": []string{"1000"},
-			})
-			client := futures.NewClient("", "")
-			klines, err = client.NewKlinesService().
-				Symbol(s.si.Symbol).Interval(s.si.Interval).Limit(1000).
-				Do(s.ctx)
-		}
+		klines, err := s.fetchKlines(ctx, min(s.retention, maxKlinesPerRequest), 0)
 
 		// Check for bans (resp might be nil for SDK calls, so we check err)
-		if banDetector.CheckResponse(s.si.Class, resp, err) {
+		if banDetector.CheckResponse(s.si.Class, nil, err) {
 			log.Debugf("%s %s@%s kline initialization stopped due to detected ban", s.si.Class, s.si.Symbol, s.si.Interval)
-			s.klinesList = list.New()
+			s.klinesList = s.seedList()
 			defer s.initDone()
 			return
 		}
 
 		if err != nil {
 			log.Errorf("%s %s@%s kline initialization via REST failed, error: %s.", s.si.Class, s.si.Symbol, s.si.Interval, err)
+			span.SetError(err)
 			continue
 		}
 
-		s.klinesList = list.New()
-
-		if vi, ok := klines.([]*spot.Kline); ok {
-			for _, v := range vi {
-				t := &Kline{
-					OpenTime:                 v.OpenTime,
-					Open:                     v.Open,
-					High:                     v.High,
-					Low:                      v.Low,
-					Close:                    v.Close,
-					Volume:                   v.Volume,
-					CloseTime:                v.CloseTime,
-					QuoteAssetVolume:         v.QuoteAssetVolume,
-					TradeNum:                 v.TradeNum,
-					TakerBuyBaseAssetVolume:  v.TakerBuyBaseAssetVolume,
-					TakerBuyQuoteAssetVolume: v.TakerBuyQuoteAssetVolume,
-				}
-
-				s.klinesList.PushBack(t)
+		// Retention beyond one page is filled with older pages; a failure
+		// there keeps what was fetched so far
+		for page := klines; len(klines) < s.retention && len(page) == maxKlinesPerRequest; {
+			page, err = s.fetchKlines(ctx, min(s.retention-len(klines), maxKlinesPerRequest), klines[0].OpenTime-1)
+			if banDetector.CheckResponse(s.si.Class, nil, err) || err != nil {
+				log.Debugf("%s %s@%s kline initialization kept %d klines, fetching older ones failed: %v", s.si.Class, s.si.Symbol, s.si.Interval, len(klines), err)
+				break
 			}
-		} else if vi, ok := klines.([]*futures.Kline); ok {
-			for _, v := range vi {
-				t := &Kline{
-					OpenTime:                 v.OpenTime,
-					Open:                     v.Open,
-					High:                     v.High,
-					Low:                      v.Low,
-					Close:                    v.Close,
-					Volume:                   v.Volume,
-					CloseTime:                v.CloseTime,
-					QuoteAssetVolume:         v.QuoteAssetVolume,
-					TradeNum:                 v.TradeNum,
-					TakerBuyBaseAssetVolume:  v.TakerBuyBaseAssetVolume,
-					TakerBuyQuoteAssetVolume: v.TakerBuyQuoteAssetVolume,
-				}
+			klines = append(page, klines...)
+		}
+		span.SetAttr("binance_proxy.klines", len(klines))
 
-				s.klinesList.PushBack(t)
-			}
+		s.klinesList = list.New()
+		for _, k := range klines {
+			s.klinesList.PushBack(k)
 		}
 
 		defer s.initDone()
@@ -205,7 +226,75 @@ func (s *KlinesSrv) initKlineData() {
 	}
 }
 
+// fetchKlines requests up to limit klines through REST, the latest ones or
+// with endTime those opening at or before it.
+func (s *KlinesSrv) fetchKlines(ctx context.Context, limit int, endTime int64) ([]*Kline, error) {
+	if s.si.Price != "" {
+		return s.fetchPriceKlines(ctx, limit, endTime)
+	}
+	query := url.Values{"limit": []string{strconv.Itoa(limit)}}
+	var klines []*Kline
+	if s.si.Class == SPOT {
+		initRateWait(ctx, s.si.Class, "/api/v3/klines", query)
+		client := spot.NewClient("", "")
+		client.HTTPClient = getHTTPClient()
+		svc := client.NewKlinesService().Symbol(s.si.Symbol).Interval(s.si.Interval).Limit(limit)
+		if endTime > 0 {
+			svc.EndTime(endTime)
+		}
+		res, err := svc.Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range res {
+			klines = append(klines, &Kline{
+				OpenTime:                 v.OpenTime,
+				Open:                     v.Open,
+				High:                     v.High,
+				Low:                      v.Low,
+				Close:                    v.Close,
+				Volume:                   v.Volume,
+				CloseTime:                v.CloseTime,
+				QuoteAssetVolume:         v.QuoteAssetVolume,
+				TradeNum:                 v.TradeNum,
+				TakerBuyBaseAssetVolume:  v.TakerBuyBaseAssetVolume,
+				TakerBuyQuoteAssetVolume: v.TakerBuyQuoteAssetVolume,
+			})
+		}
+		return klines, nil
+	}
+
+	initRateWait(ctx, s.si.Class, "/fapi/v1/klines", query)
+	client := futures.NewClient("", "")
+	client.HTTPClient = getHTTPClient()
+	svc := client.NewKlinesService().Symbol(s.si.Symbol).Interval(s.si.Interval).Limit(limit)
+	if endTime > 0 {
+		svc.EndTime(endTime)
+	}
+	res, err := svc.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range res {
+		klines = append(klines, &Kline{
+			OpenTime:                 v.OpenTime,
+			Open:                     v.Open,
+			High:                     v.High,
+			Low:                      v.Low,
+			Close:                    v.Close,
+			Volume:                   v.Volume,
+			CloseTime:                v.CloseTime,
+			QuoteAssetVolume:         v.QuoteAssetVolume,
+			TradeNum:                 v.TradeNum,
+			TakerBuyBaseAssetVolume:  v.TakerBuyBaseAssetVolume,
+			TakerBuyQuoteAssetVolume: v.TakerBuyQuoteAssetVolume,
+		})
+	}
+	return klines, nil
+}
+
 func (s *KlinesSrv) wsHandler(event interface{}) {
+	s.touch()
 	if s.klinesList == nil {
 		s.initKlineData()
 	}
@@ -225,6 +314,7 @@ func (s *KlinesSrv) wsHandler(event interface{}) {
 			TradeNum:                 vi.Kline.TradeNum,
 			TakerBuyBaseAssetVolume:  vi.Kline.ActiveBuyVolume,
 			TakerBuyQuoteAssetVolume: vi.Kline.ActiveBuyQuoteVolume,
+			Final:                    vi.Kline.IsFinal,
 		}
 	} else if vi, ok := event.(*futures.WsKlineEvent); ok {
 		k = &Kline{
@@ -239,18 +329,24 @@ func (s *KlinesSrv) wsHandler(event interface{}) {
 			TradeNum:                 vi.Kline.TradeNum,
 			TakerBuyBaseAssetVolume:  vi.Kline.ActiveBuyVolume,
 			TakerBuyQuoteAssetVolume: vi.Kline.ActiveBuyQuoteVolume,
+			Final:                    vi.Kline.IsFinal,
 		}
+	} else if vi, ok := event.(*Kline); ok {
+		k = vi
 	}
 
-	log.Tracef("%s %s@%s kline websocket message received for open timestamp %d", s.si.Class, s.si.Symbol, s.si.Interval, k.OpenTime)
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.Tracef("%s %s@%s kline websocket message received for open timestamp %d", s.si.Class, s.si.Symbol, s.si.Interval, k.OpenTime)
+	}
 
-	if s.klinesList.Back().Value.(*Kline).OpenTime < k.OpenTime {
+	// The list is empty when the REST initialization was skipped during a ban
+	if back := s.klinesList.Back(); back == nil || back.Value.(*Kline).OpenTime < k.OpenTime {
 		s.klinesList.PushBack(k)
-	} else if s.klinesList.Back().Value.(*Kline).OpenTime == k.OpenTime {
-		s.klinesList.Back().Value = k
+	} else if back.Value.(*Kline).OpenTime == k.OpenTime {
+		back.Value = k
 	}
 
-	for s.klinesList.Len() > 1000 {
+	for s.klinesList.Len() > s.retention {
 		s.klinesList.Remove(s.klinesList.Front())
 	}
 
@@ -262,13 +358,19 @@ func (s *KlinesSrv) wsHandler(event interface{}) {
 	}
 
 	s.rw.Lock()
-	defer s.rw.Unlock()
-
 	s.klinesArr = klinesArr
+	s.rw.Unlock()
+
+	// Update sinks and subscribers only know trade klines
+	if s.si.Price == "" {
+		broker.publish(StreamKline, s.si, k)
+	}
 }
 
-func (s *KlinesSrv) GetKlines() []*Kline {
-	<-s.initCtx.Done()
+func (s *KlinesSrv) GetKlines(ctx context.Context) []*Kline {
+	if !awaitInit(ctx, s.initCtx, s.si.Class, StreamKline) {
+		return nil
+	}
 	s.rw.RLock()
 	defer s.rw.RUnlock()
 
@@ -1,76 +1,187 @@
 package service
 
 import (
+	"binance-proxy/internal/logcache"
+	"binance-proxy/internal/tracing"
 	"context"
+	"fmt"
 	"net/http"
 	"net/url"
-	"strconv"
+	"sync"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/time/rate"
 )
 
 var (
-	SpotLimiter    = rate.NewLimiter(20, 1200)
-	FuturesLimiter = rate.NewLimiter(40, 2400)
+	// DialLimiter caps upstream websocket dial attempts across all streams and
+	// classes; Binance allows 300 connection attempts per 5 minutes per IP.
+	DialLimiter = rate.NewLimiter(1, 30)
 )
 
-func RateWait(ctx context.Context, class Class, method, path string, query url.Values) {
-	weight := 1
-	switch path {
-	case "/fapi/v1/klines":
-		weight = 5
-		limitInt, _ := strconv.Atoi(query.Get("limit"))
-		if limitInt >= 1 && limitInt < 100 {
-			weight = 1
-		} else if limitInt >= 100 && limitInt < 500 {
-			weight = 2
-		} else if limitInt >= 500 && limitInt <= 1000 {
-			weight = 5
-		} else if limitInt > 1000 && limitInt <= 1500 {
-			weight = 10
-		}
-	case "/api/v3/depth":
-		limitInt, _ := strconv.Atoi(query.Get("limit"))
-		if limitInt >= 5 && limitInt <= 100 {
-			weight = 1
-		} else if limitInt >= 100 && limitInt < 500 {
-			weight = 2
-		} else if limitInt == 500 {
-			weight = 5
-		} else if limitInt == 1000 {
-			weight = 10
-		} else if limitInt == 5000 {
-			weight = 50
+// SetDialLimit changes the global websocket dial rate and burst.
+func SetDialLimit(perSecond float64, burst int) {
+	DialLimiter.SetLimit(rate.Limit(perSecond))
+	DialLimiter.SetBurst(burst)
+}
+
+// DialWait queues the caller until the global dial budget allows another
+// upstream websocket connection attempt.
+func DialWait(ctx context.Context) error {
+	if DialLimiter.Tokens() < 1 {
+		logcache.LogOncePerDuration("warn", "Upstream websocket dial rate limit reached, queueing reconnects")
+	}
+	return DialLimiter.Wait(ctx)
+}
+
+// RateWait blocks until the upstream weight budget allows a forwarded
+// request. It returns an error without consuming weight if ctx ends first.
+// Forwards can't use the headroom reserved for cache initialization.
+func RateWait(ctx context.Context, class Class, method, path string, query url.Values) error {
+	return tracedWait(ctx, class, requestWeight(method, path, query), priorityForward)
+}
+
+// initRateWait is RateWait for the REST initialization of the caches, which
+// may use the whole budget.
+func initRateWait(ctx context.Context, class Class, path string, query url.Values) error {
+	return tracedWait(ctx, class, requestWeight(http.MethodGet, path, query), priorityInit)
+}
+
+func tracedWait(ctx context.Context, class Class, weight int, p priority) error {
+	_, span := tracing.Start(ctx, "rate_wait", tracing.KindInternal)
+	span.SetAttr("binance.weight", weight)
+	span.SetAttr("binance.init", p == priorityInit)
+	err := budgetFor(class).wait(ctx, weight, p)
+	span.SetError(err)
+	span.End()
+	return err
+}
+
+type priority int
+
+const (
+	priorityForward priority = iota // requests forwarded for clients
+	priorityInit                    // REST initialization of the caches
+)
+
+// defaultWeightReserve is the share of the minute budget held back from
+// forwards so cache initializations can still run.
+const defaultWeightReserve = 0.1
+
+// weightBudget schedules upstream weight within Binance's per-minute window.
+// Usage is what the proxy spent in the window or, when higher, what Binance
+// reports in X-MBX-USED-WEIGHT-1M, which also covers weight spent by other
+// clients on the same IP.
+type weightBudget struct {
+	mu      sync.Mutex
+	class   Class
+	limit   int
+	reserve float64
+	minute  int64 // current window as unix minute
+	used    int
+}
+
+var (
+	spotBudget    = &weightBudget{class: SPOT, limit: 1200, reserve: defaultWeightReserve}
+	futuresBudget = &weightBudget{class: FUTURES, limit: 2400, reserve: defaultWeightReserve}
+)
+
+// WeightLimit returns the request weight Binance allows per minute for the
+// class.
+func WeightLimit(class Class) int {
+	b := budgetFor(class)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit
+}
+
+func budgetFor(class Class) *weightBudget {
+	if class == SPOT {
+		return spotBudget
+	}
+	return futuresBudget
+}
+
+// SetWeightReserve sets the share of the minute budget only cache
+// initializations may use, between 0 and 1.
+func SetWeightReserve(share float64) {
+	for _, b := range []*weightBudget{spotBudget, futuresBudget} {
+		b.mu.Lock()
+		b.reserve = share
+		b.mu.Unlock()
+	}
+}
+
+// roll starts a new window once the minute changed. Callers hold mu.
+func (b *weightBudget) roll(now time.Time) {
+	if m := now.Unix() / 60; m != b.minute {
+		b.minute = m
+		b.used = 0
+	}
+}
+
+func (b *weightBudget) wait(ctx context.Context, weight int, p priority) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.roll(now)
+		allowed := b.limit
+		if p == priorityForward {
+			allowed -= int(float64(b.limit) * b.reserve)
 		}
-	case "/fapi/v1/depth":
-		limitInt, _ := strconv.Atoi(query.Get("limit"))
-		if limitInt >= 5 && limitInt <= 50 {
-			weight = 2
-		} else if limitInt == 100 {
-			weight = 5
-		} else if limitInt == 500 {
-			weight = 10
-		} else if limitInt == 1000 {
-			weight = 20
+		// A request heavier than the budget still runs in an empty window
+		if b.used+weight <= allowed || b.used == 0 {
+			b.used += weight
+			b.mu.Unlock()
+			return nil
 		}
-	case "/api/v3/ticker/24hr", "/fapi/v1/ticker/24hr":
-		if query.Get("symbol") == "" {
-			weight = 40
+		next := time.Unix((b.minute+1)*60, 0)
+		b.mu.Unlock()
+
+		if p == priorityForward {
+			logcache.LogOncePerDuration("warn", fmt.Sprintf("%s upstream weight budget low, forwarded requests wait for the next minute", b.class))
+		} else if log.IsLevelEnabled(log.DebugLevel) {
+			log.Debugf("%s upstream weight budget exhausted, cache initialization waits for the next minute", b.class)
 		}
-	case "/api/v3/exchangeInfo", "/fapi/v1/exchangeInfo", "/api/v3/account", "/api/v3/myTrades":
-		weight = 10
-	case "/api/v3/order":
-		if method == http.MethodGet {
-			weight = 2
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
 		}
-	case "/fapi/v1/userTrades", "/fapi/v2/account":
-		weight = 5
+	}
+}
+
+// setLimit changes the minute budget, as published in exchangeInfo.
+func (b *weightBudget) setLimit(limit int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
+	if limit != b.limit {
+		log.Infof("%s upstream weight limit is %d per minute (was %d)", b.class, limit, b.limit)
+		b.limit = limit
 	}
+}
 
-	if class == SPOT {
-		SpotLimiter.WaitN(ctx, weight)
-	} else {
-		FuturesLimiter.WaitN(ctx, weight)
+// report records the used weight Binance returned for the current window.
+func (b *weightBudget) report(used int, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.roll(now)
+	if used > b.used {
+		b.used = used
 	}
 }
+
+// saturation returns the used share of the window, between 0 and 1.
+func (b *weightBudget) saturation() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.roll(time.Now())
+	return clamp01(float64(b.used) / float64(b.limit))
+}
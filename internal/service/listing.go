@@ -0,0 +1,99 @@
+package service
+
+import (
+	"binance-proxy/internal/notify"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	listingMu        sync.RWMutex
+	listingIntervals []string
+	listingWarm      time.Duration
+	// listingUntil holds the kline streams started for new listings, with
+	// the time until which they stay open while idle
+	listingUntil = map[symbolInterval]time.Time{}
+)
+
+// SetListingStreams starts kline streams of the given intervals for symbols
+// that start trading, and keeps them open for warm while no client requests
+// them. Without intervals new listings are only logged and notified.
+func SetListingStreams(intervals []string, warm time.Duration) error {
+	var list []string
+	for _, interval := range intervals {
+		interval = strings.TrimSpace(interval)
+		if interval == "" {
+			continue
+		}
+		if _, ok := INTERVAL_2_DURATION[interval]; !ok {
+			return fmt.Errorf("invalid listing interval %q", interval)
+		}
+		list = append(list, interval)
+	}
+
+	listingMu.Lock()
+	defer listingMu.Unlock()
+	listingIntervals = list
+	listingWarm = warm
+	return nil
+}
+
+// isListingWarm reports whether the klines of si were started for a new
+// listing that is still within its warm period.
+func isListingWarm(si symbolInterval, now time.Time) bool {
+	listingMu.Lock()
+	defer listingMu.Unlock()
+
+	until, ok := listingUntil[si]
+	if ok && now.After(until) {
+		delete(listingUntil, si)
+		return false
+	}
+	return ok
+}
+
+// exchangeInfoRefreshed is called after every exchangeInfo refresh with the
+// symbols that started trading and the symbols delisted by it.
+func (s *Service) exchangeInfoRefreshed(listed, delisted []string) {
+	s.stopDelisted(delisted)
+	if len(listed) > 0 {
+		s.startListed(listed)
+	}
+}
+
+// startListed announces new listings and starts their configured kline
+// streams.
+func (s *Service) startListed(listed []string) {
+	listingMu.Lock()
+	intervals, until := listingIntervals, time.Now().Add(listingWarm)
+	for _, symbol := range listed {
+		for _, interval := range intervals {
+			listingUntil[*NewSymbolInterval(s.class, symbol, interval)] = until
+		}
+	}
+	listingMu.Unlock()
+
+	log.Warnf("%s new symbols trading: %s", s.class, strings.Join(listed, ", "))
+	for _, symbol := range listed {
+		for _, interval := range intervals {
+			s.klinesSrvFor(NewSymbolInterval(s.class, symbol, interval))
+		}
+	}
+
+	list := listed
+	if len(list) > 10 {
+		list = append(list[:10:10], fmt.Sprintf("and %d more", len(listed)-10))
+	}
+	message := fmt.Sprintf("%s started trading", strings.Join(list, ", "))
+	if len(intervals) > 0 {
+		message += fmt.Sprintf(", %s klines streaming", strings.Join(intervals, ", "))
+	}
+	incidents.mu.Lock()
+	incidents.record(Event{Time: time.Now(), Class: s.class, Type: "listing", Message: message})
+	incidents.mu.Unlock()
+	notify.Send(notify.KindListing, string(s.class), "%s", message)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	spot "github.com/adshao/go-binance/v2"
+	futures "github.com/adshao/go-binance/v2/futures"
+)
+
+var (
+	restProxy atomic.Pointer[url.URL]
+	wsProxy   atomic.Pointer[url.URL]
+)
+
+// SetOutboundProxy sends the connections to Binance through proxies: rest
+// for REST requests and ws for websockets, which default to rest. Empty
+// proxies keep the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+func SetOutboundProxy(rest, ws string) error {
+	restURL, err := parseOutboundProxy(rest, "http", "https", "socks5", "socks5h")
+	if err != nil {
+		return fmt.Errorf("upstream proxy: %w", err)
+	}
+	if ws == "" {
+		ws = rest
+	}
+	// The websocket dialers only speak HTTP CONNECT and SOCKS5
+	wsURL, err := parseOutboundProxy(ws, "http", "socks5")
+	if err != nil {
+		return fmt.Errorf("upstream websocket proxy: %w", err)
+	}
+
+	restProxy.Store(restURL)
+	wsProxy.Store(wsURL)
+	if wsURL != nil {
+		spot.SetWsProxyUrl(wsURL.String())
+		futures.SetWsProxyUrl(wsURL.String())
+	}
+	return nil
+}
+
+func parseOutboundProxy(raw string, schemes ...string) (*url.URL, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q has no host", u.Redacted())
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported scheme %q in %s, expected one of %v", u.Scheme, u.Redacted(), schemes)
+}
+
+// UpstreamProxy is the Proxy function of transports to Binance's REST API.
+func UpstreamProxy(req *http.Request) (*url.URL, error) {
+	if u := restProxy.Load(); u != nil {
+		return u, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+func upstreamWsProxy(req *http.Request) (*url.URL, error) {
+	if u := wsProxy.Load(); u != nil {
+		return u, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
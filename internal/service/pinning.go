@@ -0,0 +1,106 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+var upstreamTLS atomic.Pointer[tls.Config]
+
+// UpstreamTLSOptions restrict the TLS connections to Binance.
+type UpstreamTLSOptions struct {
+	// CAFile is a PEM bundle trusted instead of the system roots.
+	CAFile string
+	// Pins are public keys written as "sha256/<base64 SPKI digest>"; a
+	// certificate of the verified chain must have one of them.
+	Pins []string
+	// MinVersion is the lowest accepted TLS version, 0 for the default.
+	MinVersion uint16
+	// CipherSuites are the TLS 1.2 cipher suites offered, nil for the default.
+	CipherSuites []uint16
+}
+
+// SetUpstreamTLS restricts the TLS connections to Binance. The zero value
+// keeps the system defaults.
+func SetUpstreamTLS(opts UpstreamTLSOptions) error {
+	caFile, pins := opts.CAFile, opts.Pins
+	if caFile == "" && len(pins) == 0 && opts.MinVersion == 0 && len(opts.CipherSuites) == 0 {
+		upstreamTLS.Store(nil)
+		return nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12, CipherSuites: opts.CipherSuites}
+	if opts.MinVersion != 0 {
+		config.MinVersion = opts.MinVersion
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("upstream CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("upstream CA: no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if len(pins) > 0 {
+		pinned := make(map[string]bool, len(pins))
+		for _, pin := range pins {
+			pin = strings.TrimSpace(pin)
+			digest, ok := strings.CutPrefix(pin, "sha256/")
+			if b, err := base64.StdEncoding.DecodeString(digest); !ok || err != nil || len(b) != sha256.Size {
+				return fmt.Errorf("invalid upstream pin %q, expected sha256/<base64 SPKI digest>", pin)
+			}
+			pinned[digest] = true
+		}
+		config.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+			for _, chain := range chains {
+				for _, cert := range chain {
+					sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+					if pinned[base64.StdEncoding.EncodeToString(sum[:])] {
+						return nil
+					}
+				}
+			}
+			return errors.New("upstream certificate doesn't match any pinned public key")
+		}
+	}
+
+	upstreamTLS.Store(config)
+	return nil
+}
+
+// UpstreamTLSConfig returns the TLS configuration for connections to Binance,
+// nil for the system defaults.
+func UpstreamTLSConfig() *tls.Config {
+	if config := upstreamTLS.Load(); config != nil {
+		return config.Clone()
+	}
+	return nil
+}
+
+// UpstreamTLSRestricted reports whether upstream TLS options are configured.
+func UpstreamTLSRestricted() bool {
+	return upstreamTLS.Load() != nil
+}
+
+// upstreamDialer returns the websocket dialer for upstream streams.
+func upstreamDialer() *websocket.Dialer {
+	d := *websocket.DefaultDialer
+	d.TLSClientConfig = UpstreamTLSConfig()
+	d.Proxy = upstreamWsProxy
+	d.NetDialContext = UpstreamDialContext
+	return &d
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"binance-proxy/internal/metrics"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	spot "github.com/adshao/go-binance/v2"
+	futures "github.com/adshao/go-binance/v2/futures"
+)
+
+const (
+	// defaultPollInterval is how often polled klines, depth and tickers are
+	// refreshed through REST.
+	defaultPollInterval = 2 * time.Second
+	// pollDepthLimit matches the 20 levels of the depth websocket stream.
+	pollDepthLimit = 20
+	// autoPollingFailures is how many websocket dials of a class must fail
+	// in a row, without any succeeding, before auto mode switches the class
+	// to REST polling.
+	autoPollingFailures = 10
+)
+
+var (
+	pollInterval atomic.Int64
+
+	pollingClasses sync.Map // Class -> true while the class polls
+	autoPolling    atomic.Bool
+
+	dialFailuresMu sync.Mutex
+	dialFailures   = map[Class]int{}
+)
+
+// SetRESTPolling selects the classes whose klines, depth and tickers are
+// refreshed through scheduled REST requests instead of websockets: spot,
+// futures, or auto to switch a class once its websocket dials keep failing.
+func SetRESTPolling(modes []string) error {
+	for _, mode := range modes {
+		switch strings.ToLower(strings.TrimSpace(mode)) {
+		case "spot":
+			startPolling(SPOT)
+		case "futures":
+			startPolling(FUTURES)
+		case "auto":
+			autoPolling.Store(true)
+		case "":
+		default:
+			return fmt.Errorf("invalid REST polling mode %q, expected spot, futures or auto", mode)
+		}
+	}
+	return nil
+}
+
+// SetPollInterval sets how often polled streams are refreshed.
+func SetPollInterval(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("invalid poll interval %s, expected a positive duration", d)
+	}
+	pollInterval.Store(int64(d))
+	return nil
+}
+
+func currentPollInterval() time.Duration {
+	if d := pollInterval.Load(); d > 0 {
+		return time.Duration(d)
+	}
+	return defaultPollInterval
+}
+
+// Polling reports whether the streams of class are refreshed through REST.
+func Polling(class Class) bool {
+	_, ok := pollingClasses.Load(class)
+	return ok
+}
+
+func startPolling(class Class) {
+	if _, loaded := pollingClasses.LoadOrStore(class, true); !loaded {
+		metrics.RESTPolling.WithLabelValues(string(class)).Set(1)
+	}
+}
+
+// dialResult counts the outcome of a websocket dial of class. With auto
+// polling, a run of autoPollingFailures failed dials switches the class to
+// REST polling until the proxy restarts.
+func dialResult(class Class, err error) {
+	dialFailuresMu.Lock()
+	defer dialFailuresMu.Unlock()
+
+	if err == nil {
+		dialFailures[class] = 0
+		return
+	}
+	dialFailures[class]++
+	if autoPolling.Load() && dialFailures[class] == autoPollingFailures && !Polling(class) {
+		log.Warnf("%s websockets failed to connect %d times in a row, switching klines, depth and tickers to REST polling every %s", class, autoPollingFailures, currentPollInterval())
+		startPolling(class)
+	}
+}
+
+// pollServe calls poll now and then every poll interval until stopped, with
+// the same channel semantics as the go-binance Ws*Serve functions. Failed
+// polls go to errHandler and are retried at the next interval.
+func pollServe(ctx context.Context, poll func(ctx context.Context) error, errHandler func(error)) (doneC, stopC chan struct{}, err error) {
+	doneC = make(chan struct{})
+	stopC = make(chan struct{}, 1)
+	go func() {
+		defer close(doneC)
+		t := time.NewTicker(currentPollInterval())
+		defer t.Stop()
+		for {
+			if err := poll(ctx); err != nil && ctx.Err() == nil {
+				errHandler(err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-stopC:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return doneC, stopC, nil
+}
+
+// errPollBanned stops polls while the API bans the proxy.
+var errPollBanned = fmt.Errorf("REST poll skipped during an API ban")
+
+// pollKlines fetches the last two candles, so the close of the previous one
+// isn't missed when a new one starts between polls.
+func (s *KlinesSrv) pollKlines(ctx context.Context) error {
+	banDetector := GetBanDetector()
+	if banDetector.IsBanned(s.si.Class) {
+		return errPollBanned
+	}
+	klines, err := s.fetchKlines(ctx, 2, 0)
+	if banDetector.CheckResponse(s.si.Class, nil, err) || err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	for _, k := range klines {
+		k.Final = k.CloseTime < now
+		s.wsHandler(k)
+	}
+	return nil
+}
+
+func (s *DepthSrv) pollDepth(ctx context.Context) error {
+	banDetector := GetBanDetector()
+	if banDetector.IsBanned(s.si.Class) {
+		return errPollBanned
+	}
+	query := url.Values{"symbol": {s.si.Symbol}, "limit": {fmt.Sprint(pollDepthLimit)}}
+	if s.si.Class == SPOT {
+		initRateWait(ctx, s.si.Class, "/api/v3/depth", query)
+		client := spot.NewClient("", "")
+		client.HTTPClient = getHTTPClient()
+		res, err := client.NewDepthService().Symbol(s.si.Symbol).Limit(pollDepthLimit).Do(ctx)
+		if banDetector.CheckResponse(s.si.Class, nil, err) || err != nil {
+			return err
+		}
+		now := time.Now().UnixMilli()
+		s.update(&Depth{LastUpdateID: res.LastUpdateID, Time: now, TradeTime: now, Bids: res.Bids, Asks: res.Asks}, 0, true)
+		return nil
+	}
+
+	initRateWait(ctx, s.si.Class, "/fapi/v1/depth", query)
+	client := futures.NewClient("", "")
+	client.HTTPClient = getHTTPClient()
+	res, err := client.NewDepthService().Symbol(s.si.Symbol).Limit(pollDepthLimit).Do(ctx)
+	if banDetector.CheckResponse(s.si.Class, nil, err) || err != nil {
+		return err
+	}
+	s.update(&Depth{
+		LastUpdateID: res.LastUpdateID,
+		Time:         res.Time,
+		TradeTime:    res.TradeTime,
+		Bids:         res.Bids,
+		Asks:         res.Asks,
+	}, 0, true)
+	return nil
+}
+
+func (s *TickerSrv) pollTicker24hr(ctx context.Context) error {
+	banDetector := GetBanDetector()
+	if banDetector.IsBanned(s.si.Class) {
+		return errPollBanned
+	}
+	if s.si.Class == FUTURES {
+		return s.pollFuturesTicker24hr(ctx)
+	}
+	initRateWait(ctx, s.si.Class, "/api/v3/ticker/24hr", url.Values{"symbol": {s.si.Symbol}})
+	client := spot.NewClient("", "")
+	client.HTTPClient = getHTTPClient()
+	res, err := client.NewListPriceChangeStatsService().Symbol(s.si.Symbol).Do(ctx)
+	if banDetector.CheckResponse(s.si.Class, nil, err) || err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		return fmt.Errorf("empty 24hr ticker response")
+	}
+	st := res[0]
+	s.setTicker24hr(&Ticker24hr{
+		Symbol:             st.Symbol,
+		PriceChange:        st.PriceChange,
+		PriceChangePercent: st.PriceChangePercent,
+		WeightedAvgPrice:   st.WeightedAvgPrice,
+		PrevClosePrice:     st.PrevClosePrice,
+		LastPrice:          st.LastPrice,
+		LastQty:            st.LastQty,
+		BidPrice:           st.BidPrice,
+		AskPrice:           st.AskPrice,
+		OpenPrice:          st.OpenPrice,
+		HighPrice:          st.HighPrice,
+		LowPrice:           st.LowPrice,
+		Volume:             st.Volume,
+		QuoteVolume:        st.QuoteVolume,
+		OpenTime:           st.OpenTime,
+		CloseTime:          st.CloseTime,
+		FirstID:            st.FirstID,
+		LastID:             st.LastID,
+		Count:              st.Count,
+	})
+	return nil
+}
+
+func (s *TickerSrv) pollBookTicker(ctx context.Context) error {
+	banDetector := GetBanDetector()
+	if banDetector.IsBanned(s.si.Class) {
+		return errPollBanned
+	}
+	if s.si.Class == FUTURES {
+		return s.pollFuturesBookTicker(ctx)
+	}
+	initRateWait(ctx, s.si.Class, "/api/v3/ticker/bookTicker", url.Values{"symbol": {s.si.Symbol}})
+	client := spot.NewClient("", "")
+	client.HTTPClient = getHTTPClient()
+	res, err := client.NewListBookTickersService().Symbol(s.si.Symbol).Do(ctx)
+	if banDetector.CheckResponse(s.si.Class, nil, err) || err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		return fmt.Errorf("empty bookTicker response")
+	}
+	s.setBookTicker(&BookTicker{
+		Symbol:      res[0].Symbol,
+		BidPrice:    res[0].BidPrice,
+		BidQuantity: res[0].BidQuantity,
+		AskPrice:    res[0].AskPrice,
+		AskQuantity: res[0].AskQuantity,
+	})
+	return nil
+}
+
+func (s *TickerSrv) pollFuturesTicker24hr(ctx context.Context) error {
+	banDetector := GetBanDetector()
+	initRateWait(ctx, s.si.Class, "/fapi/v1/ticker/24hr", url.Values{"symbol": {s.si.Symbol}})
+	client := futures.NewClient("", "")
+	client.HTTPClient = getHTTPClient()
+	res, err := client.NewListPriceChangeStatsService().Symbol(s.si.Symbol).Do(ctx)
+	if banDetector.CheckResponse(s.si.Class, nil, err) || err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		return fmt.Errorf("empty 24hr ticker response")
+	}
+	st := res[0]
+	s.setTicker24hr(&Ticker24hr{
+		Symbol:             st.Symbol,
+		PriceChange:        st.PriceChange,
+		PriceChangePercent: st.PriceChangePercent,
+		WeightedAvgPrice:   st.WeightedAvgPrice,
+		PrevClosePrice:     st.PrevClosePrice,
+		LastPrice:          st.LastPrice,
+		LastQty:            st.LastQuantity,
+		OpenPrice:          st.OpenPrice,
+		HighPrice:          st.HighPrice,
+		LowPrice:           st.LowPrice,
+		Volume:             st.Volume,
+		QuoteVolume:        st.QuoteVolume,
+		OpenTime:           st.OpenTime,
+		CloseTime:          st.CloseTime,
+		FirstID:            st.FirstID,
+		LastID:             st.LastID,
+		Count:              st.Count,
+	})
+	return nil
+}
+
+func (s *TickerSrv) pollFuturesBookTicker(ctx context.Context) error {
+	banDetector := GetBanDetector()
+	initRateWait(ctx, s.si.Class, "/fapi/v1/ticker/bookTicker", url.Values{"symbol": {s.si.Symbol}})
+	client := futures.NewClient("", "")
+	client.HTTPClient = getHTTPClient()
+	res, err := client.NewListBookTickersService().Symbol(s.si.Symbol).Do(ctx)
+	if banDetector.CheckResponse(s.si.Class, nil, err) || err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		return fmt.Errorf("empty bookTicker response")
+	}
+	s.setBookTicker(&BookTicker{
+		Symbol:      res[0].Symbol,
+		BidPrice:    res[0].BidPrice,
+		BidQuantity: res[0].BidQuantity,
+		AskPrice:    res[0].AskPrice,
+		AskQuantity: res[0].AskQuantity,
+	})
+	return nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// relatedIntervals are the kline intervals started together with the first
+// kline request of a symbol.
+var relatedIntervals atomic.Pointer[[]string]
+
+// SetRelatedIntervals makes the first kline request for a symbol also start
+// the klines of these intervals for the symbol in the background. Strategies
+// usually ask for several timeframes right after another, and each would
+// otherwise wait for its own initialization.
+func SetRelatedIntervals(intervals []string) error {
+	var list []string
+	for _, interval := range intervals {
+		interval = strings.TrimSpace(interval)
+		if interval == "" {
+			continue
+		}
+		if _, ok := INTERVAL_2_DURATION[interval]; !ok {
+			return fmt.Errorf("unsupported related interval %q", interval)
+		}
+		list = append(list, interval)
+	}
+	relatedIntervals.Store(&list)
+	return nil
+}
+
+// prefetchRelated starts the klines of the related intervals of symbol that
+// aren't running yet. They close after idling like any other klines.
+func (s *Service) prefetchRelated(symbol, requested string) {
+	list := relatedIntervals.Load()
+	if list == nil {
+		return
+	}
+	for _, interval := range *list {
+		if interval == requested {
+			continue
+		}
+		si := NewSymbolInterval(s.class, symbol, interval)
+		if _, ok := s.klinesSrv.Load(*si); ok {
+			continue
+		}
+		log.Debugf("%s %s@%s klines prefetched along with %s", s.class, symbol, interval, requested)
+		s.klinesSrvFor(si)
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// prewarmEntry is a symbol from --warm-symbols, with the kline interval to
+// keep open if one was given.
+type prewarmEntry struct {
+	Symbol   string
+	Interval string
+}
+
+var (
+	prewarmMu sync.RWMutex
+	prewarm   []prewarmEntry
+)
+
+// SetWarmSymbols configures streams that are started at boot and never closed
+// for idling. Entries are SYMBOL for depth and ticker or SYMBOL:interval to
+// also keep the klines of that interval.
+func SetWarmSymbols(entries []string) error {
+	var list []prewarmEntry
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		symbol, interval, _ := strings.Cut(entry, ":")
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		interval = strings.TrimSpace(interval)
+		if symbol == "" {
+			return fmt.Errorf("invalid warm symbol %q", entry)
+		}
+		if _, ok := INTERVAL_2_DURATION[interval]; interval != "" && !ok {
+			return fmt.Errorf("invalid warm symbol %q: unsupported interval %q", entry, interval)
+		}
+		list = append(list, prewarmEntry{Symbol: symbol, Interval: interval})
+	}
+
+	prewarmMu.Lock()
+	defer prewarmMu.Unlock()
+	prewarm = list
+	return nil
+}
+
+// isPrewarmed reports whether the stream of si belongs to a warm symbol.
+// Klines only match the configured interval.
+func isPrewarmed(kind string, si symbolInterval) bool {
+	prewarmMu.RLock()
+	defer prewarmMu.RUnlock()
+
+	for _, e := range prewarm {
+		if e.Symbol != si.Symbol {
+			continue
+		}
+		if kind != StreamKline || e.Interval == si.Interval {
+			return true
+		}
+	}
+	return false
+}
+
+// startPrewarmed opens the streams of all warm symbols for this class.
+func (s *Service) startPrewarmed() {
+	prewarmMu.RLock()
+	list := prewarm
+	prewarmMu.RUnlock()
+
+	depth := map[string]bool{}
+	for _, e := range list {
+		if e.Interval != "" {
+			s.klinesSrvFor(NewSymbolInterval(s.class, e.Symbol, e.Interval))
+		}
+		if depth[e.Symbol] {
+			continue
+		}
+		depth[e.Symbol] = true
+		s.depthSrvFor(NewSymbolInterval(s.class, e.Symbol, ""))
+		if s.class == SPOT {
+			s.tickerSrvFor(NewSymbolInterval(s.class, e.Symbol, ""))
+		}
+	}
+
+	if len(list) > 0 {
+		log.Infof("%s pre-warming websockets for %d watchlist entries", s.class, len(list))
+	}
+}
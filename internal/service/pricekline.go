@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	futures "github.com/adshao/go-binance/v2/futures"
+)
+
+// Prices of futures klines besides the trade price.
+const (
+	KlinePriceMark  = "mark"
+	KlinePriceIndex = "index"
+)
+
+// PriceKlines returns the cached mark or index price klines of a futures
+// symbol, the pair for index prices, starting their stream if needed. An
+// empty price returns the trade klines, like Klines.
+func (s *Service) PriceKlines(ctx context.Context, price, symbol, interval string) []*Kline {
+	if price == "" {
+		return s.Klines(ctx, symbol, interval)
+	}
+	if s.class != FUTURES || s.exchangeInfoSrv.isDelisted(symbol) {
+		return nil
+	}
+	si := NewSymbolInterval(s.class, symbol, interval)
+	si.Price = price
+	srv := s.klinesSrvFor(si)
+	if isStale(StreamKline, *si, &srv.streamClock) {
+		return nil
+	}
+	return srv.GetKlines(ctx)
+}
+
+// priceKlinesPath is the REST path of the klines of price, and the name of
+// its symbol parameter.
+func priceKlinesPath(price string) (path, param string) {
+	if price == KlinePriceIndex {
+		return "/fapi/v1/indexPriceKlines", "pair"
+	}
+	return "/fapi/v1/markPriceKlines", "symbol"
+}
+
+// priceKlineStreamName is the stream of mark or index price klines. Their
+// events decode like trade kline events, with zero volumes.
+func priceKlineStreamName(si *symbolInterval) string {
+	return fmt.Sprintf("%s@%sPriceKline_%s", strings.ToLower(si.Symbol), si.Price, si.Interval)
+}
+
+// priceServe subscribes to the mark or index price klines. go-binance has no
+// serve function for them, so they always go through the combined
+// connections.
+func (s *KlinesSrv) priceServe() (doneC, stopC chan struct{}, err error) {
+	return serveJSON(s.ctx, s.si.Class, priceKlineStreamName(s.si), func(event *futures.WsKlineEvent) { s.wsHandler(event) }, s.errHandler)
+}
+
+// fetchPriceKlines is fetchKlines for mark and index price klines. go-binance
+// drops the number of price samples of their rows, so they are decoded here.
+func (s *KlinesSrv) fetchPriceKlines(ctx context.Context, limit int, endTime int64) ([]*Kline, error) {
+	path, param := priceKlinesPath(s.si.Price)
+	query := url.Values{param: {s.si.Symbol}, "interval": {s.si.Interval}, "limit": {strconv.Itoa(limit)}}
+	if endTime > 0 {
+		query.Set("endTime", strconv.FormatInt(endTime, 10))
+	}
+	if err := initRateWait(ctx, s.si.Class, path, query); err != nil {
+		return nil, err
+	}
+
+	u := UpstreamURL(FUTURES)
+	u.Path, u.RawQuery = path, query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := getHTTPClient().Do(req)
+	if GetBanDetector().CheckResponse(s.si.Class, resp, err) || err != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if err == nil {
+			err = fmt.Errorf("%s answered %s", path, resp.Status)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", path, resp.Status)
+	}
+
+	var rows [][]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, err
+	}
+	klines := make([]*Kline, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 11 {
+			return nil, fmt.Errorf("invalid kline row in %s response", path)
+		}
+		k := &Kline{}
+		fields := []interface{}{
+			&k.OpenTime, &k.Open, &k.High, &k.Low, &k.Close, &k.Volume, &k.CloseTime,
+			&k.QuoteAssetVolume, &k.TradeNum, &k.TakerBuyBaseAssetVolume, &k.TakerBuyQuoteAssetVolume,
+		}
+		for i, field := range fields {
+			if err := json.Unmarshal(row[i], field); err != nil {
+				return nil, fmt.Errorf("invalid kline row in %s response: %w", path, err)
+			}
+		}
+		klines = append(klines, k)
+	}
+	return klines, nil
+}
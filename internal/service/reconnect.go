@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tier orders websocket reconnects after a disconnect. Lower tiers are
+// redialed first.
+type Tier int
+
+const (
+	TierPinned Tier = iota // symbols configured via --pinned-symbols
+	TierWarm               // streams requested within warmWindow
+	TierCold               // everything else
+
+	tierCount
+)
+
+func (t Tier) String() string {
+	switch t {
+	case TierPinned:
+		return "pinned"
+	case TierWarm:
+		return "warm"
+	default:
+		return "cold"
+	}
+}
+
+// warmWindow is how recently a stream must have been requested to be
+// considered warm when it needs to reconnect.
+const warmWindow = time.Minute
+
+var (
+	pinnedSymbols   = map[string]struct{}{}
+	pinnedSymbolsMu sync.RWMutex
+
+	pacer = &reconnectPacer{
+		stagger: 50 * time.Millisecond,
+		wake:    make(chan struct{}, 1),
+	}
+)
+
+// SetPinnedSymbols configures the symbols whose streams always reconnect first.
+func SetPinnedSymbols(symbols []string) {
+	pinned := make(map[string]struct{}, len(symbols))
+	for _, symbol := range symbols {
+		if symbol = strings.ToUpper(strings.TrimSpace(symbol)); symbol != "" {
+			pinned[symbol] = struct{}{}
+		}
+	}
+
+	pinnedSymbolsMu.Lock()
+	defer pinnedSymbolsMu.Unlock()
+	pinnedSymbols = pinned
+}
+
+func isPinned(symbol string) bool {
+	pinnedSymbolsMu.RLock()
+	defer pinnedSymbolsMu.RUnlock()
+	_, ok := pinnedSymbols[strings.ToUpper(symbol)]
+	return ok
+}
+
+// SetReconnectStagger sets the pause between two queued websocket reconnects.
+func SetReconnectStagger(d time.Duration) {
+	pacer.mu.Lock()
+	defer pacer.mu.Unlock()
+	pacer.stagger = d
+}
+
+// tierFunc returns a function reporting the current reconnect tier of si,
+// based on the pinned symbol list and the last time it was requested.
+func tierFunc(si symbolInterval, lastGet *sync.Map) func() Tier {
+	return func() Tier {
+		if isPinned(si.Symbol) {
+			return TierPinned
+		}
+		if t, ok := lastGet.Load(si); ok && time.Since(t.(time.Time)) < warmWindow {
+			return TierWarm
+		}
+		return TierCold
+	}
+}
+
+type pacerWaiter struct {
+	ctx   context.Context
+	ready chan struct{}
+}
+
+// reconnectPacer releases queued websocket reconnects one at a time, highest
+// tier first, so a network blip doesn't redial every stream at the same instant.
+type reconnectPacer struct {
+	mu      sync.Mutex
+	queues  [tierCount][]*pacerWaiter
+	stagger time.Duration
+	wake    chan struct{}
+	once    sync.Once
+}
+
+// awaitReconnect blocks until the pacer grants a reconnect slot for the given
+// tier. It returns false if ctx is canceled while waiting.
+func awaitReconnect(ctx context.Context, tier Tier) bool {
+	w := &pacerWaiter{ctx: ctx, ready: make(chan struct{})}
+
+	pacer.mu.Lock()
+	pacer.queues[tier] = append(pacer.queues[tier], w)
+	pacer.mu.Unlock()
+
+	pacer.once.Do(func() { go pacer.run() })
+	select {
+	case pacer.wake <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-w.ready:
+		return true
+	}
+}
+
+func (p *reconnectPacer) run() {
+	for {
+		w, stagger := p.next()
+		if w == nil {
+			<-p.wake
+			continue
+		}
+
+		close(w.ready)
+		time.Sleep(stagger)
+	}
+}
+
+// next pops the first live waiter of the highest non-empty tier.
+func (p *reconnectPacer) next() (*pacerWaiter, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for tier := range p.queues {
+		for len(p.queues[tier]) > 0 {
+			w := p.queues[tier][0]
+			p.queues[tier][0] = nil
+			p.queues[tier] = p.queues[tier][1:]
+			if w.ctx.Err() == nil {
+				return w, p.stagger
+			}
+		}
+	}
+
+	return nil, 0
+}
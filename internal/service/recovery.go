@@ -0,0 +1,298 @@
+package service
+
+import (
+	"binance-proxy/internal/notify"
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// goroutinesPerStream is roughly how many goroutines a healthy websocket
+// service keeps running (stream loop, SDK reader and keepalive).
+const goroutinesPerStream = 4
+
+// HealthSignals are the inputs of the auto recovery health score.
+type HealthSignals struct {
+	Streams            int     `json:"streams"`
+	StaleStreams       int     `json:"stale_streams"`
+	Banned             bool    `json:"banned"`
+	LimiterSaturation  float64 `json:"limiter_saturation"`
+	Goroutines         int     `json:"goroutines"`
+	ExpectedGoroutines int     `json:"expected_goroutines"`
+}
+
+// RecoveryWeights weigh each health signal in the score. Every signal is
+// normalized to [0,1] before weighting.
+type RecoveryWeights struct {
+	Stale      float64 `json:"stale"`
+	Ban        float64 `json:"ban"`
+	Limiter    float64 `json:"limiter"`
+	Goroutines float64 `json:"goroutines"`
+}
+
+// DefaultRecoveryWeights favors stale streams, the signal a re-initialization
+// actually fixes; a ban or a busy limiter alone never triggers a recovery.
+var DefaultRecoveryWeights = RecoveryWeights{Stale: 0.5, Ban: 0.1, Limiter: 0.1, Goroutines: 0.3}
+
+// ParseRecoveryWeights parses "stale=0.5,ban=0.1,..." on top of the defaults.
+func ParseRecoveryWeights(s string) (RecoveryWeights, error) {
+	w := DefaultRecoveryWeights
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if !ok || err != nil || f < 0 {
+			return w, fmt.Errorf("invalid recovery weight %q", part)
+		}
+		switch strings.TrimSpace(name) {
+		case "stale":
+			w.Stale = f
+		case "ban":
+			w.Ban = f
+		case "limiter":
+			w.Limiter = f
+		case "goroutines":
+			w.Goroutines = f
+		default:
+			return w, fmt.Errorf("unknown recovery weight %q, valid names are stale, ban, limiter and goroutines", name)
+		}
+	}
+	return w, nil
+}
+
+// Score combines the signals into a value between 0 (healthy) and the sum of
+// the weights.
+func (h HealthSignals) Score(w RecoveryWeights) float64 {
+	score := 0.0
+	if h.Streams > 0 {
+		score += w.Stale * float64(h.StaleStreams) / float64(h.Streams)
+	}
+	if h.Banned {
+		score += w.Ban
+	}
+	score += w.Limiter * clamp01(h.LimiterSaturation)
+	if h.ExpectedGoroutines > 0 {
+		// 0 at the expected count, 1 at three times as many
+		growth := float64(h.Goroutines)/float64(h.ExpectedGoroutines) - 1
+		score += w.Goroutines * clamp01(growth/2)
+	}
+	return score
+}
+
+func clamp01(f float64) float64 {
+	if f < 0 {
+		return 0
+	}
+	if f > 1 {
+		return 1
+	}
+	return f
+}
+
+// LimiterSaturation returns how much of the upstream weight budget of the
+// class is used up in the current minute, between 0 and 1.
+func LimiterSaturation(class Class) float64 {
+	return budgetFor(class).saturation()
+}
+
+// HealthSignals collects the current health signals of the service. Streams
+// that haven't delivered a message for staleAfter count as stale.
+func (s *Service) HealthSignals(staleAfter time.Duration, baseGoroutines int) HealthSignals {
+	now := time.Now()
+	h := HealthSignals{
+		Banned:            GetBanDetector().IsBanned(s.class),
+		LimiterSaturation: LimiterSaturation(s.class),
+		Goroutines:        runtime.NumGoroutine(),
+	}
+
+	count := func(c *streamClock) {
+		h.Streams++
+		if c.stale(now, staleAfter) {
+			h.StaleStreams++
+		}
+	}
+	s.klinesSrv.Range(func(k, v interface{}) bool {
+		count(&v.(*KlinesSrv).streamClock)
+		return true
+	})
+	s.depthSrv.Range(func(k, v interface{}) bool {
+		count(&v.(*DepthSrv).streamClock)
+		return true
+	})
+	s.tickerSrv.Range(func(k, v interface{}) bool {
+		count(&v.(*TickerSrv).streamClock)
+		return true
+	})
+	s.tradesSrv.Range(func(k, v interface{}) bool {
+		count(&v.(*TradesSrv).streamClock)
+		return true
+	})
+
+	// Goroutines are counted for the whole process, so compare them against
+	// the streams of all classes
+	h.ExpectedGoroutines = baseGoroutines + goroutinesPerStream*int(activeStreams.Load())
+	return h
+}
+
+// RecoveryConfig configures an AutoRecovery.
+type RecoveryConfig struct {
+	Interval   time.Duration   // time between health checks
+	Threshold  float64         // score at or above which a check fails
+	Failures   int             // consecutive failed checks before recovering
+	Cooldown   time.Duration   // minimum time between two recoveries
+	StaleAfter time.Duration   // silence after which a stream counts as stale
+	Weights    RecoveryWeights // weight of each signal in the score
+}
+
+// RecoveryStats describes the state of an AutoRecovery.
+type RecoveryStats struct {
+	Class           Class           `json:"class"`
+	Enabled         bool            `json:"enabled"`
+	Threshold       float64         `json:"threshold"`
+	Weights         RecoveryWeights `json:"weights"`
+	LastCheck       time.Time       `json:"last_check"`
+	LastScore       float64         `json:"last_score"`
+	LastSignals     HealthSignals   `json:"last_signals"`
+	FailedChecks    int             `json:"failed_checks"`
+	Recoveries      int             `json:"recoveries"`
+	LastRecovery    time.Time       `json:"last_recovery"`
+	NextRecoveryMin time.Time       `json:"next_recovery_min"`
+}
+
+// AutoRecovery periodically scores the health of a service and calls restart
+// once the score stayed above the threshold for several checks.
+type AutoRecovery struct {
+	class   Class
+	config  RecoveryConfig
+	signals func() HealthSignals
+	restart func()
+
+	mu    sync.Mutex
+	stats RecoveryStats
+}
+
+// NewAutoRecovery creates an AutoRecovery; signals is called for every check
+// and restart performs the recovery.
+func NewAutoRecovery(class Class, config RecoveryConfig, signals func() HealthSignals, restart func()) *AutoRecovery {
+	if config.Failures < 1 {
+		config.Failures = 1
+	}
+	return &AutoRecovery{
+		class:   class,
+		config:  config,
+		signals: signals,
+		restart: restart,
+		stats: RecoveryStats{
+			Class:     class,
+			Enabled:   true,
+			Threshold: config.Threshold,
+			Weights:   config.Weights,
+		},
+	}
+}
+
+// Run checks the health until ctx is done.
+func (a *AutoRecovery) Run(ctx context.Context) {
+	t := time.NewTicker(a.config.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			a.check()
+		}
+	}
+}
+
+func (a *AutoRecovery) check() {
+	h := a.signals()
+	score := h.Score(a.config.Weights)
+	now := time.Now()
+
+	a.mu.Lock()
+	a.stats.LastCheck = now
+	a.stats.LastScore = score
+	a.stats.LastSignals = h
+	if score < a.config.Threshold {
+		if a.stats.FailedChecks > 0 {
+			log.Infof("%s health recovered (score %.2f)", a.class, score)
+		}
+		a.stats.FailedChecks = 0
+		a.mu.Unlock()
+		incidents.end(a.class, incidentRecovery, "health-recovered", now)
+		return
+	}
+
+	a.stats.FailedChecks++
+	if a.stats.FailedChecks == 1 {
+		used, limit, _ := GetBanDetector().GetWeightInfo(a.class)
+		incidents.begin(a.class, incidentRecovery, "health-score", used, limit)
+	}
+	log.Warnf("%s health check failed (score %.2f, threshold %.2f, %d/%d stale streams, %d goroutines)",
+		a.class, score, a.config.Threshold, h.StaleStreams, h.Streams, h.Goroutines)
+
+	if a.stats.FailedChecks < a.config.Failures || now.Before(a.stats.NextRecoveryMin) {
+		a.mu.Unlock()
+		return
+	}
+	a.stats.FailedChecks = 0
+	a.stats.Recoveries++
+	a.stats.LastRecovery = now
+	a.stats.NextRecoveryMin = now.Add(a.config.Cooldown)
+	a.mu.Unlock()
+
+	log.Warnf("%s auto recovery: re-initializing websocket services", a.class)
+	notify.Send(notify.KindRecovery, string(a.class), "health score %.2f above threshold %.2f (%d/%d stale streams), re-initializing websocket services",
+		score, a.config.Threshold, h.StaleStreams, h.Streams)
+	a.restart()
+	incidents.end(a.class, incidentRecovery, "auto-recovery", time.Now())
+}
+
+// Stats returns a snapshot of the recovery state.
+func (a *AutoRecovery) Stats() RecoveryStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stats
+}
+
+// Reinit replaces every running websocket service with a fresh instance,
+// keeping the set of open streams and their subscribers. Services are swapped
+// one by one with restartStream, so streams closed or replaced concurrently
+// (idle removal, the stream supervisor) are left alone.
+func (s *Service) Reinit() {
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	var keys []streamKey
+	collect := func(kind string) func(k, _ interface{}) bool {
+		return func(k, _ interface{}) bool {
+			keys = append(keys, streamKey{kind, k.(symbolInterval)})
+			return true
+		}
+	}
+	s.klinesSrv.Range(collect(StreamKline))
+	s.depthSrv.Range(collect(StreamDepth))
+	s.tickerSrv.Range(collect(StreamTicker))
+	s.tradesSrv.Range(collect(StreamTrade))
+
+	n := 0
+	for _, key := range keys {
+		if s.restartStream(key) {
+			n++
+		}
+	}
+
+	log.Infof("%s re-initialized %d websocket services", s.class, n)
+}
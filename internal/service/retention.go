@@ -0,0 +1,58 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// defaultKlineRetention is the number of klines kept per stream for
+	// intervals missing from the retention table.
+	defaultKlineRetention = 1000
+	// maxKlineRetention bounds the retention of an interval.
+	maxKlineRetention = 10000
+	// maxKlinesPerRequest is the most klines Binance returns per request.
+	maxKlinesPerRequest = 1000
+)
+
+var klineRetention atomic.Pointer[map[string]int]
+
+// SetKlineRetention sets how many klines are kept per interval, from entries
+// like "1d=2000" or "1m=600". "default=N" changes the count of the intervals
+// not listed. Streams started afterwards use the new counts.
+func SetKlineRetention(entries []string) error {
+	table := make(map[string]int)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		interval, count, ok := strings.Cut(entry, "=")
+		interval = strings.TrimSpace(interval)
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if !ok || err != nil || n < 1 || n > maxKlineRetention {
+			return fmt.Errorf("invalid kline retention %q, expected interval=count with a count from 1 to %d", entry, maxKlineRetention)
+		}
+		if _, known := INTERVAL_2_DURATION[interval]; !known && interval != "default" {
+			return fmt.Errorf("invalid kline retention %q: unsupported interval %q", entry, interval)
+		}
+		table[interval] = n
+	}
+	klineRetention.Store(&table)
+	return nil
+}
+
+// KlineRetention returns the number of klines kept for interval.
+func KlineRetention(interval string) int {
+	if table := klineRetention.Load(); table != nil {
+		if n, ok := (*table)[interval]; ok {
+			return n
+		}
+		if n, ok := (*table)["default"]; ok {
+			return n
+		}
+	}
+	return defaultKlineRetention
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"binance-proxy/internal/tool"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Subsystems with their own retry schedule.
+const (
+	ScheduleKlineInit    = "kline-init"
+	ScheduleExchangeInfo = "exchange-info"
+	ScheduleReconnect    = "reconnect"
+)
+
+var (
+	schedulesMu sync.RWMutex
+	schedules   = map[string][]time.Duration{}
+)
+
+// SetRetrySchedule sets the retry delays of a subsystem; an empty list
+// restores the default schedule.
+func SetRetrySchedule(subsystem string, delays []time.Duration) error {
+	switch subsystem {
+	case ScheduleKlineInit, ScheduleExchangeInfo, ScheduleReconnect:
+	default:
+		return fmt.Errorf("unknown retry schedule %q", subsystem)
+	}
+
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	schedules[subsystem] = delays
+	return nil
+}
+
+// retryIterator returns a DelayIterator following the subsystem's schedule.
+func retryIterator(subsystem string) *tool.DelayIterator {
+	schedulesMu.RLock()
+	defer schedulesMu.RUnlock()
+	return tool.NewScheduledDelayIterator(schedules[subsystem])
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"binance-proxy/internal/metrics"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// clockDriftWarning is the clock offset from Binance above which signed
+// requests risk being rejected for their recvWindow.
+const clockDriftWarning = time.Second
+
+// clockSync is the last measured offset of Binance's clock of a class.
+type clockSync struct {
+	offset atomic.Int64 // nanoseconds Binance is ahead of the local clock
+	synced atomic.Bool
+}
+
+var clockSyncs = map[Class]*clockSync{SPOT: {}, FUTURES: {}}
+
+// serverTimePath is the REST path of Binance's server time of class.
+func serverTimePath(class Class) string {
+	if class == SPOT {
+		return "/api/v3/time"
+	}
+	return "/fapi/v1/time"
+}
+
+// StartTimeSync measures the offset of Binance's clock for each class every
+// interval until ctx ends, so the server time can be answered locally.
+// Interval 0 leaves time requests to Binance.
+func StartTimeSync(ctx context.Context, classes []Class, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			for _, class := range classes {
+				if err := clockSyncs[class].sync(ctx, class); err != nil && ctx.Err() == nil {
+					log.Warnf("%s server time sync failed (error: %s), keeping the previous offset.", class, err)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// sync requests Binance's server time and takes it as the time halfway
+// through the round trip. Syncs are skipped during a ban.
+func (c *clockSync) sync(ctx context.Context, class Class) error {
+	banDetector := GetBanDetector()
+	if banDetector.IsBanned(class) {
+		return nil
+	}
+	path := serverTimePath(class)
+	if err := initRateWait(ctx, class, path, nil); err != nil {
+		return err
+	}
+	u := UpstreamURL(class)
+	u.Path = path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	resp, err := getHTTPClient().Do(req)
+	if banDetector.CheckResponse(class, resp, err) || err != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	rtt := time.Since(start)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("time answered %s", resp.Status)
+	}
+	var body struct {
+		ServerTime int64 `json:"serverTime"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+
+	offset := time.UnixMilli(body.ServerTime).Sub(start.Add(rtt / 2))
+	previous := time.Duration(c.offset.Swap(int64(offset)))
+	first := !c.synced.Swap(true)
+	metrics.ClockOffset.WithLabelValues(string(class)).Set(offset.Seconds())
+	if offset.Abs() > clockDriftWarning && (first || previous.Abs() <= clockDriftWarning) {
+		log.Warnf("%s local clock is off Binance's by %s, signed requests may be rejected.", class, offset.Round(time.Millisecond))
+	}
+	log.Debugf("%s server time synced, offset %s, round trip %s", class, offset.Round(time.Millisecond), rtt.Round(time.Millisecond))
+	return nil
+}
+
+// ServerTime estimates Binance's server time of class from the local clock
+// and the measured offset. It is false until the first sync succeeded.
+func ServerTime(class Class) (time.Time, bool) {
+	c, ok := clockSyncs[class]
+	if !ok || !c.synced.Load() {
+		return time.Time{}, false
+	}
+	return time.Now().Add(time.Duration(c.offset.Load())), true
+}
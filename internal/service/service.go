@@ -1,8 +1,11 @@
 package service
 
 import (
+	"binance-proxy/internal/metrics"
 	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -17,17 +20,22 @@ type Service struct {
 	klinesSrv       sync.Map // map[symbolInterval]*Klines
 	depthSrv        sync.Map // map[symbolInterval]*Depth
 	tickerSrv       sync.Map // map[symbolInterval]*Ticker
+	tradesSrv       sync.Map // map[symbolInterval]*Trades
 
 	lastGetKlines sync.Map // map[symbolInterval]time.Time
 	lastGetDepth  sync.Map // map[symbolInterval]time.Time
 	lastGetTicker sync.Map // map[symbolInterval]time.Time
+	lastGetTrades sync.Map // map[symbolInterval]time.Time
 }
 
 func NewService(ctx context.Context, class Class) *Service {
 	s := &Service{class: class}
 	s.ctx, s.cancel = context.WithCancel(ctx)
 	s.exchangeInfoSrv = NewExchangeInfoSrv(s.ctx, NewSymbolInterval(s.class, "", ""))
+	s.exchangeInfoSrv.onRefresh = s.exchangeInfoRefreshed
 	s.exchangeInfoSrv.Start()
+	s.startPrewarmed()
+	services.Store(class, s)
 
 	go func() {
 		t := time.NewTimer(time.Second)
@@ -39,6 +47,8 @@ func NewService(ctx context.Context, class Class) *Service {
 				return
 			case <-t.C:
 				s.autoRemoveExpired()
+				s.resubscribeStale()
+				s.exchangeInfoSrv.updateAgeMetric()
 				t.Reset(time.Second)
 			}
 		}
@@ -47,20 +57,37 @@ func NewService(ctx context.Context, class Class) *Service {
 	return s
 }
 
+// idleGrace is added to the idle time after which streams are closed.
+var idleGrace atomic.Int64
+
+// SetIdleGrace keeps idle streams open for d longer than the default of two
+// minutes (two intervals for klines), so clients that are away for a short
+// restart find their streams still running instead of causing a burst of
+// REST initializations when they return.
+func SetIdleGrace(d time.Duration) {
+	idleGrace.Store(int64(d))
+}
+
 func (s *Service) autoRemoveExpired() {
 	now := time.Now() // Cache time.Now() call
+	grace := time.Duration(idleGrace.Load())
 
+	// Services are only removed while still stored, a restart may have
+	// replaced them since Range loaded them
 	s.klinesSrv.Range(func(k, v interface{}) bool {
 		si := k.(symbolInterval)
 		srv := v.(*KlinesSrv)
 
-		if t, ok := s.lastGetKlines.Load(si); ok {
-			expiry := 2 * INTERVAL_2_DURATION[si.Interval]
-			if now.Sub(t.(time.Time)) > expiry {
+		if broker.hasSubscribers(StreamKline, si) || isPrewarmed(StreamKline, si) || isListingWarm(si, now) {
+			s.lastGetKlines.Store(si, now)
+		} else if t, ok := s.lastGetKlines.Load(si); ok {
+			expiry := 2*INTERVAL_2_DURATION[si.Interval] + grace
+			if now.Sub(t.(time.Time)) > expiry && s.klinesSrv.CompareAndDelete(si, v) {
 				log.Debugf("%s %s@%s kline websocket closed after being idle for %.0fs.", si.Class, si.Symbol, si.Interval, expiry.Seconds())
 				s.lastGetKlines.Delete(si)
-				s.klinesSrv.Delete(si)
 				srv.Stop()
+				streamStopped(si.Class, StreamKline)
+				metrics.StreamsIdleClosed.WithLabelValues(string(si.Class), StreamKline).Inc()
 			}
 		} else {
 			s.lastGetKlines.Store(si, now)
@@ -71,13 +98,16 @@ func (s *Service) autoRemoveExpired() {
 		si := k.(symbolInterval)
 		srv := v.(*DepthSrv)
 
-		if t, ok := s.lastGetDepth.Load(si); ok {
-			expiry := 2 * time.Minute
-			if now.Sub(t.(time.Time)) > expiry {
+		if broker.hasSubscribers(StreamDepth, si) || isPrewarmed(StreamDepth, si) {
+			s.lastGetDepth.Store(si, now)
+		} else if t, ok := s.lastGetDepth.Load(si); ok {
+			expiry := 2*time.Minute + grace
+			if now.Sub(t.(time.Time)) > expiry && s.depthSrv.CompareAndDelete(si, v) {
 				log.Debugf("%s %s depth websocket closed after being idle for %.0fs.", si.Class, si.Symbol, expiry.Seconds())
 				s.lastGetDepth.Delete(si)
-				s.depthSrv.Delete(si)
 				srv.Stop()
+				streamStopped(si.Class, StreamDepth)
+				metrics.StreamsIdleClosed.WithLabelValues(string(si.Class), StreamDepth).Inc()
 			}
 		} else {
 			s.lastGetDepth.Store(si, now)
@@ -88,60 +118,308 @@ func (s *Service) autoRemoveExpired() {
 		si := k.(symbolInterval)
 		srv := v.(*TickerSrv)
 
-		if t, ok := s.lastGetTicker.Load(si); ok {
-			expiry := 2 * time.Minute
-			if now.Sub(t.(time.Time)) > expiry {
+		if broker.hasSubscribers(StreamTicker, si) || isPrewarmed(StreamTicker, si) {
+			s.lastGetTicker.Store(si, now)
+		} else if t, ok := s.lastGetTicker.Load(si); ok {
+			expiry := 2*time.Minute + grace
+			if now.Sub(t.(time.Time)) > expiry && s.tickerSrv.CompareAndDelete(si, v) {
 				log.Debugf("%s %s ticker24hr websocket closed after being idle for %.0fs.", si.Class, si.Symbol, expiry.Seconds())
 				s.lastGetTicker.Delete(si)
-				s.tickerSrv.Delete(si)
 				srv.Stop()
+				streamStopped(si.Class, StreamTicker)
+				metrics.StreamsIdleClosed.WithLabelValues(string(si.Class), StreamTicker).Inc()
 			}
 		} else {
 			s.lastGetTicker.Store(si, now)
 		}
 		return true
 	})
+	s.tradesSrv.Range(func(k, v interface{}) bool {
+		si := k.(symbolInterval)
+		srv := v.(*TradesSrv)
+
+		if t, ok := s.lastGetTrades.Load(si); ok {
+			expiry := 2*time.Minute + grace
+			if now.Sub(t.(time.Time)) > expiry && s.tradesSrv.CompareAndDelete(si, v) {
+				log.Debugf("%s %s trade websocket closed after being idle for %.0fs.", si.Class, si.Symbol, expiry.Seconds())
+				s.lastGetTrades.Delete(si)
+				srv.Stop()
+				streamStopped(si.Class, StreamTrade)
+				metrics.StreamsIdleClosed.WithLabelValues(string(si.Class), StreamTrade).Inc()
+			}
+		} else {
+			s.lastGetTrades.Store(si, now)
+		}
+		return true
+	})
 }
 
-func (s *Service) Ticker(symbol string) *Ticker24hr {
-	si := NewSymbolInterval(s.class, symbol, "")
+// Close stops every websocket service of this class and waits until they have
+// disconnected or ctx expires. It returns the number of services that didn't
+// stop in time.
+func (s *Service) Close(ctx context.Context) int {
+	s.cancel()
+	services.CompareAndDelete(s.class, s)
+
+	var stopped []<-chan struct{}
+	s.klinesSrv.Range(func(k, v interface{}) bool {
+		stopped = append(stopped, v.(*KlinesSrv).Stopped())
+		return true
+	})
+	s.depthSrv.Range(func(k, v interface{}) bool {
+		stopped = append(stopped, v.(*DepthSrv).Stopped())
+		return true
+	})
+	s.tickerSrv.Range(func(k, v interface{}) bool {
+		stopped = append(stopped, v.(*TickerSrv).Stopped())
+		return true
+	})
+	s.tradesSrv.Range(func(k, v interface{}) bool {
+		stopped = append(stopped, v.(*TradesSrv).Stopped())
+		return true
+	})
+
+	pending := 0
+	for _, c := range stopped {
+		select {
+		case <-c:
+		case <-ctx.Done():
+			select {
+			case <-c:
+			default:
+				pending++
+			}
+		}
+	}
+	return pending
+}
+
+// activeStreams counts the running websocket services of all classes,
+// peakStreams is the highest count since start.
+var activeStreams, peakStreams atomic.Int64
+
+// PeakStreams returns the highest number of websocket services that ran at
+// the same time.
+func PeakStreams() int64 {
+	return peakStreams.Load()
+}
+
+func streamStarted(class Class, kind string) {
+	for n := activeStreams.Add(1); ; {
+		peak := peakStreams.Load()
+		if n <= peak || peakStreams.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	metrics.ActiveStreams.WithLabelValues(string(class), kind).Inc()
+}
+
+func streamStopped(class Class, kind string) {
+	activeStreams.Add(-1)
+	metrics.ActiveStreams.WithLabelValues(string(class), kind).Dec()
+}
+
+func (s *Service) tickerSrvFor(si *symbolInterval) *TickerSrv {
 	srv, loaded := s.tickerSrv.Load(*si)
 	if !loaded {
-		if srv, loaded = s.tickerSrv.LoadOrStore(*si, NewTickerSrv(s.ctx, si)); !loaded {
+		if srv, loaded = s.tickerSrv.LoadOrStore(*si, NewTickerSrv(s.ctx, si, tierFunc(*si, &s.lastGetTicker))); !loaded {
 			srv.(*TickerSrv).Start()
+			streamStarted(s.class, StreamTicker)
+			reportDuplicate(StreamTicker, si)
 		}
 	}
 	s.lastGetTicker.Store(*si, time.Now())
 
-	return srv.(*TickerSrv).GetTicker()
-}
-
-func (s *Service) ExchangeInfo() []byte {
-	return s.exchangeInfoSrv.GetExchangeInfo()
+	return srv.(*TickerSrv)
 }
 
-func (s *Service) Klines(symbol, interval string) []*Kline {
-	si := NewSymbolInterval(s.class, symbol, interval)
+func (s *Service) klinesSrvFor(si *symbolInterval) *KlinesSrv {
 	srv, loaded := s.klinesSrv.Load(*si)
 	if !loaded {
-		if srv, loaded = s.klinesSrv.LoadOrStore(*si, NewKlinesSrv(s.ctx, si)); !loaded {
+		if srv, loaded = s.klinesSrv.LoadOrStore(*si, NewKlinesSrv(s.ctx, si, tierFunc(*si, &s.lastGetKlines))); !loaded {
 			srv.(*KlinesSrv).Start()
+			streamStarted(s.class, StreamKline)
+			reportDuplicate(StreamKline, si)
 		}
 	}
 	s.lastGetKlines.Store(*si, time.Now())
 
-	return srv.(*KlinesSrv).GetKlines()
+	return srv.(*KlinesSrv)
 }
 
-func (s *Service) Depth(symbol string) *Depth {
-	si := NewSymbolInterval(s.class, symbol, "")
+func (s *Service) depthSrvFor(si *symbolInterval) *DepthSrv {
 	srv, loaded := s.depthSrv.Load(*si)
 	if !loaded {
-		if srv, loaded = s.depthSrv.LoadOrStore(*si, NewDepthSrv(s.ctx, si)); !loaded {
+		if srv, loaded = s.depthSrv.LoadOrStore(*si, NewDepthSrv(s.ctx, si, tierFunc(*si, &s.lastGetDepth))); !loaded {
 			srv.(*DepthSrv).Start()
+			streamStarted(s.class, StreamDepth)
+			reportDuplicate(StreamDepth, si)
 		}
 	}
 	s.lastGetDepth.Store(*si, time.Now())
 
-	return srv.(*DepthSrv).GetDepth()
+	return srv.(*DepthSrv)
+}
+
+func (s *Service) tradesSrvFor(si *symbolInterval) *TradesSrv {
+	srv, loaded := s.tradesSrv.Load(*si)
+	if !loaded {
+		if srv, loaded = s.tradesSrv.LoadOrStore(*si, NewTradesSrv(s.ctx, si, tierFunc(*si, &s.lastGetTrades))); !loaded {
+			srv.(*TradesSrv).Start()
+			streamStarted(s.class, StreamTrade)
+			reportDuplicate(StreamTrade, si)
+		}
+	}
+	s.lastGetTrades.Store(*si, time.Now())
+
+	return srv.(*TradesSrv)
+}
+
+func (s *Service) Ticker(ctx context.Context, symbol string) *Ticker24hr {
+	if s.exchangeInfoSrv.isDelisted(symbol) {
+		return nil
+	}
+	si := NewSymbolInterval(s.class, symbol, "")
+	srv := s.tickerSrvFor(si)
+	if isStale(StreamTicker, *si, &srv.streamClock) {
+		return nil
+	}
+	return srv.GetTicker(ctx)
+}
+
+// CachedTicker returns the ticker of symbol if its stream has data, without
+// waiting for the initialization. Otherwise it returns nil and the stream is
+// started for the following requests.
+func (s *Service) CachedTicker(symbol string) *Ticker24hr {
+	if s.exchangeInfoSrv.isDelisted(symbol) {
+		return nil
+	}
+	si := NewSymbolInterval(s.class, symbol, "")
+	srv := s.tickerSrvFor(si)
+	if isStale(StreamTicker, *si, &srv.streamClock) {
+		return nil
+	}
+	return srv.snapshot()
+}
+
+// LastPrice is the last traded price of a symbol and the time of the 24hr
+// ticker it was taken from.
+type LastPrice struct {
+	Symbol string
+	Price  string
+	Time   int64
+}
+
+// CachedLastPrice is CachedTicker for the last traded price, as served by
+// the price ticker endpoints.
+func (s *Service) CachedLastPrice(symbol string) *LastPrice {
+	if s.exchangeInfoSrv.isDelisted(symbol) {
+		return nil
+	}
+	si := NewSymbolInterval(s.class, symbol, "")
+	srv := s.tickerSrvFor(si)
+	if isStale(StreamTicker, *si, &srv.streamClock) {
+		return nil
+	}
+	return srv.lastPrice()
+}
+
+func (s *Service) ExchangeInfo() []byte {
+	return s.exchangeInfoSrv.GetExchangeInfo()
+}
+
+// ExchangeInfoStale is ExchangeInfo that also reports whether the data is a
+// snapshot served until the first refresh succeeds, and when it was fetched
+// (zero if unknown).
+func (s *Service) ExchangeInfoStale() (data []byte, stale bool, updatedAt time.Time) {
+	return s.exchangeInfoSrv.getExchangeInfo()
+}
+
+// GzipExchangeInfo is ExchangeInfoStale compressed with gzip, nil when there
+// is no exchangeInfo yet.
+func (s *Service) GzipExchangeInfo() (gz []byte, stale bool, updatedAt time.Time) {
+	return s.exchangeInfoSrv.getGzipExchangeInfo()
+}
+
+// FilteredExchangeInfo is ExchangeInfoStale with only the given symbols, or
+// only the symbols having one of permissions. ok is false when there is no
+// exchangeInfo yet or one of the symbols isn't listed.
+func (s *Service) FilteredExchangeInfo(symbols, permissions []string) (data []byte, stale bool, updatedAt time.Time, ok bool) {
+	return s.exchangeInfoSrv.getFilteredExchangeInfo(symbols, permissions)
+}
+
+func (s *Service) Klines(ctx context.Context, symbol, interval string) []*Kline {
+	if s.exchangeInfoSrv.isDelisted(symbol) {
+		return nil
+	}
+	si := NewSymbolInterval(s.class, symbol, interval)
+	if _, ok := s.klinesSrv.Load(*si); !ok {
+		go s.prefetchRelated(symbol, interval)
+	}
+	srv := s.klinesSrvFor(si)
+	if isStale(StreamKline, *si, &srv.streamClock) {
+		return nil
+	}
+	return srv.GetKlines(ctx)
+}
+
+// Unlisted reports whether the current exchangeInfo doesn't list symbol. It
+// is false while there is no exchangeInfo to tell.
+func (s *Service) Unlisted(symbol string) bool {
+	status, loaded, err := s.exchangeInfoSrv.symbolStatus(symbol)
+	return loaded && err == nil && status == ""
+}
+
+func (s *Service) Depth(ctx context.Context, symbol string) *Depth {
+	if s.exchangeInfoSrv.isDelisted(symbol) {
+		return nil
+	}
+	si := NewSymbolInterval(s.class, symbol, "")
+	srv := s.depthSrvFor(si)
+	if isStale(StreamDepth, *si, &srv.streamClock) {
+		return nil
+	}
+	return srv.GetDepth(ctx)
+}
+
+// Trades returns up to limit of the latest trades of symbol, oldest first.
+func (s *Service) Trades(ctx context.Context, symbol string, limit int) []*Trade {
+	if s.exchangeInfoSrv.isDelisted(symbol) {
+		return nil
+	}
+	return s.tradesSrvFor(NewSymbolInterval(s.class, symbol, "")).GetTrades(ctx, limit)
+}
+
+// Subscribe starts the upstream websocket for the given stream if needed and
+// delivers its updates to ch until the returned function is called. Streams
+// with subscribers are never closed for idling.
+func (s *Service) Subscribe(kind, symbol, interval string, ch chan<- Update) (unsubscribe func(), err error) {
+	if s.exchangeInfoSrv.isDelisted(symbol) {
+		return nil, fmt.Errorf("symbol %s is delisted or halted", symbol)
+	}
+	var si *symbolInterval
+	switch kind {
+	case StreamKline:
+		if _, ok := INTERVAL_2_DURATION[interval]; !ok {
+			return nil, fmt.Errorf("unsupported interval %q", interval)
+		}
+		si = NewSymbolInterval(s.class, symbol, interval)
+		s.klinesSrvFor(si)
+	case StreamDepth:
+		si = NewSymbolInterval(s.class, symbol, "")
+		s.depthSrvFor(si)
+	case StreamTicker:
+		if s.class != SPOT {
+			return nil, fmt.Errorf("ticker stream is only available for %s", SPOT)
+		}
+		si = NewSymbolInterval(s.class, symbol, "")
+		s.tickerSrvFor(si)
+	default:
+		return nil, fmt.Errorf("unsupported stream %q", kind)
+	}
+
+	key := streamKey{kind: kind, si: *si}
+	broker.subscribe(key, ch)
+
+	return func() { broker.unsubscribe(key, ch) }, nil
 }
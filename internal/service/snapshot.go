@@ -0,0 +1,85 @@
+package service
+
+import (
+	"embed"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// snapshotSaveInterval is how often a refreshed exchangeInfo is written to
+// the snapshot directory.
+const snapshotSaveInterval = time.Hour
+
+//go:embed snapshots
+var embeddedSnapshots embed.FS
+
+var (
+	snapshotDirMu sync.RWMutex
+	snapshotDir   string
+)
+
+// SetExchangeInfoSnapshotDir sets the directory exchangeInfo snapshots are
+// read from at startup and saved to after refreshes, one file per class.
+func SetExchangeInfoSnapshotDir(dir string) {
+	snapshotDirMu.Lock()
+	defer snapshotDirMu.Unlock()
+	snapshotDir = dir
+}
+
+func snapshotFile(class Class) string {
+	return strings.ToLower(string(class)) + ".json"
+}
+
+// readSnapshot returns the newest exchangeInfo snapshot for the class from
+// the snapshot directory or, failing that, the one embedded in the binary,
+// with the time the file was written, zero for the embedded one.
+func readSnapshot(class Class) (data []byte, source string, modTime time.Time) {
+	snapshotDirMu.RLock()
+	dir := snapshotDir
+	snapshotDirMu.RUnlock()
+
+	if dir != "" {
+		path := filepath.Join(dir, snapshotFile(class))
+		if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
+			if fi, err := os.Stat(path); err == nil {
+				modTime = fi.ModTime()
+			}
+			return data, path, modTime
+		} else if err != nil && !os.IsNotExist(err) {
+			log.Warnf("%s exchangeInfo snapshot %s not readable (error: %s).", class, path, err)
+		}
+	}
+	if data, err := embeddedSnapshots.ReadFile("snapshots/" + snapshotFile(class)); err == nil && len(data) > 0 {
+		return data, "embedded snapshot", time.Time{}
+	}
+	return nil, "", time.Time{}
+}
+
+// saveSnapshot writes exchangeInfo to the snapshot directory, if one is set.
+func saveSnapshot(class Class, data []byte) error {
+	snapshotDirMu.RLock()
+	dir := snapshotDir
+	snapshotDirMu.RUnlock()
+	if dir == "" {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(dir, ".exchangeinfo-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, snapshotFile(class)))
+}
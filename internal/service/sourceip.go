@@ -0,0 +1,128 @@
+package service
+
+import (
+	"binance-proxy/internal/metrics"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Source IP modes of SetSourceIPs.
+const (
+	SourceIPRoundRobin = "round-robin"
+	SourceIPPerClass   = "per-class"
+)
+
+// sourceIPs are the local addresses connections to Binance are bound to, in
+// turn, per class.
+type sourceIPs struct {
+	addrs map[Class][]net.IP
+	next  map[Class]*atomic.Uint64
+}
+
+var (
+	sourceIPsMu     sync.RWMutex
+	upstreamSources *sourceIPs
+)
+
+// SetSourceIPs binds the connections to Binance to the given local
+// addresses, so Binance's IP based limits are spread over them. With
+// round-robin both classes take turns over all addresses; with per-class
+// spot uses the 1st, 3rd, ... address and futures the 2nd, 4th, ... Empty
+// ips leave the choice to the system.
+func SetSourceIPs(ips []string, mode string) error {
+	var addrs []net.IP
+	for _, s := range ips {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return fmt.Errorf("invalid source IP %q", s)
+		}
+		addrs = append(addrs, ip)
+	}
+	if len(addrs) == 0 {
+		sourceIPsMu.Lock()
+		upstreamSources = nil
+		sourceIPsMu.Unlock()
+		return nil
+	}
+
+	src := &sourceIPs{
+		addrs: map[Class][]net.IP{},
+		next:  map[Class]*atomic.Uint64{SPOT: {}, FUTURES: {}},
+	}
+	switch mode {
+	case SourceIPRoundRobin, "":
+		src.addrs[SPOT] = addrs
+		src.addrs[FUTURES] = addrs
+	case SourceIPPerClass:
+		for i, ip := range addrs {
+			class := SPOT
+			if i%2 == 1 {
+				class = FUTURES
+			}
+			src.addrs[class] = append(src.addrs[class], ip)
+		}
+		if len(src.addrs[FUTURES]) == 0 {
+			src.addrs[FUTURES] = src.addrs[SPOT]
+		}
+	default:
+		return fmt.Errorf("invalid source IP mode %q, expected %s or %s", mode, SourceIPRoundRobin, SourceIPPerClass)
+	}
+
+	sourceIPsMu.Lock()
+	upstreamSources = src
+	sourceIPsMu.Unlock()
+	return nil
+}
+
+// SourceIPsEnabled reports whether connections to Binance are bound to
+// configured local addresses.
+func SourceIPsEnabled() bool {
+	sourceIPsMu.RLock()
+	defer sourceIPsMu.RUnlock()
+	return upstreamSources != nil
+}
+
+// hostClass tells the class of a Binance host from its name: fapi, fstream
+// and the futures testnet are FUTURES, anything else SPOT.
+func hostClass(host string) Class {
+	if strings.Contains(host, "fapi") || strings.Contains(host, "fstream") || strings.Contains(host, "binancefuture") {
+		return FUTURES
+	}
+	return SPOT
+}
+
+// UpstreamDialContext dials connections to Binance from the next source
+// address of the class of addr's host.
+func UpstreamDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	sourceIPsMu.RLock()
+	src := upstreamSources
+	sourceIPsMu.RUnlock()
+	if src == nil {
+		return d.DialContext(ctx, network, addr)
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	class := hostClass(host)
+	addrs := src.addrs[class]
+	ip := addrs[(src.next[class].Add(1)-1)%uint64(len(addrs))]
+	d.LocalAddr = &net.TCPAddr{IP: ip}
+	// Stay within the family of the source address
+	if ip.To4() != nil {
+		network = "tcp4"
+	} else {
+		network = "tcp6"
+	}
+	metrics.SourceIPDials.WithLabelValues(string(class), ip.String()).Inc()
+	return d.DialContext(ctx, network, addr)
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"binance-proxy/internal/metrics"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// staleKlineIntervals is the kline silence, in intervals, after which a
+	// kline stream is stale, stored as float64 bits; 0 turns the guard off
+	staleKlineIntervals atomic.Uint64
+	// staleAfter is the silence after which a depth or ticker stream is stale
+	staleAfter atomic.Int64
+)
+
+// SetStaleGuard makes kline streams that received no message for
+// klineIntervals of their interval, and depth and ticker streams silent for
+// after, stale: requests for them are forwarded to Binance and the stream is
+// resubscribed. Zero turns the guard off for the respective streams.
+func SetStaleGuard(klineIntervals float64, after time.Duration) {
+	staleKlineIntervals.Store(math.Float64bits(klineIntervals))
+	staleAfter.Store(int64(after))
+}
+
+// staleThreshold returns the silence after which the stream is stale, 0 if it
+// is never considered stale.
+func staleThreshold(kind string, si symbolInterval) time.Duration {
+	switch kind {
+	case StreamKline:
+		n := math.Float64frombits(staleKlineIntervals.Load())
+		return time.Duration(n * float64(INTERVAL_2_DURATION[si.Interval]))
+	case StreamDepth, StreamTicker:
+		return time.Duration(staleAfter.Load())
+	}
+	return 0
+}
+
+// isStale reports whether the stream of si is stale, so requests for it are
+// better forwarded. Streams aren't stale during a ban, when their cached data
+// is all there is.
+func isStale(kind string, si symbolInterval, c *streamClock) bool {
+	after := staleThreshold(kind, si)
+	if after == 0 || !c.stale(time.Now(), after) || GetBanDetector().IsBanned(si.Class) {
+		return false
+	}
+	metrics.StaleStreamRequests.WithLabelValues(string(si.Class), kind).Inc()
+	return true
+}
+
+// resubscribeStale replaces the services of stale streams with fresh ones,
+// each at most once per stale threshold. The fresh service stays stale until
+// its first message arrives.
+func (s *Service) resubscribeStale() {
+	if GetBanDetector().IsBanned(s.class) {
+		return
+	}
+	now := time.Now()
+
+	due := func(kind string, si symbolInterval, c *streamClock) bool {
+		after := staleThreshold(kind, si)
+		if after == 0 || !c.stale(now, after) {
+			return false
+		}
+		return c.resubscribed.IsZero() || now.Sub(c.resubscribed) > after
+	}
+	resubscribed := func(kind string, si symbolInterval, c *streamClock) {
+		metrics.StaleStreamResubscribes.WithLabelValues(string(si.Class), kind).Inc()
+		log.Warnf("%s %s %s websocket silent since %s, resubscribing", si.Class, si.Symbol, kind, c.silentSince().Format(time.RFC3339))
+	}
+
+	for _, kind := range []string{StreamKline, StreamDepth, StreamTicker} {
+		m, _, _ := s.streamServices(kind)
+		m.Range(func(k, v interface{}) bool {
+			si, old := k.(symbolInterval), v.(streamService)
+			c := old.clock()
+			if !due(kind, si, c) {
+				return true
+			}
+			if s.replaceStream(kind, si, old, func(fresh streamService) { fresh.clock().inherit(c, now) }) {
+				resubscribed(kind, si, c)
+			}
+			return true
+		})
+	}
+}
+
+// StreamHealth is the state of one running stream.
+type StreamHealth struct {
+	Kind          string     `json:"kind"`
+	Symbol        string     `json:"symbol"`
+	Interval      string     `json:"interval,omitempty"`
+	Price         string     `json:"price,omitempty"`
+	Started       time.Time  `json:"started"`
+	Initialized   bool       `json:"initialized"`
+	Messages      int64      `json:"messages"`
+	Reconnects    int64      `json:"reconnects"`
+	LastMessage   *time.Time `json:"last_message,omitempty"`
+	SilentSeconds float64    `json:"silent_seconds"`
+	StaleAfter    float64    `json:"stale_after_seconds,omitempty"`
+	Stale         bool       `json:"stale"`
+}
+
+// StreamHealth lists the running streams of the class with whether their
+// cache is initialized, their message and reconnect counts and how long they
+// have been silent, stale ones first.
+func (s *Service) StreamHealth() []StreamHealth {
+	now := time.Now()
+	var streams []StreamHealth
+	add := func(kind string, si symbolInterval, c *streamClock, initialized bool) {
+		h := StreamHealth{
+			Kind:          kind,
+			Symbol:        si.Symbol,
+			Interval:      si.Interval,
+			Price:         si.Price,
+			Started:       c.started,
+			Initialized:   initialized,
+			Messages:      c.messages.Load(),
+			Reconnects:    c.reconnects.Load(),
+			SilentSeconds: math.Round(now.Sub(c.silentSince()).Seconds()),
+		}
+		if last := c.LastMessage(); !last.IsZero() {
+			h.LastMessage = &last
+		}
+		if after := staleThreshold(kind, si); after > 0 {
+			h.StaleAfter = after.Seconds()
+			h.Stale = c.stale(now, after)
+		}
+		streams = append(streams, h)
+	}
+	s.klinesSrv.Range(func(k, v interface{}) bool {
+		srv := v.(*KlinesSrv)
+		add(StreamKline, k.(symbolInterval), &srv.streamClock, srv.initCtx.Err() != nil)
+		return true
+	})
+	s.depthSrv.Range(func(k, v interface{}) bool {
+		srv := v.(*DepthSrv)
+		add(StreamDepth, k.(symbolInterval), &srv.streamClock, srv.initCtx.Err() != nil)
+		return true
+	})
+	s.tickerSrv.Range(func(k, v interface{}) bool {
+		srv := v.(*TickerSrv)
+		add(StreamTicker, k.(symbolInterval), &srv.streamClock, srv.initCtx.Err() != nil)
+		return true
+	})
+	s.tradesSrv.Range(func(k, v interface{}) bool {
+		srv := v.(*TradesSrv)
+		add(StreamTrade, k.(symbolInterval), &srv.streamClock, srv.initCtx.Err() != nil)
+		return true
+	})
+
+	sort.Slice(streams, func(i, j int) bool {
+		a, b := streams[i], streams[j]
+		if a.Stale != b.Stale {
+			return a.Stale
+		}
+		if a.Symbol != b.Symbol {
+			return a.Symbol < b.Symbol
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Interval < b.Interval
+	})
+	return streams
+}
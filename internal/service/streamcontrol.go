@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Stream control actions.
+const (
+	StreamActionStop        = "stop"
+	StreamActionRestart     = "restart"
+	StreamActionResubscribe = "resubscribe"
+)
+
+// ErrStreamNotRunning is returned by ControlStream for streams that aren't
+// open.
+var ErrStreamNotRunning = errors.New("stream is not running")
+
+// streamService is what the websocket services of every kind have in common.
+type streamService interface {
+	Start()
+	Stop()
+	Resubscribe()
+	clock() *streamClock
+}
+
+// ControlStream applies action to the running stream of kind for symbol
+// (and interval for klines): stop closes it and drops its cache, restart
+// replaces it with a fresh service that initializes again through REST, and
+// resubscribe reconnects its websocket, keeping the service.
+func (s *Service) ControlStream(action, kind, symbol, interval string) error {
+	switch action {
+	case StreamActionStop, StreamActionRestart, StreamActionResubscribe:
+	default:
+		return fmt.Errorf("unsupported action %q, valid actions are %s, %s and %s", action, StreamActionStop, StreamActionRestart, StreamActionResubscribe)
+	}
+
+	if kind == StreamKline {
+		if _, ok := INTERVAL_2_DURATION[interval]; !ok {
+			return fmt.Errorf("unsupported interval %q", interval)
+		}
+	}
+	m, lastGet, _ := s.streamServices(kind)
+	if m == nil {
+		return fmt.Errorf("unsupported stream %q", kind)
+	}
+	name := symbol
+	if kind == StreamKline {
+		name += "@" + interval
+	} else {
+		interval = ""
+	}
+	si := NewSymbolInterval(s.class, symbol, interval)
+
+	v, ok := m.Load(*si)
+	if !ok {
+		return ErrStreamNotRunning
+	}
+	old := v.(streamService)
+
+	switch action {
+	case StreamActionStop:
+		if !m.CompareAndDelete(*si, v) {
+			return ErrStreamNotRunning
+		}
+		lastGet.Delete(*si)
+		old.Stop()
+		streamStopped(s.class, kind)
+	case StreamActionRestart:
+		if !s.replaceStream(kind, *si, old, nil) {
+			return ErrStreamNotRunning
+		}
+	case StreamActionResubscribe:
+		old.Resubscribe()
+	}
+
+	log.Infof("%s %s %s stream: %s by admin request", s.class, name, kind, action)
+	return nil
+}
+
+// streamServices returns the running services of kind, the last requests for
+// them and a constructor of fresh ones; m is nil for unknown kinds.
+func (s *Service) streamServices(kind string) (m, lastGet *sync.Map, fresh func(si symbolInterval) streamService) {
+	switch kind {
+	case StreamKline:
+		m, lastGet = &s.klinesSrv, &s.lastGetKlines
+		fresh = func(si symbolInterval) streamService { return NewKlinesSrv(s.ctx, &si, tierFunc(si, lastGet)) }
+	case StreamDepth:
+		m, lastGet = &s.depthSrv, &s.lastGetDepth
+		fresh = func(si symbolInterval) streamService { return NewDepthSrv(s.ctx, &si, tierFunc(si, lastGet)) }
+	case StreamTicker:
+		m, lastGet = &s.tickerSrv, &s.lastGetTicker
+		fresh = func(si symbolInterval) streamService { return NewTickerSrv(s.ctx, &si, tierFunc(si, lastGet)) }
+	case StreamTrade:
+		m, lastGet = &s.tradesSrv, &s.lastGetTrades
+		fresh = func(si symbolInterval) streamService { return NewTradesSrv(s.ctx, &si, tierFunc(si, lastGet)) }
+	}
+	return m, lastGet, fresh
+}
+
+// replaceStream replaces the running service old of kind for si with a fresh
+// instance, stopping old and starting the new one. prepare, if set, is called
+// with the fresh service before it is published. It reports false when old is
+// no longer the running service.
+func (s *Service) replaceStream(kind string, si symbolInterval, old streamService, prepare func(fresh streamService)) bool {
+	m, _, fresh := s.streamServices(kind)
+	if m == nil || s.ctx.Err() != nil {
+		return false
+	}
+	srv := fresh(si)
+	if prepare != nil {
+		prepare(srv)
+	}
+	if !m.CompareAndSwap(si, old, srv) {
+		srv.Stop()
+		return false
+	}
+	old.Stop()
+	srv.Start()
+	return true
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"binance-proxy/internal/notify"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// streamErrors counts websocket errors per class since the last check of
+// WatchStreamErrors.
+var streamErrors = map[Class]*atomic.Int64{SPOT: {}, FUTURES: {}}
+
+// perStreamErrors counts websocket errors per stream since the last check of
+// the stream supervisor, map[streamKey]*atomic.Int64.
+var perStreamErrors sync.Map
+
+func countStreamError(kind string, si *symbolInterval) {
+	if n, ok := streamErrors[si.Class]; ok {
+		n.Add(1)
+	}
+	v, _ := perStreamErrors.LoadOrStore(streamKey{kind, *si}, new(atomic.Int64))
+	v.(*atomic.Int64).Add(1)
+}
+
+// takeStreamErrors returns the errors of every stream of class counted since
+// the last call and resets them. Streams without errors are forgotten.
+func takeStreamErrors(class Class) map[streamKey]int64 {
+	counts := map[streamKey]int64{}
+	perStreamErrors.Range(func(k, v interface{}) bool {
+		key := k.(streamKey)
+		if key.si.Class != class {
+			return true
+		}
+		if n := v.(*atomic.Int64).Swap(0); n > 0 {
+			counts[key] = n
+		} else {
+			perStreamErrors.CompareAndDelete(k, v)
+		}
+		return true
+	})
+	return counts
+}
+
+// WatchStreamErrors sends a notification when a class sees more than
+// threshold websocket errors within a minute, until ctx is done.
+func WatchStreamErrors(ctx context.Context, threshold int64) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		for class, n := range streamErrors {
+			if errors := n.Swap(0); errors > threshold {
+				notify.Send(notify.KindStreamError, string(class), "%d websocket errors in the last minute (threshold %d)", errors, threshold)
+			}
+		}
+	}
+}
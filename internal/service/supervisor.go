@@ -0,0 +1,180 @@
+package service
+
+import (
+	"binance-proxy/internal/metrics"
+	"binance-proxy/internal/notify"
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SupervisorConfig configures a StreamSupervisor.
+type SupervisorConfig struct {
+	Interval     time.Duration // time between two checks
+	StreamErrors int64         // errors of a stream within Interval that restart it
+	ClassShare   float64       // share of failing streams that re-initializes the whole class instead
+	Cooldown     time.Duration // minimum time between two re-initializations of the class
+}
+
+// SupervisorStats describes the state of a StreamSupervisor.
+type SupervisorStats struct {
+	Class          Class     `json:"class"`
+	Enabled        bool      `json:"enabled"`
+	StreamErrors   int64     `json:"stream_errors"`
+	ClassShare     float64   `json:"class_share"`
+	LastCheck      time.Time `json:"last_check"`
+	StreamRestarts int       `json:"stream_restarts"`
+	ClassReinits   int       `json:"class_reinits"`
+	LastRestart    time.Time `json:"last_restart"`
+	LastRestartOf  string    `json:"last_restart_of,omitempty"`
+	NextReinitMin  time.Time `json:"next_reinit_min"`
+}
+
+// StreamSupervisor restarts the websocket services of a class whose errors
+// exceed a threshold, one at a time, so the caches of every other stream stay
+// warm. When too many streams of the class fail together it re-initializes
+// the websocket layer of the class instead.
+type StreamSupervisor struct {
+	srv    *Service
+	config SupervisorConfig
+
+	mu    sync.Mutex
+	stats SupervisorStats
+}
+
+// NewStreamSupervisor creates a StreamSupervisor for the streams of srv.
+func NewStreamSupervisor(srv *Service, config SupervisorConfig) *StreamSupervisor {
+	return &StreamSupervisor{
+		srv:    srv,
+		config: config,
+		stats: SupervisorStats{
+			Class:        srv.class,
+			Enabled:      true,
+			StreamErrors: config.StreamErrors,
+			ClassShare:   config.ClassShare,
+		},
+	}
+}
+
+// Run checks the streams until ctx is done.
+func (p *StreamSupervisor) Run(ctx context.Context) {
+	t := time.NewTicker(p.config.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			p.check()
+		}
+	}
+}
+
+func (p *StreamSupervisor) check() {
+	class := p.srv.class
+	counts := takeStreamErrors(class)
+	now := time.Now()
+
+	var failing []streamKey
+	for key, n := range counts {
+		if n >= p.config.StreamErrors {
+			failing = append(failing, key)
+		}
+	}
+
+	p.mu.Lock()
+	p.stats.LastCheck = now
+	p.mu.Unlock()
+	// Fresh services initialize through REST, which has to wait out a ban
+	if len(failing) == 0 || GetBanDetector().IsBanned(class) {
+		return
+	}
+
+	streams := p.srv.streamCount()
+	p.mu.Lock()
+	reinit := streams > 1 && float64(len(failing)) >= p.config.ClassShare*float64(streams) && !now.Before(p.stats.NextReinitMin)
+	if reinit {
+		p.stats.ClassReinits++
+		p.stats.LastRestart = now
+		p.stats.LastRestartOf = "class"
+		p.stats.NextReinitMin = now.Add(p.config.Cooldown)
+	}
+	p.mu.Unlock()
+
+	if reinit {
+		log.Warnf("%s supervisor: %d of %d streams exceeded %d websocket errors, re-initializing websocket services",
+			class, len(failing), streams, p.config.StreamErrors)
+		notify.Send(notify.KindRecovery, string(class), "%d of %d streams exceeded %d websocket errors, re-initializing websocket services",
+			len(failing), streams, p.config.StreamErrors)
+		p.srv.Reinit()
+		metrics.StreamSupervisorRestarts.WithLabelValues(string(class), "class").Inc()
+		return
+	}
+
+	for _, key := range failing {
+		if !p.srv.restartStream(key) {
+			continue
+		}
+		name := streamName(key)
+		log.Warnf("%s supervisor: %s %s stream exceeded %d websocket errors (%d), restarted it",
+			class, name, key.kind, p.config.StreamErrors, counts[key])
+		metrics.StreamSupervisorRestarts.WithLabelValues(string(class), key.kind).Inc()
+
+		p.mu.Lock()
+		p.stats.StreamRestarts++
+		p.stats.LastRestart = now
+		p.stats.LastRestartOf = name + " " + key.kind
+		p.mu.Unlock()
+	}
+}
+
+// Stats returns a snapshot of the supervisor state.
+func (p *StreamSupervisor) Stats() SupervisorStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// streamName is the symbol of a stream, with the interval for klines and
+// the price for mark and index price klines.
+func streamName(key streamKey) string {
+	name := key.si.Symbol
+	if key.si.Interval != "" {
+		name += "@" + key.si.Interval
+	}
+	if key.si.Price != "" {
+		name += " " + key.si.Price + " price"
+	}
+	return name
+}
+
+// streamCount returns the number of running websocket services.
+func (s *Service) streamCount() int {
+	n := 0
+	count := func(_, _ interface{}) bool {
+		n++
+		return true
+	}
+	s.klinesSrv.Range(count)
+	s.depthSrv.Range(count)
+	s.tickerSrv.Range(count)
+	s.tradesSrv.Range(count)
+	return n
+}
+
+// restartStream replaces the running service of key with a fresh instance,
+// reporting false when it is no longer running.
+func (s *Service) restartStream(key streamKey) bool {
+	m, _, _ := s.streamServices(key.kind)
+	if m == nil {
+		return false
+	}
+	v, ok := m.Load(key.si)
+	if !ok {
+		return false
+	}
+	return s.replaceStream(key.kind, key.si, v.(streamService), nil)
+}
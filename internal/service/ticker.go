@@ -1,7 +1,7 @@
 package service
 
 import (
-	"binance-proxy/internal/tool"
+	"binance-proxy/internal/metrics"
 	"context"
 	"strings"
 	"sync"
@@ -9,18 +9,22 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	spot "github.com/adshao/go-binance/v2"
+	futures "github.com/adshao/go-binance/v2/futures"
 )
 
 type TickerSrv struct {
 	rw sync.RWMutex
+	streamClock
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	initCtx  context.Context
 	initDone context.CancelFunc
+	stopped  chan struct{}
 
 	si         *symbolInterval
+	tier       func() Tier
 	ticker24hr *Ticker24hr
 	bookTicker *BookTicker
 }
@@ -55,8 +59,8 @@ type Ticker24hr struct {
 	Count              int64  `json:"count"`
 }
 
-func NewTickerSrv(ctx context.Context, si *symbolInterval) *TickerSrv {
-	s := &TickerSrv{si: si}
+func NewTickerSrv(ctx context.Context, si *symbolInterval, tier func() Tier) *TickerSrv {
+	s := &TickerSrv{streamClock: newStreamClock(), si: si, tier: tier, stopped: make(chan struct{})}
 	s.ctx, s.cancel = context.WithCancel(ctx)
 	s.initCtx, s.initDone = context.WithCancel(context.Background())
 
@@ -65,21 +69,43 @@ func NewTickerSrv(ctx context.Context, si *symbolInterval) *TickerSrv {
 
 func (s *TickerSrv) Start() {
 	go func() {
-		for d := tool.NewDelayIterator(); ; d.Delay() {
+		defer close(s.stopped)
+
+		reconnect := false
+		for d := retryIterator(ScheduleReconnect); ; d.Delay() {
+			if reconnect {
+				metrics.StreamReconnects.WithLabelValues(string(s.si.Class), StreamTicker).Inc()
+				s.reconnects.Add(1)
+				incidents.countReconnect(s.si.Class)
+				if !awaitReconnect(s.ctx, s.tier()) {
+					return
+				}
+			}
+			reconnect = true
+
 			s.rw.Lock()
 			s.ticker24hr = nil
 			s.bookTicker = nil
 			s.rw.Unlock()
 
+			if streamDialWait(s.ctx) != nil {
+				return
+			}
 			ticker24hrDoneC, ticker24hrstopC, err := s.connectTicker24hr()
+			dialResult(s.si.Class, err)
 			if err != nil {
 				log.Errorf("%s %s ticker24hr websocket connection error: %s.", s.si.Class, s.si.Symbol, err)
 				continue
 			}
 
+			if streamDialWait(s.ctx) != nil {
+				ticker24hrstopC <- struct{}{}
+				return
+			}
 			bookDoneC, bookStopC, err := s.connectTickerBook()
+			dialResult(s.si.Class, err)
 			if err != nil {
-				bookStopC <- struct{}{}
+				ticker24hrstopC <- struct{}{}
 				log.Errorf("%s %s bookTicker websocket connection error: %s.", s.si.Class, s.si.Symbol, err)
 				continue
 			}
@@ -89,7 +115,16 @@ func (s *TickerSrv) Start() {
 			case <-s.ctx.Done():
 				bookStopC <- struct{}{}
 				ticker24hrstopC <- struct{}{}
+				<-bookDoneC
+				<-ticker24hrDoneC
 				return
+			case <-s.resubscribeC:
+				log.Infof("%s %s ticker24hr and bookTicker websocket resubscribing on request.", s.si.Class, s.si.Symbol)
+				bookStopC <- struct{}{}
+				ticker24hrstopC <- struct{}{}
+				<-bookDoneC
+				<-ticker24hrDoneC
+				continue
 			case <-bookDoneC:
 				ticker24hrstopC <- struct{}{}
 			case <-ticker24hrDoneC:
@@ -105,19 +140,51 @@ func (s *TickerSrv) Stop() {
 	s.cancel()
 }
 
+// Stopped is closed once the websocket loops have exited after Stop.
+func (s *TickerSrv) Stopped() <-chan struct{} {
+	return s.stopped
+}
+
 func (s *TickerSrv) connectTickerBook() (doneC, stopC chan struct{}, err error) {
+	if Polling(s.si.Class) {
+		return pollServe(s.ctx, s.pollBookTicker, s.errHandler)
+	}
+	if combinedEnabled.Load() {
+		// Futures events decode into the spot layout, minus fields spot lacks
+		return serveJSON(s.ctx, s.si.Class, strings.ToLower(s.si.Symbol)+"@bookTicker", s.wsHandlerBookTicker, s.errHandler)
+	}
+	if s.si.Class == FUTURES {
+		return futures.WsBookTickerServe(s.si.Symbol, s.wsHandlerFuturesBookTicker, s.errHandler)
+	}
 	return spot.WsBookTickerServe(s.si.Symbol, s.wsHandlerBookTicker, s.errHandler)
 }
 
 func (s *TickerSrv) connectTicker24hr() (doneC, stopC chan struct{}, err error) {
+	if Polling(s.si.Class) {
+		return pollServe(s.ctx, s.pollTicker24hr, s.errHandler)
+	}
+	if combinedEnabled.Load() {
+		return serveJSON(s.ctx, s.si.Class, strings.ToLower(s.si.Symbol)+"@ticker", s.wsHandlerTicker24hr, s.errHandler)
+	}
+	if s.si.Class == FUTURES {
+		return futures.WsMarketTickerServe(s.si.Symbol, s.wsHandlerFuturesTicker24hr, s.errHandler)
+	}
 	return spot.WsMarketStatServe(s.si.Symbol, s.wsHandlerTicker24hr, s.errHandler)
 }
 
-func (s *TickerSrv) GetTicker() *Ticker24hr {
-	<-s.initCtx.Done()
+func (s *TickerSrv) GetTicker(ctx context.Context) *Ticker24hr {
+	if !awaitInit(ctx, s.initCtx, s.si.Class, StreamTicker) {
+		return nil
+	}
 	s.rw.RLock()
 	defer s.rw.RUnlock()
 
+	return s.mergedTicker()
+}
+
+// mergedTicker combines the 24hr ticker with the faster bookTicker prices.
+// Callers must hold s.rw.
+func (s *TickerSrv) mergedTicker() *Ticker24hr {
 	bidPrice := s.ticker24hr.BidPrice
 	askPrice := s.ticker24hr.AskPrice
 	if s.bookTicker != nil {
@@ -149,28 +216,38 @@ func (s *TickerSrv) GetTicker() *Ticker24hr {
 }
 
 func (s *TickerSrv) wsHandlerBookTicker(event *spot.WsBookTickerEvent) {
-	s.rw.Lock()
-	defer s.rw.Unlock()
+	s.setBookTicker(&BookTicker{
+		Symbol:      event.Symbol,
+		BidPrice:    event.BestBidPrice,
+		BidQuantity: event.BestBidQty,
+		AskPrice:    event.BestAskPrice,
+		AskQuantity: event.BestAskQty,
+	})
+}
 
-	s.bookTicker = &BookTicker{
+func (s *TickerSrv) wsHandlerFuturesBookTicker(event *futures.WsBookTickerEvent) {
+	s.setBookTicker(&BookTicker{
 		Symbol:      event.Symbol,
 		BidPrice:    event.BestBidPrice,
 		BidQuantity: event.BestBidQty,
 		AskPrice:    event.BestAskPrice,
 		AskQuantity: event.BestAskQty,
-	}
-	log.Tracef("%s %s bookTicker websocket message received", s.si.Class, s.si.Symbol)
+	})
 }
 
-func (s *TickerSrv) wsHandlerTicker24hr(event *spot.WsMarketStatEvent) {
+func (s *TickerSrv) setBookTicker(t *BookTicker) {
+	s.touch()
 	s.rw.Lock()
 	defer s.rw.Unlock()
 
-	if s.ticker24hr == nil {
-		defer s.initDone()
+	s.bookTicker = t
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.Tracef("%s %s bookTicker websocket message received", s.si.Class, s.si.Symbol)
 	}
+}
 
-	s.ticker24hr = &Ticker24hr{
+func (s *TickerSrv) wsHandlerTicker24hr(event *spot.WsMarketStatEvent) {
+	s.setTicker24hr(&Ticker24hr{
 		Symbol:             event.Symbol,
 		PriceChange:        event.PriceChange,
 		PriceChangePercent: event.PriceChangePercent,
@@ -190,11 +267,50 @@ func (s *TickerSrv) wsHandlerTicker24hr(event *spot.WsMarketStatEvent) {
 		FirstID:            event.FirstID,
 		LastID:             event.LastID,
 		Count:              event.Count,
+	})
+}
+
+// wsHandlerFuturesTicker24hr handles the futures 24hr ticker, which has no
+// previous close or best prices; the latter come from the bookTicker.
+func (s *TickerSrv) wsHandlerFuturesTicker24hr(event *futures.WsMarketTickerEvent) {
+	s.setTicker24hr(&Ticker24hr{
+		Symbol:             event.Symbol,
+		PriceChange:        event.PriceChange,
+		PriceChangePercent: event.PriceChangePercent,
+		WeightedAvgPrice:   event.WeightedAvgPrice,
+		LastPrice:          event.ClosePrice,
+		LastQty:            event.CloseQty,
+		OpenPrice:          event.OpenPrice,
+		HighPrice:          event.HighPrice,
+		LowPrice:           event.LowPrice,
+		Volume:             event.BaseVolume,
+		QuoteVolume:        event.QuoteVolume,
+		OpenTime:           event.OpenTime,
+		CloseTime:          event.CloseTime,
+		FirstID:            event.FirstID,
+		LastID:             event.LastID,
+		Count:              event.TradeCount,
+	})
+}
+
+func (s *TickerSrv) setTicker24hr(t *Ticker24hr) {
+	s.touch()
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	if s.ticker24hr == nil {
+		defer s.initDone()
+	}
+
+	s.ticker24hr = t
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.Tracef("%s %s ticker24hr websocket message received", s.si.Class, s.si.Symbol)
 	}
-	log.Tracef("%s %s ticker24hr websocket message received", s.si.Class, s.si.Symbol)
+	broker.publish(StreamTicker, s.si, s.mergedTicker())
 }
 
 func (s *TickerSrv) errHandler(err error) {
+	countStreamError(StreamTicker, s.si)
 	if strings.Contains(err.Error(), "context canceled") {
 		log.Warnf("%s %s ticker websocket context canceled, will restart connection.", s.si.Class, s.si.Symbol)
 	} else {
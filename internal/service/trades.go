@@ -0,0 +1,338 @@
+package service
+
+import (
+	"binance-proxy/internal/metrics"
+	"binance-proxy/internal/tracing"
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+
+	spot "github.com/adshao/go-binance/v2"
+	futures "github.com/adshao/go-binance/v2/futures"
+)
+
+// StreamTrade is the kind of the trade websocket services. Trades are only
+// served through REST and can't be subscribed to.
+const StreamTrade = "trade"
+
+const (
+	// defaultTradesRetention is the number of trades kept per symbol.
+	defaultTradesRetention = 1000
+	// maxTradesPerRequest is the most trades Binance returns per request.
+	maxTradesPerRequest = 1000
+)
+
+var tradesRetention atomic.Int64
+
+// SetTradesRetention sets how many of the latest trades are kept per symbol.
+// Streams started afterwards use the new count.
+func SetTradesRetention(n int) error {
+	if n < 1 || n > maxTradesPerRequest {
+		return fmt.Errorf("invalid trades retention %d, expected a count from 1 to %d", n, maxTradesPerRequest)
+	}
+	tradesRetention.Store(int64(n))
+	return nil
+}
+
+// TradesRetention returns the number of trades kept per symbol.
+func TradesRetention() int {
+	if n := tradesRetention.Load(); n > 0 {
+		return int(n)
+	}
+	return defaultTradesRetention
+}
+
+type Trade struct {
+	ID            int64  `json:"id"`
+	Price         string `json:"price"`
+	Quantity      string `json:"qty"`
+	QuoteQuantity string `json:"quoteQty"`
+	Time          int64  `json:"time"`
+	IsBuyerMaker  bool   `json:"isBuyerMaker"`
+	IsBestMatch   bool   `json:"isBestMatch"`
+}
+
+type TradesSrv struct {
+	rw sync.RWMutex
+	streamClock
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	initCtx  context.Context
+	initDone context.CancelFunc
+	stopped  chan struct{}
+
+	si        *symbolInterval
+	tier      func() Tier
+	retention int
+	trades    []*Trade // oldest first, nil until initialized
+	pending   []*Trade // websocket trades received during initialization
+}
+
+func NewTradesSrv(ctx context.Context, si *symbolInterval, tier func() Tier) *TradesSrv {
+	s := &TradesSrv{streamClock: newStreamClock(), si: si, tier: tier, retention: TradesRetention(), stopped: make(chan struct{})}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.initCtx, s.initDone = context.WithCancel(context.Background())
+
+	return s
+}
+
+func (s *TradesSrv) Start() {
+	go func() {
+		defer close(s.stopped)
+
+		reconnect := false
+		for d := retryIterator(ScheduleReconnect); ; d.Delay() {
+			if reconnect {
+				metrics.StreamReconnects.WithLabelValues(string(s.si.Class), StreamTrade).Inc()
+				s.reconnects.Add(1)
+				incidents.countReconnect(s.si.Class)
+				if !awaitReconnect(s.ctx, s.tier()) {
+					return
+				}
+			}
+			reconnect = true
+
+			s.rw.Lock()
+			s.trades = nil
+			s.pending = nil
+			s.rw.Unlock()
+
+			if streamDialWait(s.ctx) != nil {
+				return
+			}
+			doneC, stopC, err := s.connect()
+			if err != nil {
+				log.Errorf("%s %s trade websocket connection error: %s.", s.si.Class, s.si.Symbol, err)
+				continue
+			}
+
+			log.Debugf("%s %s trade websocket connected.", s.si.Class, s.si.Symbol)
+			// Initialize right away instead of on the first message like
+			// klines, since illiquid symbols may not trade for minutes
+			s.initialize(s.initTradeData())
+			select {
+			case <-s.ctx.Done():
+				stopC <- struct{}{}
+				<-doneC
+				return
+			case <-s.resubscribeC:
+				log.Infof("%s %s trade websocket resubscribing on request.", s.si.Class, s.si.Symbol)
+				stopC <- struct{}{}
+				<-doneC
+				continue
+			case <-doneC:
+			}
+			log.Warnf("%s %s trade websocket disconnected, trying to reconnect.", s.si.Class, s.si.Symbol)
+		}
+	}()
+}
+
+func (s *TradesSrv) Stop() {
+	s.cancel()
+}
+
+// Stopped is closed once the websocket loop has exited after Stop.
+func (s *TradesSrv) Stopped() <-chan struct{} {
+	return s.stopped
+}
+
+func (s *TradesSrv) connect() (doneC, stopC chan struct{}, err error) {
+	// go-binance has no raw trade stream for futures, so futures trades
+	// always go through the combined connections
+	if combinedEnabled.Load() || s.si.Class == FUTURES {
+		return serveJSON(s.ctx, s.si.Class, strings.ToLower(s.si.Symbol)+"@trade", s.wsHandler, s.errHandler)
+	}
+	return spot.WsTradeServe(s.si.Symbol, s.wsHandler, s.errHandler)
+}
+
+// GetTrades returns up to limit of the latest trades, oldest first.
+func (s *TradesSrv) GetTrades(ctx context.Context, limit int) []*Trade {
+	if !awaitInit(ctx, s.initCtx, s.si.Class, StreamTrade) {
+		return nil
+	}
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+
+	trades := s.trades[max(0, len(s.trades)-limit):]
+	return append([]*Trade(nil), trades...)
+}
+
+func (s *TradesSrv) wsHandler(event *spot.WsTradeEvent) {
+	s.touch()
+	t := &Trade{
+		ID:            event.TradeID,
+		Price:         event.Price,
+		Quantity:      event.Quantity,
+		QuoteQuantity: quoteQuantity(event.Price, event.Quantity),
+		Time:          event.TradeTime,
+		IsBuyerMaker:  event.IsBuyerMaker,
+		IsBestMatch:   true,
+	}
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.Tracef("%s %s trade websocket message received for trade %d", s.si.Class, s.si.Symbol, t.ID)
+	}
+
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	if s.trades == nil {
+		s.pending = s.append(s.pending, t)
+		return
+	}
+	s.trades = s.append(s.trades, t)
+}
+
+// initialize sets the trades fetched through REST and adds those received
+// over the websocket meanwhile.
+func (s *TradesSrv) initialize(trades []*Trade) {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	for _, t := range s.pending {
+		trades = s.append(trades, t)
+	}
+	s.trades = trades
+	s.pending = nil
+	s.initDone()
+}
+
+// append adds t to trades if it is newer than the last one, keeping at most
+// the retention. Trade IDs are consecutive, so after a gap the older trades
+// are dropped rather than served with trades missing in between; requests
+// go to Binance until the list has filled up again.
+func (s *TradesSrv) append(trades []*Trade, t *Trade) []*Trade {
+	if n := len(trades); n > 0 {
+		last := trades[n-1].ID
+		if t.ID <= last {
+			return trades
+		}
+		if t.ID > last+1 {
+			log.Debugf("%s %s trade websocket skipped trades %d to %d, dropping the older trades.", s.si.Class, s.si.Symbol, last+1, t.ID-1)
+			trades = trades[:0:0]
+		}
+	}
+	trades = append(trades, t)
+	if n := len(trades); n > s.retention {
+		trades = trades[n-s.retention:]
+	}
+	return trades
+}
+
+// initTradeData fetches the latest trades through REST. During a ban it
+// returns an empty list, which is filled by the websocket alone.
+func (s *TradesSrv) initTradeData() []*Trade {
+	ctx, span := tracing.Start(s.ctx, "trades.init", tracing.KindInternal)
+	span.SetAttr("binance.class", string(s.si.Class))
+	span.SetAttr("binance.symbol", s.si.Symbol)
+	defer span.End()
+
+	banDetector := GetBanDetector()
+	if banDetector.IsBanned(s.si.Class) {
+		span.SetAttr("binance.banned", true)
+		log.Debugf("%s %s trade initialization skipped due to API ban", s.si.Class, s.si.Symbol)
+		return []*Trade{}
+	}
+
+	log.Debugf("%s %s trade initialization through REST.", s.si.Class, s.si.Symbol)
+	for d := retryIterator(ScheduleKlineInit); ; d.Delay() {
+		if banDetector.IsBanned(s.si.Class) {
+			log.Debugf("%s %s trade initialization stopped due to API ban", s.si.Class, s.si.Symbol)
+			return []*Trade{}
+		}
+
+		trades, err := s.fetchTrades(ctx, s.retention)
+		if banDetector.CheckResponse(s.si.Class, nil, err) {
+			log.Debugf("%s %s trade initialization stopped due to detected ban", s.si.Class, s.si.Symbol)
+			return []*Trade{}
+		}
+		if err != nil {
+			log.Errorf("%s %s trade initialization via REST failed, error: %s.", s.si.Class, s.si.Symbol, err)
+			span.SetError(err)
+			if s.ctx.Err() != nil {
+				return []*Trade{}
+			}
+			continue
+		}
+
+		span.SetAttr("binance_proxy.trades", len(trades))
+		return trades
+	}
+}
+
+// fetchTrades requests the latest limit trades through REST.
+func (s *TradesSrv) fetchTrades(ctx context.Context, limit int) ([]*Trade, error) {
+	query := url.Values{"limit": []string{strconv.Itoa(limit)}}
+	trades := []*Trade{}
+	if s.si.Class == SPOT {
+		initRateWait(ctx, s.si.Class, "/api/v3/trades", query)
+		client := spot.NewClient("", "")
+		client.HTTPClient = getHTTPClient()
+		res, err := client.NewRecentTradesService().Symbol(s.si.Symbol).Limit(limit).Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range res {
+			trades = append(trades, &Trade{
+				ID:            v.ID,
+				Price:         v.Price,
+				Quantity:      v.Quantity,
+				QuoteQuantity: v.QuoteQuantity,
+				Time:          v.Time,
+				IsBuyerMaker:  v.IsBuyerMaker,
+				IsBestMatch:   v.IsBestMatch,
+			})
+		}
+		return trades, nil
+	}
+
+	initRateWait(ctx, s.si.Class, "/fapi/v1/trades", query)
+	client := futures.NewClient("", "")
+	client.HTTPClient = getHTTPClient()
+	res, err := client.NewRecentTradesService().Symbol(s.si.Symbol).Limit(limit).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range res {
+		trades = append(trades, &Trade{
+			ID:            v.ID,
+			Price:         v.Price,
+			Quantity:      v.Quantity,
+			QuoteQuantity: v.QuoteQuantity,
+			Time:          v.Time,
+			IsBuyerMaker:  v.IsBuyerMaker,
+		})
+	}
+	return trades, nil
+}
+
+// quoteQuantity is price times quantity, which trade websocket events leave
+// out, formatted like the REST quoteQty.
+func quoteQuantity(price, quantity string) string {
+	p, err := decimal.NewFromString(price)
+	if err != nil {
+		return ""
+	}
+	q, err := decimal.NewFromString(quantity)
+	if err != nil {
+		return ""
+	}
+	return p.Mul(q).String()
+}
+
+func (s *TradesSrv) errHandler(err error) {
+	countStreamError(StreamTrade, s.si)
+	if strings.Contains(err.Error(), "context canceled") {
+		log.Warnf("%s %s trade websocket context canceled, will restart connection.", s.si.Class, s.si.Symbol)
+	} else {
+		log.Errorf("%s %s trade websocket connection error: %s.", s.si.Class, s.si.Symbol, err)
+	}
+}
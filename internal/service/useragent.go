@@ -0,0 +1,46 @@
+package service
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+var upstreamUserAgent atomic.Pointer[string]
+
+// SetUpstreamUserAgent sets the User-Agent sent with every request to
+// Binance, so instances can be told apart in upstream diagnostics. An empty
+// string keeps the defaults: the client's own User-Agent on forwarded
+// requests and the SDK's on the proxy's own calls.
+func SetUpstreamUserAgent(ua string) {
+	upstreamUserAgent.Store(&ua)
+}
+
+// UpstreamUserAgent returns the configured User-Agent, empty if none is set.
+func UpstreamUserAgent() string {
+	if ua := upstreamUserAgent.Load(); ua != nil {
+		return *ua
+	}
+	return ""
+}
+
+// upstreamHeader returns the headers for upstream websocket handshakes.
+func upstreamHeader() http.Header {
+	if ua := UpstreamUserAgent(); ua != "" {
+		return http.Header{"User-Agent": {ua}}
+	}
+	return nil
+}
+
+// userAgentTransport sets the configured User-Agent on the proxy's own REST
+// calls, including the ones made by the SDK.
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if ua := UpstreamUserAgent(); ua != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", ua)
+	}
+	return t.base.RoundTrip(req)
+}
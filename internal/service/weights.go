@@ -0,0 +1,161 @@
+package service
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bundledWeights is the weight table shipped with the proxy. Binance doesn't
+// publish per-endpoint weights in exchangeInfo, only the per-minute limits.
+//
+//go:embed weights.json
+var bundledWeights []byte
+
+// endpointWeight is the weight Binance charges for an endpoint.
+type endpointWeight struct {
+	// Weight applies unless one of the fields below matches.
+	Weight int `json:"weight"`
+	// Methods overrides Weight for some HTTP methods.
+	Methods map[string]int `json:"methods,omitempty"`
+	// LimitTiers price the request by its limit parameter, DefaultLimit
+	// being Binance's default when the parameter is missing.
+	LimitTiers   []weightTier `json:"limit_tiers,omitempty"`
+	DefaultLimit int          `json:"default_limit,omitempty"`
+	// WithoutSymbol is charged when the symbol parameter is missing.
+	WithoutSymbol int `json:"without_symbol,omitempty"`
+	// SymbolsTiers price the request by the number of symbols in its
+	// symbols parameter, max_limit being the most symbols of a tier.
+	SymbolsTiers []weightTier `json:"symbols_tiers,omitempty"`
+}
+
+type weightTier struct {
+	MaxLimit int `json:"max_limit"`
+	Weight   int `json:"weight"`
+}
+
+type weightTable map[string]endpointWeight
+
+var weights atomic.Pointer[weightTable]
+
+func init() {
+	table, err := parseWeightTable(bundledWeights)
+	if err != nil {
+		panic(fmt.Sprintf("bundled weight table: %s", err))
+	}
+	weights.Store(&table)
+}
+
+func parseWeightTable(data []byte) (weightTable, error) {
+	var table weightTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+	for path, w := range table {
+		if w.Weight < 1 {
+			return nil, fmt.Errorf("%s: weight must be at least 1", path)
+		}
+		sort.Slice(w.LimitTiers, func(i, j int) bool { return w.LimitTiers[i].MaxLimit < w.LimitTiers[j].MaxLimit })
+		sort.Slice(w.SymbolsTiers, func(i, j int) bool { return w.SymbolsTiers[i].MaxLimit < w.SymbolsTiers[j].MaxLimit })
+	}
+	return table, nil
+}
+
+// LoadWeightTable overrides entries of the bundled weight table with the
+// endpoints listed in the JSON file at path, so weights can follow changes
+// published by Binance without a new release.
+func LoadWeightTable(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("weight table: %w", err)
+	}
+	overrides, err := parseWeightTable(data)
+	if err != nil {
+		return fmt.Errorf("weight table %s: %w", path, err)
+	}
+
+	table := make(weightTable)
+	for path, w := range *weights.Load() {
+		table[path] = w
+	}
+	for path, w := range overrides {
+		table[path] = w
+	}
+	weights.Store(&table)
+	log.Infof("Weight table overrides loaded for %d endpoints from %s", len(overrides), path)
+	return nil
+}
+
+// requestWeight returns the weight Binance charges for a request, 1 for
+// endpoints missing from the table.
+func requestWeight(method, path string, query url.Values) int {
+	w, ok := (*weights.Load())[path]
+	if !ok {
+		return 1
+	}
+
+	if weight, ok := w.Methods[method]; ok {
+		return weight
+	}
+	if symbols := query.Get("symbols"); symbols != "" && len(w.SymbolsTiers) > 0 {
+		n := strings.Count(symbols, ",") + 1
+		for _, tier := range w.SymbolsTiers {
+			if n <= tier.MaxLimit {
+				return tier.Weight
+			}
+		}
+		return w.SymbolsTiers[len(w.SymbolsTiers)-1].Weight
+	}
+	if w.WithoutSymbol != 0 && query.Get("symbol") == "" {
+		return w.WithoutSymbol
+	}
+	if len(w.LimitTiers) > 0 {
+		limit := w.DefaultLimit
+		if l := query.Get("limit"); l != "" {
+			limit, _ = strconv.Atoi(l)
+		}
+		for _, tier := range w.LimitTiers {
+			if limit >= 1 && limit <= tier.MaxLimit {
+				return tier.Weight
+			}
+		}
+	}
+	return w.Weight
+}
+
+// applyRateLimits adopts the per-minute request weight limit published in
+// the rateLimits of exchangeInfo.
+func applyRateLimits(class Class, exchangeInfo []byte) {
+	var info struct {
+		RateLimits []struct {
+			RateLimitType string `json:"rateLimitType"`
+			Interval      string `json:"interval"`
+			IntervalNum   int    `json:"intervalNum"`
+			Limit         int    `json:"limit"`
+		} `json:"rateLimits"`
+	}
+	if err := json.Unmarshal(exchangeInfo, &info); err != nil {
+		log.Debugf("%s exchangeInfo rate limits not readable: %s", class, err)
+		return
+	}
+	for _, rl := range info.RateLimits {
+		if rl.RateLimitType == "REQUEST_WEIGHT" && rl.Interval == "MINUTE" && rl.IntervalNum == 1 && rl.Limit > 0 {
+			budgetFor(class).setLimit(rl.Limit)
+			return
+		}
+	}
+}
+
+// RequestWeight returns the weight Binance charges for a request, 1 for
+// endpoints missing from the weight table.
+func RequestWeight(method, path string, query url.Values) int {
+	return requestWeight(method, path, query)
+}
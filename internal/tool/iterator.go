@@ -7,6 +7,16 @@ type DelayIterator struct {
 	delayList []time.Duration
 }
 
+// NewScheduledDelayIterator returns an iterator over delayList, falling back
+// to the default schedule when it is empty.
+func NewScheduledDelayIterator(delayList []time.Duration) *DelayIterator {
+	d := NewDelayIterator()
+	if len(delayList) > 0 {
+		d.SetDelayList(delayList)
+	}
+	return d
+}
+
 func NewDelayIterator() *DelayIterator {
 	return &DelayIterator{
 		delayList: []time.Duration{
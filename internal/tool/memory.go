@@ -0,0 +1,52 @@
+package tool
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cgroupMemoryFiles hold the container memory limit for cgroup v2 and v1.
+var cgroupMemoryFiles = []string{
+	"/sys/fs/cgroup/memory.max",
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+}
+
+// CgroupMemoryLimit returns the memory limit of the container the process
+// runs in, in bytes. ok is false outside containers and without a limit.
+func CgroupMemoryLimit() (limit int64, ok bool) {
+	for _, f := range cgroupMemoryFiles {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		s := strings.TrimSpace(string(data))
+		if s == "max" {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		// cgroup v1 reports "no limit" as a huge page-aligned number
+		if err != nil || n <= 0 || n >= 1<<62 {
+			return 0, false
+		}
+		return n, true
+	}
+	return 0, false
+}
+
+// ParseMemoryLimit parses a memory size in MB, "auto" or "" and returns
+// the size in bytes; auto and empty return 0 with auto telling them apart.
+func ParseMemoryLimit(s string) (bytes int64, auto bool, err error) {
+	switch s = strings.TrimSpace(s); s {
+	case "":
+		return 0, false, nil
+	case "auto":
+		return 0, true, nil
+	}
+	mb, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || mb <= 0 {
+		return 0, false, fmt.Errorf("invalid memory limit %q, expected a size in MB or auto", s)
+	}
+	return mb << 20, false, nil
+}
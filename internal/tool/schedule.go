@@ -0,0 +1,80 @@
+package tool
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScheduleSteps bounds the length of generated exponential schedules.
+const maxScheduleSteps = 64
+
+// ParseSchedule parses a retry schedule for a DelayIterator. It is either a
+// comma separated list of delays ("0,100ms,1s,5s") or exponential parameters
+// ("exponential:initial=100ms,factor=2,max=1m"). The last delay repeats once
+// the schedule is exhausted.
+func ParseSchedule(s string) ([]time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if params, ok := strings.CutPrefix(s, "exponential:"); ok {
+		return parseExponential(params)
+	}
+
+	var delays []time.Duration
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if part == "0" {
+			delays = append(delays, 0)
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid delay %q in schedule %q", part, s)
+		}
+		delays = append(delays, d)
+	}
+	if len(delays) == 0 {
+		return nil, fmt.Errorf("empty schedule %q", s)
+	}
+	return delays, nil
+}
+
+func parseExponential(params string) ([]time.Duration, error) {
+	initial, factor, max := 100*time.Millisecond, 2.0, time.Minute
+	for _, part := range strings.Split(params, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid exponential schedule parameter %q", part)
+		}
+		var err error
+		switch name {
+		case "initial":
+			initial, err = time.ParseDuration(value)
+		case "max":
+			max, err = time.ParseDuration(value)
+		case "factor":
+			factor, err = strconv.ParseFloat(value, 64)
+		default:
+			err = fmt.Errorf("unknown parameter")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponential schedule parameter %q: %s", part, err)
+		}
+	}
+	if initial <= 0 || max < initial || factor < 1 {
+		return nil, fmt.Errorf("exponential schedule needs initial > 0, max >= initial and factor >= 1")
+	}
+
+	var delays []time.Duration
+	for d := initial; len(delays) < maxScheduleSteps; d = time.Duration(float64(d) * factor) {
+		if d >= max {
+			delays = append(delays, max)
+			break
+		}
+		delays = append(delays, d)
+	}
+	return delays, nil
+}
@@ -0,0 +1,234 @@
+package tracing
+
+import (
+	"binance-proxy/internal/logcache"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// queueSize bounds the spans waiting for export; more are dropped.
+	queueSize = 4096
+	// batchSize is the most spans sent in one request.
+	batchSize = 512
+	// flushInterval is the longest a span waits for its batch.
+	flushInterval = 5 * time.Second
+)
+
+type exporter struct {
+	url      string
+	headers  map[string]string
+	resource []otlpAttr
+	client   *http.Client
+	queue    chan *Span
+	done     chan struct{}
+}
+
+// Configure exports spans to the OTLP/HTTP collector at endpoint (such as
+// http://otel-collector:4318) with the given request headers, sampling
+// ratio of the traces started by the proxy. Traces started by clients
+// follow the sampled flag of their traceparent. An empty endpoint disables
+// tracing.
+func Configure(endpoint string, headers []string, ratio float64, serviceName, version string) error {
+	if endpoint == "" {
+		current.Store(nil)
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid OTLP endpoint %q, expected an http or https URL", endpoint)
+	}
+	if !strings.HasSuffix(u.Path, "/v1/traces") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/traces"
+	}
+	if ratio < 0 || ratio > 1 {
+		return fmt.Errorf("trace sample ratio must be between 0 and 1")
+	}
+
+	e := &exporter{
+		url:     u.String(),
+		headers: make(map[string]string),
+		resource: []otlpAttr{
+			attr("service.name", serviceName),
+			attr("service.version", version),
+		},
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan *Span, queueSize),
+		done:   make(chan struct{}),
+	}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid OTLP header %q, expected name=value", h)
+		}
+		e.headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	if ratio >= 1 {
+		sampleRatio.Store(math.MaxUint64)
+	} else {
+		sampleRatio.Store(uint64(ratio * math.MaxUint64))
+	}
+	go e.run()
+	current.Store(e)
+	return nil
+}
+
+// Shutdown exports the queued spans, waiting until ctx is done at most.
+func Shutdown(ctx context.Context) {
+	e := current.Swap(nil)
+	if e == nil {
+		return
+	}
+	close(e.queue)
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+	}
+}
+
+func (e *exporter) enqueue(s *Span) {
+	defer func() {
+		recover() // the queue was closed by Shutdown
+	}()
+	select {
+	case e.queue <- s:
+	default:
+		logcache.LogOncePerDuration("warn", "Trace export queue full, dropping spans")
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	for {
+		select {
+		case s, ok := <-e.queue:
+			if !ok {
+				e.export(batch)
+				return
+			}
+			if batch = append(batch, s); len(batch) < batchSize {
+				continue
+			}
+		case <-t.C:
+		}
+		e.export(batch)
+		batch = batch[:0]
+	}
+}
+
+func (e *exporter) export(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(e.payload(spans))
+	if err != nil {
+		log.Errorf("Trace export failed (error: %s).", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("Trace export failed (error: %s).", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		logcache.LogOncePerDuration("warn", fmt.Sprintf("Trace export to %s failed (error: %s).", e.url, err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logcache.LogOncePerDuration("warn", fmt.Sprintf("Trace export to %s failed with status %d.", e.url, resp.StatusCode))
+	}
+}
+
+// The OTLP/JSON layout, see opentelemetry-proto's trace service.
+type otlpAttr struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         Kind       `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func attr(key string, value interface{}) otlpAttr {
+	switch v := value.(type) {
+	case string:
+		return otlpAttr{key, map[string]interface{}{"stringValue": v}}
+	case bool:
+		return otlpAttr{key, map[string]interface{}{"boolValue": v}}
+	case int:
+		return otlpAttr{key, map[string]interface{}{"intValue": strconv.Itoa(v)}}
+	case int64:
+		return otlpAttr{key, map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}}
+	case float64:
+		return otlpAttr{key, map[string]interface{}{"doubleValue": v}}
+	}
+	return otlpAttr{key, map[string]interface{}{"stringValue": fmt.Sprint(value)}}
+}
+
+func (e *exporter) payload(spans []*Span) interface{} {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		o := otlpSpan{
+			TraceID: hex.EncodeToString(s.sc.traceID[:]),
+			SpanID:  hex.EncodeToString(s.sc.spanID[:]),
+			Name:    s.name,
+			Kind:    s.kind,
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for key, value := range s.attrs {
+			o.Attributes = append(o.Attributes, attr(key, value))
+		}
+		if s.failed {
+			o.Status = otlpStatus{Code: 2, Message: s.errorMsg}
+		}
+		out[i] = o
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": e.resource},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "binance-proxy"},
+				"spans": out,
+			}},
+		}},
+	}
+}
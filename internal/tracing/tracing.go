@@ -0,0 +1,183 @@
+// Package tracing records spans of requests and exports them to an
+// OpenTelemetry collector over OTLP/HTTP with JSON encoding. Trace context
+// is taken over from W3C traceparent headers.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the OpenTelemetry span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type contextKey struct{}
+
+// Span is a timed operation of a trace. A nil *Span, returned while tracing
+// is disabled or the trace isn't sampled, ignores every call.
+type Span struct {
+	sc       spanContext
+	parent   [8]byte
+	name     string
+	kind     Kind
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	errorMsg string
+	failed   bool
+	ended    atomic.Bool
+}
+
+var (
+	current     atomic.Pointer[exporter]
+	sampleRatio atomic.Uint64 // ratio scaled to the range of uint64
+)
+
+// Enabled reports whether spans are exported.
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// Extract returns ctx with the remote parent from the traceparent header, if
+// the request carries a valid one.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	parts := strings.Split(strings.TrimSpace(h.Get("traceparent")), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	var sc spanContext
+	flags, err1 := hex.DecodeString(parts[3])
+	_, err2 := hex.Decode(sc.traceID[:], []byte(parts[1]))
+	_, err3 := hex.Decode(sc.spanID[:], []byte(parts[2]))
+	if err1 != nil || err2 != nil || err3 != nil || sc.traceID == [16]byte{} || sc.spanID == [8]byte{} {
+		return ctx
+	}
+	sc.sampled = flags[0]&1 == 1
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// Start begins a span as a child of the span in ctx, or of a new trace, and
+// returns ctx carrying it. End must be called on the span.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if !Enabled() {
+		return ctx, nil
+	}
+
+	parent, hasParent := ctx.Value(contextKey{}).(spanContext)
+	sc := spanContext{traceID: parent.traceID, sampled: parent.sampled}
+	if !hasParent {
+		rand.Read(sc.traceID[:])
+		sc.sampled = binary.BigEndian.Uint64(sc.traceID[8:]) < sampleRatio.Load() || sampleRatio.Load() == ^uint64(0)
+	}
+	rand.Read(sc.spanID[:])
+	ctx = context.WithValue(ctx, contextKey{}, sc)
+	if !sc.sampled {
+		return ctx, nil
+	}
+	return ctx, &Span{sc: sc, parent: parent.spanID, name: name, kind: kind, start: time.Now()}
+}
+
+// TraceID returns the trace the span belongs to in hex, "" for nil spans.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.sc.traceID[:])
+}
+
+// SetName renames the span, for names only known once the work is done.
+func (s *Span) SetName(name string) {
+	if s != nil {
+		s.name = name
+	}
+}
+
+// SetAttr sets an attribute of the span. Values other than strings, bools,
+// integers and floats are recorded as strings.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	s.attrs[key] = value
+}
+
+// SetError marks the span as failed with err, if err isn't nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.failed = true
+	s.errorMsg = err.Error()
+}
+
+// Fail marks the span as failed with msg.
+func (s *Span) Fail(format string, args ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.failed = true
+	s.errorMsg = fmt.Sprintf(format, args...)
+}
+
+// End finishes the span and queues it for export.
+func (s *Span) End() {
+	if s == nil || s.ended.Swap(true) {
+		return
+	}
+	s.end = time.Now()
+	if e := current.Load(); e != nil {
+		e.enqueue(s)
+	}
+}
+
+// Transport wraps base with client spans for every request.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return transport{base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, span := Start(req.Context(), req.Method+" "+req.URL.Path, KindClient)
+	span.SetAttr("http.request.method", req.Method)
+	span.SetAttr("server.address", req.URL.Host)
+	span.SetAttr("url.path", req.URL.Path)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttr("http.response.status_code", resp.StatusCode)
+		if w := resp.Header.Get("X-MBX-USED-WEIGHT-1M"); w != "" {
+			span.SetAttr("binance.used_weight_1m", w)
+		}
+		if resp.StatusCode >= 400 {
+			span.Fail("upstream status %d", resp.StatusCode)
+		}
+	}
+	span.End()
+	return resp, err
+}